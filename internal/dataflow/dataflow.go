@@ -0,0 +1,266 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package dataflow runs the analyses as pipelines over the csv files.
+// A Source is scanned in parallel chunks, every row passes the Ops of
+// the source, which filter or rewrite it, and feeds its Sinks. The sinks
+// group and aggregate the rows by a Key, build an Index to hash-join
+// later sources with, or collect names. TopK ranks the aggregates.
+//
+// Every chunk of a source fills its own copy of the sinks, which are
+// merged in the order of the chunks, so that a pipeline gives the same
+// result as a single pass over the file no matter the number of workers.
+package dataflow
+
+import (
+	"os"
+	"sync"
+
+	"gitlab.com/ansrivas/go-analyze-git/internal/fileops"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+)
+
+// Flow runs the scans of an analysis, which share the
+// dictionary of IDs, the row counts and the memory budget
+type Flow struct {
+	// IDs turns the IDs of all the sources into int64 keys
+	IDs *utils.IDs
+	// Workers is the number of chunks a source is scanned in parallel
+	Workers int
+	// MaxMemory is the budget in bytes of the join state, which is
+	// spilled to disk once exceeded. Zero keeps it all in memory.
+	MaxMemory int64
+	// Stats counts the rows of all the scans
+	Stats   utils.RunStats
+	tempDir string
+}
+
+// New creates a flow. Under a memory budget it creates a temp dir
+// for the spilled join state, which Close removes again.
+func New(workers int, maxMemory int64) (*Flow, error) {
+	f := &Flow{IDs: utils.NewIDs(), Workers: workers, MaxMemory: maxMemory}
+	if maxMemory > 0 {
+		dir, err := os.MkdirTemp("", "go-analyze-git-")
+		if err != nil {
+			return nil, err
+		}
+		f.tempDir = dir
+	}
+	return f, nil
+}
+
+// Close removes the files spilled by the flow
+func (f *Flow) Close() error {
+	if f.tempDir == "" {
+		return nil
+	}
+	return os.RemoveAll(f.tempDir)
+}
+
+// Row is a line of a source split into its fields. Both are only
+// valid until the row is handed on to the next line.
+type Row struct {
+	Line   []byte
+	Fields [][]byte
+	ids    *utils.IDs
+	// key is a buffer for the keys of Unique
+	key []byte
+}
+
+// Field returns field i, counted from the end if i is negative
+func (r *Row) Field(i int) []byte {
+	if i < 0 {
+		i += len(r.Fields)
+	}
+	return r.Fields[i]
+}
+
+// Source is a csv file to scan, or a part of it, or an in-memory Table
+type Source struct {
+	File string
+	// Chunks are the parts of File to scan if not nil, like the
+	// lines appended since an earlier scan. Nil scans all of File.
+	Chunks []fileops.Chunk
+	// Table is scanned instead of File if set
+	Table Table
+	// Columns is the number of fields of a valid row, rows with another
+	// number are skipped and counted as such. Zero accepts any row.
+	Columns int
+	// Ops filter and rewrite every row in their order
+	Ops []Op
+	// Sinks all consume every row passing the ops
+	Sinks []Sink
+}
+
+// Table is an in-memory table, like the columns of a cache. Its rows
+// are written out as the lines of the csv file it was read from.
+type Table interface {
+	Len() int
+	// AppendRow appends row i as a csv line to dst
+	AppendRow(dst []byte, i int) []byte
+}
+
+// Input is what a Source scans, a csv file, a part of it or a Table.
+// The zero Input is a file which wasn't given.
+type Input struct {
+	File   string
+	Chunks []fileops.Chunk
+	Table  Table
+}
+
+// Given reports whether the input is set
+func (in Input) Given() bool {
+	return in.File != "" || in.Table != nil
+}
+
+// Source returns a source scanning the input
+func (in Input) Source(columns int, ops []Op, sinks ...Sink) Source {
+	return Source{File: in.File, Chunks: in.Chunks, Table: in.Table, Columns: columns, Ops: ops, Sinks: sinks}
+}
+
+// partial holds the sinks of a single chunk
+type partial struct {
+	sinks []Sink
+	row   Row
+	stats utils.RunStats
+	err   error
+}
+
+// newPartial returns empty sinks for a chunk of the source
+func (f *Flow) newPartial(src Source) *partial {
+	p := &partial{sinks: make([]Sink, len(src.Sinks)), row: Row{ids: f.IDs}}
+	for i, sink := range src.Sinks {
+		p.sinks[i] = sink.fork(f)
+	}
+	return p
+}
+
+// consume hands a line of the source to the sinks of the chunk
+func (p *partial) consume(src Source, line []byte) {
+	p.stats.RowsRead++
+	row := &p.row
+	row.Line = line
+	row.Fields = fileops.SplitFields(line, ',', row.Fields)
+	if src.Columns > 0 && len(row.Fields) != src.Columns {
+		p.stats.RowsSkipped++
+		return
+	}
+	if !pass(src.Ops, row) || p.err != nil {
+		return
+	}
+	for _, sink := range p.sinks {
+		if p.err = sink.consume(row); p.err != nil {
+			break
+		}
+	}
+}
+
+// Scan reads the source into its sinks
+func (f *Flow) Scan(src Source) error {
+	var partials []*partial
+	var err error
+	if src.Table != nil {
+		partials = f.scanTable(src)
+	} else {
+		partials, err = f.scanFile(src)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, p := range partials {
+		if p.err != nil {
+			return p.err
+		}
+		f.Stats.Add(p.stats)
+		for i, sink := range src.Sinks {
+			sink.merge(p.sinks[i])
+		}
+	}
+	for _, sink := range src.Sinks {
+		if err := sink.finish(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanFile reads the chunks of the file of the source in parallel
+func (f *Flow) scanFile(src Source) ([]*partial, error) {
+	chunks := src.Chunks
+	if chunks == nil {
+		var err error
+		if chunks, err = fileops.SplitChunks(src.File, f.Workers); err != nil {
+			return nil, err
+		}
+	}
+	return utils.ReadChunks(src.File, chunks, func(batches <-chan *fileops.Batch) *partial {
+		p := f.newPartial(src)
+		for batch := range batches {
+			for _, line := range batch.Lines {
+				p.consume(src, line)
+			}
+			batch.Release()
+		}
+		return p
+	})
+}
+
+// scanTable reads the rows of the table of the source in as many
+// ranges as there are workers, in parallel
+func (f *Flow) scanTable(src Source) []*partial {
+	rows := src.Table.Len()
+	workers := f.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > rows {
+		workers = rows
+	}
+	partials := make([]*partial, workers)
+	var wg sync.WaitGroup
+	for i := range partials {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			p := f.newPartial(src)
+			var line []byte
+			for row := rows * i / workers; row < rows*(i+1)/workers; row++ {
+				line = src.Table.AppendRow(line[:0], row)
+				p.consume(src, line)
+			}
+			partials[i] = p
+		}(i)
+	}
+	wg.Wait()
+	return partials
+}
+
+// pass reports whether the row passes all the ops
+func pass(ops []Op, row *Row) bool {
+	for _, op := range ops {
+		if !op(row) {
+			return false
+		}
+	}
+	return true
+}
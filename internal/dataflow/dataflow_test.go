@@ -0,0 +1,223 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dataflow
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dedup"
+	"gitlab.com/ansrivas/go-analyze-git/internal/fileops"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	fname := filepath.Join(t.TempDir(), name)
+	assert.Nil(t, os.WriteFile(fname, []byte(content), 0o600))
+	return fname
+}
+
+func TestScan(t *testing.T) {
+	assert := assert.New(t)
+
+	eventsFile := writeFile(t, "events.csv", "id,type,actor_id,repo_id\n"+
+		"1,WatchEvent,10,100\n"+
+		"2,WatchEvent,11,100\n"+
+		"2,WatchEvent,11,100\n"+
+		"3,PushEvent,10,100\n"+
+		"4,WatchEvent,10,101\n"+
+		"broken\n"+
+		"5,WatchEvent,10,100\n")
+	reposFile := writeFile(t, "repos.csv", "id,name\n100,first\n101,other\n100,renamed\n")
+
+	for workers := 1; workers <= 4; workers++ {
+		flow, err := New(workers, 0)
+		assert.Nil(err)
+		filter, _ := dedup.New(dedup.Exact, 0)
+		watches := NewCount(Field(3), 0)
+		actors := NewDistinct(Field(3), Field(2), 0)
+		err = flow.Scan(Source{
+			File:    eventsFile,
+			Columns: 4,
+			Ops:     []Op{Where(1, "WatchEvent"), Unique(filter, Prefix(2))},
+			Sinks:   []Sink{watches, actors},
+		})
+		assert.Nil(err)
+		assert.Equal(int64(1), filter.Dropped())
+		count, _, _ := watches.Counts.Get(100)
+		assert.Equal(3, count, "workers: %d", workers)
+		count, _ = actors.Counts.Count(100)
+		assert.Equal(2, count)
+
+		first := FirstNames(KnownField(0), 1, func(id int64) bool { return id == 100 })
+		last := LastNames(KnownField(0), 1, func(id int64) bool { return true })
+		assert.Nil(flow.Scan(Source{File: reposFile, Columns: 2, Sinks: []Sink{first, last}}))
+		assert.Equal(map[int64]string{100: "first"}, first.Names)
+		assert.Equal(map[int64]string{100: "renamed", 101: "other"}, last.Names)
+
+		// The header of both files and the broken line
+		assert.Equal(int64(12), flow.Stats.RowsRead)
+		assert.Equal(int64(1), flow.Stats.RowsSkipped)
+
+		top := TopK(watches.Counts, 1, utils.Selection{}, func(id int64, row *utils.GenericDict) bool {
+			row.Key = last.Names[id]
+			return true
+		})
+		assert.Equal(utils.GenericDictHeap{{Key: "renamed", Value: 3}}, top)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	assert := assert.New(t)
+
+	eventsFile := writeFile(t, "events.csv", "1,PushEvent,10,100\n"+
+		"2,PushEvent,11,101\n"+
+		"3,CreateEvent,11,102\n"+
+		// A later event replaces an earlier one with the same id
+		"2,PushEvent,12,102\n")
+	commitsFile := writeFile(t, "commits.csv", "sha,message,event_id\n"+
+		"a,one,1\n"+
+		"b,two,2\n"+
+		"c,three,2\n"+
+		"d,four,3\n"+
+		"e,orphan,9\n")
+
+	// Under a tiny budget the join spills and merge-joins
+	for _, maxMemory := range []int64{0, 64} {
+		for workers := 1; workers <= 3; workers++ {
+			flow, err := New(workers, maxMemory)
+			assert.Nil(err)
+			pushes := NewIndex(Field(0), Field(3))
+			err = flow.Scan(Source{
+				File:    eventsFile,
+				Columns: 4,
+				Ops:     []Op{Where(1, "PushEvent")},
+				Sinks:   []Sink{pushes},
+			})
+			assert.Nil(err)
+
+			commits := NewCount(pushes.Join(-1), 0)
+			commits.AddValues(pushes)
+			err = flow.Scan(Source{File: commitsFile, Ops: []Op{Known(-1)}, Sinks: []Sink{commits}})
+			assert.Nil(err)
+
+			counts := make(map[int64]int)
+			commits.Counts.Each(func(key int64, count, err int) { counts[key] = count })
+			// 101 was pushed to by a replaced event
+			assert.Equal(map[int64]int{100: 1, 101: 0, 102: 2}, counts, "memory %d, workers %d", maxMemory, workers)
+			assert.Nil(flow.Close())
+		}
+	}
+}
+
+func TestFieldsKey(t *testing.T) {
+	assert := assert.New(t)
+
+	row := &Row{Line: []byte("a,b,c"), Fields: [][]byte{[]byte("a"), []byte("b"), []byte("c")}}
+	assert.Equal("a,c", string(Fields(0, -1)(row, nil)))
+	assert.Equal("a,b", string(Prefix(2)(row, nil)))
+}
+
+// lines is a Table of csv lines
+type lines []string
+
+func (l lines) Len() int {
+	return len(l)
+}
+
+func (l lines) AppendRow(dst []byte, i int) []byte {
+	return append(dst, l[i]...)
+}
+
+func TestScanTable(t *testing.T) {
+	assert := assert.New(t)
+
+	events := lines{
+		"1,WatchEvent,10,100",
+		"2,WatchEvent,11,100",
+		"2,WatchEvent,11,100",
+		"3,PushEvent,10,100",
+		"4,WatchEvent,10,101",
+		"broken",
+	}
+	for workers := 1; workers <= 8; workers++ {
+		flow, err := New(workers, 0)
+		assert.Nil(err)
+		filter, _ := dedup.New(dedup.Exact, 0)
+		watches := NewCount(Field(3), 0)
+		pushes := NewCount(Field(3), 0)
+		err = flow.Scan(Input{Table: events}.Source(4,
+			[]Op{Unique(filter, Prefix(2))},
+			Branch([]Op{Where(1, "WatchEvent")}, watches),
+			Branch([]Op{Where(1, "PushEvent")}, pushes),
+		))
+		assert.Nil(err)
+		count, _, _ := watches.Counts.Get(100)
+		assert.Equal(2, count, "workers: %d", workers)
+		count, _, _ = pushes.Counts.Get(100)
+		assert.Equal(1, count)
+		assert.Equal(int64(6), flow.Stats.RowsRead)
+		assert.Equal(int64(1), flow.Stats.RowsSkipped)
+	}
+}
+
+func TestPending(t *testing.T) {
+	assert := assert.New(t)
+
+	eventsFile := writeFile(t, "events.csv", "1,PushEvent,10,100\n")
+	commitsFile := writeFile(t, "commits.csv", "a,one,1\nb,two,2\nc,three,2\n")
+
+	flow, err := New(2, 0)
+	assert.Nil(err)
+	pushes := NewIndex(Field(0), Field(3))
+	assert.Nil(flow.Scan(Source{File: eventsFile, Columns: 4, Sinks: []Sink{pushes}}))
+	commits := NewCount(pushes.Join(-1), 0)
+	pending := NewPending(pushes, -1)
+	assert.Nil(flow.Scan(Source{File: commitsFile, Sinks: []Sink{commits, pending}}))
+	count, _, _ := commits.Counts.Get(100)
+	assert.Equal(1, count)
+	event2, _ := flow.IDs.Lookup([]byte("2"))
+	assert.Equal(map[int64]int{event2: 2}, pending.Counts)
+
+	// The event of the pending commits is appended later, no
+	// chunk scans nothing
+	state := pushes.State()
+	pushes = NewIndex(Field(0), Field(3))
+	pushes.Resume(state)
+	assert.Nil(flow.Scan(Source{File: eventsFile, Chunks: []fileops.Chunk{}, Sinks: []Sink{pushes}}))
+	assert.Equal(int64(4), flow.Stats.RowsRead)
+	assert.Nil(os.WriteFile(eventsFile, []byte("1,PushEvent,10,100\n2,PushEvent,11,101\n"), 0o600))
+	chunks, _, err := fileops.SplitTail(eventsFile, int64(len("1,PushEvent,10,100\n")), 1)
+	assert.Nil(err)
+	assert.Nil(flow.Scan(Source{File: eventsFile, Chunks: chunks, Sinks: []Sink{pushes}}))
+	pending.index = pushes
+	resolved := map[int64]int{}
+	pending.Resolve(func(eventID, repoID int64, count int) {
+		resolved[repoID] += count
+	})
+	repo101, _ := flow.IDs.Lookup([]byte("101"))
+	assert.Equal(map[int64]int{repo101: 2}, resolved)
+	assert.Empty(pending.Counts)
+}
@@ -0,0 +1,168 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dataflow
+
+import (
+	"gitlab.com/ansrivas/go-analyze-git/internal/dedup"
+)
+
+// Op filters or rewrites a row before it reaches the sinks, the row
+// is dropped if it reports false. An op is shared by all the workers.
+type Op func(row *Row) bool
+
+// Where keeps the rows whose field i is one of values
+func Where(i int, values ...string) Op {
+	return func(row *Row) bool {
+		field := row.Field(i)
+		for _, value := range values {
+			if string(field) == value {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// NotHeader drops the header row of a csv file, the one whose first
+// field is the name of the first column. Only Where and the joins
+// drop it otherwise.
+func NotHeader(name string) Op {
+	return func(row *Row) bool {
+		return string(row.Field(0)) != name
+	}
+}
+
+// Known keeps the rows whose field i holds an ID seen before, only
+// those can be joined with the rows of an earlier source
+func Known(i int) Op {
+	return func(row *Row) bool {
+		_, ok := row.ids.Lookup(row.Field(i))
+		return ok
+	}
+}
+
+// In keeps the rows whose field i holds an ID seen before which keep
+// accepts, like the repositories of an organization
+func In(i int, keep func(id int64) bool) Op {
+	return func(row *Row) bool {
+		id, ok := row.ids.Lookup(row.Field(i))
+		return ok && keep(id)
+	}
+}
+
+// Unique drops the rows whose key the filter has seen before
+func Unique(filter *dedup.Filter, key KeyBytes) Op {
+	return func(row *Row) bool {
+		row.key = key(row, row.key)
+		return !filter.Duplicate(row.key)
+	}
+}
+
+// KeyBytes returns the key of a row identifying its duplicates,
+// reusing the memory of dst
+type KeyBytes func(row *Row, dst []byte) []byte
+
+// Prefix identifies a row by its first n fields
+func Prefix(n int) KeyBytes {
+	return func(row *Row, dst []byte) []byte {
+		end := n - 1
+		for _, field := range row.Fields[:n] {
+			end += len(field)
+		}
+		return row.Line[:end]
+	}
+}
+
+// Span identifies a row by its fields from up to to, counted from the end
+// if negative, as they are written in the line. A commit message holding
+// commas spans several fields.
+func Span(from, to int) KeyBytes {
+	return func(row *Row, dst []byte) []byte {
+		end := to
+		if end < 0 {
+			end += len(row.Fields)
+		}
+		if end <= from {
+			return dst[:0]
+		}
+		start := from
+		for _, field := range row.Fields[:from] {
+			start += len(field)
+		}
+		length := end - from - 1
+		for _, field := range row.Fields[from:end] {
+			length += len(field)
+		}
+		return row.Line[start : start+length]
+	}
+}
+
+// Fields identifies a row by the given fields, separated by commas
+func Fields(fields ...int) KeyBytes {
+	return func(row *Row, dst []byte) []byte {
+		dst = dst[:0]
+		for i, field := range fields {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = append(dst, row.Field(field)...)
+		}
+		return dst
+	}
+}
+
+// Key is the int64 key a sink groups a row by
+type Key struct {
+	field int
+	known bool
+	index *Index
+}
+
+// Field keys a row by the ID in field i, counted from the end if negative
+func Field(i int) Key {
+	return Key{field: i}
+}
+
+// KnownField is Field, but skips the rows whose ID was
+// never seen before instead of adding it to the IDs
+func KnownField(i int) Key {
+	return Key{field: i, known: true}
+}
+
+// of returns the key of a row, if it has one
+func (k Key) of(row *Row) (int64, bool) {
+	if k.index == nil && !k.known {
+		return row.ids.Parse(row.Field(k.field)), true
+	}
+	id, ok := row.ids.Lookup(row.Field(k.field))
+	if !ok || k.index == nil {
+		return id, ok
+	}
+	value, ok := k.index.pairs[id]
+	return value, ok
+}
+
+// spilled reports whether the key is joined with a spilled index
+func (k Key) spilled() bool {
+	return k.index != nil && k.index.spilling
+}
@@ -0,0 +1,489 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dataflow
+
+import (
+	"hash/maphash"
+
+	"github.com/rs/zerolog/log"
+	"gitlab.com/ansrivas/go-analyze-git/internal/sketch"
+	"gitlab.com/ansrivas/go-analyze-git/internal/spill"
+)
+
+// Sink consumes the rows of a source. Every chunk of the source is
+// consumed by a fork of the sink, which is merged back afterwards.
+type Sink interface {
+	// fork returns an empty sink for the rows of one chunk
+	fork(f *Flow) Sink
+	consume(row *Row) error
+	// merge adds a fork, the forks are merged in the order of the chunks
+	merge(part Sink)
+	// finish completes the sink once all the forks are merged
+	finish(f *Flow) error
+}
+
+// Count groups the rows by a key and counts them, exactly or
+// approximately in a sketch of the given number of counters.
+// Counting by a key joined with a spilled Index collects the
+// keys of the rows instead, which are merge-joined at the end.
+type Count struct {
+	Counts   *sketch.Counter
+	key      Key
+	counters int
+	// keys collects the keys of a fork to merge-join, spilled
+	// the ones of all the forks
+	keys    *spill.Keys
+	spilled []*spill.Keys
+}
+
+// NewCount creates a count of the rows by key
+func NewCount(key Key, counters int) *Count {
+	return &Count{Counts: sketch.NewCounter(counters), key: key, counters: counters}
+}
+
+// AddValues counts every value of the index, even
+// if no row is counted for it, with zero rows
+func (c *Count) AddValues(ix *Index) {
+	for value := range ix.values {
+		c.Counts.Add(value, 0)
+	}
+}
+
+func (c *Count) fork(f *Flow) Sink {
+	part := NewCount(c.key, c.counters)
+	if c.key.spilled() {
+		part.keys = spill.NewKeys(f.tempDir, spill.Limit(f.MaxMemory/2, spill.KeyBytes, f.Workers))
+	}
+	return part
+}
+
+func (c *Count) consume(row *Row) error {
+	if c.keys != nil {
+		if id, ok := row.ids.Lookup(row.Field(c.key.field)); ok {
+			return c.keys.Add(id)
+		}
+		return nil
+	}
+	if key, ok := c.key.of(row); ok {
+		c.Counts.Add(key, 1)
+	}
+	return nil
+}
+
+func (c *Count) merge(part Sink) {
+	p := part.(*Count)
+	c.Counts.Merge(p.Counts)
+	if p.keys != nil {
+		c.spilled = append(c.spilled, p.keys)
+	}
+}
+
+func (c *Count) finish(f *Flow) error {
+	if len(c.spilled) == 0 {
+		return nil
+	}
+	runs := 0
+	for _, p := range c.key.index.spilled {
+		runs += p.Spilled()
+	}
+	for _, k := range c.spilled {
+		runs += k.Spilled()
+	}
+	log.Debug().Msgf("Merge-joining the commits with %d spilled runs in %s", runs, f.tempDir)
+	return spill.Join(c.key.index.spilled, c.spilled, func(value int64) {
+		c.Counts.Add(value, 1)
+	})
+}
+
+// Distinct groups the rows by a key and counts their distinct values,
+// exactly or approximately in sketches of the given precision
+type Distinct struct {
+	Counts    *sketch.Distinct
+	key       Key
+	value     Key
+	precision int
+}
+
+// NewDistinct creates a count of the distinct values per key
+func NewDistinct(key, value Key, precision int) *Distinct {
+	return &Distinct{Counts: sketch.NewDistinct(precision), key: key, value: value, precision: precision}
+}
+
+// Counter returns the distinct counts as an exact counter,
+// which is ranked like any other count
+func (d *Distinct) Counter() *sketch.Counter {
+	counts := sketch.NewCounter(0)
+	d.Counts.Each(func(key int64, count int) {
+		counts.Add(key, count)
+	})
+	return counts
+}
+
+func (d *Distinct) fork(f *Flow) Sink {
+	return NewDistinct(d.key, d.value, d.precision)
+}
+
+func (d *Distinct) consume(row *Row) error {
+	key, ok := d.key.of(row)
+	if !ok {
+		return nil
+	}
+	if value, ok := d.value.of(row); ok {
+		d.Counts.Add(key, value)
+	}
+	return nil
+}
+
+func (d *Distinct) merge(part Sink) {
+	d.Counts.Merge(part.(*Distinct).Counts)
+}
+
+func (d *Distinct) finish(f *Flow) error {
+	return nil
+}
+
+// Repeats groups the rows by a key and counts the rows sharing the most
+// frequent value of the key, like the commits of a user with the same
+// message. The values are hashed, a key joined with a spilled Index
+// isn't supported.
+type Repeats struct {
+	// Counts holds the rows of the most frequent value per key,
+	// once the source is scanned
+	Counts map[int64]int
+	key    Key
+	value  KeyBytes
+	seed   maphash.Seed
+	values map[int64]map[uint64]int
+	buf    []byte
+}
+
+// NewRepeats creates a count of the repeated values per key
+func NewRepeats(key Key, value KeyBytes) *Repeats {
+	return &Repeats{Counts: make(map[int64]int), key: key, value: value, seed: maphash.MakeSeed(), values: make(map[int64]map[uint64]int)}
+}
+
+func (r *Repeats) fork(f *Flow) Sink {
+	return &Repeats{key: r.key, value: r.value, seed: r.seed, values: make(map[int64]map[uint64]int)}
+}
+
+func (r *Repeats) consume(row *Row) error {
+	key, ok := r.key.of(row)
+	if !ok {
+		return nil
+	}
+	r.buf = r.value(row, r.buf)
+	values, ok := r.values[key]
+	if !ok {
+		values = make(map[uint64]int)
+		r.values[key] = values
+	}
+	values[maphash.Bytes(r.seed, r.buf)]++
+	return nil
+}
+
+func (r *Repeats) merge(part Sink) {
+	for key, values := range part.(*Repeats).values {
+		merged, ok := r.values[key]
+		if !ok {
+			r.values[key] = values
+			continue
+		}
+		for value, count := range values {
+			merged[value] += count
+		}
+	}
+}
+
+func (r *Repeats) finish(f *Flow) error {
+	for key, values := range r.values {
+		for _, count := range values {
+			if count > r.Counts[key] {
+				r.Counts[key] = count
+			}
+		}
+	}
+	r.values = make(map[int64]map[uint64]int)
+	return nil
+}
+
+// Index is the build side of a hash-join, pairing the key of every row
+// with a value. A later row replaces the value of an earlier one. Under
+// the memory budget of the flow the pairs are spilled to sorted run
+// files, and the joins with the index become merge-joins.
+type Index struct {
+	key   Key
+	value Key
+	pairs map[int64]int64
+	// values holds every value paired, replaced ones included
+	values map[int64]struct{}
+	// spill collects the pairs of a fork under a memory
+	// budget, spilled the ones of all the forks
+	spill    *spill.Pairs
+	spilled  []*spill.Pairs
+	spilling bool
+}
+
+// NewIndex creates an index of the value of every row by key
+func NewIndex(key, value Key) *Index {
+	return &Index{key: key, value: value, pairs: make(map[int64]int64), values: make(map[int64]struct{})}
+}
+
+// Join keys a row by the value the index pairs with the ID in field i,
+// joining the row with the latest row the index was built from
+func (ix *Index) Join(i int) Key {
+	return Key{field: i, index: ix}
+}
+
+func (ix *Index) fork(f *Flow) Sink {
+	part := NewIndex(ix.key, ix.value)
+	if f.MaxMemory > 0 {
+		part.spill = spill.NewPairs(f.tempDir, spill.Limit(f.MaxMemory, spill.PairBytes, f.Workers))
+	}
+	return part
+}
+
+func (ix *Index) consume(row *Row) error {
+	key, ok := ix.key.of(row)
+	if !ok {
+		return nil
+	}
+	value, ok := ix.value.of(row)
+	if !ok {
+		return nil
+	}
+	ix.values[value] = struct{}{}
+	if ix.spill != nil {
+		return ix.spill.Add(key, value)
+	}
+	ix.pairs[key] = value
+	return nil
+}
+
+func (ix *Index) merge(part Sink) {
+	p := part.(*Index)
+	for key, value := range p.pairs {
+		ix.pairs[key] = value
+	}
+	for value := range p.values {
+		ix.values[value] = struct{}{}
+	}
+	if p.spill != nil {
+		ix.spilled = append(ix.spilled, p.spill)
+	}
+}
+
+func (ix *Index) finish(f *Flow) error {
+	ix.spilling = f.MaxMemory > 0
+	if !ix.spilling {
+		return nil
+	}
+	// Leave at least half of the budget to the rows joined later
+	pending := 0
+	for _, p := range ix.spilled {
+		pending += p.Pending()
+	}
+	if int64(pending)*spill.PairBytes <= f.MaxMemory/2 {
+		return nil
+	}
+	for _, p := range ix.spilled {
+		if err := p.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IndexState is the content of an Index, which is carried over
+// between the scans of a growing file. It can't be spilled.
+type IndexState struct {
+	Pairs  map[int64]int64
+	Values map[int64]bool
+}
+
+// State returns the pairs and values of the index
+func (ix *Index) State() IndexState {
+	values := make(map[int64]bool, len(ix.values))
+	for value := range ix.values {
+		values[value] = true
+	}
+	return IndexState{Pairs: ix.pairs, Values: values}
+}
+
+// Resume continues the index from an earlier state
+func (ix *Index) Resume(state IndexState) {
+	if state.Pairs != nil {
+		ix.pairs = state.Pairs
+	}
+	for value := range state.Values {
+		ix.values[value] = struct{}{}
+	}
+}
+
+// Get returns the value paired with key, if the index isn't spilled
+func (ix *Index) Get(key int64) (int64, bool) {
+	value, ok := ix.pairs[key]
+	return value, ok
+}
+
+// Pending counts the rows per ID in field i which the index doesn't
+// pair yet, like the commits of events not read so far. They are
+// counted once a later scan adds their ID to the index.
+type Pending struct {
+	Counts map[int64]int
+	index  *Index
+	field  int
+}
+
+// NewPending creates a count of the rows the index can't join
+func NewPending(ix *Index, i int) *Pending {
+	return &Pending{Counts: make(map[int64]int), index: ix, field: i}
+}
+
+// Resolve hands every ID the index pairs by now to add, together
+// with the value paired and the count of its rows, and forgets them
+func (p *Pending) Resolve(add func(id, value int64, count int)) {
+	for id, count := range p.Counts {
+		if value, ok := p.index.pairs[id]; ok {
+			add(id, value, count)
+			delete(p.Counts, id)
+		}
+	}
+}
+
+func (p *Pending) fork(f *Flow) Sink {
+	return NewPending(p.index, p.field)
+}
+
+func (p *Pending) consume(row *Row) error {
+	id := row.ids.Parse(row.Field(p.field))
+	if _, ok := p.index.pairs[id]; !ok {
+		p.Counts[id]++
+	}
+	return nil
+}
+
+func (p *Pending) merge(part Sink) {
+	for id, count := range part.(*Pending).Counts {
+		p.Counts[id] += count
+	}
+}
+
+func (p *Pending) finish(f *Flow) error {
+	return nil
+}
+
+// branch hands the rows passing its ops to its sinks
+type branch struct {
+	ops   []Op
+	sinks []Sink
+}
+
+// Branch returns a sink handing the rows passing ops to sinks, so
+// that several analyses with their own ops share a single scan
+func Branch(ops []Op, sinks ...Sink) Sink {
+	return &branch{ops: ops, sinks: sinks}
+}
+
+func (b *branch) fork(f *Flow) Sink {
+	part := &branch{ops: b.ops, sinks: make([]Sink, len(b.sinks))}
+	for i, sink := range b.sinks {
+		part.sinks[i] = sink.fork(f)
+	}
+	return part
+}
+
+func (b *branch) consume(row *Row) error {
+	if !pass(b.ops, row) {
+		return nil
+	}
+	for _, sink := range b.sinks {
+		if err := sink.consume(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *branch) merge(part Sink) {
+	for i, sink := range part.(*branch).sinks {
+		b.sinks[i].merge(sink)
+	}
+}
+
+func (b *branch) finish(f *Flow) error {
+	for _, sink := range b.sinks {
+		if err := sink.finish(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Names collects the names of IDs, like the logins of the actors
+type Names struct {
+	Names map[int64]string
+	key   Key
+	field int
+	keep  func(id int64) bool
+	first bool
+}
+
+// FirstNames collects the name in field of every key keep accepts,
+// the first name of a key wins
+func FirstNames(key Key, field int, keep func(id int64) bool) *Names {
+	return &Names{Names: make(map[int64]string), key: key, field: field, keep: keep, first: true}
+}
+
+// LastNames is FirstNames, but the last name of a key wins
+func LastNames(key Key, field int, keep func(id int64) bool) *Names {
+	return &Names{Names: make(map[int64]string), key: key, field: field, keep: keep}
+}
+
+func (n *Names) fork(f *Flow) Sink {
+	return &Names{Names: make(map[int64]string), key: n.key, field: n.field, keep: n.keep, first: n.first}
+}
+
+func (n *Names) consume(row *Row) error {
+	id, ok := n.key.of(row)
+	if !ok || !n.keep(id) {
+		return nil
+	}
+	if _, seen := n.Names[id]; seen && n.first {
+		return nil
+	}
+	n.Names[id] = string(row.Field(n.field))
+	return nil
+}
+
+func (n *Names) merge(part Sink) {
+	for id, name := range part.(*Names).Names {
+		if _, seen := n.Names[id]; seen && n.first {
+			continue
+		}
+		n.Names[id] = name
+	}
+}
+
+func (n *Names) finish(f *Flow) error {
+	return nil
+}
@@ -0,0 +1,50 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dataflow
+
+import (
+	"gitlab.com/ansrivas/go-analyze-git/internal/sketch"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+)
+
+// TopK returns the count highest counts, highest first, selecting the
+// rows as told by sel. The rows of an approximate
+// counter hold their overestimation in the ErrorHeader column. fill names
+// the row of a key and may add further columns, the key is dropped if it
+// reports false. It is only called for the keys which may still enter
+// the top k.
+func TopK(counts *sketch.Counter, count int, sel utils.Selection, fill func(key int64, row *utils.GenericDict) bool) utils.GenericDictHeap {
+	r := utils.NewRanker(count, sel)
+	counts.Each(func(key int64, value, err int) {
+		// Naming the key is only worth it if it may enter the top k,
+		// a tie has to be named to be ranked by its name
+		if !r.Admits(value) {
+			return
+		}
+		row := utils.CounterRow(counts, "", value, err)
+		if fill(key, &row) {
+			r.Push(row)
+		}
+	})
+	return r.Rows()
+}
@@ -0,0 +1,200 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package dedup drops the duplicated rows of an at-least-once export.
+package dedup
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+)
+
+// Modes of a Filter
+const (
+	// Exact remembers every key
+	Exact = "exact"
+	// Bloom remembers the keys in a Bloom filter of a fixed size,
+	// which may take a few unique keys for duplicates
+	Bloom = "bloom"
+	// None keeps every row
+	None = "none"
+)
+
+// Modes lists the modes of a Filter
+var Modes = []string{Exact, Bloom, None}
+
+// hashes is the number of bits set per key in a Bloom filter,
+// about 1% false positives at 10 bits per key
+const hashes = 7
+
+// shards splits the keys of an exact filter, so
+// that the workers rarely wait for each other
+const shards = 64
+
+// Filter reports the keys it has seen before. It is safe for
+// concurrent use by the workers reading the chunks of a file.
+type Filter struct {
+	mode    string
+	seed    maphash.Seed
+	exact   [shards]shard
+	bits    []uint64
+	dropped int64
+}
+
+type shard struct {
+	sync.Mutex
+	keys map[string]struct{}
+}
+
+// New creates a filter of the given mode. A Bloom filter
+// takes memory bytes, at least 8.
+func New(mode string, memory int64) (*Filter, error) {
+	f := &Filter{mode: mode, seed: maphash.MakeSeed()}
+	switch mode {
+	case Exact:
+		for i := range f.exact {
+			f.exact[i].keys = make(map[string]struct{})
+		}
+	case Bloom:
+		words := memory / 8
+		if words < 1 {
+			words = 1
+		}
+		f.bits = make([]uint64, words)
+	case None, "":
+		f.mode = None
+	default:
+		return nil, fmt.Errorf("unknown dedup mode %q, expected one of %v", mode, Modes)
+	}
+	return f, nil
+}
+
+// Duplicate records key and reports whether it was recorded before
+func (f *Filter) Duplicate(key []byte) bool {
+	var duplicate bool
+	switch f.mode {
+	case Exact:
+		h := maphash.Bytes(f.seed, key)
+		s := &f.exact[h%shards]
+		s.Lock()
+		if _, duplicate = s.keys[string(key)]; !duplicate {
+			s.keys[string(key)] = struct{}{}
+		}
+		s.Unlock()
+	case Bloom:
+		duplicate = f.bloom(maphash.Bytes(f.seed, key))
+	}
+	if duplicate {
+		atomic.AddInt64(&f.dropped, 1)
+	}
+	return duplicate
+}
+
+// bloom sets the bits of hash h and reports whether they were all set
+func (f *Filter) bloom(h uint64) bool {
+	// Double hashing derives all the positions from two hashes
+	h2 := mix(h) | 1
+	n := uint64(len(f.bits)) * 64
+	set := true
+	for i := uint64(0); i < hashes; i++ {
+		bit := (h + i*h2) % n
+		word, mask := &f.bits[bit/64], uint64(1)<<(bit%64)
+		for {
+			old := atomic.LoadUint64(word)
+			if old&mask != 0 {
+				break
+			}
+			if atomic.CompareAndSwapUint64(word, old, old|mask) {
+				set = false
+				break
+			}
+		}
+	}
+	return set
+}
+
+// Dropped returns the number of duplicates reported
+func (f *Filter) Dropped() int64 {
+	return atomic.LoadInt64(&f.dropped)
+}
+
+// Enabled reports whether the filter drops anything
+func (f *Filter) Enabled() bool {
+	return f.mode != None
+}
+
+// MarshalBinary encodes the keys of an exact filter. A Bloom
+// filter hashes with a seed of the process, so it can't be persisted.
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	switch f.mode {
+	case Bloom:
+		return nil, fmt.Errorf("a %s filter can not be persisted", Bloom)
+	case None:
+		return []byte(None), nil
+	}
+	buf := []byte(Exact)
+	for i := range f.exact {
+		for key := range f.exact[i].keys {
+			buf = binary.AppendUvarint(buf, uint64(len(key)))
+			buf = append(buf, key...)
+		}
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary restores a filter encoded by MarshalBinary
+func (f *Filter) UnmarshalBinary(data []byte) error {
+	f.seed = maphash.MakeSeed()
+	atomic.StoreInt64(&f.dropped, 0)
+	if string(data) == None {
+		f.mode = None
+		return nil
+	}
+	if len(data) < len(Exact) || string(data[:len(Exact)]) != Exact {
+		return errors.New("corrupt dedup filter")
+	}
+	f.mode = Exact
+	for i := range f.exact {
+		f.exact[i].keys = make(map[string]struct{})
+	}
+	for data = data[len(Exact):]; len(data) > 0; {
+		n, size := binary.Uvarint(data)
+		if size <= 0 || n > uint64(len(data)-size) {
+			return errors.New("corrupt dedup filter")
+		}
+		key := data[size : size+int(n)]
+		f.exact[maphash.Bytes(f.seed, key)%shards].keys[string(key)] = struct{}{}
+		data = data[size+int(n):]
+	}
+	return nil
+}
+
+// mix spreads the bits of x (splitmix64)
+func mix(x uint64) uint64 {
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
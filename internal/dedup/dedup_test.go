@@ -0,0 +1,127 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dedup
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, mode := range Modes {
+		f, err := New(mode, 1<<16)
+		assert.Nil(err)
+		assert.Equal(mode != None, f.Enabled())
+
+		assert.False(f.Duplicate([]byte("1,WatchEvent")))
+		assert.False(f.Duplicate([]byte("1,ForkEvent")))
+		assert.Equal(mode != None, f.Duplicate([]byte("1,WatchEvent")), mode)
+		if mode == None {
+			assert.Equal(int64(0), f.Dropped())
+		} else {
+			assert.Equal(int64(1), f.Dropped())
+		}
+	}
+
+	_, err := New("fuzzy", 0)
+	assert.NotNil(err)
+}
+
+func TestFilterBinary(t *testing.T) {
+	assert := assert.New(t)
+
+	f, _ := New(Exact, 0)
+	f.Duplicate([]byte("1,WatchEvent"))
+	f.Duplicate([]byte("2,PushEvent"))
+	data, err := f.MarshalBinary()
+	assert.Nil(err)
+
+	// The restored filter knows the keys seen before
+	restored := &Filter{}
+	assert.Nil(restored.UnmarshalBinary(data))
+	assert.True(restored.Duplicate([]byte("1,WatchEvent")))
+	assert.True(restored.Duplicate([]byte("2,PushEvent")))
+	assert.False(restored.Duplicate([]byte("3,PushEvent")))
+	assert.Nil(restored.UnmarshalBinary(data[:len(Exact)]))
+	assert.False(restored.Duplicate([]byte("1,WatchEvent")))
+	assert.NotNil(restored.UnmarshalBinary(data[:len(data)-1]))
+
+	f, _ = New(None, 0)
+	data, err = f.MarshalBinary()
+	assert.Nil(err)
+	assert.Nil(restored.UnmarshalBinary(data))
+	assert.False(restored.Enabled())
+
+	f, _ = New(Bloom, 64)
+	_, err = f.MarshalBinary()
+	assert.NotNil(err)
+}
+
+func TestFilterConcurrent(t *testing.T) {
+	assert := assert.New(t)
+
+	// Every key is added by four workers, three of them see a duplicate
+	for _, mode := range []string{Exact, Bloom} {
+		f, err := New(mode, 1<<20)
+		assert.Nil(err)
+		var wg sync.WaitGroup
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var key []byte
+				for i := 0; i < 10000; i++ {
+					key = strconv.AppendInt(key[:0], int64(i), 10)
+					f.Duplicate(key)
+				}
+			}()
+		}
+		wg.Wait()
+		if mode == Exact {
+			assert.Equal(int64(30000), f.Dropped())
+		} else {
+			// False positives only ever add to the duplicates
+			assert.GreaterOrEqual(f.Dropped(), int64(30000))
+			assert.Less(f.Dropped(), int64(30100))
+		}
+	}
+}
+
+func TestBloomFalsePositives(t *testing.T) {
+	assert := assert.New(t)
+
+	// At 10 bits per key about 1% of the unique keys are taken for duplicates
+	f, err := New(Bloom, 10000*10/8)
+	assert.Nil(err)
+	var key []byte
+	for i := 0; i < 10000; i++ {
+		key = strconv.AppendInt(key[:0], int64(i), 10)
+		f.Duplicate(key)
+	}
+	assert.Less(f.Dropped(), int64(300))
+}
@@ -24,8 +24,11 @@ package fileops
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
+	"io"
 	"os"
+	"sync"
 )
 
 type FileOps struct {
@@ -56,7 +59,11 @@ func (f *FileOps) readFileStreaming(fname string, outputChan chan<- string, spli
 	}
 	defer fileHandle.Close()
 
-	fileReader := bufio.NewScanner(fileHandle)
+	return f.scan(fileHandle, outputChan, splitFunc)
+}
+
+func (f *FileOps) scan(reader io.Reader, outputChan chan<- string, splitFunc bufio.SplitFunc) error {
+	fileReader := bufio.NewScanner(reader)
 	fileReader.Split(splitFunc)
 	buf := make([]byte, f.BufSize)
 	fileReader.Buffer(buf, f.BufSize)
@@ -81,3 +88,239 @@ func (f *FileOps) readFileStreaming(fname string, outputChan chan<- string, spli
 func (f *FileOps) ReadFileStreaming(fname string, outputChan chan<- string, splitFunc bufio.SplitFunc) error {
 	return f.readFileStreaming(fname, outputChan, splitFunc)
 }
+
+// Chunk is a byte range of a file
+type Chunk struct {
+	Offset int64
+	Length int64
+}
+
+// SplitChunks splits a file into at most n chunks of roughly equal size.
+// Every chunk but the last one ends right after a newline, so that no
+// line is split across two chunks.
+func SplitChunks(fname string, n int) ([]Chunk, error) {
+	fileHandle, err := os.Open(fname)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file at path %s with error %v", fname, err.Error())
+	}
+	defer fileHandle.Close()
+
+	info, err := fileHandle.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file at path %s with error %v", fname, err.Error())
+	}
+	return splitRange(fileHandle, fname, 0, info.Size(), n)
+}
+
+// SplitTail splits the part of a growing file after offset like
+// SplitChunks. The tail ends right after its last newline, a last line
+// without a newline may still be being written and is left out. end is
+// the offset the next tail starts at.
+func SplitTail(fname string, offset int64, n int) (chunks []Chunk, end int64, err error) {
+	fileHandle, err := os.Open(fname)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open file at path %s with error %v", fname, err.Error())
+	}
+	defer fileHandle.Close()
+
+	info, err := fileHandle.Stat()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to stat file at path %s with error %v", fname, err.Error())
+	}
+	if info.Size() < offset {
+		return nil, 0, fmt.Errorf("file at path %s is shorter than offset %d", fname, offset)
+	}
+	end, err = lastLineEnd(fileHandle, offset, info.Size())
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read file at path %s with error %v", fname, err.Error())
+	}
+	chunks, err = splitRange(fileHandle, fname, offset, end, n)
+	return chunks, end, err
+}
+
+// splitRange splits the bytes between start and size into at most n chunks
+func splitRange(reader io.ReaderAt, fname string, start, size int64, n int) ([]Chunk, error) {
+	if n < 1 {
+		n = 1
+	}
+
+	var chunks []Chunk
+	first, length := start, size-start
+	for i := 1; i <= n && start < size; i++ {
+		end := size
+		if i < n {
+			var err error
+			end, err = nextLineStart(reader, first+length*int64(i)/int64(n), size)
+			if err != nil {
+				return nil, fmt.Errorf("failed to split file at path %s with error %v", fname, err.Error())
+			}
+		}
+		if end > start {
+			chunks = append(chunks, Chunk{Offset: start, Length: end - start})
+			start = end
+		}
+	}
+	return chunks, nil
+}
+
+// lastLineEnd returns the offset right after the last newline
+// between offset and size, or offset if there is none
+func lastLineEnd(reader io.ReaderAt, offset, size int64) (int64, error) {
+	buf := make([]byte, 4096)
+	for end := size; end > offset; {
+		pos := end - int64(len(buf))
+		if pos < offset {
+			pos = offset
+		}
+		n, err := reader.ReadAt(buf[:end-pos], pos)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return pos + int64(i) + 1, nil
+		}
+		end = pos
+	}
+	return offset, nil
+}
+
+// nextLineStart returns the offset of the first line starting at or after
+// offset, i.e. the position right after the first newline at or after offset-1
+func nextLineStart(reader io.ReaderAt, offset, size int64) (int64, error) {
+	if offset <= 0 {
+		return 0, nil
+	}
+	buf := make([]byte, 4096)
+	for pos := offset - 1; pos < size; pos += int64(len(buf)) {
+		n, err := reader.ReadAt(buf, pos)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return pos + int64(i) + 1, nil
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+// Batch is a block of whole lines backed by a reusable buffer.
+// The lines are only valid until the batch is released.
+type Batch struct {
+	Lines [][]byte
+	// Offset is the position of the first line in the file
+	Offset int64
+	data   []byte
+	pool   *sync.Pool
+}
+
+// Release hands the buffer of the batch back to the reader.
+// Neither the batch nor its lines may be used afterwards.
+func (b *Batch) Release() {
+	b.Lines = b.Lines[:0]
+	b.pool.Put(b)
+}
+
+// split cuts data into lines like bufio.ScanLines, dropping the
+// newlines and a trailing carriage return of every line
+func (b *Batch) split(data []byte) {
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		line := data
+		if i >= 0 {
+			line = data[:i]
+			data = data[i+1:]
+		} else {
+			data = nil
+		}
+		if n := len(line); n > 0 && line[n-1] == '\r' {
+			line = line[:n-1]
+		}
+		b.Lines = append(b.Lines, line)
+	}
+}
+
+// ReadChunkBatches reads the lines of a single chunk of the given file
+// and publishes them in batches of up to BufSize bytes on the output
+// channel. The buffers of released batches are reused, so that reading
+// a file allocates only a handful of buffers no matter its size. A line
+// longer than BufSize is an error.
+//
+// NOTE: Once the chunk is read, the channel is closed from
+// inside the function. DO NOT close it from outside.
+//
+// **This function is intended to be used as a Go-Routine**
+func (f *FileOps) ReadChunkBatches(fname string, chunk Chunk, outputChan chan<- *Batch) error {
+
+	defer close(outputChan)
+
+	fileHandle, err := os.Open(fname)
+	if err != nil {
+		return fmt.Errorf("failed to open file at path %s with error %v", fname, err.Error())
+	}
+	defer fileHandle.Close()
+
+	reader := io.NewSectionReader(fileHandle, chunk.Offset, chunk.Length)
+	// Small chunks don't need a full sized buffer, one byte
+	// more than the chunk makes sure to run into EOF
+	bufSize := f.BufSize
+	if chunk.Length < int64(bufSize) {
+		bufSize = int(chunk.Length) + 1
+	}
+	pool := &sync.Pool{New: func() interface{} {
+		return &Batch{data: make([]byte, bufSize)}
+	}}
+	// carry holds the beginning of a line which did not fit into the previous batch
+	var carry []byte
+	// offset is the position of the first byte of the next batch
+	offset := chunk.Offset
+
+	for {
+		batch := pool.Get().(*Batch)
+		batch.pool = pool
+		batch.Offset = offset
+		n := copy(batch.data, carry)
+		read, err := io.ReadFull(reader, batch.data[n:])
+		n += read
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			return fmt.Errorf("failed to read file at path %s with error %v", fname, err.Error())
+		}
+
+		end := n
+		if !eof {
+			end = bytes.LastIndexByte(batch.data[:n], '\n') + 1
+			if end == 0 {
+				return fmt.Errorf("shouldn't see an error scanning a string %s", bufio.ErrTooLong.Error())
+			}
+		}
+		carry = append(carry[:0], batch.data[end:n]...)
+		offset += int64(end)
+
+		batch.split(batch.data[:end])
+		if len(batch.Lines) > 0 {
+			outputChan <- batch
+		} else {
+			batch.Release()
+		}
+		if eof {
+			return nil
+		}
+	}
+}
+
+// SplitFields splits line at every sep into fields, reusing the
+// memory of dst. The fields share the memory of line.
+func SplitFields(line []byte, sep byte, dst [][]byte) [][]byte {
+	dst = dst[:0]
+	for {
+		i := bytes.IndexByte(line, sep)
+		if i < 0 {
+			return append(dst, line)
+		}
+		dst = append(dst, line[:i])
+		line = line[i+1:]
+	}
+}
@@ -24,6 +24,9 @@ package fileops
 
 import (
 	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/oklog/run"
@@ -117,3 +120,131 @@ func TestReadFileStreamingExtendedBuffer(t *testing.T) {
 	err := g.Run()
 	assert.Nil(err)
 }
+
+func TestSplitChunks(t *testing.T) {
+	assert := assert.New(t)
+
+	content := "id,name\n1,first\n22,second\n333,third\n\n4444,fourth"
+	fname := filepath.Join(t.TempDir(), "data.csv")
+	assert.Nil(os.WriteFile(fname, []byte(content), 0o600))
+
+	for n := 1; n <= 12; n++ {
+		chunks, err := SplitChunks(fname, n)
+		assert.Nil(err)
+		assert.LessOrEqual(len(chunks), n)
+
+		var joined string
+		for i, chunk := range chunks {
+			part := content[chunk.Offset : chunk.Offset+chunk.Length]
+			if i < len(chunks)-1 {
+				assert.True(strings.HasSuffix(part, "\n"), "chunk %d of %d: %q", i, n, part)
+			}
+			joined += part
+
+			var lines []string
+			outputChan := make(chan *Batch, 10)
+			go func() {
+				// Small batches so that lines get carried over between batches
+				assert.Nil(NewWithBufSize(16).ReadChunkBatches(fname, chunk, outputChan))
+			}()
+			for batch := range outputChan {
+				// The offset points at the first line of the batch
+				assert.True(strings.HasPrefix(content[batch.Offset:], string(batch.Lines[0])))
+				for _, line := range batch.Lines {
+					lines = append(lines, string(line))
+				}
+				batch.Release()
+			}
+			assert.Equal(strings.Split(strings.TrimSuffix(part, "\n"), "\n"), lines)
+		}
+		assert.Equal(content, joined)
+	}
+
+	// A single line can not be split
+	assert.Nil(os.WriteFile(fname, []byte("header1,header2,header3\n"), 0o600))
+	chunks, err := SplitChunks(fname, 4)
+	assert.Nil(err)
+	assert.Equal([]Chunk{{Offset: 0, Length: 24}}, chunks)
+
+	_, err = SplitChunks("testdata/missing.csv", 4)
+	assert.NotNil(err)
+}
+
+func TestSplitTail(t *testing.T) {
+	assert := assert.New(t)
+
+	content := "id,name\n1,first\n22,second\n333,third\n4444,fou"
+	fname := filepath.Join(t.TempDir(), "data.csv")
+	assert.Nil(os.WriteFile(fname, []byte(content), 0o600))
+
+	// The tail starts at the offset and leaves out the unfinished last line
+	offset := int64(len("id,name\n"))
+	for n := 1; n <= 4; n++ {
+		chunks, end, err := SplitTail(fname, offset, n)
+		assert.Nil(err)
+		assert.Equal(int64(strings.LastIndex(content, "\n")+1), end)
+		assert.Equal(offset, chunks[0].Offset)
+		var joined string
+		for _, chunk := range chunks {
+			joined += content[chunk.Offset : chunk.Offset+chunk.Length]
+		}
+		assert.Equal("1,first\n22,second\n333,third\n", joined)
+	}
+
+	// Nothing new to read
+	chunks, end, err := SplitTail(fname, int64(len(content)-3), 2)
+	assert.Nil(err)
+	assert.Empty(chunks)
+	assert.Equal(int64(len(content)-3), end)
+
+	// A truncated file is an error
+	_, _, err = SplitTail(fname, int64(len(content)+1), 2)
+	assert.NotNil(err)
+}
+
+func TestReadChunkBatches(t *testing.T) {
+	assert := assert.New(t)
+
+	content := "id,name\r\n1,first\n\n22,second"
+	fname := filepath.Join(t.TempDir(), "data.csv")
+	assert.Nil(os.WriteFile(fname, []byte(content), 0o600))
+	chunk := Chunk{Offset: 0, Length: int64(len(content))}
+
+	var lines []string
+	outputChan := make(chan *Batch, 10)
+	go func() {
+		assert.Nil(New().ReadChunkBatches(fname, chunk, outputChan))
+	}()
+	for batch := range outputChan {
+		for _, line := range batch.Lines {
+			lines = append(lines, string(line))
+		}
+		batch.Release()
+	}
+	assert.Equal([]string{"id,name", "1,first", "", "22,second"}, lines)
+
+	// A line longer than the buffer is an error, like with ReadFileStreaming
+	outputChan = make(chan *Batch, 10)
+	go func() {
+		for batch := range outputChan {
+			batch.Release()
+		}
+	}()
+	assert.NotNil(NewWithBufSize(8).ReadChunkBatches(fname, chunk, outputChan))
+}
+
+func TestSplitFields(t *testing.T) {
+	assert := assert.New(t)
+
+	var fields [][]byte
+	fields = SplitFields([]byte("a,bc,,d"), ',', fields)
+	assert.Equal([][]byte{[]byte("a"), []byte("bc"), []byte(""), []byte("d")}, fields)
+
+	fields = SplitFields([]byte("single"), ',', fields)
+	assert.Equal([][]byte{[]byte("single")}, fields)
+
+	line := []byte("1,2,3,4")
+	assert.Equal(0.0, testing.AllocsPerRun(100, func() {
+		fields = SplitFields(line, ',', fields)
+	}))
+}
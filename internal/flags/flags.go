@@ -23,34 +23,46 @@
 package flags
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
 	"github.com/urfave/cli/v2"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dedup"
+	"gitlab.com/ansrivas/go-analyze-git/internal/match"
+	"gitlab.com/ansrivas/go-analyze-git/internal/sketch"
+	"gitlab.com/ansrivas/go-analyze-git/internal/spill"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
 	"gitlab.com/ansrivas/go-analyze-git/pkg/events"
 )
 
 var (
+	DatasetFlag = &cli.StringFlag{
+		Name:    "dataset",
+		Usage:   "Path of a dataset.yaml manifest or of a dataset directory, supplying the input files",
+		EnvVars: []string{"DATASET"},
+	}
 	ReposFileFlag = &cli.StringFlag{
-		Name:     "repos-file",
-		Usage:    "Path to the repos.csv file",
-		EnvVars:  []string{"REPOS_FILE"},
-		Required: true,
+		Name:    "repos-file",
+		Usage:   "Path to the repos.csv file",
+		EnvVars: []string{"REPOS_FILE"},
 	}
 	EventsFileFlag = &cli.StringFlag{
-		Name:     "events-file",
-		Usage:    "Path to the events.csv file",
-		EnvVars:  []string{"EVENTS_FILE"},
-		Required: true,
+		Name:    "events-file",
+		Usage:   "Path to the events.csv file",
+		EnvVars: []string{"EVENTS_FILE"},
 	}
 	CommitsFileFlag = &cli.StringFlag{
-		Name:     "commits-file",
-		Usage:    "Path to the commits.csv file",
-		EnvVars:  []string{"COMMITS_FILE"},
-		Required: true,
+		Name:    "commits-file",
+		Usage:   "Path to the commits.csv file",
+		EnvVars: []string{"COMMITS_FILE"},
 	}
 	ActorsFileFlag = &cli.StringFlag{
-		Name:     "actors-file",
-		Usage:    "Path to the actors.csv file",
-		EnvVars:  []string{"ACTORS_FILE"},
-		Required: true,
+		Name:    "actors-file",
+		Usage:   "Path to the actors.csv file",
+		EnvVars: []string{"ACTORS_FILE"},
 	}
 	CountFlag = &cli.IntFlag{
 		Name:    "count",
@@ -58,16 +70,283 @@ var (
 		Value:   10,
 		EnvVars: []string{"COUNT"},
 	}
+	TiesFlag = &cli.StringFlag{
+		Name:    "ties",
+		Usage:   fmt.Sprintf("Rows tied with the last row of the top k, one of %q. exclude breaks the ties by name, include adds them all", utils.Ties),
+		Value:   utils.TiesExclude,
+		EnvVars: []string{"TIES"},
+	}
+	OffsetFlag = &cli.IntFlag{
+		Name:    "offset",
+		Usage:   "Number of leading rows to skip, to page through a ranking",
+		EnvVars: []string{"OFFSET"},
+	}
+	OrderFlag = &cli.StringFlag{
+		Name:    "order",
+		Usage:   fmt.Sprintf("Order of the ranking, one of %q. asc lists the least active first", utils.Orders),
+		Value:   utils.OrderDesc,
+		EnvVars: []string{"ORDER"},
+	}
+	MinFlag = &cli.IntFlag{
+		Name:    "min",
+		Usage:   "Only rank the rows with a value of at least min",
+		EnvVars: []string{"MIN"},
+	}
+	MaxFlag = &cli.IntFlag{
+		Name:    "max",
+		Usage:   "Only rank the rows with a value of at most max",
+		EnvVars: []string{"MAX"},
+	}
+	RepoIncludeFlag = &cli.StringSliceFlag{
+		Name:    "repo-include",
+		Usage:   "Only rank the repositories whose name or ID matches a glob, a regex prefixed by re: or an @file listing them",
+		EnvVars: []string{"REPO_INCLUDE"},
+	}
+	RepoExcludeFlag = &cli.StringSliceFlag{
+		Name:    "repo-exclude",
+		Usage:   "Drop the repositories whose name or ID matches a glob, a regex prefixed by re: or an @file listing them",
+		EnvVars: []string{"REPO_EXCLUDE"},
+	}
+	ExcludeBotsFlag = &cli.BoolFlag{
+		Name:    "exclude-bots",
+		Usage:   "Drop the users suspected to be bots before ranking, see user bots",
+		EnvVars: []string{"EXCLUDE_BOTS"},
+	}
+	BotsFileFlag = &cli.StringFlag{
+		Name:    "bots-file",
+		Usage:   "File allowing or denying accounts regardless of the heuristics, a line like 'allow octocat' or 'deny ci-*' each",
+		EnvVars: []string{"BOTS_FILE"},
+	}
+	BotCommitsFlag = &cli.IntFlag{
+		Name:    "bot-commits",
+		Usage:   "Number of commits no person pushes in the period of the dataset, 0 disables the heuristic",
+		Value:   1000,
+		EnvVars: []string{"BOT_COMMITS"},
+	}
+	BotRepeatedFlag = &cli.Float64Flag{
+		Name:    "bot-repeated",
+		Usage:   "Share of the commits of an account with the same message above which it is a bot, 0 disables the heuristic",
+		Value:   0.9,
+		EnvVars: []string{"BOT_REPEATED"},
+	}
+	UserIncludeFlag = &cli.StringSliceFlag{
+		Name:    "user-include",
+		Usage:   "Only rank the users whose login or ID matches a glob, a regex prefixed by re: or an @file listing them",
+		EnvVars: []string{"USER_INCLUDE"},
+	}
+	UserExcludeFlag = &cli.StringSliceFlag{
+		Name:    "user-exclude",
+		Usage:   "Drop the users whose login or ID matches a glob, a regex prefixed by re: or an @file listing them",
+		EnvVars: []string{"USER_EXCLUDE"},
+	}
 	EventTypeFlag = &cli.StringFlag{
 		Name:    "event-type",
-		Usage:   "Event type to analyze from ['WatchEvent']",
+		Usage:   "Event type to rank the repositories by, e.g. WatchEvent or ForkEvent",
 		Value:   events.Watch,
 		EnvVars: []string{"EVENT_TYPE"},
 	}
+	WorkersFlag = &cli.IntFlag{
+		Name:    "workers",
+		Usage:   "Number of workers parsing the input files in parallel",
+		Value:   runtime.NumCPU(),
+		EnvVars: []string{"WORKERS"},
+	}
+	MaxMemoryFlag = &cli.StringFlag{
+		Name:    "max-memory",
+		Usage:   "Memory budget of joining events and commits, e.g. 512MB, spilling to the temp dir once exceeded. 0 means no limit",
+		Value:   "0",
+		EnvVars: []string{"MAX_MEMORY"},
+	}
+	ApproxFlag = &cli.BoolFlag{
+		Name:    "approx",
+		Usage:   "Count approximately in a fixed amount of memory, reporting the error of every count",
+		Value:   false,
+		EnvVars: []string{"APPROX"},
+	}
+	ApproxMemoryFlag = &cli.StringFlag{
+		Name:    "approx-memory",
+		Usage:   "Memory of the sketch counting with --approx, e.g. 16MB. More memory lowers the error",
+		Value:   "16MB",
+		EnvVars: []string{"APPROX_MEMORY"},
+	}
+	HLLPrecisionFlag = &cli.IntFlag{
+		Name:    "hll-precision",
+		Usage:   fmt.Sprintf("Precision of the HyperLogLog sketches estimating distinct counts with --approx, between %d and %d. Each sketch takes up to 2^precision bytes", sketch.MinPrecision, sketch.MaxPrecision),
+		Value:   12,
+		EnvVars: []string{"HLL_PRECISION"},
+	}
+	DistinctActorsFlag = &cli.BoolFlag{
+		Name:    "distinct-actors",
+		Usage:   "Rank by the number of distinct actors instead of the number of events",
+		Value:   false,
+		EnvVars: []string{"DISTINCT_ACTORS"},
+	}
+	GroupByFlag = &cli.StringFlag{
+		Name:    "group-by",
+		Usage:   `Rank per "repo", or per "owner" summing the repositories of an organization or user namespace`,
+		Value:   "repo",
+		EnvVars: []string{"GROUP_BY"},
+	}
+	DedupFlag = &cli.StringFlag{
+		Name:    "dedup",
+		Usage:   fmt.Sprintf("Drop duplicated events by (id, type) and commits by (sha, event_id), one of %q. exact keeps every key in memory regardless of --max-memory, bloom bounds it by --dedup-memory", dedup.Modes),
+		Value:   dedup.Exact,
+		EnvVars: []string{"DEDUP"},
+	}
+	DedupMemoryFlag = &cli.StringFlag{
+		Name:    "dedup-memory",
+		Usage:   "Size of the Bloom filter of --dedup bloom, e.g. 256MB. 10 bits per unique row drop about 1% of them by mistake",
+		Value:   "64MB",
+		EnvVars: []string{"DEDUP_MEMORY"},
+	}
+	CacheDirFlag = &cli.StringFlag{
+		Name:    "cache-dir",
+		Usage:   "Directory of the columnar cache written by ingest, used as long as it is fresh. Empty disables the cache",
+		Value:   defaultCacheDir(),
+		EnvVars: []string{"CACHE_DIR"},
+	}
 	JsonFlag = &cli.BoolFlag{
 		Name:    "json",
-		Usage:   "Render the result as json",
+		Usage:   "Render the result as json, shorthand for --output json",
 		Value:   false,
 		EnvVars: []string{"JSON"},
 	}
+	OutputFlag = &cli.StringFlag{
+		Name:    "output",
+		Usage:   "Output format, one of ['table', 'json', 'openmetrics']",
+		Value:   utils.OutputTable,
+		EnvVars: []string{"OUTPUT"},
+	}
+	ListenFlag = &cli.StringFlag{
+		Name:    "listen",
+		Usage:   "Address to serve HTTP requests on",
+		Value:   ":8080",
+		EnvVars: []string{"LISTEN"},
+	}
+	ChartFlag = &cli.BoolFlag{
+		Name:    "chart",
+		Usage:   "Draw a bar chart next to each row of the table",
+		Value:   false,
+		EnvVars: []string{"CHART"},
+	}
 )
+
+// defaultCacheDir returns the cache directory of the user,
+// or no cache directory if there is none
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "go-analyze-git")
+}
+
+// OutputFormat returns the requested output format,
+// honouring the --json shorthand
+func OutputFormat(c *cli.Context) string {
+	if c.Bool(JsonFlag.Name) {
+		return utils.OutputJSON
+	}
+	return c.String(OutputFlag.Name)
+}
+
+// MaxMemory returns the --max-memory budget in bytes
+func MaxMemory(c *cli.Context) (int64, error) {
+	return spill.ParseSize(c.String(MaxMemoryFlag.Name))
+}
+
+// Counters returns the number of counters of the sketch fitting into
+// --approx-memory, or zero to count exactly without --approx
+func Counters(c *cli.Context) (int, error) {
+	if !c.Bool(ApproxFlag.Name) {
+		return 0, nil
+	}
+	size, err := spill.ParseSize(c.String(ApproxMemoryFlag.Name))
+	if err != nil {
+		return 0, err
+	}
+	if counters := size / sketch.CounterBytes; counters > 0 {
+		return int(counters), nil
+	}
+	return 1, nil
+}
+
+// Precision returns the precision of the HyperLogLog sketches
+// estimating distinct counts, or zero to count exactly without --approx
+func Precision(c *cli.Context) (int, error) {
+	if !c.Bool(ApproxFlag.Name) {
+		return 0, nil
+	}
+	precision := c.Int(HLLPrecisionFlag.Name)
+	if precision < sketch.MinPrecision || precision > sketch.MaxPrecision {
+		return 0, fmt.Errorf("--%s must be between %d and %d, got %d", HLLPrecisionFlag.Name, sketch.MinPrecision, sketch.MaxPrecision, precision)
+	}
+	return precision, nil
+}
+
+// Dedup returns the --dedup mode and the size of its Bloom filter in bytes
+func Dedup(c *cli.Context) (string, int64, error) {
+	memory, err := spill.ParseSize(c.String(DedupMemoryFlag.Name))
+	if err != nil {
+		return "", 0, err
+	}
+	mode := c.String(DedupFlag.Name)
+	// Fail before reading any file
+	if _, err := dedup.New(mode, 0); err != nil {
+		return "", 0, err
+	}
+	return mode, memory, nil
+}
+
+// Selection returns the rows of a ranking selected by --offset, --order,
+// --min, --max and --ties
+func Selection(c *cli.Context) (utils.Selection, error) {
+	sel := utils.Selection{
+		Offset: c.Int(OffsetFlag.Name),
+		Order:  c.String(OrderFlag.Name),
+		Ties:   c.String(TiesFlag.Name),
+	}
+	if c.IsSet(MinFlag.Name) {
+		min := c.Int(MinFlag.Name)
+		sel.Min = &min
+	}
+	if c.IsSet(MaxFlag.Name) {
+		max := c.Int(MaxFlag.Name)
+		sel.Max = &max
+	}
+	if err := sel.Validate(); err != nil {
+		return utils.Selection{}, fmt.Errorf("invalid selection: %w", err)
+	}
+	return sel, nil
+}
+
+// RepoFilter returns the filter of --repo-include and --repo-exclude,
+// nil if neither is set
+func RepoFilter(c *cli.Context) (*match.Filter, error) {
+	return match.New(c.StringSlice(RepoIncludeFlag.Name), c.StringSlice(RepoExcludeFlag.Name))
+}
+
+// UserFilter returns the filter of --user-include and --user-exclude,
+// nil if neither is set
+func UserFilter(c *cli.Context) (*match.Filter, error) {
+	return match.New(c.StringSlice(UserIncludeFlag.Name), c.StringSlice(UserExcludeFlag.Name))
+}
+
+// Required returns an error naming the flags which are not set. The file
+// flags are checked by the commands, once a profile had the chance to set them.
+func Required(c *cli.Context, names ...string) error {
+	var missing []string
+	for _, name := range names {
+		if c.String(name) == "" {
+			missing = append(missing, name)
+		}
+	}
+	switch len(missing) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("required flag %q not set", missing[0])
+	default:
+		return fmt.Errorf("required flags %q not set", strings.Join(missing, ", "))
+	}
+}
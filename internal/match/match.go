@@ -0,0 +1,155 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package match selects repositories and users by their name or ID.
+package match
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// RegexPrefix marks a pattern as a regular expression, any other
+// pattern is a glob where * matches any text and ? a single character
+const RegexPrefix = "re:"
+
+// FilePrefix reads the patterns from a file, one per line, like a
+// list of names or IDs. Blank lines and lines starting with # are skipped.
+const FilePrefix = "@"
+
+// Filter keeps the names or IDs matching one of its include patterns,
+// every one if there are none, and none of its exclude patterns. A nil
+// filter keeps everything.
+type Filter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// New compiles the include and exclude patterns. It returns nil if
+// there are none.
+func New(include, exclude []string) (*Filter, error) {
+	f := &Filter{}
+	var err error
+	if f.include, err = compile(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = compile(exclude); err != nil {
+		return nil, err
+	}
+	if len(f.include) == 0 && len(f.exclude) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// Enabled tells if the filter drops anything
+func (f *Filter) Enabled() bool {
+	return f != nil
+}
+
+// Match tells if the entity of the given ID and name is kept. The
+// patterns match either of them.
+func (f *Filter) Match(id, name string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.include) > 0 && !matchAny(f.include, id, name) {
+		return false
+	}
+	return !matchAny(f.exclude, id, name)
+}
+
+func matchAny(patterns []*regexp.Regexp, id, name string) bool {
+	for _, p := range patterns {
+		if p.MatchString(name) || (id != "" && p.MatchString(id)) {
+			return true
+		}
+	}
+	return false
+}
+
+// compile compiles the patterns, expanding the files they refer to
+func compile(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		if path, ok := strings.CutPrefix(pattern, FilePrefix); ok {
+			lines, err := readLines(path)
+			if err != nil {
+				return nil, err
+			}
+			for _, line := range lines {
+				p, err := Compile(line)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", path, err)
+				}
+				compiled = append(compiled, p)
+			}
+			continue
+		}
+		p, err := Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, p)
+	}
+	return compiled, nil
+}
+
+// Compile compiles a glob, or a regular expression prefixed by
+// RegexPrefix. A glob matches the whole name, a regular expression
+// any part of it unless it is anchored.
+func Compile(pattern string) (*regexp.Regexp, error) {
+	if expr, ok := strings.CutPrefix(pattern, RegexPrefix); ok {
+		p, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		return p, nil
+	}
+	// Brackets are literal, so that *[bot] matches the bot accounts
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	return regexp.Compile("^" + expr + "$")
+}
+
+// readLines returns the patterns listed in a file
+func readLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
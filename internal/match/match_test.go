@@ -0,0 +1,69 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package match
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	f, err := New(nil, nil)
+	assert.Nil(err)
+	assert.False(f.Enabled())
+	assert.True(f.Match("1", "anything"))
+
+	f, err = New([]string{"kubernetes/*"}, []string{"*[bot]", "re:(?i)-archive$"})
+	assert.Nil(err)
+	assert.True(f.Enabled())
+	assert.True(f.Match("1", "kubernetes/kubernetes"))
+	assert.False(f.Match("2", "kubernetes/dependabot[bot]"))
+	assert.False(f.Match("3", "kubernetes/docs-ARCHIVE"))
+	assert.False(f.Match("4", "helm/helm"))
+	// Brackets and dots are literal in a glob
+	assert.False(f.Match("5", "kubernetes"))
+
+	f, err = New(nil, []string{"*[bot]", "user-?"})
+	assert.Nil(err)
+	assert.False(f.Match("6", "dependabot[bot]"))
+	assert.True(f.Match("7", "botanist"))
+	assert.False(f.Match("8", "user-1"))
+	assert.True(f.Match("9", "user-12"))
+
+	// A file lists names, IDs and patterns
+	f, err = New([]string{"@testdata/repos.txt"}, nil)
+	assert.Nil(err)
+	assert.True(f.Match("1", "kubernetes/kubernetes"))
+	assert.True(f.Match("231065965", "testrepo2"))
+	assert.True(f.Match("10", "helm/charts"))
+	assert.False(f.Match("11", "kubernetes/website"))
+	assert.False(f.Match("12", "# our repositories"))
+
+	for _, patterns := range [][]string{{"re:("}, {"@testdata/missing.txt"}} {
+		_, err := New(patterns, nil)
+		assert.NotNil(err, patterns)
+	}
+}
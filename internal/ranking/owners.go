@@ -0,0 +1,79 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ranking
+
+import (
+	"strings"
+
+	"gitlab.com/ansrivas/go-analyze-git/internal/sketch"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+)
+
+// Owner returns the owner of a repository named like owner/name, the
+// whole name if it has no owner
+func Owner(repo string) string {
+	owner, _, _ := strings.Cut(repo, "/")
+	return owner
+}
+
+// topKOwners sums the counts of the repositories per owner, compared
+// case-insensitively. Repositories without a name or not matching the
+// filter are dropped.
+func topKOwners(counts *sketch.Counter, r Ranking, id func(repoID int64) string, name func(repoID int64) (string, bool)) utils.GenericDictHeap {
+	owners := make(map[string]*utils.GenericDict)
+	counts.Each(func(repoID int64, value, err int) {
+		repo, ok := name(repoID)
+		if !ok || !r.Repos.Match(id(repoID), repo) {
+			return
+		}
+		// The lowest spelling of an owner is kept, so that the
+		// key doesn't depend on the order of the counts
+		owner := Owner(repo)
+		row, ok := owners[strings.ToLower(owner)]
+		if !ok {
+			row = &utils.GenericDict{Key: owner}
+			row.SetExtra(ReposHeader, 0)
+			owners[strings.ToLower(owner)] = row
+		} else if owner < row.Key {
+			row.Key = owner
+		}
+		row.Value += value
+		row.Extra[ReposHeader]++
+		if counts.Approx() {
+			row.Extra[utils.ErrorHeader] += err
+		}
+	})
+	rows := make(utils.GenericDictHeap, 0, len(owners))
+	for _, row := range owners {
+		rows = append(rows, *row)
+	}
+	return utils.Rerank(rows, r.Count, r.Selection)
+}
+
+// lookup returns the name of a repository in names, if it has one
+func lookup(names map[int64]string) func(repoID int64) (string, bool) {
+	return func(repoID int64) (string, bool) {
+		name, ok := names[repoID]
+		return name, ok && name != ""
+	}
+}
@@ -0,0 +1,591 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package ranking computes the leaderboards of the repositories and
+// users. Every command ranking them shares its joins of the events
+// with the commits and its rules naming the rows.
+package ranking
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dataflow"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dedup"
+	"gitlab.com/ansrivas/go-analyze-git/internal/match"
+	"gitlab.com/ansrivas/go-analyze-git/internal/sketch"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/events"
+)
+
+// Analyses of a Ranking, named after their commands
+const (
+	ReposByEvents  = "topk-by-events"
+	ReposByCommits = "topk-by-commits"
+	UsersByCommits = "topk-by-pc"
+)
+
+// Groupings of the repository rankings
+const (
+	// GroupByRepo ranks every repository
+	GroupByRepo = "repo"
+	// GroupByOwner ranks the owners, the organization or user
+	// namespace of the names of the repositories
+	GroupByOwner = "owner"
+)
+
+const (
+	// ContributorsHeader is the column of the distinct actors
+	// pushing to a repository
+	ContributorsHeader = "Contributors"
+	// ReposHeader is the column of the distinct repositories of a
+	// user, or of the repositories of an owner
+	ReposHeader = "Repos"
+)
+
+// Inputs are the events, commits, repos and actors tables, only the
+// ones needed by the rankings have to be given
+type Inputs struct {
+	Events  dataflow.Input
+	Commits dataflow.Input
+	Repos   dataflow.Input
+	Actors  dataflow.Input
+	// Stats replaces the rows read and skipped by the rankings if
+	// set, like the ones of the csv files a cache was ingested from
+	Stats *utils.RunStats
+}
+
+// Ranking is a single leaderboard
+type Ranking struct {
+	Analysis string
+	// EventType is the type of the events ReposByEvents ranks the
+	// repositories by, events.Watch if empty
+	EventType string
+	// DistinctActors ranks ReposByEvents by the distinct actors
+	// of the events instead
+	DistinctActors bool
+	// GroupBy is GroupByRepo or GroupByOwner for the repository
+	// rankings, empty ranks every repository
+	GroupBy string
+	// Count is the number of rows
+	Count int
+	// Selection tells which rows of the ranking are returned
+	Selection utils.Selection
+	// Repos and Users drop the repositories and users by their name
+	// or ID before they are ranked, nil keeps every one
+	Repos *match.Filter
+	Users *match.Filter
+	// Exclude drops the users it reports true for by their ID, like
+	// the bots. Nil keeps every user.
+	Exclude func(actorID string) bool
+}
+
+// eventType returns the type of the events of the ranking
+func (r Ranking) eventType() string {
+	if r.EventType == "" {
+		return events.Watch
+	}
+	return r.EventType
+}
+
+// Validate checks the analysis and the grouping of the ranking
+func (r Ranking) Validate() error {
+	switch r.Analysis {
+	case ReposByEvents, ReposByCommits, UsersByCommits:
+	default:
+		return fmt.Errorf("unknown analysis %q, expected one of %q", r.Analysis,
+			[]string{ReposByEvents, ReposByCommits, UsersByCommits})
+	}
+	switch r.GroupBy {
+	case "", GroupByRepo:
+	case GroupByOwner:
+		if r.Analysis == UsersByCommits {
+			return fmt.Errorf("%s can't be grouped by %s", r.Analysis, GroupByOwner)
+		}
+		if r.DistinctActors {
+			return fmt.Errorf("distinct actors can't be summed per %s", GroupByOwner)
+		}
+	default:
+		return fmt.Errorf("invalid group-by %q, expected %q or %q", r.GroupBy, GroupByRepo, GroupByOwner)
+	}
+	if r.DistinctActors && r.Analysis != ReposByEvents {
+		return fmt.Errorf("distinct actors are only counted by %s", ReposByEvents)
+	}
+	return r.Selection.Validate()
+}
+
+// Result is a computed ranking
+type Result struct {
+	Rows  utils.GenericDictHeap
+	Stats utils.RunStats
+}
+
+// Engine computes rankings, reading every input only once
+// for all of them
+type Engine struct {
+	// Workers is the number of workers parsing an input in parallel
+	Workers int
+	// MaxMemory is the budget in bytes of the join state, which is
+	// spilled to disk once exceeded. Zero keeps it all in memory.
+	MaxMemory int64
+	// Counters is the number of counters of the sketch counting
+	// approximately. Zero counts exactly.
+	Counters int
+	// Precision is the precision of the HyperLogLog sketches counting
+	// distinct values approximately. Zero counts exactly.
+	Precision int
+	// Dedup is the dedup.Filter mode dropping duplicated rows,
+	// DedupMemory the size of its Bloom filter
+	Dedup       string
+	DedupMemory int64
+}
+
+// Run computes the rankings
+func (e *Engine) Run(inputs Inputs, rankings ...Ranking) ([]Result, error) {
+	start := time.Now()
+	for _, r := range rankings {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	p, err := e.newPipeline(rankings, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer p.flow.Close()
+	if err := p.scan(inputs); err != nil {
+		return nil, err
+	}
+	results, err := p.rank(inputs)
+	if err != nil {
+		return nil, err
+	}
+	duration := time.Since(start)
+	for i := range results {
+		results[i].Stats.Duration = duration
+	}
+	return results, nil
+}
+
+// byType counts the events of a type per repository
+type byType struct {
+	eventType string
+	events    *dataflow.Count
+	actors    *dataflow.Distinct
+}
+
+// pipeline holds the sinks the rankings are computed from
+type pipeline struct {
+	engine   *Engine
+	rankings []Ranking
+	flow     *dataflow.Flow
+	// incremental keeps the names of every repository and actor and
+	// the commits of events not read yet, which later updates may need
+	incremental bool
+
+	eventsFilter  *dedup.Filter
+	commitsFilter *dedup.Filter
+	types         []*byType
+	// pushes pairs the push events with their repository,
+	// contributors counts the distinct actors pushing to it
+	pushes       *dataflow.Index
+	contributors *dataflow.Distinct
+	repoCommits  *dataflow.Count
+	// userEvents pairs the push and create events with their actor,
+	// userRepos counts the distinct repositories of the actor
+	userEvents  *dataflow.Index
+	userRepos   *dataflow.Distinct
+	userCommits *dataflow.Count
+	// pending counts the commits of the events not read yet
+	pending *dataflow.Pending
+	// firstNames and lastNames hold the first and last name of the
+	// counted repositories, logins the last login of the counted users
+	firstNames *dataflow.Names
+	lastNames  *dataflow.Names
+	logins     *dataflow.Names
+	// ranked are the repositories ranked by their commits before
+	// their names are read, see preRank
+	ranked map[int64]bool
+}
+
+// newPipeline returns the sinks the rankings need. A state makes
+// the pipeline incremental, continuing from it.
+func (e *Engine) newPipeline(rankings []Ranking, state *State) (*pipeline, error) {
+	flow, err := dataflow.New(e.Workers, e.MaxMemory)
+	if err != nil {
+		return nil, err
+	}
+	p := &pipeline{engine: e, rankings: rankings, flow: flow, incremental: state != nil, ranked: make(map[int64]bool)}
+	if p.eventsFilter, err = dedup.New(e.Dedup, e.DedupMemory); err != nil {
+		return nil, err
+	}
+	if p.commitsFilter, err = dedup.New(e.Dedup, e.DedupMemory); err != nil {
+		return nil, err
+	}
+	for _, r := range rankings {
+		switch r.Analysis {
+		case ReposByEvents:
+			t := p.eventsOf(r.eventType())
+			if r.DistinctActors && t.actors == nil {
+				t.actors = dataflow.NewDistinct(dataflow.Field(3), dataflow.Field(2), e.Precision)
+			} else if !r.DistinctActors && t.events == nil {
+				t.events = dataflow.NewCount(dataflow.Field(3), e.Counters)
+			}
+		case ReposByCommits:
+			if p.pushes == nil {
+				p.pushes = dataflow.NewIndex(dataflow.Field(0), dataflow.Field(3))
+				p.contributors = dataflow.NewDistinct(dataflow.Field(3), dataflow.Field(2), e.Precision)
+				p.repoCommits = dataflow.NewCount(p.pushes.Join(-1), e.Counters)
+			}
+		case UsersByCommits:
+			if p.userEvents == nil {
+				p.userEvents = dataflow.NewIndex(dataflow.Field(0), dataflow.Field(2))
+				p.userRepos = dataflow.NewDistinct(dataflow.Field(2), dataflow.Field(3), e.Precision)
+				p.userCommits = dataflow.NewCount(p.userEvents.Join(-1), e.Counters)
+			}
+		}
+	}
+	if state != nil {
+		p.resume(state)
+	}
+	return p, nil
+}
+
+// eventsOf returns the counts of the events of the given type
+func (p *pipeline) eventsOf(eventType string) *byType {
+	for _, t := range p.types {
+		if t.eventType == eventType {
+			return t
+		}
+	}
+	t := &byType{eventType: eventType}
+	p.types = append(p.types, t)
+	return t
+}
+
+// scan reads the inputs into the sinks
+func (p *pipeline) scan(inputs Inputs) error {
+	if err := p.scanEvents(inputs.Events); err != nil {
+		return err
+	}
+	if p.repoCommits != nil || p.userCommits != nil {
+		if err := p.scanCommits(inputs.Commits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanEvents reads the events of every ranking in a single scan
+func (p *pipeline) scanEvents(in dataflow.Input) error {
+	var types []string
+	var sinks []dataflow.Sink
+	for _, t := range p.types {
+		var counts []dataflow.Sink
+		if t.events != nil {
+			counts = append(counts, t.events)
+		}
+		if t.actors != nil {
+			counts = append(counts, t.actors)
+		}
+		types = append(types, t.eventType)
+		sinks = append(sinks, dataflow.Branch([]dataflow.Op{dataflow.Where(1, t.eventType)}, counts...))
+	}
+	if p.pushes != nil {
+		types = append(types, events.Push)
+		sinks = append(sinks, dataflow.Branch([]dataflow.Op{dataflow.Where(1, events.Push)}, p.contributors, p.pushes))
+	}
+	if p.userEvents != nil {
+		types = append(types, events.Push, events.Create)
+		sinks = append(sinks, dataflow.Branch([]dataflow.Op{dataflow.Where(1, events.Push, events.Create)}, p.userRepos, p.userEvents))
+	}
+
+	dropped := p.eventsFilter.Dropped()
+	// Duplicated events share their id and type, the leading columns
+	ops := []dataflow.Op{dataflow.Where(1, types...), dataflow.Unique(p.eventsFilter, dataflow.Prefix(2))}
+	if err := p.flow.Scan(in.Source(4, ops, sinks...)); err != nil {
+		return err
+	}
+	if p.eventsFilter.Enabled() {
+		log.Debug().Msgf("Dropped %d duplicated events", p.eventsFilter.Dropped()-dropped)
+	}
+
+	// Commits read before their event are counted now
+	if p.pending != nil {
+		p.pending.Resolve(func(eventID, actorID int64, count int) {
+			p.userCommits.Counts.Add(actorID, count)
+			if repoID, ok := p.pushes.Get(eventID); ok {
+				p.repoCommits.Counts.Add(repoID, count)
+			}
+		})
+	}
+	// Every pushing repository and user is ranked, even without commits
+	if p.repoCommits != nil {
+		p.repoCommits.AddValues(p.pushes)
+	}
+	if p.userCommits != nil {
+		p.userCommits.AddValues(p.userEvents)
+	}
+	return nil
+}
+
+// scanCommits counts the commits of the events, the event id of a
+// commit is its last column. Although commits are supposed to have
+// 3 columns there is a chance they might not.
+func (p *pipeline) scanCommits(in dataflow.Input) error {
+	var ops []dataflow.Op
+	if !p.incremental {
+		ops = append(ops, dataflow.Known(-1))
+	}
+	ops = append(ops, dataflow.Unique(p.commitsFilter, dataflow.Fields(0, -1)))
+	var sinks []dataflow.Sink
+	if p.repoCommits != nil {
+		sinks = append(sinks, p.repoCommits)
+	}
+	if p.userCommits != nil {
+		sinks = append(sinks, p.userCommits)
+	}
+	if p.pending != nil {
+		sinks = append(sinks, p.pending)
+	}
+
+	dropped := p.commitsFilter.Dropped()
+	if err := p.flow.Scan(in.Source(0, ops, sinks...)); err != nil {
+		return err
+	}
+	if p.commitsFilter.Enabled() {
+		log.Debug().Msgf("Dropped %d duplicated commits", p.commitsFilter.Dropped()-dropped)
+	}
+	return nil
+}
+
+// counted reports whether one of counts counts the id
+func counted(counts ...*sketch.Counter) func(id int64) bool {
+	return func(id int64) bool {
+		for _, c := range counts {
+			if _, _, ok := c.Get(id); ok {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// every keeps the names of every ID
+func every(id int64) bool {
+	return true
+}
+
+// narrowed tells if the names of a ranking by commits are only read
+// for the repositories ranked, which needs neither a filter matching
+// the names nor the owners in the names
+func (p *pipeline) narrowed(r Ranking) bool {
+	return !p.incremental && r.Analysis == ReposByCommits && !r.Repos.Enabled() && r.GroupBy != GroupByOwner
+}
+
+// scanNames reads the names of the counted repositories and users. The
+// events rankings keep the first name of a repository, the commits
+// ranking the last one, and the last login of a user wins.
+func (p *pipeline) scanNames(inputs Inputs) error {
+	key := dataflow.KnownField(0)
+	if p.incremental {
+		key = dataflow.Field(0)
+	}
+	var repos []dataflow.Sink
+	if len(p.types) > 0 {
+		var counts []*sketch.Counter
+		for _, t := range p.types {
+			if t.events != nil {
+				counts = append(counts, t.events.Counts)
+			}
+			if t.actors != nil {
+				counts = append(counts, t.actors.Counter())
+			}
+		}
+		keep := counted(counts...)
+		if p.incremental {
+			keep = every
+		}
+		first := dataflow.FirstNames(key, 1, keep)
+		if p.firstNames != nil {
+			first.Names = p.firstNames.Names
+		}
+		p.firstNames = first
+		repos = append(repos, first)
+	}
+	if p.repoCommits != nil {
+		named := false
+		for _, r := range p.rankings {
+			named = named || (r.Analysis == ReposByCommits && !p.narrowed(r))
+		}
+		pushed := counted(p.repoCommits.Counts)
+		keep := func(repoID int64) bool {
+			return p.ranked[repoID] || (named && pushed(repoID))
+		}
+		if p.incremental {
+			keep = every
+		}
+		last := dataflow.LastNames(key, 1, keep)
+		if p.lastNames != nil {
+			last.Names = p.lastNames.Names
+		}
+		p.lastNames = last
+		repos = append(repos, last)
+	}
+	if len(repos) > 0 {
+		if err := p.flow.Scan(inputs.Repos.Source(2, nil, repos...)); err != nil {
+			return err
+		}
+	}
+	if p.userCommits != nil {
+		keep := counted(p.userCommits.Counts)
+		if p.incremental {
+			keep = every
+		}
+		logins := dataflow.LastNames(key, 1, keep)
+		if p.logins != nil {
+			logins.Names = p.logins.Names
+		}
+		p.logins = logins
+		if err := p.flow.Scan(inputs.Actors.Source(2, nil, logins)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rank ranks the counts for every ranking. The rankings by commits
+// which don't need the names of all the repositories are ranked first,
+// only the names of their rows are read.
+func (p *pipeline) rank(inputs Inputs) ([]Result, error) {
+	rows := make([]utils.GenericDictHeap, len(p.rankings))
+	for i, r := range p.rankings {
+		if p.narrowed(r) {
+			rows[i] = p.preRank(r, nil)
+			for _, gd := range rows[i] {
+				repoID, _ := p.flow.IDs.Lookup([]byte(gd.Key))
+				p.ranked[repoID] = true
+			}
+		}
+	}
+	if err := p.scanNames(inputs); err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, len(p.rankings))
+	for i, r := range p.rankings {
+		var counts *sketch.Counter
+		switch r.Analysis {
+		case ReposByEvents:
+			counts = p.eventCounts(r)
+			rows[i] = p.reposByEvents(r, counts)
+		case ReposByCommits:
+			counts = p.repoCommits.Counts
+			rows[i] = p.reposByCommits(r, rows[i])
+		case UsersByCommits:
+			counts = p.userCommits.Counts
+			rows[i] = p.usersByCommits(r)
+		}
+		results[i] = Result{Rows: rows[i], Stats: p.flow.Stats}
+		if inputs.Stats != nil {
+			results[i].Stats = *inputs.Stats
+		}
+		results[i].Stats.ErrorBound = counts.Bound()
+	}
+	return results, nil
+}
+
+// eventCounts returns the counts of the events ranking the repositories
+func (p *pipeline) eventCounts(r Ranking) *sketch.Counter {
+	t := p.eventsOf(r.eventType())
+	if r.DistinctActors {
+		return t.actors.Counter()
+	}
+	return t.events.Counts
+}
+
+// reposByEvents ranks the repositories by their events. The first
+// name of a repository wins, the ones without a name are dropped.
+func (p *pipeline) reposByEvents(r Ranking, counts *sketch.Counter) utils.GenericDictHeap {
+	names := p.firstNames.Names
+	if r.GroupBy == GroupByOwner {
+		return topKOwners(counts, r, p.flow.IDs.String, lookup(names))
+	}
+	return dataflow.TopK(counts, r.Count, r.Selection, func(repoID int64, row *utils.GenericDict) bool {
+		name, ok := names[repoID]
+		row.Key = name
+		return ok && r.Repos.Match(p.flow.IDs.String(repoID), name)
+	})
+}
+
+// preRank ranks the repositories by their commits, keyed by their ID.
+// Every row tied at the boundary is kept until the names break the
+// ties, the offset is skipped once they are. Without names the filter
+// isn't applied.
+func (p *pipeline) preRank(r Ranking, names map[int64]string) utils.GenericDictHeap {
+	ranking := r.Selection
+	ranking.Offset, ranking.Ties = 0, utils.TiesInclude
+	return dataflow.TopK(p.repoCommits.Counts, r.Selection.Offset+r.Count, ranking, func(repoID int64, row *utils.GenericDict) bool {
+		row.Key = p.flow.IDs.String(repoID)
+		contributors, _ := p.contributors.Counts.Count(repoID)
+		row.SetExtra(ContributorsHeader, contributors)
+		return names == nil || r.Repos.Match(row.Key, names[repoID])
+	})
+}
+
+// reposByCommits ranks the repositories by their commits, continuing
+// the rows of preRank if given. The last name of a repository wins,
+// the ones without a name keep their ID.
+func (p *pipeline) reposByCommits(r Ranking, rows utils.GenericDictHeap) utils.GenericDictHeap {
+	names := p.lastNames.Names
+	if r.GroupBy == GroupByOwner {
+		return topKOwners(p.repoCommits.Counts, r, p.flow.IDs.String, lookup(names))
+	}
+	if rows == nil {
+		rows = p.preRank(r, names)
+	}
+	for i, gd := range rows {
+		repoID, _ := p.flow.IDs.Lookup([]byte(gd.Key))
+		if name, ok := names[repoID]; ok && name != "" {
+			rows[i].Key = name
+		} else {
+			log.Error().Msgf("Couldn't find the reponame in cache. Keeping ID")
+		}
+	}
+	return utils.Rerank(rows, r.Count, r.Selection)
+}
+
+// usersByCommits ranks the users by their PRs created and commits
+// pushed. The last login of a user wins, the ones without a login
+// are dropped.
+func (p *pipeline) usersByCommits(r Ranking) utils.GenericDictHeap {
+	logins := p.logins.Names
+	return dataflow.TopK(p.userCommits.Counts, r.Count, r.Selection, func(userID int64, row *utils.GenericDict) bool {
+		login, ok := logins[userID]
+		row.Key = login
+		repos, _ := p.userRepos.Counts.Count(userID)
+		row.SetExtra(ReposHeader, repos)
+		id := p.flow.IDs.String(userID)
+		return ok && r.Users.Match(id, login) && (r.Exclude == nil || !r.Exclude(id))
+	})
+}
@@ -0,0 +1,223 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ranking
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dataflow"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dedup"
+	"gitlab.com/ansrivas/go-analyze-git/internal/fileops"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+)
+
+const (
+	testEvents = "1,WatchEvent,10,100\n" +
+		"2,WatchEvent,11,100\n" +
+		"2,WatchEvent,11,100\n" +
+		"3,PushEvent,10,100\n" +
+		"4,PushEvent,11,101\n" +
+		"5,CreateEvent,11,102\n"
+	testCommits = "a,one,3\n" +
+		"a,one,3\n" +
+		"b,two,3\n" +
+		"c,three,4\n" +
+		"d,four,5\n"
+	testRepos  = "100,first\n101,other\n100,renamed\n"
+	testActors = "10,alice\n11,bob\n"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	fname := filepath.Join(dir, name)
+	assert.Nil(t, os.WriteFile(fname, []byte(content), 0o600))
+	return fname
+}
+
+func testInputs(t *testing.T) Inputs {
+	dir := t.TempDir()
+	return Inputs{
+		Events:  dataflow.Input{File: writeFile(t, dir, "events.csv", testEvents)},
+		Commits: dataflow.Input{File: writeFile(t, dir, "commits.csv", testCommits)},
+		Repos:   dataflow.Input{File: writeFile(t, dir, "repos.csv", testRepos)},
+		Actors:  dataflow.Input{File: writeFile(t, dir, "actors.csv", testActors)},
+	}
+}
+
+func TestRun(t *testing.T) {
+	assert := assert.New(t)
+
+	inputs := testInputs(t)
+	rankings := []Ranking{
+		{Analysis: ReposByEvents, Count: 3},
+		{Analysis: ReposByCommits, Count: 3},
+		{Analysis: UsersByCommits, Count: 3},
+	}
+	for workers := 1; workers <= 3; workers++ {
+		engine := &Engine{Workers: workers, Dedup: dedup.Exact}
+		results, err := engine.Run(inputs, rankings...)
+		assert.Nil(err)
+
+		// The events ranking keeps the first name of a repository
+		assert.Equal(utils.GenericDictHeap{{Key: "first", Value: 2}}, results[0].Rows)
+		// The commits ranking keeps the last one
+		assert.Equal(utils.GenericDictHeap{
+			{Key: "renamed", Value: 2, Extra: map[string]int{ContributorsHeader: 1}},
+			{Key: "other", Value: 1, Extra: map[string]int{ContributorsHeader: 1}},
+		}, results[1].Rows)
+		assert.Equal(utils.GenericDictHeap{
+			{Key: "alice", Value: 2, Extra: map[string]int{ReposHeader: 1}},
+			{Key: "bob", Value: 2, Extra: map[string]int{ReposHeader: 2}},
+		}, results[2].Rows)
+		// Every file is read once for all the rankings
+		assert.Equal(results[0].Stats, results[2].Stats)
+		assert.Equal(int64(16), results[0].Stats.RowsRead)
+	}
+
+	// Without dedup the duplicated rows are counted
+	results, err := (&Engine{Workers: 2, Dedup: dedup.None}).Run(inputs, rankings[:2]...)
+	assert.Nil(err)
+	assert.Equal(utils.GenericDictHeap{{Key: "first", Value: 3}}, results[0].Rows)
+	assert.Equal(3, results[1].Rows[0].Value)
+}
+
+func TestRunExclude(t *testing.T) {
+	assert := assert.New(t)
+
+	results, err := (&Engine{Workers: 1}).Run(testInputs(t), Ranking{
+		Analysis: UsersByCommits,
+		Count:    3,
+		Exclude:  func(actorID string) bool { return actorID == "11" },
+	}, Ranking{
+		Analysis: ReposByCommits,
+		GroupBy:  GroupByOwner,
+		Count:    3,
+	})
+	assert.Nil(err)
+	assert.Equal(utils.GenericDictHeap{{Key: "alice", Value: 3, Extra: map[string]int{ReposHeader: 1}}}, results[0].Rows)
+	assert.Equal(utils.GenericDictHeap{
+		{Key: "renamed", Value: 3, Extra: map[string]int{ReposHeader: 1}},
+		{Key: "other", Value: 1, Extra: map[string]int{ReposHeader: 1}},
+	}, results[1].Rows)
+
+	_, err = (&Engine{}).Run(testInputs(t), Ranking{Analysis: UsersByCommits, GroupBy: GroupByOwner})
+	assert.NotNil(err)
+}
+
+func TestOwner(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("acme", Owner("acme/rockets"))
+	assert.Equal("acme", Owner("acme/rockets/v2"))
+	assert.Equal("loner", Owner("loner"))
+	assert.Equal("", Owner(""))
+}
+
+func TestReportHeaders(t *testing.T) {
+	assert := assert.New(t)
+
+	engine := &Engine{}
+	assert.Equal([]string{"RepoID", "Count"}, engine.Report(Ranking{Analysis: ReposByCommits}, Result{}).Headers)
+	assert.Equal([]string{"Owner", "Count"}, engine.Report(Ranking{Analysis: ReposByCommits, GroupBy: GroupByOwner}, Result{}).Headers)
+	assert.Equal([]string{"User", "Count"}, engine.Report(Ranking{Analysis: UsersByCommits}, Result{}).Headers)
+}
+
+func TestUpdate(t *testing.T) {
+	assert := assert.New(t)
+
+	inputs := testInputs(t)
+	rankings := []Ranking{
+		{Analysis: ReposByEvents, Count: 3},
+		{Analysis: ReposByEvents, Count: 3, DistinctActors: true},
+		{Analysis: ReposByCommits, Count: 3},
+		{Analysis: UsersByCommits, Count: 3},
+	}
+	engine := &Engine{Workers: 2, Dedup: dedup.Exact}
+	expected, err := engine.Run(inputs, rankings...)
+	assert.Nil(err)
+
+	// The commits come before their events, which are split in two
+	split := int64(len("1,WatchEvent,10,100\n2,WatchEvent,11,100\n"))
+	var state State
+	var results []Result
+	for _, step := range []struct {
+		events  [2]int64
+		commits [2]int64
+	}{
+		{events: [2]int64{0, split}, commits: [2]int64{0, int64(len(testCommits))}},
+		{events: [2]int64{split, int64(len(testEvents))}, commits: [2]int64{int64(len(testCommits)), int64(len(testCommits))}},
+	} {
+		tail := inputs
+		tail.Events.Chunks = []fileops.Chunk{{Offset: step.events[0], Length: step.events[1] - step.events[0]}}
+		tail.Commits.Chunks = []fileops.Chunk{{Offset: step.commits[0], Length: step.commits[1] - step.commits[0]}}
+		results, err = engine.Update(&state, tail, rankings...)
+		assert.Nil(err)
+	}
+	assert.Empty(state.Pending)
+	for i := range rankings {
+		assert.Equal(expected[i].Rows, results[i].Rows, rankings[i].Analysis)
+	}
+
+	_, err = engine.Update(&state, inputs, Ranking{Analysis: ReposByEvents, EventType: "ForkEvent"})
+	assert.NotNil(err)
+}
+
+func TestUpdatePrunesPending(t *testing.T) {
+	assert := assert.New(t)
+
+	// Every update appends a commit of an event which never shows up
+	updates := 3 * MaxPendingUpdates
+	var commits []byte
+	for i := 0; i < updates; i++ {
+		commits = fmt.Appendf(commits, "sha%d,orphan,%d\n", i, 1000+i)
+	}
+	dir := t.TempDir()
+	inputs := Inputs{
+		Events:  dataflow.Input{File: writeFile(t, dir, "events.csv", testEvents)},
+		Commits: dataflow.Input{File: writeFile(t, dir, "commits.csv", string(commits))},
+		Repos:   dataflow.Input{File: writeFile(t, dir, "repos.csv", testRepos)},
+		Actors:  dataflow.Input{File: writeFile(t, dir, "actors.csv", testActors)},
+	}
+	engine := &Engine{Workers: 2}
+	var state State
+	offset := int64(0)
+	for i := 0; i < updates; i++ {
+		line := int64(len(fmt.Sprintf("sha%d,orphan,%d\n", i, 1000+i)))
+		tail := inputs
+		if i > 0 {
+			tail.Events.Chunks = []fileops.Chunk{{Offset: int64(len(testEvents)), Length: 0}}
+		}
+		tail.Commits.Chunks = []fileops.Chunk{{Offset: offset, Length: line}}
+		offset += line
+		_, err := engine.Update(&state, tail, Ranking{Analysis: UsersByCommits})
+		assert.Nil(err)
+		assert.LessOrEqual(len(state.Pending), MaxPendingUpdates-1, "update %d", i)
+		assert.Equal(len(state.Pending), len(state.PendingUpdates), "update %d", i)
+	}
+	// The latest orphans are still waiting for their events
+	assert.Contains(state.Pending, int64(1000+updates-1))
+	assert.NotContains(state.Pending, int64(1000))
+}
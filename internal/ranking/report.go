@@ -0,0 +1,108 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ranking
+
+import (
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+)
+
+// Report describes the rows of a ranking, the same whichever
+// command computed it
+func (e *Engine) Report(r Ranking, result Result) utils.Report {
+	report := utils.Report{
+		Command: r.Analysis,
+		Rows:    result.Rows,
+		Headers: []string{"RepoID", "Count"},
+		Stats:   result.Stats,
+	}
+	switch r.Analysis {
+	case ReposByEvents:
+		report.Metric = "git_repo_events_total"
+		report.Help = "Number of events per repository."
+		report.KeyLabel = "repo"
+		report.Labels = map[string]string{"type": r.eventType()}
+		if r.GroupBy == GroupByOwner {
+			ownerReport(&report, "git_owner_events_total", "Number of events per owner of the repositories.")
+		}
+		switch {
+		case r.DistinctActors:
+			report.Headers = []string{"RepoID", "Actors"}
+			report.Metric = "git_repo_distinct_actors"
+			report.Help = "Number of distinct actors of the events per repository."
+			if e.Precision > 0 {
+				report.Estimated(e.Precision)
+			}
+		case e.Counters > 0:
+			report.Approximate()
+		}
+	case ReposByCommits:
+		report.Metric = "git_repo_commits_total"
+		report.Help = "Number of commits pushed per repository."
+		report.KeyLabel = "repo"
+		report.Columns = []utils.Column{{
+			Header: ContributorsHeader,
+			Metric: "git_repo_contributors",
+			Help:   "Number of distinct actors pushing to the repository.",
+		}}
+		if r.GroupBy == GroupByOwner {
+			ownerReport(&report, "git_owner_commits_total", "Number of commits pushed per owner of the repositories.")
+		}
+		if e.Counters > 0 {
+			report.Approximate()
+		}
+		if e.Precision > 0 && r.GroupBy != GroupByOwner {
+			report.Estimated(e.Precision)
+		}
+	case UsersByCommits:
+		report.Headers = []string{"User", "Count"}
+		report.Metric = "git_user_prs_and_commits_total"
+		report.Help = "Number of PRs created and commits pushed per user."
+		report.KeyLabel = "user"
+		report.Columns = []utils.Column{{
+			Header: ReposHeader,
+			Metric: "git_user_repos",
+			Help:   "Number of distinct repositories the user pushed to or created in.",
+		}}
+		if e.Counters > 0 {
+			report.Approximate()
+		}
+		if e.Precision > 0 {
+			report.Estimated(e.Precision)
+		}
+	}
+	return report
+}
+
+// ownerReport turns report into the report of the owners, their
+// repositories replace the other columns
+func ownerReport(report *utils.Report, metric, help string) {
+	report.Headers = []string{"Owner", "Count"}
+	report.Metric = metric
+	report.Help = help
+	report.KeyLabel = "owner"
+	report.Columns = []utils.Column{{
+		Header: ReposHeader,
+		Metric: "git_owner_repos",
+		Help:   "Number of repositories of the owner.",
+	}}
+}
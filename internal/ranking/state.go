@@ -0,0 +1,201 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ranking
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dataflow"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dedup"
+	"gitlab.com/ansrivas/go-analyze-git/internal/sketch"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/events"
+)
+
+// MaxPendingUpdates is the number of updates the commits of an event
+// which was not read yet are kept for. Their event is most likely
+// missing from the export afterwards, they are dropped so that the
+// state doesn't grow with every update.
+const MaxPendingUpdates = 10
+
+// State carries the counts over between the updates of growing
+// inputs. It counts everything a later update may rank, the events
+// ranking the repositories are the events.Watch ones. The zero State
+// starts from scratch.
+type State struct {
+	IDs           []string
+	EventsFilter  *dedup.Filter
+	CommitsFilter *dedup.Filter
+	Watches       *sketch.Counter
+	WatchActors   *sketch.Distinct
+	Pushes        dataflow.IndexState
+	Contributors  *sketch.Distinct
+	RepoCommits   *sketch.Counter
+	UserEvents    dataflow.IndexState
+	UserRepos     *sketch.Distinct
+	UserCommits   *sketch.Counter
+	// Pending counts the commits per event which was not read yet,
+	// PendingUpdates the updates they have been waiting for
+	Pending        map[int64]int
+	PendingUpdates map[int64]int
+	// FirstRepoNames and LastRepoNames hold the first and last name
+	// of every repository, Logins the last login of every actor
+	FirstRepoNames map[int64]string
+	LastRepoNames  map[int64]string
+	Logins         map[int64]string
+}
+
+// everything are the rankings a State counts for
+var everything = []Ranking{
+	{Analysis: ReposByEvents},
+	{Analysis: ReposByEvents, DistinctActors: true},
+	{Analysis: ReposByCommits},
+	{Analysis: UsersByCommits},
+}
+
+// Update computes the rankings like Run, but continues the counts of
+// the state with the inputs, which hold what was appended since the
+// last update. The state is updated in place.
+func (e *Engine) Update(state *State, inputs Inputs, rankings ...Ranking) ([]Result, error) {
+	start := time.Now()
+	if e.MaxMemory > 0 {
+		return nil, fmt.Errorf("updates keep the join state in memory")
+	}
+	if e.Counters > 0 || e.Precision > 0 {
+		return nil, fmt.Errorf("updates can only carry exact counts over")
+	}
+	for _, r := range rankings {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+		if r.Analysis == ReposByEvents && r.eventType() != events.Watch {
+			return nil, fmt.Errorf("updates only rank the repositories by %s", events.Watch)
+		}
+	}
+	p, err := e.newPipeline(everything, state)
+	if err != nil {
+		return nil, err
+	}
+	defer p.flow.Close()
+	if err := p.scan(inputs); err != nil {
+		return nil, err
+	}
+	// Every name is read, the rankings of the update don't narrow them
+	p.rankings = rankings
+	results, err := p.rank(inputs)
+	if err != nil {
+		return nil, err
+	}
+	waited := state.PendingUpdates
+	p.save(state)
+	state.prune(waited)
+	duration := time.Since(start)
+	for i := range results {
+		results[i].Stats.Duration = duration
+	}
+	return results, nil
+}
+
+// resume continues the sinks of an incremental pipeline from the state
+func (p *pipeline) resume(s *State) {
+	if s.IDs != nil {
+		p.flow.IDs = utils.NewIDsFrom(s.IDs)
+	}
+	if s.EventsFilter != nil {
+		p.eventsFilter, p.commitsFilter = s.EventsFilter, s.CommitsFilter
+	}
+	watches := p.eventsOf(events.Watch)
+	if s.Watches != nil {
+		watches.events.Counts = s.Watches
+		watches.actors.Counts = s.WatchActors
+		p.contributors.Counts = s.Contributors
+		p.repoCommits.Counts = s.RepoCommits
+		p.userRepos.Counts = s.UserRepos
+		p.userCommits.Counts = s.UserCommits
+	}
+	p.pushes.Resume(s.Pushes)
+	p.userEvents.Resume(s.UserEvents)
+	p.pending = dataflow.NewPending(p.userEvents, -1)
+	for eventID, count := range s.Pending {
+		p.pending.Counts[eventID] = count
+	}
+	p.firstNames = dataflow.FirstNames(dataflow.Field(0), 1, every)
+	p.lastNames = dataflow.LastNames(dataflow.Field(0), 1, every)
+	p.logins = dataflow.LastNames(dataflow.Field(0), 1, every)
+	for _, names := range []struct {
+		sink  *dataflow.Names
+		names map[int64]string
+	}{
+		{p.firstNames, s.FirstRepoNames},
+		{p.lastNames, s.LastRepoNames},
+		{p.logins, s.Logins},
+	} {
+		for id, name := range names.names {
+			names.sink.Names[id] = name
+		}
+	}
+}
+
+// save writes the sinks of an incremental pipeline back to the state
+func (p *pipeline) save(s *State) {
+	watches := p.eventsOf(events.Watch)
+	*s = State{
+		IDs:            p.flow.IDs.Names(),
+		EventsFilter:   p.eventsFilter,
+		CommitsFilter:  p.commitsFilter,
+		Watches:        watches.events.Counts,
+		WatchActors:    watches.actors.Counts,
+		Pushes:         p.pushes.State(),
+		Contributors:   p.contributors.Counts,
+		RepoCommits:    p.repoCommits.Counts,
+		UserEvents:     p.userEvents.State(),
+		UserRepos:      p.userRepos.Counts,
+		UserCommits:    p.userCommits.Counts,
+		Pending:        p.pending.Counts,
+		FirstRepoNames: p.firstNames.Names,
+		LastRepoNames:  p.lastNames.Names,
+		Logins:         p.logins.Names,
+	}
+}
+
+// prune drops the commits of the events which are still not read after
+// MaxPendingUpdates updates. waited holds the updates every event was
+// waiting for before the current one.
+func (s *State) prune(waited map[int64]int) {
+	s.PendingUpdates = make(map[int64]int, len(s.Pending))
+	dropped := 0
+	for eventID, count := range s.Pending {
+		updates := waited[eventID] + 1
+		if updates >= MaxPendingUpdates {
+			delete(s.Pending, eventID)
+			dropped += count
+			continue
+		}
+		s.PendingUpdates[eventID] = updates
+	}
+	if dropped > 0 {
+		log.Debug().Msgf("Dropped %d commits of events missing after %d updates", dropped, MaxPendingUpdates)
+	}
+}
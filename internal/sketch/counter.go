@@ -0,0 +1,123 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package sketch
+
+// Counter counts occurrences of int64 keys, either exactly in a map
+// or approximately in a SpaceSaving sketch of a fixed number of counters
+type Counter struct {
+	exact  map[int64]int
+	approx *SpaceSaving[int64]
+	// taken holds the keys taken from an approximate counter,
+	// which are never more than its counters
+	taken map[int64]struct{}
+}
+
+// NewCounter creates an exact counter, or an approximate
+// one with the given number of counters if it isn't zero
+func NewCounter(counters int) *Counter {
+	if counters > 0 {
+		return &Counter{approx: NewSpaceSaving[int64](counters)}
+	}
+	return &Counter{exact: make(map[int64]int)}
+}
+
+// Approx reports whether the counts are approximate
+func (c *Counter) Approx() bool {
+	return c.approx != nil
+}
+
+// Add adds n occurrences of key. Adding zero occurrences makes an
+// exact counter report the key, an approximate counter ignores it.
+func (c *Counter) Add(key int64, n int) {
+	if c.approx == nil {
+		c.exact[key] += n
+		return
+	}
+	if n != 0 {
+		c.approx.Add(key, n)
+	}
+}
+
+// Get returns the count of key and how much it may be
+// overestimated, if the key is counted
+func (c *Counter) Get(key int64) (count, err int, ok bool) {
+	if c.approx == nil {
+		count, ok = c.exact[key]
+		return count, 0, ok
+	}
+	item, ok := c.approx.Get(key)
+	return item.Count, item.Error, ok
+}
+
+// Take is Get, but reports every key only once
+func (c *Counter) Take(key int64) (count, err int, ok bool) {
+	if c.approx == nil {
+		count, ok = c.exact[key]
+		delete(c.exact, key)
+		return count, 0, ok
+	}
+	if _, taken := c.taken[key]; taken {
+		return 0, 0, false
+	}
+	count, err, ok = c.Get(key)
+	if ok {
+		if c.taken == nil {
+			c.taken = make(map[int64]struct{})
+		}
+		c.taken[key] = struct{}{}
+	}
+	return count, err, ok
+}
+
+// Merge adds the counts of other, both have to be exact or approximate
+func (c *Counter) Merge(other *Counter) {
+	if c.approx == nil {
+		for key, count := range other.exact {
+			c.exact[key] += count
+		}
+		return
+	}
+	c.approx.Merge(other.approx)
+}
+
+// Each calls fn for every counted key, in no particular order
+func (c *Counter) Each(fn func(key int64, count, err int)) {
+	if c.approx == nil {
+		for key, count := range c.exact {
+			fn(key, count, 0)
+		}
+		return
+	}
+	c.approx.Each(func(item Item[int64]) {
+		fn(item.Key, item.Count, item.Error)
+	})
+}
+
+// Bound returns how much any count is overestimated at most,
+// zero for an exact counter
+func (c *Counter) Bound() int {
+	if c.approx == nil {
+		return 0
+	}
+	return c.approx.Bound()
+}
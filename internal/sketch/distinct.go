@@ -0,0 +1,110 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package sketch
+
+// Distinct counts the distinct values per int64 key, either exactly
+// or approximately in a HyperLogLog sketch per key
+type Distinct struct {
+	precision int
+	// pairs and counts count exactly
+	pairs  map[[2]int64]struct{}
+	counts map[int64]int
+	approx map[int64]*HyperLogLog
+}
+
+// NewDistinct creates an exact distinct counter, or an approximate
+// one with sketches of the given precision if it isn't zero
+func NewDistinct(precision int) *Distinct {
+	if precision > 0 {
+		return &Distinct{precision: precision, approx: make(map[int64]*HyperLogLog)}
+	}
+	return &Distinct{pairs: make(map[[2]int64]struct{}), counts: make(map[int64]int)}
+}
+
+// Approx reports whether the counts are approximate
+func (d *Distinct) Approx() bool {
+	return d.approx != nil
+}
+
+// Add adds value to the distinct values of key
+func (d *Distinct) Add(key, value int64) {
+	if d.approx == nil {
+		pair := [2]int64{key, value}
+		if _, ok := d.pairs[pair]; !ok {
+			d.pairs[pair] = struct{}{}
+			d.counts[key]++
+		}
+		return
+	}
+	h, ok := d.approx[key]
+	if !ok {
+		h = NewHyperLogLog(d.precision)
+		d.approx[key] = h
+	}
+	h.Add(value)
+}
+
+// Count returns the number of distinct values of key, if it has any
+func (d *Distinct) Count(key int64) (int, bool) {
+	if d.approx == nil {
+		count, ok := d.counts[key]
+		return count, ok
+	}
+	h, ok := d.approx[key]
+	if !ok {
+		return 0, false
+	}
+	return h.Count(), true
+}
+
+// Merge adds the values of other, both have to be exact
+// or approximate with the same precision. other may share its
+// sketches with d afterwards, so it must not be used anymore.
+func (d *Distinct) Merge(other *Distinct) {
+	if d.approx == nil {
+		for pair := range other.pairs {
+			d.Add(pair[0], pair[1])
+		}
+		return
+	}
+	for key, h := range other.approx {
+		if mine, ok := d.approx[key]; ok {
+			mine.Merge(h)
+		} else {
+			d.approx[key] = h
+		}
+	}
+}
+
+// Each calls fn for every key, in no particular order
+func (d *Distinct) Each(fn func(key int64, count int)) {
+	if d.approx == nil {
+		for key, count := range d.counts {
+			fn(key, count)
+		}
+		return
+	}
+	for key, h := range d.approx {
+		fn(key, h.Count())
+	}
+}
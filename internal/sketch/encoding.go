@@ -0,0 +1,122 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package sketch
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// errApprox is returned persisting an approximate summary, only
+// exact ones can be carried over to later runs
+var errApprox = errors.New("approximate counts can not be persisted")
+
+// errCorrupt is returned decoding a truncated or malformed summary
+var errCorrupt = errors.New("corrupt summary")
+
+// MarshalBinary encodes the counts of an exact counter
+func (c *Counter) MarshalBinary() ([]byte, error) {
+	if c.approx != nil {
+		return nil, errApprox
+	}
+	buf := binary.AppendUvarint(nil, uint64(len(c.exact)))
+	for key, count := range c.exact {
+		buf = binary.AppendVarint(buf, key)
+		buf = binary.AppendVarint(buf, int64(count))
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary restores an exact counter encoded by MarshalBinary
+func (c *Counter) UnmarshalBinary(data []byte) error {
+	d := varints{buf: data}
+	n := d.length()
+	*c = Counter{exact: make(map[int64]int, n)}
+	for i := 0; i < n; i++ {
+		key := d.next()
+		c.exact[key] = int(d.next())
+	}
+	return d.err()
+}
+
+// MarshalBinary encodes the pairs of an exact distinct counter
+func (d *Distinct) MarshalBinary() ([]byte, error) {
+	if d.approx != nil {
+		return nil, errApprox
+	}
+	buf := binary.AppendUvarint(nil, uint64(len(d.pairs)))
+	for pair := range d.pairs {
+		buf = binary.AppendVarint(buf, pair[0])
+		buf = binary.AppendVarint(buf, pair[1])
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary restores an exact distinct counter encoded by MarshalBinary
+func (d *Distinct) UnmarshalBinary(data []byte) error {
+	v := varints{buf: data}
+	n := v.length()
+	*d = *NewDistinct(0)
+	for i := 0; i < n; i++ {
+		key := v.next()
+		d.Add(key, v.next())
+	}
+	return v.err()
+}
+
+// varints decodes a sequence of varints, remembering the first error
+type varints struct {
+	buf     []byte
+	corrupt bool
+}
+
+func (v *varints) next() int64 {
+	x, n := binary.Varint(v.buf)
+	if n <= 0 {
+		v.corrupt = true
+		v.buf = nil
+		return 0
+	}
+	v.buf = v.buf[n:]
+	return x
+}
+
+// length decodes the number of entries that follow,
+// which can not be more than the remaining bytes
+func (v *varints) length() int {
+	x, n := binary.Uvarint(v.buf)
+	if n <= 0 || x > uint64(len(v.buf)) {
+		v.corrupt = true
+		v.buf = nil
+		return 0
+	}
+	v.buf = v.buf[n:]
+	return int(x)
+}
+
+func (v *varints) err() error {
+	if v.corrupt || len(v.buf) > 0 {
+		return errCorrupt
+	}
+	return nil
+}
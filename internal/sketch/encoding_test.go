@@ -0,0 +1,67 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package sketch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounterBinary(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewCounter(0)
+	c.Add(1, 3)
+	c.Add(-2, 0)
+	c.Add(1<<40, 7)
+	data, err := c.MarshalBinary()
+	assert.Nil(err)
+
+	restored := &Counter{}
+	assert.Nil(restored.UnmarshalBinary(data))
+	assert.Equal(c, restored)
+
+	assert.NotNil(restored.UnmarshalBinary(data[:len(data)-1]))
+	_, err = NewCounter(8).MarshalBinary()
+	assert.NotNil(err)
+}
+
+func TestDistinctBinary(t *testing.T) {
+	assert := assert.New(t)
+
+	d := NewDistinct(0)
+	d.Add(1, 10)
+	d.Add(1, 11)
+	d.Add(-3, 10)
+	data, err := d.MarshalBinary()
+	assert.Nil(err)
+
+	restored := &Distinct{}
+	assert.Nil(restored.UnmarshalBinary(data))
+	assert.Equal(d, restored)
+
+	assert.NotNil(restored.UnmarshalBinary(append(data, 0)))
+	_, err = NewDistinct(12).MarshalBinary()
+	assert.NotNil(err)
+}
@@ -0,0 +1,142 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package sketch
+
+import (
+	"math"
+	"math/bits"
+)
+
+// HyperLogLog estimates the number of distinct values of a stream in
+// 2^precision bytes (Flajolet et al., 2007) with a relative standard
+// error of 1.04/sqrt(2^precision). Small sets are kept exactly until
+// they would outgrow the registers, like the sparse mode of HLL++.
+type HyperLogLog struct {
+	precision uint8
+	sparse    map[uint64]struct{}
+	registers []uint8
+}
+
+// MinPrecision and MaxPrecision bound the precision of a HyperLogLog
+const (
+	MinPrecision = 4
+	MaxPrecision = 18
+)
+
+// NewHyperLogLog creates a sketch of 2^precision registers
+func NewHyperLogLog(precision int) *HyperLogLog {
+	if precision < MinPrecision {
+		precision = MinPrecision
+	}
+	if precision > MaxPrecision {
+		precision = MaxPrecision
+	}
+	return &HyperLogLog{precision: uint8(precision), sparse: make(map[uint64]struct{})}
+}
+
+// StandardError returns the relative standard error of the
+// estimates of a sketch with the given precision
+func StandardError(precision int) float64 {
+	return 1.04 / math.Sqrt(float64(uint64(1)<<precision))
+}
+
+// Add adds a value to the sketch
+func (h *HyperLogLog) Add(value int64) {
+	h.addHash(hash(value))
+}
+
+func (h *HyperLogLog) addHash(x uint64) {
+	if h.registers == nil {
+		h.sparse[x] = struct{}{}
+		// A map entry takes about 16 bytes, a register one
+		if len(h.sparse) > (1<<h.precision)/16 {
+			h.densify()
+		}
+		return
+	}
+	index := x >> (64 - h.precision)
+	// The marker bit bounds the rank if the remaining bits are all zero
+	rank := uint8(bits.LeadingZeros64(x<<h.precision|1<<(h.precision-1))) + 1
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+// densify moves the exact set into the registers
+func (h *HyperLogLog) densify() {
+	h.registers = make([]uint8, 1<<h.precision)
+	for x := range h.sparse {
+		h.addHash(x)
+	}
+	h.sparse = nil
+}
+
+// Count returns the estimated number of distinct values,
+// which is exact as long as the sketch is sparse
+func (h *HyperLogLog) Count() int {
+	if h.registers == nil {
+		return len(h.sparse)
+	}
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	// Linear counting is more accurate for small cardinalities
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int(estimate + 0.5)
+}
+
+// Merge adds the values of other, which must have the same precision
+func (h *HyperLogLog) Merge(other *HyperLogLog) {
+	if other.registers == nil {
+		for x := range other.sparse {
+			h.addHash(x)
+		}
+		return
+	}
+	if h.registers == nil {
+		h.densify()
+	}
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+}
+
+// hash spreads the bits of value over 64 bits (splitmix64)
+func hash(value int64) uint64 {
+	x := uint64(value) + 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
@@ -0,0 +1,97 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package sketch
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	assert := assert.New(t)
+
+	// Small sets are counted exactly
+	h := NewHyperLogLog(12)
+	for i := 0; i < 200; i++ {
+		h.Add(int64(i % 100))
+	}
+	assert.Equal(100, h.Count())
+
+	// Large sets within a few standard errors
+	for _, n := range []int{1000, 20000, 200000} {
+		h := NewHyperLogLog(12)
+		for i := 0; i < n; i++ {
+			h.Add(int64(i))
+			h.Add(int64(i))
+		}
+		relative := math.Abs(float64(h.Count()-n)) / float64(n)
+		assert.Less(relative, 4*StandardError(12), "n: %d, count: %d", n, h.Count())
+	}
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, n := range []int{100, 50000} {
+		a, b, union := NewHyperLogLog(10), NewHyperLogLog(10), NewHyperLogLog(10)
+		for i := 0; i < n; i++ {
+			a.Add(int64(i))
+			b.Add(int64(i + n/2))
+			union.Add(int64(i))
+			union.Add(int64(i + n/2))
+		}
+		a.Merge(b)
+		assert.Equal(union.Count(), a.Count(), "n: %d", n)
+	}
+}
+
+func TestDistinct(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, precision := range []int{0, 12} {
+		d := NewDistinct(precision)
+		d.Add(1, 10)
+		d.Add(1, 10)
+		d.Add(1, 11)
+		other := NewDistinct(precision)
+		other.Add(1, 11)
+		other.Add(1, 12)
+		other.Add(2, 10)
+		d.Merge(other)
+		assert.Equal(precision > 0, d.Approx())
+
+		count, ok := d.Count(1)
+		assert.True(ok)
+		assert.Equal(3, count, "precision: %d", precision)
+		count, _ = d.Count(2)
+		assert.Equal(1, count)
+		_, ok = d.Count(3)
+		assert.False(ok)
+
+		keys := 0
+		d.Each(func(key int64, count int) { keys++ })
+		assert.Equal(2, keys)
+	}
+}
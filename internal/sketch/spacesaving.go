@@ -0,0 +1,191 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package sketch holds streaming summaries, which answer questions
+// about a stream approximately in a fixed amount of memory.
+package sketch
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// CounterBytes is the estimated memory used by a single counter of a
+// SpaceSaving sketch of int64 keys, including its map entry
+const CounterBytes = 64
+
+// Item is a key of a SpaceSaving sketch and its estimated count.
+// The true count lies within [Count-Error, Count].
+type Item[K comparable] struct {
+	Key   K
+	Count int
+	Error int
+}
+
+// SpaceSaving finds the most frequent keys of a stream with a fixed
+// number of counters (Metwally et al., 2005). A key missing from a full
+// sketch replaces the key with the smallest count, taking over that count
+// as its error. Every count is overestimated by at most Bound, which
+// never exceeds Total/capacity.
+type SpaceSaving[K comparable] struct {
+	capacity int
+	total    int64
+	// missing bounds the count of keys which were
+	// not tracked by the sketches merged into this one
+	missing int
+	index   map[K]int
+	items   items[K]
+}
+
+// NewSpaceSaving creates a sketch of capacity counters
+func NewSpaceSaving[K comparable](capacity int) *SpaceSaving[K] {
+	if capacity < 1 {
+		capacity = 1
+	}
+	s := &SpaceSaving[K]{capacity: capacity, index: make(map[K]int)}
+	s.items.index = s.index
+	return s
+}
+
+// Add adds n occurrences of key
+func (s *SpaceSaving[K]) Add(key K, n int) {
+	s.total += int64(n)
+	if i, ok := s.index[key]; ok {
+		s.items.list[i].Count += n
+		heap.Fix(&s.items, i)
+		return
+	}
+	if len(s.items.list) < s.capacity {
+		heap.Push(&s.items, Item[K]{Key: key, Count: s.missing + n, Error: s.missing})
+		return
+	}
+	// Replace the smallest counter
+	smallest := s.items.list[0]
+	delete(s.index, smallest.Key)
+	s.items.list[0] = Item[K]{Key: key, Count: smallest.Count + n, Error: smallest.Count}
+	s.index[key] = 0
+	heap.Fix(&s.items, 0)
+}
+
+// Get returns the estimated count and error of key, if it is tracked
+func (s *SpaceSaving[K]) Get(key K) (Item[K], bool) {
+	i, ok := s.index[key]
+	if !ok {
+		return Item[K]{}, false
+	}
+	return s.items.list[i], true
+}
+
+// Total returns the number of occurrences added to the sketch
+func (s *SpaceSaving[K]) Total() int64 {
+	return s.total
+}
+
+// Bound returns how much any count is overestimated at most. A key
+// which is not tracked occurred at most Bound times.
+func (s *SpaceSaving[K]) Bound() int {
+	if len(s.items.list) < s.capacity || s.items.list[0].Count < s.missing {
+		return s.missing
+	}
+	return s.items.list[0].Count
+}
+
+// Merge adds the counts of other, which may have another capacity
+// (Agarwal et al., Mergeable Summaries, 2012). Keys missing from one of
+// the sketches may have occurred up to its Bound times, which is added
+// to their count and error.
+func (s *SpaceSaving[K]) Merge(other *SpaceSaving[K]) {
+	sBound, otherBound := s.Bound(), other.Bound()
+	merged := make(map[K]Item[K], len(s.items.list)+len(other.items.list))
+	for _, item := range s.items.list {
+		if o, ok := other.Get(item.Key); ok {
+			item.Count += o.Count
+			item.Error += o.Error
+		} else {
+			item.Count += otherBound
+			item.Error += otherBound
+		}
+		merged[item.Key] = item
+	}
+	for _, item := range other.items.list {
+		if _, ok := merged[item.Key]; ok {
+			continue
+		}
+		item.Count += sBound
+		item.Error += sBound
+		merged[item.Key] = item
+	}
+
+	list := make([]Item[K], 0, len(merged))
+	for _, item := range merged {
+		list = append(list, item)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Count > list[j].Count })
+	if len(list) > s.capacity {
+		list = list[:s.capacity]
+	}
+
+	total := s.total + other.total
+	*s = *NewSpaceSaving[K](s.capacity)
+	s.total = total
+	s.missing = sBound + otherBound
+	for _, item := range list {
+		s.index[item.Key] = len(s.items.list)
+		s.items.list = append(s.items.list, item)
+	}
+	heap.Init(&s.items)
+}
+
+// Each calls fn for every tracked key, in no particular order
+func (s *SpaceSaving[K]) Each(fn func(item Item[K])) {
+	for _, item := range s.items.list {
+		fn(item)
+	}
+}
+
+// items is a min-heap of counts, which keeps the
+// positions of the keys in index up to date
+type items[K comparable] struct {
+	list  []Item[K]
+	index map[K]int
+}
+
+func (h items[K]) Len() int           { return len(h.list) }
+func (h items[K]) Less(i, j int) bool { return h.list[i].Count < h.list[j].Count }
+func (h items[K]) Swap(i, j int) {
+	h.list[i], h.list[j] = h.list[j], h.list[i]
+	h.index[h.list[i].Key] = i
+	h.index[h.list[j].Key] = j
+}
+
+func (h *items[K]) Push(x interface{}) {
+	item := x.(Item[K])
+	h.index[item.Key] = len(h.list)
+	h.list = append(h.list, item)
+}
+
+func (h *items[K]) Pop() interface{} {
+	item := h.list[len(h.list)-1]
+	h.list = h.list[:len(h.list)-1]
+	delete(h.index, item.Key)
+	return item
+}
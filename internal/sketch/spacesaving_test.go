@@ -0,0 +1,146 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package sketch
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// zipf returns a skewed stream of n keys, like the events of repositories
+func zipf(n int, seed int64) []int64 {
+	r := rand.New(rand.NewSource(seed))
+	z := rand.NewZipf(r, 1.2, 1, 10000)
+	stream := make([]int64, n)
+	for i := range stream {
+		stream[i] = int64(z.Uint64())
+	}
+	return stream
+}
+
+// checkBounds asserts that every true count lies within
+// the estimated count and its error
+func checkBounds(t *testing.T, s *SpaceSaving[int64], exact map[int64]int) {
+	for key, count := range exact {
+		item, ok := s.Get(key)
+		if !ok {
+			assert.LessOrEqual(t, count, s.Bound(), "key %d", key)
+			continue
+		}
+		assert.GreaterOrEqual(t, item.Count, count, "key %d", key)
+		assert.LessOrEqual(t, item.Count-item.Error, count, "key %d", key)
+		assert.LessOrEqual(t, item.Error, s.Bound(), "key %d", key)
+	}
+}
+
+func TestSpaceSaving(t *testing.T) {
+	assert := assert.New(t)
+
+	stream := zipf(20000, 1)
+	exact := make(map[int64]int)
+	s := NewSpaceSaving[int64](100)
+	for _, key := range stream {
+		exact[key]++
+		s.Add(key, 1)
+	}
+	assert.Equal(int64(len(stream)), s.Total())
+	assert.LessOrEqual(int64(s.Bound()), s.Total()/100)
+	checkBounds(t, s, exact)
+
+	// The most frequent key is found with its exact count
+	item, ok := s.Get(0)
+	assert.True(ok)
+	assert.Equal(exact[0], item.Count-item.Error)
+
+	// Without evictions the counts are exact
+	small := NewSpaceSaving[int64](10)
+	for _, key := range []int64{1, 2, 1, 3, 1, 2} {
+		small.Add(key, 1)
+	}
+	assert.Equal(0, small.Bound())
+	item, _ = small.Get(1)
+	assert.Equal(Item[int64]{Key: 1, Count: 3}, item)
+}
+
+func TestSpaceSavingMerge(t *testing.T) {
+	// Merging the sketches of shards must keep the guarantees
+	exact := make(map[int64]int)
+	merged := NewSpaceSaving[int64](100)
+	for shard := int64(0); shard < 4; shard++ {
+		s := NewSpaceSaving[int64](100)
+		for _, key := range zipf(5000, shard+10) {
+			// Shift the keys, so that every shard has some keys of its own
+			key += shard * 3
+			exact[key]++
+			s.Add(key, 1)
+		}
+		merged.Merge(s)
+	}
+	assert.Equal(t, int64(20000), merged.Total())
+	checkBounds(t, merged, exact)
+
+	// Keys added after a merge keep the guarantees as well
+	for _, key := range zipf(5000, 42) {
+		exact[key]++
+		merged.Add(key, 1)
+	}
+	checkBounds(t, merged, exact)
+}
+
+func TestCounter(t *testing.T) {
+	assert := assert.New(t)
+
+	exact := NewCounter(0)
+	exact.Add(1, 2)
+	exact.Add(2, 0)
+	other := NewCounter(0)
+	other.Add(1, 1)
+	exact.Merge(other)
+	count, err, ok := exact.Get(1)
+	assert.Equal([]interface{}{3, 0, true}, []interface{}{count, err, ok})
+	_, _, ok = exact.Get(2)
+	assert.True(ok)
+	assert.False(exact.Approx())
+
+	approx := NewCounter(2)
+	approx.Add(1, 2)
+	approx.Add(2, 0)
+	_, _, ok = approx.Get(2)
+	assert.False(ok)
+	approx.Add(2, 1)
+	approx.Add(3, 1)
+	assert.True(approx.Approx())
+	assert.Equal(2, approx.Bound())
+	count, err, ok = approx.Get(3)
+	assert.Equal([]interface{}{2, 1, true}, []interface{}{count, err, ok})
+
+	// Every key is taken only once
+	for _, c := range []*Counter{exact, approx} {
+		_, _, ok = c.Take(1)
+		assert.True(ok)
+		_, _, ok = c.Take(1)
+		assert.False(ok)
+	}
+}
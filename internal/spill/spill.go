@@ -0,0 +1,425 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package spill joins int64 keyed data sets which don't have to fit into
+// memory. Both sides of the join are collected into sorted runs, which
+// are written to files once a limit of entries is reached, and merged
+// back in key order by Join.
+package spill
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PairBytes is the estimated memory used by a pair waiting in memory
+	PairBytes = 64
+	// KeyBytes is the memory used by a key waiting in memory
+	KeyBytes = 8
+)
+
+// Limit returns how many entries of entryBytes each of workers
+// may hold in memory without exceeding budget, at least one
+func Limit(budget, entryBytes int64, workers int) int {
+	if workers < 1 {
+		workers = 1
+	}
+	limit := budget / entryBytes / int64(workers)
+	if limit < 1 {
+		return 1
+	}
+	return int(limit)
+}
+
+// ParseSize parses a size in bytes like 512MB, 2GiB or 1048576.
+// Decimal and binary units are accepted, both mean powers of 1024.
+func ParseSize(size string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"TIB", 1 << 40}, {"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+		{"B", 1},
+	}
+	factor := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			factor = unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return n * factor, nil
+}
+
+// pair is a single entry of a run, runs of keys leave value empty
+type pair struct {
+	key   int64
+	value int64
+}
+
+// run is a sequence of pairs sorted by key, either
+// kept in memory or written to a file
+type run struct {
+	pairs      []pair
+	fname      string
+	withValues bool
+}
+
+// writeRun sorts pairs and writes them to a new file in dir
+func writeRun(dir string, pairs []pair, withValues bool) (*run, error) {
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].key < pairs[j].key })
+
+	f, err := os.CreateTemp(dir, "run-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a run file in %s with error %v", dir, err.Error())
+	}
+	defer f.Close()
+
+	w := bufio.NewWriterSize(f, 64*1024)
+	var buf [16]byte
+	for _, p := range pairs {
+		binary.LittleEndian.PutUint64(buf[:8], uint64(p.key))
+		record := buf[:8]
+		if withValues {
+			binary.LittleEndian.PutUint64(buf[8:], uint64(p.value))
+			record = buf[:]
+		}
+		if _, err := w.Write(record); err != nil {
+			return nil, fmt.Errorf("failed to write run file %s with error %v", f.Name(), err.Error())
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write run file %s with error %v", f.Name(), err.Error())
+	}
+	return &run{fname: f.Name(), withValues: withValues}, nil
+}
+
+// Pairs collects key value pairs in memory and spills them into
+// a sorted run file whenever limit distinct keys are reached.
+// A pair replaces an earlier pair with the same key.
+type Pairs struct {
+	dir     string
+	limit   int
+	pending map[int64]int64
+	runs    []*run
+}
+
+// NewPairs creates an empty set of pairs spilling into dir
+func NewPairs(dir string, limit int) *Pairs {
+	if limit < 1 {
+		limit = 1
+	}
+	return &Pairs{dir: dir, limit: limit, pending: make(map[int64]int64)}
+}
+
+// Add adds a pair, spilling the pending pairs if the limit is reached
+func (p *Pairs) Add(key, value int64) error {
+	p.pending[key] = value
+	if len(p.pending) < p.limit {
+		return nil
+	}
+	return p.Flush()
+}
+
+// Pending returns the number of pairs held in memory
+func (p *Pairs) Pending() int {
+	return len(p.pending)
+}
+
+// Flush spills the pending pairs to a run file, freeing their memory
+func (p *Pairs) Flush() error {
+	if len(p.pending) == 0 {
+		return nil
+	}
+	pairs := make([]pair, 0, len(p.pending))
+	for key, value := range p.pending {
+		pairs = append(pairs, pair{key: key, value: value})
+	}
+	r, err := writeRun(p.dir, pairs, true)
+	if err != nil {
+		return err
+	}
+	p.runs = append(p.runs, r)
+	p.pending = make(map[int64]int64)
+	return nil
+}
+
+// Spilled returns the number of runs written to disk
+func (p *Pairs) Spilled() int {
+	return len(p.runs)
+}
+
+// sorted returns all the runs, the pending pairs as the last one
+func (p *Pairs) sorted() []*run {
+	pairs := make([]pair, 0, len(p.pending))
+	for key, value := range p.pending {
+		pairs = append(pairs, pair{key: key, value: value})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].key < pairs[j].key })
+	return append(p.runs[:len(p.runs):len(p.runs)], &run{pairs: pairs, withValues: true})
+}
+
+// Keys collects keys, duplicates included, in memory and spills
+// them into a sorted run file whenever limit keys are reached.
+type Keys struct {
+	dir     string
+	limit   int
+	pending []pair
+	runs    []*run
+}
+
+// NewKeys creates an empty list of keys spilling into dir
+func NewKeys(dir string, limit int) *Keys {
+	if limit < 1 {
+		limit = 1
+	}
+	return &Keys{dir: dir, limit: limit}
+}
+
+// Add adds a key, spilling the pending keys if the limit is reached
+func (k *Keys) Add(key int64) error {
+	k.pending = append(k.pending, pair{key: key})
+	if len(k.pending) < k.limit {
+		return nil
+	}
+	r, err := writeRun(k.dir, k.pending, false)
+	if err != nil {
+		return err
+	}
+	k.runs = append(k.runs, r)
+	k.pending = k.pending[:0]
+	return nil
+}
+
+// Spilled returns the number of runs written to disk
+func (k *Keys) Spilled() int {
+	return len(k.runs)
+}
+
+// sorted returns all the runs, the pending keys as the last one
+func (k *Keys) sorted() []*run {
+	sort.Slice(k.pending, func(i, j int) bool { return k.pending[i].key < k.pending[j].key })
+	return append(k.runs[:len(k.runs):len(k.runs)], &run{pairs: k.pending})
+}
+
+// cursor reads a run in key order
+type cursor struct {
+	run    *run
+	rank   int
+	file   *os.File
+	reader *bufio.Reader
+	next   int
+	cur    pair
+}
+
+func open(r *run, rank int) (*cursor, error) {
+	c := &cursor{run: r, rank: rank}
+	if r.fname != "" {
+		f, err := os.Open(r.fname)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open run file %s with error %v", r.fname, err.Error())
+		}
+		c.file = f
+		c.reader = bufio.NewReaderSize(f, 64*1024)
+	}
+	return c, nil
+}
+
+// advance moves to the next pair, it is false at the end of the run
+func (c *cursor) advance() (bool, error) {
+	if c.file == nil {
+		if c.next >= len(c.run.pairs) {
+			return false, nil
+		}
+		c.cur = c.run.pairs[c.next]
+		c.next++
+		return true, nil
+	}
+
+	var buf [16]byte
+	record := buf[:8]
+	if c.run.withValues {
+		record = buf[:]
+	}
+	if _, err := io.ReadFull(c.reader, record); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to read run file %s with error %v", c.run.fname, err.Error())
+	}
+	c.cur.key = int64(binary.LittleEndian.Uint64(buf[:8]))
+	if c.run.withValues {
+		c.cur.value = int64(binary.LittleEndian.Uint64(buf[8:]))
+	}
+	return true, nil
+}
+
+func (c *cursor) close() {
+	if c.file != nil {
+		c.file.Close()
+	}
+}
+
+// merger merges runs into a single sequence sorted by key,
+// pairs with equal keys come in the order of the runs
+type merger []*cursor
+
+func (m merger) Len() int { return len(m) }
+func (m merger) Less(i, j int) bool {
+	if m[i].cur.key != m[j].cur.key {
+		return m[i].cur.key < m[j].cur.key
+	}
+	return m[i].rank < m[j].rank
+}
+func (m merger) Swap(i, j int)       { m[i], m[j] = m[j], m[i] }
+func (m *merger) Push(x interface{}) { *m = append(*m, x.(*cursor)) }
+func (m *merger) Pop() interface{} {
+	old := *m
+	c := old[len(old)-1]
+	*m = old[:len(old)-1]
+	return c
+}
+
+func newMerger(runs []*run) (*merger, error) {
+	m := &merger{}
+	for rank, r := range runs {
+		c, err := open(r, rank)
+		if err != nil {
+			m.close()
+			return nil, err
+		}
+		ok, err := c.advance()
+		if err != nil {
+			c.close()
+			m.close()
+			return nil, err
+		}
+		if !ok {
+			c.close()
+			continue
+		}
+		*m = append(*m, c)
+	}
+	heap.Init(m)
+	return m, nil
+}
+
+// pop returns the smallest pair, it is false once all the runs are exhausted
+func (m *merger) pop() (pair, bool, error) {
+	if m.Len() == 0 {
+		return pair{}, false, nil
+	}
+	c := (*m)[0]
+	p := c.cur
+	ok, err := c.advance()
+	if err != nil {
+		return pair{}, false, err
+	}
+	if ok {
+		heap.Fix(m, 0)
+	} else {
+		heap.Pop(m)
+		c.close()
+	}
+	return p, true, nil
+}
+
+func (m *merger) close() {
+	for _, c := range *m {
+		c.close()
+	}
+}
+
+// Join calls fn with the value paired with every key of keys, once for
+// every occurrence of the key. Keys without a pair are skipped. When
+// several pairs share a key, the pair added last wins, with pairs
+// ordered like the given sets.
+func Join(pairs []*Pairs, keys []*Keys, fn func(value int64)) error {
+	var pairRuns, keyRuns []*run
+	for _, p := range pairs {
+		pairRuns = append(pairRuns, p.sorted()...)
+	}
+	for _, k := range keys {
+		keyRuns = append(keyRuns, k.sorted()...)
+	}
+
+	left, err := newMerger(pairRuns)
+	if err != nil {
+		return err
+	}
+	defer left.close()
+	right, err := newMerger(keyRuns)
+	if err != nil {
+		return err
+	}
+	defer right.close()
+
+	// current is the winning pair of the smallest key not yet passed
+	current, hasCurrent, err := left.pop()
+	if err != nil {
+		return err
+	}
+	for {
+		key, ok, err := right.pop()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		for hasCurrent && current.key < key.key {
+			current, hasCurrent, err = left.pop()
+			if err != nil {
+				return err
+			}
+		}
+		if !hasCurrent {
+			return nil
+		}
+		// Later runs replace the value of earlier ones
+		for left.Len() > 0 && (*left)[0].cur.key == current.key {
+			current, _, err = left.pop()
+			if err != nil {
+				return err
+			}
+		}
+		if current.key == key.key {
+			fn(current.value)
+		}
+	}
+}
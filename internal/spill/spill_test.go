@@ -0,0 +1,129 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package spill
+
+import (
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSize(t *testing.T) {
+	assert := assert.New(t)
+
+	for size, expected := range map[string]int64{
+		"0":       0,
+		"1048576": 1 << 20,
+		"512MB":   512 << 20,
+		"512 mb":  512 << 20,
+		"2GiB":    2 << 30,
+		"64k":     64 << 10,
+		"10B":     10,
+	} {
+		n, err := ParseSize(size)
+		assert.Nil(err, size)
+		assert.Equal(expected, n, size)
+	}
+
+	for _, size := range []string{"", "MB", "-1GB", "1.5GB", "lots"} {
+		_, err := ParseSize(size)
+		assert.NotNil(err, size)
+	}
+}
+
+// join joins in memory, like the external merge-join should
+func join(pairs [][][2]int64, keys [][]int64) []int64 {
+	values := make(map[int64]int64)
+	for _, set := range pairs {
+		for _, p := range set {
+			values[p[0]] = p[1]
+		}
+	}
+	var joined []int64
+	for _, set := range keys {
+		for _, key := range set {
+			if value, ok := values[key]; ok {
+				joined = append(joined, value)
+			}
+		}
+	}
+	sort.Slice(joined, func(i, j int) bool { return joined[i] < joined[j] })
+	return joined
+}
+
+func TestJoin(t *testing.T) {
+	assert := assert.New(t)
+
+	// Duplicated keys within and across sets, missing
+	// keys on both sides and negative keys
+	pairSets := [][][2]int64{
+		{{5, 50}, {1, 10}, {3, 30}, {5, 51}, {9, 90}, {-2, 20}},
+		{{3, 31}, {7, 70}, {1, 11}},
+		{{8, 80}, {3, 32}},
+	}
+	keySets := [][]int64{
+		{1, 3, 3, 4, 5, 9, -2},
+		{7, 7, 7, 0, 5, 8, 1, 100},
+	}
+	expected := join(pairSets, keySets)
+
+	for limit := 1; limit <= 8; limit++ {
+		dir := t.TempDir()
+		var pairs []*Pairs
+		spilled := 0
+		for _, set := range pairSets {
+			p := NewPairs(dir, limit)
+			for _, pair := range set {
+				assert.Nil(p.Add(pair[0], pair[1]))
+			}
+			if limit%2 == 0 {
+				assert.Nil(p.Flush())
+				assert.Equal(0, p.Pending())
+			}
+			spilled += p.Spilled()
+			pairs = append(pairs, p)
+		}
+		var keys []*Keys
+		for _, set := range keySets {
+			k := NewKeys(dir, limit)
+			for _, key := range set {
+				assert.Nil(k.Add(key))
+			}
+			spilled += k.Spilled()
+			keys = append(keys, k)
+		}
+
+		var joined []int64
+		assert.Nil(Join(pairs, keys, func(value int64) {
+			joined = append(joined, value)
+		}))
+		sort.Slice(joined, func(i, j int) bool { return joined[i] < joined[j] })
+		assert.Equal(expected, joined, "limit: %d", limit)
+
+		files, err := os.ReadDir(dir)
+		assert.Nil(err)
+		assert.Equal(spilled, len(files), "limit: %d", limit)
+	}
+}
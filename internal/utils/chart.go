@@ -0,0 +1,133 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package utils
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+	"github.com/olekukonko/tablewriter"
+)
+
+// barWidth is the width in cells of the longest bar in a chart
+const barWidth = 40
+
+// charset holds the glyphs used to draw bars
+type charset struct {
+	// full is the glyph of a completely filled bar cell
+	full string
+	// partial glyphs fill 1/len(partial) of a cell each, from
+	// the smallest to the largest fraction
+	partial []string
+}
+
+var (
+	unicodeCharset = charset{
+		full:    "█",
+		partial: []string{"▏", "▎", "▍", "▌", "▋", "▊", "▉"},
+	}
+	asciiCharset = charset{
+		full:    "#",
+		partial: []string{},
+	}
+)
+
+// detectCharset returns the unicode charset only if the given file is
+// a terminal and the locale advertises UTF-8, otherwise plain ASCII
+func detectCharset(f *os.File) charset {
+	if !isatty.IsTerminal(f.Fd()) && !isatty.IsCygwinTerminal(f.Fd()) {
+		return asciiCharset
+	}
+	// Same precedence as setlocale(3): the first non-empty variable wins
+	for _, env := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		locale := os.Getenv(env)
+		if locale == "" {
+			continue
+		}
+		locale = strings.ToLower(locale)
+		if strings.Contains(locale, "utf-8") || strings.Contains(locale, "utf8") {
+			return unicodeCharset
+		}
+		return asciiCharset
+	}
+	return asciiCharset
+}
+
+// bar draws a horizontal bar for value scaled against max,
+// so that max fills exactly width cells
+func (cs charset) bar(value, max, width int) string {
+	if max <= 0 || value <= 0 {
+		return ""
+	}
+	// Work in sub-cell units so that unicode bars can use partial blocks
+	steps := len(cs.partial) + 1
+	units := value * width * steps / max
+	var sb strings.Builder
+	sb.WriteString(strings.Repeat(cs.full, units/steps))
+	if rest := units % steps; rest > 0 {
+		sb.WriteString(cs.partial[rest-1])
+	}
+	return sb.String()
+}
+
+// renderChart renders the data like RenderTable, with an additional bar
+// next to each row scaled to the maximum value
+func renderChart(out io.Writer, tableData GenericDictHeap, headers []string, cs charset) {
+	max := 0
+	for _, row := range tableData {
+		if row.Value > max {
+			max = row.Value
+		}
+	}
+
+	columns := len(headers)
+	headers = append(headers[:len(headers):len(headers)], "Chart")
+
+	var data [][]string
+	for _, row := range tableData {
+		data = append(data, append(row.cells(headers[:columns]), cs.bar(row.Value, max, barWidth)))
+	}
+
+	table := tablewriter.NewWriter(out)
+	table.SetBorder(true)
+	table.SetAutoWrapText(false)
+	table.SetHeader(headers)
+	colors := make([]tablewriter.Colors, len(headers))
+	for i := range colors {
+		colors[i] = tablewriter.Colors{tablewriter.Bold}
+	}
+	table.SetHeaderColor(colors...)
+	alignments := make([]int, len(headers))
+	for i := range alignments {
+		alignments[i] = tablewriter.ALIGN_LEFT
+	}
+	for i := 1; i < columns; i++ {
+		alignments[i] = tablewriter.ALIGN_RIGHT
+	}
+	table.SetColumnAlignment(alignments)
+
+	table.AppendBulk(data)
+	table.Render()
+}
@@ -0,0 +1,60 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChartBar(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("##########", asciiCharset.bar(10, 10, 10))
+	assert.Equal("#####", asciiCharset.bar(5, 10, 10))
+	assert.Equal("", asciiCharset.bar(0, 10, 10))
+	assert.Equal("", asciiCharset.bar(5, 0, 10))
+
+	assert.Equal("████", unicodeCharset.bar(4, 4, 4))
+	// 3/8 of two cells is six eighths, i.e. 3/4 of the first cell
+	assert.Equal("▊", unicodeCharset.bar(3, 8, 2))
+	assert.Equal("█▌", unicodeCharset.bar(3, 4, 2))
+}
+
+func TestRenderChart(t *testing.T) {
+	assert := assert.New(t)
+
+	var out bytes.Buffer
+	renderChart(&out, GenericDictHeap{
+		{Key: "repo1", Value: 4},
+		{Key: "repo2", Value: 2},
+	}, []string{"RepoID", "Count"}, asciiCharset)
+
+	assert.Contains(out.String(), "CHART")
+	lines := strings.Split(out.String(), "\n")
+	assert.Contains(lines[3], strings.Repeat("#", barWidth))
+	assert.Contains(lines[4], strings.Repeat("#", barWidth/2)+" ")
+}
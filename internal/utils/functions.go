@@ -23,20 +23,62 @@
 package utils
 
 import (
-	"bufio"
+	"sync"
 
 	"github.com/rs/zerolog/log"
 	"gitlab.com/ansrivas/go-analyze-git/internal/fileops"
 )
 
-func ExecuteFunc(fname string, line chan string) func() error {
+func ExecuteChunkBatchFunc(fname string, chunk fileops.Chunk, batches chan *fileops.Batch) func() error {
 	return func() error {
 		return fileops.
-			NewWithBufSize(75*1024).
-			ReadFileStreaming(fname, line, bufio.ScanLines)
+			NewWithBufSize(256*1024).
+			ReadChunkBatches(fname, chunk, batches)
 	}
 }
 
+// ReadParallel splits fname into at most workers chunks aligned on
+// line boundaries, and hands the line batches of every chunk to its own
+// consume call. All the chunks are read and consumed concurrently,
+// the partial results are returned in the order of the chunks.
+//
+// consume has to release every batch once it is done with its lines.
+func ReadParallel[T any](fname string, workers int, consume func(batches <-chan *fileops.Batch) T) ([]T, error) {
+	chunks, err := fileops.SplitChunks(fname, workers)
+	if err != nil {
+		return nil, err
+	}
+	return ReadChunks(fname, chunks, consume)
+}
+
+// ReadChunks is ReadParallel for chunks which were already split,
+// like the tail of a file returned by fileops.SplitTail
+func ReadChunks[T any](fname string, chunks []fileops.Chunk, consume func(batches <-chan *fileops.Batch) T) ([]T, error) {
+	var wg sync.WaitGroup
+	errs := make([]error, len(chunks))
+	partials := make([]T, len(chunks))
+	for i, chunk := range chunks {
+		batches := make(chan *fileops.Batch, 4)
+		wg.Add(2)
+		go func(i int, chunk fileops.Chunk) {
+			defer wg.Done()
+			errs[i] = ExecuteChunkBatchFunc(fname, chunk, batches)()
+		}(i, chunk)
+		go func(i int) {
+			defer wg.Done()
+			partials[i] = consume(batches)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return partials, nil
+}
+
 func InterruptFunc(msg string) func(err error) {
 	return func(err error) {
 		if err != nil {
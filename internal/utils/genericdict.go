@@ -23,21 +23,36 @@
 package utils
 
 import (
+	"container/heap"
 	"encoding/json"
+	"fmt"
 	"io"
+	"sort"
+
+	"gitlab.com/ansrivas/go-analyze-git/internal/sketch"
 )
 
 // GenericDict represents a generic map represented as key, value
 type GenericDict struct {
 	Key   string `json:"Key"`
 	Value int    `json:"Value"`
+	// Extra holds further values of the row by column header,
+	// rendered as additional columns after Value
+	Extra map[string]int `json:"Extra,omitempty"`
 }
 
+// GenericDictHeap is a min-heap of rows. Rows of equal value are
+// ordered by their key, the greater key ranks lower.
 type GenericDictHeap []GenericDict
 
-func (g GenericDictHeap) Len() int           { return len(g) }
-func (g GenericDictHeap) Less(i, j int) bool { return g[i].Value < g[j].Value }
-func (g GenericDictHeap) Swap(i, j int)      { g[i], g[j] = g[j], g[i] }
+func (g GenericDictHeap) Len() int { return len(g) }
+func (g GenericDictHeap) Less(i, j int) bool {
+	if g[i].Value != g[j].Value {
+		return g[i].Value < g[j].Value
+	}
+	return g[i].Key > g[j].Key
+}
+func (g GenericDictHeap) Swap(i, j int) { g[i], g[j] = g[j], g[i] }
 
 func (g GenericDictHeap) Take(count int) GenericDictHeap {
 	if count < len(g) {
@@ -62,6 +77,221 @@ func (g *GenericDictHeap) Pop() interface{} {
 	return x
 }
 
+// TopK returns the count entries of counts with the highest values,
+// highest first, as selected by sel. Keys are translated with rename
+// before they are ranked, and dropped if rename reports false.
+func TopK(counts map[string]int, count int, sel Selection, rename func(key string) (string, bool)) GenericDictHeap {
+	return TopKBy(counts, count, sel, rename)
+}
+
+// TopKBy is TopK for counts of any key type
+func TopKBy[K comparable](counts map[K]int, count int, sel Selection, rename func(key K) (string, bool)) GenericDictHeap {
+	r := NewRanker(count, sel)
+	for key, value := range counts {
+		if !r.Admits(value) {
+			continue
+		}
+		if name, ok := rename(key); ok {
+			r.Push(GenericDict{Key: name, Value: value})
+		}
+	}
+	return r.Rows()
+}
+
+// CounterRow returns the row of a count of counts
+func CounterRow(counts *sketch.Counter, key string, value, err int) GenericDict {
+	row := GenericDict{Key: key, Value: value}
+	if counts.Approx() {
+		row.Extra = map[string]int{ErrorHeader: err}
+	}
+	return row
+}
+
+// SetExtra sets the value of the column header
+func (g *GenericDict) SetExtra(header string, value int) {
+	if g.Extra == nil {
+		g.Extra = make(map[string]int)
+	}
+	g.Extra[header] = value
+}
+
+// Rerank ranks rows again, once their keys changed
+func Rerank(rows GenericDictHeap, count int, sel Selection) GenericDictHeap {
+	r := NewRanker(count, sel)
+	for _, row := range rows {
+		r.Push(row)
+	}
+	return r.Rows()
+}
+
+// Handling of the rows tied with the last row of a top k
+const (
+	// TiesExclude cuts the top k at k rows, ties are broken by their key
+	TiesExclude = "exclude"
+	// TiesInclude adds every row tied with the last row of the top k
+	TiesInclude = "include"
+)
+
+// Ties lists the handlings of ties
+var Ties = []string{TiesExclude, TiesInclude}
+
+// Orders of a ranking
+const (
+	// OrderDesc ranks the highest values first
+	OrderDesc = "desc"
+	// OrderAsc ranks the lowest values first
+	OrderAsc = "asc"
+)
+
+// Orders lists the orders of a ranking
+var Orders = []string{OrderDesc, OrderAsc}
+
+// Selection tells which rows of a ranking are returned. The zero
+// value selects the highest rows, cutting ties by their key.
+type Selection struct {
+	// Offset is the number of leading rows skipped
+	Offset int `yaml:"offset" json:"offset,omitempty"`
+	// Order is one of Orders, desc if empty
+	Order string `yaml:"order" json:"order,omitempty"`
+	// Min and Max bound the values of the rows, if set
+	Min *int `yaml:"min" json:"min,omitempty"`
+	Max *int `yaml:"max" json:"max,omitempty"`
+	// Ties is one of Ties, exclude if empty
+	Ties string `yaml:"ties" json:"ties,omitempty"`
+}
+
+// Validate returns an error if the selection can't be applied
+func (s Selection) Validate() error {
+	if s.Offset < 0 {
+		return fmt.Errorf("offset %d is negative", s.Offset)
+	}
+	if s.Order != "" && !contains(Orders, s.Order) {
+		return fmt.Errorf("unknown order %q, expected one of %q", s.Order, Orders)
+	}
+	if s.Ties != "" && !contains(Ties, s.Ties) {
+		return fmt.Errorf("unknown ties %q, expected one of %q", s.Ties, Ties)
+	}
+	if s.Min != nil && s.Max != nil && *s.Min > *s.Max {
+		return fmt.Errorf("min %d is greater than max %d", *s.Min, *s.Max)
+	}
+	return nil
+}
+
+// InRange tells if a row of the given value is within the bounds
+func (s Selection) InRange(value int) bool {
+	return (s.Min == nil || value >= *s.Min) && (s.Max == nil || value <= *s.Max)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// rankHeap keeps the worst of the ranked rows at its root. Rows of equal
+// value rank by their key in either order, the greater key is worse.
+type rankHeap struct {
+	GenericDictHeap
+	asc bool
+}
+
+func (h rankHeap) Less(i, j int) bool {
+	if h.asc && h.GenericDictHeap[i].Value != h.GenericDictHeap[j].Value {
+		return h.GenericDictHeap[i].Value > h.GenericDictHeap[j].Value
+	}
+	return h.GenericDictHeap.Less(i, j)
+}
+
+// Ranker keeps the offset+count best rows pushed to it, the highest ones
+// or the lowest ones in ascending order, without sorting all of them.
+// Rows of equal value rank by their key, so that the top k doesn't depend
+// on the order of the rows. With TiesInclude it also keeps the rows tied
+// with the last of them, which would be cut off otherwise.
+type Ranker struct {
+	sel   Selection
+	count int
+	heap  rankHeap
+	// tied are the rows cut off with the value of the root of the heap
+	tied []GenericDict
+}
+
+// NewRanker returns a ranker keeping the count best rows after the
+// offset of sel
+func NewRanker(count int, sel Selection) *Ranker {
+	r := &Ranker{sel: sel, heap: rankHeap{asc: sel.Order == OrderAsc}}
+	if count > 0 {
+		r.count = sel.Offset + count
+	}
+	return r
+}
+
+// InRange tells if a row of the given value is within the bounds of the
+// selection
+func (r *Ranker) InRange(value int) bool {
+	return r.sel.InRange(value)
+}
+
+// Admits tells if a row of the given value may still enter the top k,
+// so that naming the rows which can't is skipped
+func (r *Ranker) Admits(value int) bool {
+	if r.count <= 0 || !r.InRange(value) {
+		return false
+	}
+	if r.heap.Len() < r.count {
+		return true
+	}
+	if r.heap.asc {
+		return value <= r.heap.GenericDictHeap[0].Value
+	}
+	return value >= r.heap.GenericDictHeap[0].Value
+}
+
+// Push offers a row to the top k
+func (r *Ranker) Push(row GenericDict) {
+	if r.count <= 0 || !r.InRange(row.Value) {
+		return
+	}
+	heap.Push(&r.heap, row)
+	if r.heap.Len() <= r.count {
+		return
+	}
+	cut := heap.Pop(&r.heap).(GenericDict)
+	if r.sel.Ties != TiesInclude {
+		return
+	}
+	last := r.heap.GenericDictHeap[0].Value
+	if len(r.tied) > 0 && r.tied[0].Value != last {
+		r.tied = r.tied[:0]
+	}
+	if cut.Value == last {
+		r.tied = append(r.tied, cut)
+	}
+}
+
+// Rows returns the top k after the offset, best first
+func (r *Ranker) Rows() GenericDictHeap {
+	result := make(GenericDictHeap, r.heap.Len(), r.heap.Len()+len(r.tied))
+	for i := len(result); i > 0; i-- {
+		result[i-1] = heap.Pop(&r.heap).(GenericDict)
+	}
+	if r.sel.Offset < len(result) {
+		result = result[r.sel.Offset:]
+	} else {
+		result = result[:0]
+	}
+	// Ties only extend the end of the window after the offset, never
+	// a cut within the rows skipped
+	if len(result) == 0 || len(r.tied) == 0 || r.tied[0].Value != result[len(result)-1].Value {
+		return result
+	}
+	tied := GenericDictHeap(r.tied)
+	sort.Slice(tied, func(i, j int) bool { return tied[i].Key < tied[j].Key })
+	return append(result, tied...)
+}
+
 // Write the generated json to output
 func (g GenericDictHeap) ToJson(out io.Writer) error {
 	payload, err := json.MarshalIndent(g, "", "    ")
@@ -24,6 +24,7 @@ package utils
 
 import (
 	"container/heap"
+	"math/rand"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -37,7 +38,7 @@ func TestGenericDictListMaxHeap(t *testing.T) {
 	heap.Init(h)
 	maxCount := 3
 	push := func(key string, value int) {
-		heap.Push(h, GenericDict{key, value})
+		heap.Push(h, GenericDict{Key: key, Value: value})
 		if h.Len() > maxCount {
 			heap.Pop(h)
 		}
@@ -62,3 +63,92 @@ func TestGenericDictListMaxHeap(t *testing.T) {
 		i += 1
 	}
 }
+
+func TestRankerTies(t *testing.T) {
+	assert := assert.New(t)
+
+	rows := GenericDictHeap{
+		{Key: "e", Value: 2}, {Key: "a", Value: 5}, {Key: "d", Value: 3},
+		{Key: "c", Value: 3}, {Key: "b", Value: 3}, {Key: "f", Value: 1},
+	}
+	expected := map[string]GenericDictHeap{
+		TiesExclude: {{Key: "a", Value: 5}, {Key: "b", Value: 3}},
+		TiesInclude: {{Key: "a", Value: 5}, {Key: "b", Value: 3}, {Key: "c", Value: 3}, {Key: "d", Value: 3}},
+	}
+
+	// The top k doesn't depend on the order of the rows
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		random.Shuffle(len(rows), rows.Swap)
+		for _, ties := range Ties {
+			assert.Equal(expected[ties], Rerank(rows, 2, Selection{Ties: ties}), "ties: %s, rows: %v", ties, rows)
+		}
+	}
+
+	// Ties cut off earlier are forgotten once a higher row enters
+	r := NewRanker(1, Selection{Ties: TiesInclude})
+	for _, row := range []GenericDict{{Key: "x", Value: 1}, {Key: "y", Value: 1}, {Key: "z", Value: 2}} {
+		r.Push(row)
+	}
+	assert.Equal(GenericDictHeap{{Key: "z", Value: 2}}, r.Rows())
+
+	assert.Empty(Rerank(rows, 0, Selection{Ties: TiesInclude}))
+	assert.Equal(GenericDictHeap{{Key: "a", Value: 5}, {Key: "b", Value: 3}, {Key: "c", Value: 3}, {Key: "d", Value: 3}, {Key: "e", Value: 2}, {Key: "f", Value: 1}},
+		Rerank(rows, 10, Selection{Ties: TiesExclude}))
+}
+
+func TestRankerSelection(t *testing.T) {
+	assert := assert.New(t)
+
+	rows := GenericDictHeap{
+		{Key: "e", Value: 2}, {Key: "a", Value: 5}, {Key: "d", Value: 3},
+		{Key: "c", Value: 3}, {Key: "b", Value: 3}, {Key: "f", Value: 1},
+	}
+	bound := func(value int) *int { return &value }
+	tests := []struct {
+		sel      Selection
+		count    int
+		expected GenericDictHeap
+	}{
+		// Pages of two rows
+		{Selection{}, 2, GenericDictHeap{{Key: "a", Value: 5}, {Key: "b", Value: 3}}},
+		{Selection{Offset: 2}, 2, GenericDictHeap{{Key: "c", Value: 3}, {Key: "d", Value: 3}}},
+		{Selection{Offset: 4}, 2, GenericDictHeap{{Key: "e", Value: 2}, {Key: "f", Value: 1}}},
+		{Selection{Offset: 6}, 2, GenericDictHeap{}},
+		// The bottom of the list, ties still rank by key
+		{Selection{Order: OrderAsc}, 3, GenericDictHeap{{Key: "f", Value: 1}, {Key: "e", Value: 2}, {Key: "b", Value: 3}}},
+		{Selection{Order: OrderAsc, Ties: TiesInclude}, 3, GenericDictHeap{{Key: "f", Value: 1}, {Key: "e", Value: 2}, {Key: "b", Value: 3}, {Key: "c", Value: 3}, {Key: "d", Value: 3}}},
+		{Selection{Order: OrderAsc, Offset: 1}, 2, GenericDictHeap{{Key: "e", Value: 2}, {Key: "b", Value: 3}}},
+		// Ties extend the end of the page after the offset only
+		{Selection{Offset: 1, Ties: TiesInclude}, 1, GenericDictHeap{{Key: "b", Value: 3}, {Key: "c", Value: 3}, {Key: "d", Value: 3}}},
+		{Selection{Offset: 2, Ties: TiesInclude}, 1, GenericDictHeap{{Key: "c", Value: 3}, {Key: "d", Value: 3}}},
+		{Selection{Offset: 2, Ties: TiesInclude}, 2, GenericDictHeap{{Key: "c", Value: 3}, {Key: "d", Value: 3}}},
+		{Selection{Offset: 3, Ties: TiesInclude}, 1, GenericDictHeap{{Key: "d", Value: 3}}},
+		{Selection{Offset: 3, Ties: TiesInclude}, 2, GenericDictHeap{{Key: "d", Value: 3}, {Key: "e", Value: 2}}},
+		{Selection{Offset: 1, Ties: TiesInclude}, 5, GenericDictHeap{{Key: "b", Value: 3}, {Key: "c", Value: 3}, {Key: "d", Value: 3}, {Key: "e", Value: 2}, {Key: "f", Value: 1}}},
+		{Selection{Offset: 6, Ties: TiesInclude}, 1, GenericDictHeap{}},
+		// Thresholds apply before the offset
+		{Selection{Min: bound(2), Max: bound(3)}, 10, GenericDictHeap{{Key: "b", Value: 3}, {Key: "c", Value: 3}, {Key: "d", Value: 3}, {Key: "e", Value: 2}}},
+		{Selection{Min: bound(3), Offset: 1}, 10, GenericDictHeap{{Key: "b", Value: 3}, {Key: "c", Value: 3}, {Key: "d", Value: 3}}},
+		{Selection{Max: bound(2), Order: OrderAsc}, 10, GenericDictHeap{{Key: "f", Value: 1}, {Key: "e", Value: 2}}},
+	}
+	random := rand.New(rand.NewSource(1))
+	for _, tt := range tests {
+		random.Shuffle(len(rows), rows.Swap)
+		assert.Equal(tt.expected, Rerank(rows, tt.count, tt.sel), "selection: %+v", tt.sel)
+	}
+
+	r := NewRanker(2, Selection{Order: OrderAsc, Max: bound(4)})
+	assert.True(r.Admits(3))
+	assert.False(r.Admits(5))
+	r.Push(GenericDict{Key: "x", Value: 1})
+	r.Push(GenericDict{Key: "y", Value: 2})
+	assert.False(r.Admits(3))
+	assert.True(r.Admits(2))
+
+	assert.NoError(Selection{Order: OrderAsc, Ties: TiesInclude}.Validate())
+	assert.Error(Selection{Offset: -1}.Validate())
+	assert.Error(Selection{Order: "up"}.Validate())
+	assert.Error(Selection{Ties: "all"}.Validate())
+	assert.Error(Selection{Min: bound(3), Max: bound(2)}.Validate())
+}
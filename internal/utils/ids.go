@@ -0,0 +1,114 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package utils
+
+import (
+	"strconv"
+	"sync"
+)
+
+// IDs turns the IDs of the input files into int64 keys, which take
+// a fraction of the memory of string keys in the caches. Numeric IDs
+// are used as they are, every other ID is stored once in a dictionary
+// and represented by a negative number. IDs is safe for concurrent use,
+// only the fallback for non-numeric IDs takes a lock.
+type IDs struct {
+	mu    sync.RWMutex
+	index map[string]int64
+	names []string
+}
+
+// NewIDs creates an empty dictionary of IDs
+func NewIDs() *IDs {
+	return &IDs{index: make(map[string]int64)}
+}
+
+// NewIDsFrom restores a dictionary from the names returned by Names
+func NewIDsFrom(names []string) *IDs {
+	d := &IDs{index: make(map[string]int64, len(names)), names: names}
+	for i, name := range names {
+		d.index[name] = -int64(i + 1)
+	}
+	return d
+}
+
+// Names returns the non-numeric IDs in the order of their keys
+func (d *IDs) Names() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return append([]string(nil), d.names...)
+}
+
+// parseNumeric parses a canonical non-negative decimal number. Leading
+// zeros and signs are rejected, so that every number has a single spelling.
+func parseNumeric(b []byte) (int64, bool) {
+	if len(b) == 0 || len(b) > 18 || (b[0] == '0' && len(b) > 1) {
+		return 0, false
+	}
+	var n int64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int64(c-'0')
+	}
+	return n, true
+}
+
+// Parse returns the key of id, adding it to the dictionary if it is not numeric
+func (d *IDs) Parse(id []byte) int64 {
+	if n, ok := d.Lookup(id); ok {
+		return n
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if n, ok := d.index[string(id)]; ok {
+		return n
+	}
+	d.names = append(d.names, string(id))
+	n := -int64(len(d.names))
+	d.index[d.names[len(d.names)-1]] = n
+	return n
+}
+
+// Lookup returns the key of id without adding it to the dictionary.
+// It is false for a non-numeric id which was never parsed.
+func (d *IDs) Lookup(id []byte) (int64, bool) {
+	if n, ok := parseNumeric(id); ok {
+		return n, true
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	n, ok := d.index[string(id)]
+	return n, ok
+}
+
+// String returns the ID a key was parsed from
+func (d *IDs) String(key int64) string {
+	if key >= 0 {
+		return strconv.FormatInt(key, 10)
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.names[-key-1]
+}
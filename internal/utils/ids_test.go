@@ -0,0 +1,63 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIDs(t *testing.T) {
+	assert := assert.New(t)
+
+	ids := NewIDs()
+	assert.Equal(int64(0), ids.Parse([]byte("0")))
+	assert.Equal(int64(4229624), ids.Parse([]byte("4229624")))
+
+	// Non-numeric IDs, and numbers with a second spelling,
+	// fall back to the dictionary
+	for _, id := range []string{"abc", "007", "-1", "+1", "", "12345678901234567890"} {
+		key := ids.Parse([]byte(id))
+		assert.Less(key, int64(0), id)
+		assert.Equal(key, ids.Parse([]byte(id)), id)
+		assert.Equal(id, ids.String(key))
+	}
+	assert.Equal("4229624", ids.String(4229624))
+
+	_, ok := ids.Lookup([]byte("unknown"))
+	assert.False(ok)
+	key, ok := ids.Lookup([]byte("abc"))
+	assert.True(ok)
+	assert.Equal(int64(-1), key)
+
+	restored := NewIDsFrom(ids.Names())
+	for _, id := range []string{"abc", "007", "4229624"} {
+		assert.Equal(ids.Parse([]byte(id)), restored.Parse([]byte(id)), id)
+	}
+
+	id := []byte("4229624")
+	assert.Equal(0.0, testing.AllocsPerRun(100, func() {
+		ids.Parse(id)
+	}))
+}
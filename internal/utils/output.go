@@ -0,0 +1,210 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gitlab.com/ansrivas/go-analyze-git/internal/sketch"
+)
+
+// Supported output formats
+const (
+	OutputTable       = "table"
+	OutputJSON        = "json"
+	OutputOpenMetrics = "openmetrics"
+)
+
+// RunStats holds the metadata of a single analysis run
+type RunStats struct {
+	RowsRead    int64
+	RowsSkipped int64
+	Duration    time.Duration
+	// ErrorBound is how much approximate counts are overestimated at most
+	ErrorBound int
+}
+
+// Add adds the row counts of other to s
+func (s *RunStats) Add(other RunStats) {
+	s.RowsRead += other.RowsRead
+	s.RowsSkipped += other.RowsSkipped
+}
+
+// Report bundles a leaderboard with everything needed to render it
+// in any of the supported output formats
+type Report struct {
+	// Command is the name of the command which produced the report
+	Command string
+	Rows    GenericDictHeap
+	Headers []string
+	// Metric is the openmetrics name of the ranked value
+	Metric string
+	// Help is the openmetrics HELP text of Metric
+	Help string
+	// KeyLabel is the openmetrics label holding the row key
+	KeyLabel string
+	// Labels are constant labels added to every sample of Metric
+	Labels map[string]string
+	// Columns describe the values of GenericDict.Extra shown
+	// next to the value of every row
+	Columns []Column
+	// Note is printed below the table
+	Note  string
+	Stats RunStats
+}
+
+// Column is an additional value of the rows of a report
+type Column struct {
+	// Header is the table header and the key in GenericDict.Extra
+	Header string
+	// Metric and Help describe the openmetrics samples of the column
+	Metric string
+	Help   string
+}
+
+// ErrorHeader is the column of the overestimation of approximate counts
+const ErrorHeader = "Error"
+
+// Approximate marks the values of the report as approximate, adding
+// their overestimation as a column and the error bound of the run as a note
+func (r *Report) Approximate() {
+	r.Columns = append(r.Columns, Column{
+		Header: ErrorHeader,
+		Metric: r.Metric + "_error",
+		Help:   "Maximum overestimation of the approximate value.",
+	})
+	r.AddNote(fmt.Sprintf("Counts are approximate, overestimated by at most the Error of their row and never by more than %d.", r.Stats.ErrorBound))
+}
+
+// Estimated notes that the distinct counts of the report are estimated
+// by HyperLogLog sketches of the given precision
+func (r *Report) Estimated(precision int) {
+	r.AddNote(fmt.Sprintf("Distinct counts are estimates with a relative standard error of %.1f%%.", 100*sketch.StandardError(precision)))
+}
+
+// AddNote adds a line to the note printed below the table
+func (r *Report) AddNote(note string) {
+	if r.Note != "" {
+		r.Note += "\n"
+	}
+	r.Note += note
+}
+
+// Render writes the report to stdout in the given format.
+// The chart flag is only honoured by the table format.
+func (r Report) Render(format string, chart bool) error {
+	return r.Write(os.Stdout, format, chart)
+}
+
+// Write writes the report like Render, but to the given writer. Charts
+// are drawn in unicode only on a terminal which supports it.
+func (r Report) Write(out io.Writer, format string, chart bool) error {
+	switch format {
+	case OutputJSON:
+		return r.Rows.ToJson(out)
+	case OutputOpenMetrics:
+		return r.ToOpenMetrics(out)
+	case OutputTable, "":
+		headers := r.Headers[:len(r.Headers):len(r.Headers)]
+		for _, column := range r.Columns {
+			headers = append(headers, column.Header)
+		}
+		if chart {
+			cs := asciiCharset
+			if f, ok := out.(*os.File); ok {
+				cs = detectCharset(f)
+			}
+			renderChart(out, r.Rows, headers, cs)
+		} else {
+			WriteTable(out, r.Rows, headers)
+		}
+		if r.Note != "" {
+			fmt.Fprintln(out, r.Note)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %s", format)
+	}
+}
+
+func (r Report) ToOpenMetrics(out io.Writer) error {
+	var sb strings.Builder
+
+	writeFamily := func(name, help string) {
+		fmt.Fprintf(&sb, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(&sb, "# TYPE %s gauge\n", name)
+	}
+
+	rowLabels := func(row GenericDict) string {
+		labels := map[string]string{r.KeyLabel: row.Key}
+		for k, v := range r.Labels {
+			labels[k] = v
+		}
+		return formatLabels(labels)
+	}
+
+	writeFamily(r.Metric, r.Help)
+	for _, row := range r.Rows {
+		fmt.Fprintf(&sb, "%s%s %d\n", r.Metric, rowLabels(row), row.Value)
+	}
+	for _, column := range r.Columns {
+		writeFamily(column.Metric, column.Help)
+		for _, row := range r.Rows {
+			fmt.Fprintf(&sb, "%s%s %d\n", column.Metric, rowLabels(row), row.Extra[column.Header])
+		}
+	}
+
+	runLabels := formatLabels(map[string]string{"command": r.Command})
+	writeFamily("git_analyze_rows_read", "Rows read from the input files.")
+	fmt.Fprintf(&sb, "git_analyze_rows_read%s %d\n", runLabels, r.Stats.RowsRead)
+	writeFamily("git_analyze_rows_skipped", "Malformed rows skipped while reading the input files.")
+	fmt.Fprintf(&sb, "git_analyze_rows_skipped%s %d\n", runLabels, r.Stats.RowsSkipped)
+	writeFamily("git_analyze_duration_seconds", "Duration of the analysis.")
+	fmt.Fprintf(&sb, "git_analyze_duration_seconds%s %g\n", runLabels, r.Stats.Duration.Seconds())
+	sb.WriteString("# EOF\n")
+
+	_, err := io.WriteString(out, sb.String())
+	return err
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels renders labels sorted by name, as {a="1",b="2"}
+func formatLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelValueReplacer.Replace(labels[name]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,107 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package utils
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportToOpenMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	report := Report{
+		Command: "topk-by-events",
+		Rows: GenericDictHeap{
+			{Key: "BurntSushi/xsv", Value: 7},
+			{Key: `quo"te`, Value: 2},
+		},
+		Metric:   "git_repo_events_total",
+		Help:     "Number of events per repository.",
+		KeyLabel: "repo",
+		Labels:   map[string]string{"type": "WatchEvent"},
+		Stats: RunStats{
+			RowsRead:    10,
+			RowsSkipped: 1,
+			Duration:    1500 * time.Millisecond,
+		},
+	}
+
+	var out bytes.Buffer
+	assert.Nil(report.ToOpenMetrics(&out))
+
+	expected := `# HELP git_repo_events_total Number of events per repository.
+# TYPE git_repo_events_total gauge
+git_repo_events_total{repo="BurntSushi/xsv",type="WatchEvent"} 7
+git_repo_events_total{repo="quo\"te",type="WatchEvent"} 2
+# HELP git_analyze_rows_read Rows read from the input files.
+# TYPE git_analyze_rows_read gauge
+git_analyze_rows_read{command="topk-by-events"} 10
+# HELP git_analyze_rows_skipped Malformed rows skipped while reading the input files.
+# TYPE git_analyze_rows_skipped gauge
+git_analyze_rows_skipped{command="topk-by-events"} 1
+# HELP git_analyze_duration_seconds Duration of the analysis.
+# TYPE git_analyze_duration_seconds gauge
+git_analyze_duration_seconds{command="topk-by-events"} 1.5
+# EOF
+`
+	assert.Equal(expected, out.String())
+}
+
+func TestReportRenderUnknownFormat(t *testing.T) {
+	assert := assert.New(t)
+	assert.NotNil(Report{}.Render("xml", false))
+}
+
+func TestReportApproximate(t *testing.T) {
+	assert := assert.New(t)
+
+	report := Report{
+		Command: "topk-by-events",
+		Rows: GenericDictHeap{
+			{Key: "a", Value: 7, Extra: map[string]int{ErrorHeader: 2}},
+		},
+		Metric:   "git_repo_events_total",
+		Help:     "Number of events per repository.",
+		KeyLabel: "repo",
+		Stats:    RunStats{ErrorBound: 3},
+	}
+	report.Approximate()
+	assert.Contains(report.Note, "3")
+
+	var out bytes.Buffer
+	assert.Nil(report.ToOpenMetrics(&out))
+	assert.Contains(out.String(), `git_repo_events_total{repo="a"} 7
+# HELP git_repo_events_total_error Maximum overestimation of the approximate value.
+# TYPE git_repo_events_total_error gauge
+git_repo_events_total_error{repo="a"} 2
+`)
+
+	out.Reset()
+	WriteTable(&out, report.Rows, []string{"RepoID", "Count", ErrorHeader})
+	assert.Contains(out.String(), "ERROR")
+	assert.Contains(out.String(), "| a      |     7 |     2 |")
+}
@@ -23,6 +23,7 @@
 package utils
 
 import (
+	"io"
 	"os"
 	"strconv"
 
@@ -31,20 +32,36 @@ import (
 
 // Renders any data in a nice tabular manner
 func RenderTable(tableData GenericDictHeap, headers []string) {
+	WriteTable(os.Stdout, tableData, headers)
+}
+
+// WriteTable renders the data like RenderTable, but to the given writer.
+// Headers after the first two name values of GenericDict.Extra.
+func WriteTable(out io.Writer, tableData GenericDictHeap, headers []string) {
 	var data [][]string
-	table := tablewriter.NewWriter(os.Stdout)
+	table := tablewriter.NewWriter(out)
 	table.SetBorder(true)
 	table.SetAutoWrapText(false)
 
 	for _, row := range tableData {
-		data = append(data, []string{row.Key, strconv.Itoa(row.Value)})
+		data = append(data, row.cells(headers))
 	}
 	table.SetHeader(headers)
-	table.SetHeaderColor(
-		tablewriter.Colors{tablewriter.Bold},
-		tablewriter.Colors{tablewriter.Bold},
-	)
+	colors := make([]tablewriter.Colors, len(headers))
+	for i := range colors {
+		colors[i] = tablewriter.Colors{tablewriter.Bold}
+	}
+	table.SetHeaderColor(colors...)
 
 	table.AppendBulk(data)
 	table.Render()
 }
+
+// cells returns the key, the value and the extra values of the row
+func (g GenericDict) cells(headers []string) []string {
+	cells := []string{g.Key, strconv.Itoa(g.Value)}
+	for i := 2; i < len(headers); i++ {
+		cells = append(cells, strconv.Itoa(g.Extra[headers[i]]))
+	}
+	return cells
+}
@@ -10,6 +10,8 @@ import (
 
 	"github.com/urfave/cli/v2"
 	"gitlab.com/ansrivas/go-analyze-git/pkg/app"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/config"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/custom"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -57,6 +59,11 @@ func createApp() *app.App {
 			Name:  "buildtime",
 			Usage: "time of this build",
 		},
+		&cli.StringFlag{
+			Name:    custom.FileFlag,
+			Usage:   "Path of the yaml or json file defining custom analyses",
+			EnvVars: []string{"ANALYSES_FILE"},
+		},
 	}
 	cliApp.Before = func(c *cli.Context) error {
 		if c.IsSet("buildtime") {
@@ -71,7 +78,21 @@ func createApp() *app.App {
 	cliApp.Commands = []*cli.Command{
 		cliApp.User(),
 		cliApp.Repository(),
+		cliApp.Org(),
+		cliApp.Explore(),
+		cliApp.Query(),
+		cliApp.Serve(),
+		cliApp.Ingest(),
+		cliApp.Run(),
+		cliApp.Update(),
+		cliApp.Validate(),
+		cliApp.Diff(),
 	}
+	// Custom analyses are registered before the arguments are parsed,
+	// their file is looked up in the raw arguments
+	cliApp.Commands = append(cliApp.Commands, cliApp.Custom(custom.Path(os.Args))...)
+	// Profiles of the configuration file fill in the flags of every command
+	config.Install(cliApp.App)
 	sort.Sort(cli.CommandsByName(cliApp.Commands))
 	return cliApp
 
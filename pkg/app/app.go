@@ -25,8 +25,19 @@ package app
 import (
 	"context"
 
+	"github.com/rs/zerolog/log"
 	"github.com/urfave/cli/v2"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/bots"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/cache"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/custom"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/dataset"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/diff"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/explore"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/multi"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/org"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/query"
 	"gitlab.com/ansrivas/go-analyze-git/pkg/repository"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/server"
 	"gitlab.com/ansrivas/go-analyze-git/pkg/user"
 )
 
@@ -66,10 +77,84 @@ func (c *App) User() *cli.Command {
 		},
 		Subcommands: []*cli.Command{
 			userCli.CmdTopKUsersByPRsAndCommits(),
+			bots.CmdBots(),
 		},
 	}
 }
 
+// Org sums up the activity of the repositories of an owner
+func (c *App) Org() *cli.Command {
+	return &cli.Command{
+		Name:  "org",
+		Usage: "Commands related to organizations and user namespaces",
+		Action: func(*cli.Context) error {
+			return nil
+		},
+		Subcommands: []*cli.Command{
+			org.CmdShow(),
+		},
+	}
+}
+
+// Ingest converts the csv files into a columnar cache
+func (c *App) Ingest() *cli.Command {
+	return cache.CmdIngest()
+}
+
+// Run runs several analyses sharing a single pass over every file
+func (c *App) Run() *cli.Command {
+	return multi.CmdRun()
+}
+
+// Update runs several analyses incrementally
+func (c *App) Update() *cli.Command {
+	return multi.CmdUpdate()
+}
+
+// Validate checks that the files of a dataset belong together
+func (c *App) Validate() *cli.Command {
+	return dataset.CmdValidate()
+}
+
+// Diff compares a leaderboard of two datasets
+func (c *App) Diff() *cli.Command {
+	return diff.CmdDiff()
+}
+
+// Explore opens the interactive browser over an in-memory dataset
+func (c *App) Explore() *cli.Command {
+	return explore.CmdExplore()
+}
+
+// Query runs SQL like queries over an in-memory dataset
+func (c *App) Query() *cli.Command {
+	return query.CmdQuery()
+}
+
+// Serve exposes the rankings of an in-memory dataset over HTTP
+func (c *App) Serve() *cli.Command {
+	return server.CmdServe()
+}
+
+// Custom returns a subcommand for every analysis defined in the file
+// at path. Definitions clashing with a registered command are skipped.
+func (c *App) Custom(path string) []*cli.Command {
+	definitions, err := custom.Load(path)
+	if err != nil {
+		log.Warn().Msgf("Skipping the custom analyses: %s", err)
+		return nil
+	}
+	var commands []*cli.Command
+	for _, d := range definitions {
+		if c.Command(d.Name) != nil {
+			log.Warn().Msgf("Skipping the custom analysis %s of %s, a command of that name exists", d.Name, path)
+			continue
+		}
+		commands = append(commands, d.Command())
+	}
+	return commands
+}
+
 // RunWithContext is a wrapper on urfave/cli RunContext function
 func (a *App) RunWithContext(ctx context.Context, arguments []string) error {
 	return a.RunContext(ctx, arguments)
@@ -0,0 +1,222 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package bots tells the bot accounts apart from the people, by their
+// login, their activity and a list of known accounts.
+package bots
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gitlab.com/ansrivas/go-analyze-git/internal/match"
+)
+
+// Suffix ends the login of a GitHub App
+const Suffix = "[bot]"
+
+// Known lists the logins of well known bots and CI accounts
+var Known = []string{
+	"allcontributors",
+	"circleci",
+	"codecov",
+	"codecov-io",
+	"deepsource-autofix",
+	"dependabot",
+	"dependabot-preview",
+	"github-actions",
+	"github-classroom",
+	"greenkeeper",
+	"greenkeeperio-bot",
+	"imgbot",
+	"mergify",
+	"netlify",
+	"pre-commit-ci",
+	"pyup-bot",
+	"renovate",
+	"renovate-bot",
+	"semantic-release-bot",
+	"snyk-bot",
+	"stale",
+	"travis-ci",
+	"vercel",
+	"web-flow",
+	"whitesource-bolt-for-github",
+}
+
+// Activity sums up what an account did in a dataset
+type Activity struct {
+	Events  int
+	Commits int
+	// Repeated is the number of commits sharing the most
+	// frequent commit message of the account
+	Repeated int
+}
+
+// Classifier suspects an account to be a bot if its login ends with
+// Suffix or is Known, if it pushed at least MaxCommits commits or if
+// most of its commits share a message. A list file allows or denies
+// accounts regardless. The commit rate is the total of MaxCommits over
+// the whole dataset, not a rate per hour, and activity at all hours is
+// not checked since the tables hold no timestamps.
+type Classifier struct {
+	// MaxCommits is the number of commits no person pushes in the period
+	// of a dataset, zero disables the heuristic
+	MaxCommits int
+	// MaxRepeated is the share of commits with the same message above
+	// which an account is a bot, once it pushed MinRepeated commits.
+	// Zero disables the heuristic.
+	MaxRepeated float64
+	MinRepeated int
+	// ListFile names the file of the allow and deny lists, if any
+	ListFile string
+	known    map[string]bool
+	allow    *match.Filter
+	deny     *match.Filter
+}
+
+// Default returns a classifier with the default thresholds and no lists
+func Default() *Classifier {
+	c := &Classifier{MaxCommits: 1000, MaxRepeated: 0.9, MinRepeated: 20, known: make(map[string]bool)}
+	for _, login := range Known {
+		c.known[login] = true
+	}
+	return c
+}
+
+// NewClassifier returns a classifier with the default thresholds, reading
+// the allow and deny lists of listFile if not empty. Every line of the file
+// is "allow" or "deny" followed by a login, an ID or a pattern like the
+// ones of --user-include. Blank lines and lines starting with # are skipped.
+func NewClassifier(listFile string) (*Classifier, error) {
+	c := Default()
+	if listFile == "" {
+		return c, nil
+	}
+	c.ListFile = listFile
+	allow, deny, err := readList(listFile)
+	if err != nil {
+		return nil, err
+	}
+	if c.allow, err = match.New(allow, nil); err != nil {
+		return nil, fmt.Errorf("%s: %w", listFile, err)
+	}
+	if c.deny, err = match.New(deny, nil); err != nil {
+		return nil, fmt.Errorf("%s: %w", listFile, err)
+	}
+	return c, nil
+}
+
+// readList returns the patterns allowed and denied by a list file
+func readList(path string) (allow, deny []string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		verb, pattern, _ := strings.Cut(text, " ")
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			return nil, nil, fmt.Errorf("%s:%d: expected allow or deny and a login, got %q", path, line, text)
+		}
+		switch verb {
+		case "allow":
+			allow = append(allow, pattern)
+		case "deny":
+			deny = append(deny, pattern)
+		default:
+			return nil, nil, fmt.Errorf("%s:%d: expected allow or deny, got %q", path, line, verb)
+		}
+	}
+	return allow, deny, scanner.Err()
+}
+
+// Classify returns the reasons to suspect the account of the given ID and
+// login to be a bot, none if it is taken for a person
+func (c *Classifier) Classify(id, login string, a Activity) []string {
+	if c.allow.Enabled() && c.allow.Match(id, login) {
+		return nil
+	}
+	var reasons []string
+	if c.deny.Enabled() && c.deny.Match(id, login) {
+		reasons = append(reasons, fmt.Sprintf("denied by %s", c.ListFile))
+	}
+	lower := strings.ToLower(login)
+	if strings.HasSuffix(lower, Suffix) {
+		reasons = append(reasons, fmt.Sprintf("login ends with %s", Suffix))
+	}
+	if c.known[strings.TrimSuffix(lower, Suffix)] {
+		reasons = append(reasons, "known bot account")
+	}
+	if c.MaxCommits > 0 && a.Commits >= c.MaxCommits {
+		reasons = append(reasons, fmt.Sprintf("pushed %d commits", a.Commits))
+	}
+	if c.MaxRepeated > 0 && a.Commits >= c.MinRepeated && float64(a.Repeated) >= c.MaxRepeated*float64(a.Commits) {
+		reasons = append(reasons, fmt.Sprintf("%d of %d commits share a message", a.Repeated, a.Commits))
+	}
+	return reasons
+}
+
+// Suspect is an account suspected to be a bot
+type Suspect struct {
+	ID       string   `json:"id"`
+	Login    string   `json:"login"`
+	Activity Activity `json:"activity"`
+	Reasons  []string `json:"reasons"`
+}
+
+// Suspects are the suspected bots by their actor ID
+type Suspects map[string]Suspect
+
+// Has tells if the actor of the given ID is suspected to be a bot
+func (s Suspects) Has(id string) bool {
+	_, ok := s[id]
+	return ok
+}
+
+// Sorted returns the suspects, the most active first
+func (s Suspects) Sorted() []Suspect {
+	sorted := make([]Suspect, 0, len(s))
+	for _, suspect := range s {
+		sorted = append(sorted, suspect)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i].Activity, sorted[j].Activity
+		if a.Commits != b.Commits {
+			return a.Commits > b.Commits
+		}
+		if a.Events != b.Events {
+			return a.Events > b.Events
+		}
+		return sorted[i].Login < sorted[j].Login
+	})
+	return sorted
+}
@@ -0,0 +1,115 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package bots
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dataflow"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dedup"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/dataset"
+)
+
+const (
+	eventsFile  = "testdata/events.csv"
+	commitsFile = "testdata/commits.csv"
+	actorsFile  = "testdata/actors.csv"
+)
+
+func TestClassify(t *testing.T) {
+	assert := assert.New(t)
+
+	c := Default()
+	assert.Equal([]string{"login ends with [bot]", "known bot account"}, c.Classify("1", "dependabot[bot]", Activity{}))
+	assert.Equal([]string{"login ends with [bot]"}, c.Classify("2", "acme-ci[Bot]", Activity{}))
+	assert.Equal([]string{"known bot account"}, c.Classify("3", "GitHub-Actions", Activity{}))
+	assert.Equal([]string{"pushed 1000 commits"}, c.Classify("4", "busy", Activity{Commits: 1000}))
+	assert.Equal([]string{"18 of 20 commits share a message"}, c.Classify("5", "ci", Activity{Commits: 20, Repeated: 18}))
+	// Too few commits to tell
+	assert.Empty(c.Classify("6", "tidy", Activity{Commits: 10, Repeated: 10}))
+	assert.Empty(c.Classify("7", "octocat", Activity{Events: 500, Commits: 20, Repeated: 17}))
+
+	c.MaxCommits, c.MaxRepeated = 0, 0
+	assert.Empty(c.Classify("4", "busy", Activity{Commits: 1000, Repeated: 1000}))
+
+	c, err := NewClassifier("testdata/bots.txt")
+	assert.Nil(err)
+	assert.Empty(c.Classify("8", "Renovate", Activity{}))
+	assert.Equal([]string{"denied by testdata/bots.txt"}, c.Classify("9", "octocat", Activity{}))
+
+	_, err = NewClassifier("testdata/missing.txt")
+	assert.NotNil(err)
+	_, err = NewClassifier(commitsFile)
+	assert.NotNil(err)
+}
+
+func TestScan(t *testing.T) {
+	assert := assert.New(t)
+
+	events, commits, actors := dataflow.Input{File: eventsFile}, dataflow.Input{File: commitsFile}, dataflow.Input{File: actorsFile}
+	suspects, err := Default().Scan(events, commits, actors, 2, dedup.Exact, 0)
+	assert.Nil(err)
+	assert.Equal([]Suspect{
+		{ID: "4", Login: "ci-runner", Activity: Activity{Events: 4, Commits: 20, Repeated: 20}, Reasons: []string{"20 of 20 commits share a message"}},
+		{ID: "2", Login: "dependabot[bot]", Activity: Activity{Events: 1, Commits: 2, Repeated: 1}, Reasons: []string{"login ends with [bot]", "known bot account"}},
+		{ID: "3", Login: "Renovate", Activity: Activity{Events: 1, Commits: 1, Repeated: 1}, Reasons: []string{"known bot account"}},
+	}, suspects.Sorted())
+	assert.True(suspects.Has("2"))
+	assert.False(suspects.Has("5"))
+
+	// A loaded dataset is classified the same
+	data, err := dataset.Load(dataset.Files{Events: eventsFile, Commits: commitsFile, Actors: actorsFile})
+	assert.Nil(err)
+	classified, err := Default().ClassifyDataset(data)
+	assert.Nil(err)
+	assert.Equal(suspects, classified)
+
+	c, err := NewClassifier("testdata/bots.txt")
+	assert.Nil(err)
+	c.MaxCommits = 21
+	suspects, err = c.Scan(events, commits, actors, 1, dedup.Exact, 0)
+	assert.Nil(err)
+	var logins []string
+	for _, s := range suspects.Sorted() {
+		logins = append(logins, s.Login)
+	}
+	assert.Equal([]string{"alice", "ci-runner", "octocat", "dependabot[bot]"}, logins)
+	assert.Equal([]string{"pushed 21 commits"}, suspects["5"].Reasons)
+}
+
+func TestClassifyDatasetDedup(t *testing.T) {
+	assert := assert.New(t)
+
+	data, err := dataset.Load(dataset.Files{Events: eventsFile, Commits: commitsFile, Actors: actorsFile})
+	assert.Nil(err)
+	expected, err := Default().ClassifyDataset(data)
+	assert.Nil(err)
+
+	// Every row exported twice is counted once
+	data.Events = append(data.Events, data.Events...)
+	data.Commits = append(data.Commits, data.Commits...)
+	suspects, err := Default().ClassifyDataset(data)
+	assert.Nil(err)
+	assert.Equal(expected, suspects)
+}
@@ -0,0 +1,136 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package bots
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+	"gitlab.com/ansrivas/go-analyze-git/internal/flags"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/cache"
+)
+
+// FromFlags returns the classifier of --bots-file, --bot-commits
+// and --bot-repeated
+func FromFlags(c *cli.Context) (*Classifier, error) {
+	classifier, err := NewClassifier(c.String(flags.BotsFileFlag.Name))
+	if err != nil {
+		return nil, err
+	}
+	classifier.MaxCommits = c.Int(flags.BotCommitsFlag.Name)
+	classifier.MaxRepeated = c.Float64(flags.BotRepeatedFlag.Name)
+	return classifier, nil
+}
+
+// Excluded returns the suspects of the given files if --exclude-bots
+// is set, nil otherwise
+func Excluded(c *cli.Context, eventsFile, commitsFile, actorsFile string) (Suspects, error) {
+	if !c.Bool(flags.ExcludeBotsFlag.Name) {
+		return nil, nil
+	}
+	if commitsFile == "" || actorsFile == "" {
+		return nil, fmt.Errorf("--%s needs --%s and --%s", flags.ExcludeBotsFlag.Name, flags.CommitsFileFlag.Name, flags.ActorsFileFlag.Name)
+	}
+	return scan(c, eventsFile, commitsFile, actorsFile)
+}
+
+// scan classifies the actors of the given files as told by the flags,
+// reading their cached tables while they are fresh
+func scan(c *cli.Context, eventsFile, commitsFile, actorsFile string) (Suspects, error) {
+	classifier, err := FromFlags(c)
+	if err != nil {
+		return nil, err
+	}
+	mode, memory, err := flags.Dedup(c)
+	if err != nil {
+		return nil, err
+	}
+	files := cache.Files{Events: eventsFile, Commits: commitsFile, Actors: actorsFile}
+	inputs := cache.Inputs(c.String(flags.CacheDirFlag.Name), files, mode)
+	return classifier.Scan(inputs.Events, inputs.Commits, inputs.Actors, c.Int(flags.WorkersFlag.Name), mode, memory)
+}
+
+// writeSuspects writes the suspects as a table
+func writeSuspects(out io.Writer, suspects []Suspect) {
+	table := tablewriter.NewWriter(out)
+	table.SetBorder(true)
+	table.SetAutoWrapText(false)
+	table.SetHeader([]string{"Login", "ID", "Events", "Commits", "Reasons"})
+	for _, s := range suspects {
+		table.Append([]string{
+			s.Login,
+			s.ID,
+			strconv.Itoa(s.Activity.Events),
+			strconv.Itoa(s.Activity.Commits),
+			strings.Join(s.Reasons, "; "),
+		})
+	}
+	table.Render()
+}
+
+// CmdBots lists the users suspected to be bots
+func CmdBots() *cli.Command {
+	return &cli.Command{
+		Name:  "bots",
+		Usage: "List the users suspected to be bots with the reasons. The commit rate is the total of --bot-commits, activity at all hours is not checked for lack of timestamps",
+		Flags: []cli.Flag{
+			flags.DatasetFlag,
+			flags.EventsFileFlag,
+			flags.CommitsFileFlag,
+			flags.ActorsFileFlag,
+			flags.BotsFileFlag,
+			flags.BotCommitsFlag,
+			flags.BotRepeatedFlag,
+			flags.WorkersFlag,
+			flags.DedupFlag,
+			flags.DedupMemoryFlag,
+			flags.CacheDirFlag,
+			flags.JsonFlag,
+		},
+		Action: func(c *cli.Context) error {
+			if err := flags.Required(c, "events-file", "commits-file", "actors-file"); err != nil {
+				return err
+			}
+			suspects, err := scan(c, c.String(flags.EventsFileFlag.Name), c.String(flags.CommitsFileFlag.Name), c.String(flags.ActorsFileFlag.Name))
+			if err != nil {
+				return err
+			}
+			sorted := suspects.Sorted()
+			if c.Bool(flags.JsonFlag.Name) {
+				payload, err := json.MarshalIndent(sorted, "", "    ")
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(c.App.Writer, string(payload))
+				return nil
+			}
+			writeSuspects(c.App.Writer, sorted)
+			return nil
+		},
+	}
+}
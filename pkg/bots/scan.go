@@ -0,0 +1,97 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package bots
+
+import (
+	"runtime"
+
+	"gitlab.com/ansrivas/go-analyze-git/internal/dataflow"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dedup"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/dataset"
+)
+
+// Scan classifies every actor of the events. The commits are joined with
+// the events of their actor. The duplicated events and commits are each
+// dropped by their own dedup.Filter of the given mode and memory.
+func (c *Classifier) Scan(events, commits, actors dataflow.Input, workers int, mode string, memory int64) (Suspects, error) {
+	eventsFilter, err := dedup.New(mode, memory)
+	if err != nil {
+		return nil, err
+	}
+	commitsFilter, err := dedup.New(mode, memory)
+	if err != nil {
+		return nil, err
+	}
+	flow, err := dataflow.New(workers, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer flow.Close()
+
+	// Every event is indexed, the commits only refer to PushEvents
+	activity := dataflow.NewCount(dataflow.Field(2), 0)
+	byEvent := dataflow.NewIndex(dataflow.Field(0), dataflow.Field(2))
+	ops := []dataflow.Op{dataflow.Unique(eventsFilter, dataflow.Prefix(2))}
+	if err := flow.Scan(events.Source(4, ops, activity, byEvent)); err != nil {
+		return nil, err
+	}
+
+	// The event id of a commit is its last column, the message
+	// is everything between the sha and the event id
+	commitCounts := dataflow.NewCount(byEvent.Join(-1), 0)
+	repeats := dataflow.NewRepeats(byEvent.Join(-1), dataflow.Span(1, -1))
+	ops = []dataflow.Op{dataflow.Known(-1), dataflow.Unique(commitsFilter, dataflow.Fields(0, -1))}
+	if err := flow.Scan(commits.Source(0, ops, commitCounts, repeats)); err != nil {
+		return nil, err
+	}
+
+	names := dataflow.LastNames(dataflow.KnownField(0), 1, func(actorID int64) bool {
+		_, _, ok := activity.Counts.Get(actorID)
+		return ok
+	})
+	if err := flow.Scan(actors.Source(2, nil, names)); err != nil {
+		return nil, err
+	}
+
+	suspects := make(Suspects)
+	activity.Counts.Each(func(actorID int64, count, _ int) {
+		commitCount, _, _ := commitCounts.Counts.Get(actorID)
+		id := flow.IDs.String(actorID)
+		c.add(suspects, id, names.Names[actorID], Activity{Events: count, Commits: commitCount, Repeated: repeats.Counts[actorID]})
+	})
+	return suspects, nil
+}
+
+// ClassifyDataset classifies every actor of a loaded dataset, dropping
+// the duplicated rows like Scan does by default
+func (c *Classifier) ClassifyDataset(d *dataset.Dataset) (Suspects, error) {
+	inputs := d.Inputs()
+	return c.Scan(inputs.Events, inputs.Commits, inputs.Actors, runtime.NumCPU(), dedup.Exact, 0)
+}
+
+// add adds the actor to the suspects if it is classified as a bot
+func (c *Classifier) add(suspects Suspects, id, login string, a Activity) {
+	if reasons := c.Classify(id, login, a); len(reasons) > 0 {
+		suspects[id] = Suspect{ID: id, Login: login, Activity: a, Reasons: reasons}
+	}
+}
@@ -0,0 +1,372 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package cache stores parsed csv files in a compact binary columnar
+// format, so that the analyses don't have to parse the csv files again.
+// A manifest records the checksums of the source files, a cache is
+// only used as long as its sources didn't change.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+)
+
+const (
+	formatVersion = 1
+	manifestName  = "manifest.json"
+	idsName       = "ids.bin"
+	eventsName    = "events.bin"
+	commitsName   = "commits.bin"
+	textsName     = "commit_texts.bin"
+	reposName     = "repos.bin"
+	actorsName    = "actors.bin"
+)
+
+// Files holds the paths of the csv files a cache is built from
+type Files struct {
+	Events  string
+	Commits string
+	Repos   string
+	Actors  string
+}
+
+// Source describes a csv file the cache was built from
+type Source struct {
+	Path        string    `json:"path"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	SHA256      string    `json:"sha256"`
+	RowsRead    int64     `json:"rows_read"`
+	RowsSkipped int64     `json:"rows_skipped"`
+}
+
+// Manifest describes a cache and the files it was built from
+type Manifest struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Events  Source    `json:"events"`
+	Commits Source    `json:"commits"`
+	Repos   Source    `json:"repos"`
+	Actors  Source    `json:"actors"`
+}
+
+// Events holds the columns of events.csv. The event
+// types are encoded as indexes into Types.
+type Events struct {
+	ID    []int64
+	Type  []uint16
+	Actor []int64
+	Repo  []int64
+	Types []string
+}
+
+// TypeCode returns the code of the given event type
+func (e *Events) TypeCode(eventType string) (uint16, bool) {
+	for code, name := range e.Types {
+		if name == eventType {
+			return uint16(code), true
+		}
+	}
+	return 0, false
+}
+
+// Commits holds the columns of commits.csv. SHA and Message
+// are only loaded on request, the analyses don't need them.
+type Commits struct {
+	SHA     []string
+	Message []string
+	Event   []int64
+}
+
+// Names holds the columns of repos.csv or actors.csv
+type Names struct {
+	ID   []int64
+	Name []string
+}
+
+// Tables are the parsed csv files. All IDs are keys of the IDs dictionary.
+type Tables struct {
+	Manifest Manifest
+	// files are the csv files the tables were loaded for
+	files   Files
+	IDs     *utils.IDs
+	Events  Events
+	Commits Commits
+	Repos   Names
+	Actors  Names
+}
+
+// Stats returns the rows read and skipped while ingesting
+// the csv files the tables were loaded for
+func (t *Tables) Stats() utils.RunStats {
+	stats := utils.RunStats{}
+	sources := []struct {
+		fname  string
+		source Source
+	}{
+		{t.files.Events, t.Manifest.Events},
+		{t.files.Commits, t.Manifest.Commits},
+		{t.files.Repos, t.Manifest.Repos},
+		{t.files.Actors, t.Manifest.Actors},
+	}
+	for _, s := range sources {
+		if s.fname != "" {
+			stats.RowsRead += s.source.RowsRead
+			stats.RowsSkipped += s.source.RowsSkipped
+		}
+	}
+	return stats
+}
+
+// checksum returns the hex encoded SHA-256 of the file
+func checksum(fname string) (string, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// describe returns the Source of a csv file
+func describe(fname string) (Source, error) {
+	path, err := filepath.Abs(fname)
+	if err != nil {
+		return Source{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return Source{}, err
+	}
+	sum, err := checksum(path)
+	if err != nil {
+		return Source{}, err
+	}
+	return Source{Path: path, Size: info.Size(), ModTime: info.ModTime(), SHA256: sum}, nil
+}
+
+// Fresh reports whether the csv file at fname is still the file the
+// source was read from. The checksum is only computed when the
+// modification time changed, the size is checked first.
+func (s Source) Fresh(fname string) bool {
+	path, err := filepath.Abs(fname)
+	if err != nil || path != s.Path {
+		return false
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != s.Size {
+		return false
+	}
+	if info.ModTime().Equal(s.ModTime) {
+		return true
+	}
+	sum, err := checksum(path)
+	return err == nil && sum == s.SHA256
+}
+
+// Write stores the tables in dir, replacing an earlier cache
+func Write(dir string, tables *Tables) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	// Without a manifest the cache is never used, so drop it
+	// first in case writing the tables fails half way
+	if err := os.Remove(filepath.Join(dir, manifestName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err := writeFile(filepath.Join(dir, idsName), func(e *encoder) {
+		e.strings(tables.IDs.Names())
+	})
+	if err == nil {
+		err = writeFile(filepath.Join(dir, eventsName), func(e *encoder) {
+			e.strings(tables.Events.Types)
+			e.int64s(tables.Events.ID)
+			e.uint16s(tables.Events.Type)
+			e.int64s(tables.Events.Actor)
+			e.int64s(tables.Events.Repo)
+		})
+	}
+	if err == nil {
+		err = writeFile(filepath.Join(dir, commitsName), func(e *encoder) {
+			e.int64s(tables.Commits.Event)
+		})
+	}
+	if err == nil {
+		err = writeFile(filepath.Join(dir, textsName), func(e *encoder) {
+			e.strings(tables.Commits.SHA)
+			e.strings(tables.Commits.Message)
+		})
+	}
+	if err == nil {
+		err = writeFile(filepath.Join(dir, reposName), func(e *encoder) {
+			e.int64s(tables.Repos.ID)
+			e.strings(tables.Repos.Name)
+		})
+	}
+	if err == nil {
+		err = writeFile(filepath.Join(dir, actorsName), func(e *encoder) {
+			e.int64s(tables.Actors.ID)
+			e.strings(tables.Actors.Name)
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.MarshalIndent(tables.Manifest, "", "    ")
+	if err != nil {
+		return err
+	}
+	manifest := filepath.Join(dir, manifestName)
+	if err := os.WriteFile(manifest+".tmp", payload, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(manifest+".tmp", manifest)
+}
+
+// ReadManifest reads the manifest of the cache in dir
+func ReadManifest(dir string) (*Manifest, error) {
+	payload, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(payload, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse the cache manifest in %s with error %v", dir, err.Error())
+	}
+	return &manifest, nil
+}
+
+// Open loads the tables of the given files from the cache in dir,
+// skipping the tables of empty paths. The commit shas and messages are
+// only loaded with texts. It returns nil without an error if dir holds
+// no cache, or a cache of other or changed files.
+func Open(dir string, files Files, texts bool) (*Tables, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	manifest, err := ReadManifest(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Version != formatVersion {
+		return nil, nil
+	}
+	sources := []struct {
+		fname  string
+		source Source
+	}{
+		{files.Events, manifest.Events},
+		{files.Commits, manifest.Commits},
+		{files.Repos, manifest.Repos},
+		{files.Actors, manifest.Actors},
+	}
+	for _, s := range sources {
+		if s.fname != "" && !s.source.Fresh(s.fname) {
+			return nil, nil
+		}
+	}
+
+	tables := &Tables{Manifest: *manifest, files: files}
+	err = readFile(filepath.Join(dir, idsName), func(d *decoder) {
+		tables.IDs = utils.NewIDsFrom(d.strings())
+	})
+	if err == nil && files.Events != "" {
+		err = readFile(filepath.Join(dir, eventsName), func(d *decoder) {
+			tables.Events.Types = d.strings()
+			tables.Events.ID = d.int64s()
+			tables.Events.Type = d.uint16s()
+			tables.Events.Actor = d.int64s()
+			tables.Events.Repo = d.int64s()
+		})
+	}
+	if err == nil && files.Commits != "" {
+		err = readFile(filepath.Join(dir, commitsName), func(d *decoder) {
+			tables.Commits.Event = d.int64s()
+		})
+	}
+	if err == nil && files.Commits != "" && texts {
+		err = readFile(filepath.Join(dir, textsName), func(d *decoder) {
+			tables.Commits.SHA = d.strings()
+			tables.Commits.Message = d.strings()
+		})
+	}
+	if err == nil && files.Repos != "" {
+		err = readFile(filepath.Join(dir, reposName), func(d *decoder) {
+			tables.Repos.ID = d.int64s()
+			tables.Repos.Name = d.strings()
+		})
+	}
+	if err == nil && files.Actors != "" {
+		err = readFile(filepath.Join(dir, actorsName), func(d *decoder) {
+			tables.Actors.ID = d.int64s()
+			tables.Actors.Name = d.strings()
+		})
+	}
+	if err == nil {
+		err = tables.validate()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+// validate checks that the columns of every table line up
+func (t *Tables) validate() error {
+	events := len(t.Events.ID)
+	if len(t.Events.Type) != events || len(t.Events.Actor) != events || len(t.Events.Repo) != events {
+		return fmt.Errorf("failed to read the events from the cache with error %v", errCorrupt)
+	}
+	for _, code := range t.Events.Type {
+		if int(code) >= len(t.Events.Types) {
+			return fmt.Errorf("failed to read the events from the cache with error %v", errCorrupt)
+		}
+	}
+	if t.Commits.SHA != nil && (len(t.Commits.SHA) != len(t.Commits.Event) || len(t.Commits.Message) != len(t.Commits.Event)) {
+		return fmt.Errorf("failed to read the commits from the cache with error %v", errCorrupt)
+	}
+	if len(t.Repos.Name) != len(t.Repos.ID) {
+		return fmt.Errorf("failed to read the repos from the cache with error %v", errCorrupt)
+	}
+	if len(t.Actors.Name) != len(t.Actors.ID) {
+		return fmt.Errorf("failed to read the actors from the cache with error %v", errCorrupt)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// copyFiles copies the dataset fixture, so that the tests can change it
+func copyFiles(t *testing.T) Files {
+	dir := t.TempDir()
+	files := Files{}
+	for name, path := range map[string]*string{
+		"events.csv":  &files.Events,
+		"commits.csv": &files.Commits,
+		"repos.csv":   &files.Repos,
+		"actors.csv":  &files.Actors,
+	} {
+		content, err := os.ReadFile(filepath.Join("..", "dataset", "testdata", name))
+		assert.Nil(t, err)
+		*path = filepath.Join(dir, name)
+		assert.Nil(t, os.WriteFile(*path, content, 0o600))
+	}
+	return files
+}
+
+func TestIngest(t *testing.T) {
+	assert := assert.New(t)
+
+	files := copyFiles(t)
+	dir := filepath.Join(t.TempDir(), "cache")
+	ingested, err := Ingest(files, dir, 3)
+	assert.Nil(err)
+
+	// Header rows are counted as read, but not stored
+	assert.Equal(17, len(ingested.Events.ID))
+	assert.Equal(9, len(ingested.Commits.Event))
+	assert.Equal(4, len(ingested.Repos.ID))
+	assert.Equal(6, len(ingested.Actors.ID))
+	assert.Equal(int64(19), ingested.Manifest.Events.RowsRead)
+	assert.Equal(int64(1), ingested.Manifest.Events.RowsSkipped)
+	assert.Equal(64, len(ingested.Manifest.Events.SHA256))
+	assert.Equal("Fix, with a comma", ingested.Commits.Message[1])
+
+	tables, err := Open(dir, files, true)
+	assert.Nil(err)
+	assert.NotNil(tables)
+	assert.Equal(ingested.Events, tables.Events)
+	assert.Equal(ingested.Commits, tables.Commits)
+	assert.Equal(ingested.Repos, tables.Repos)
+	assert.Equal(ingested.Actors, tables.Actors)
+	assert.Equal(int64(41), tables.Stats().RowsRead)
+	assert.Equal("11185452665", tables.IDs.String(tables.Events.ID[0]))
+	assert.Equal("WatchEvent", tables.Events.Types[tables.Events.Type[0]])
+
+	// Only the requested tables are loaded
+	tables, err = Open(dir, Files{Events: files.Events, Commits: files.Commits}, false)
+	assert.Nil(err)
+	assert.Equal(17, len(tables.Events.ID))
+	assert.Equal(9, len(tables.Commits.Event))
+	assert.Nil(tables.Commits.SHA)
+	assert.Nil(tables.Repos.ID)
+	assert.Equal(int64(19+10), tables.Stats().RowsRead)
+}
+
+func TestOpenStale(t *testing.T) {
+	assert := assert.New(t)
+
+	files := copyFiles(t)
+	dir := t.TempDir()
+
+	// No cache at all
+	tables, err := Open(dir, files, false)
+	assert.Nil(err)
+	assert.Nil(tables)
+
+	_, err = Ingest(files, dir, 1)
+	assert.Nil(err)
+
+	// Other files than the ingested ones
+	other := files
+	other.Repos = "../dataset/testdata/repos.csv"
+	tables, err = Open(dir, other, false)
+	assert.Nil(err)
+	assert.Nil(tables)
+
+	// Touching a file keeps the cache, the checksum didn't change
+	later := time.Now().Add(time.Hour)
+	assert.Nil(os.Chtimes(files.Repos, later, later))
+	tables, err = Open(dir, files, false)
+	assert.Nil(err)
+	assert.NotNil(tables)
+
+	// Changing its content, even with the same size, doesn't
+	content, err := os.ReadFile(files.Repos)
+	assert.Nil(err)
+	content[len(content)-2] = 'X'
+	assert.Nil(os.WriteFile(files.Repos, content, 0o600))
+	tables, err = Open(dir, files, false)
+	assert.Nil(err)
+	assert.Nil(tables)
+}
+
+func TestOpenCorrupt(t *testing.T) {
+	assert := assert.New(t)
+
+	files := copyFiles(t)
+	dir := t.TempDir()
+	_, err := Ingest(files, dir, 1)
+	assert.Nil(err)
+
+	fname := filepath.Join(dir, eventsName)
+	content, err := os.ReadFile(fname)
+	assert.Nil(err)
+	assert.Nil(os.WriteFile(fname, content[:len(content)-3], 0o600))
+
+	_, err = Open(dir, files, false)
+	assert.NotNil(err)
+}
@@ -0,0 +1,200 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// magic starts every table file, followed by the format version
+const magic = "GAGC"
+
+var errCorrupt = errors.New("corrupt cache file")
+
+// encoder writes the columns of a table file. The first error
+// is kept and all the following writes are skipped.
+type encoder struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (e *encoder) write(b []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(b)
+	}
+}
+
+func (e *encoder) uvarint(v uint64) {
+	n := binary.PutUvarint(e.buf[:], v)
+	e.write(e.buf[:n])
+}
+
+// int64s writes the length of the column and its values
+func (e *encoder) int64s(column []int64) {
+	e.uvarint(uint64(len(column)))
+	for _, v := range column {
+		binary.LittleEndian.PutUint64(e.buf[:8], uint64(v))
+		e.write(e.buf[:8])
+	}
+}
+
+// uint16s writes the length of the column and its values
+func (e *encoder) uint16s(column []uint16) {
+	e.uvarint(uint64(len(column)))
+	for _, v := range column {
+		binary.LittleEndian.PutUint16(e.buf[:2], v)
+		e.write(e.buf[:2])
+	}
+}
+
+// strings writes the length of the column and every
+// value prefixed with its length
+func (e *encoder) strings(column []string) {
+	e.uvarint(uint64(len(column)))
+	for _, v := range column {
+		e.uvarint(uint64(len(v)))
+		if e.err == nil {
+			_, e.err = e.w.WriteString(v)
+		}
+	}
+}
+
+// writeFile writes a table file through a temporary file, so
+// that a reader never sees a partially written table
+func writeFile(fname string, columns func(e *encoder)) error {
+	f, err := os.CreateTemp(filepath.Dir(fname), filepath.Base(fname)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create cache file %s with error %v", fname, err.Error())
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	e := &encoder{w: bufio.NewWriterSize(f, 256*1024)}
+	e.write([]byte(magic))
+	e.uvarint(formatVersion)
+	columns(e)
+	if e.err == nil {
+		e.err = e.w.Flush()
+	}
+	if e.err == nil {
+		e.err = f.Close()
+	}
+	if e.err != nil {
+		return fmt.Errorf("failed to write cache file %s with error %v", fname, e.err.Error())
+	}
+	return os.Rename(f.Name(), fname)
+}
+
+// decoder reads the columns of a table file loaded into memory.
+// The first error is kept and all the following reads return nothing.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errCorrupt
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+// length reads the length of a column of values of size bytes each
+func (d *decoder) length(size int) int {
+	n := d.uvarint()
+	if d.err == nil && n > uint64(len(d.buf)/size) {
+		d.err = errCorrupt
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) int64s() []int64 {
+	n := d.length(8)
+	column := make([]int64, n)
+	for i := range column {
+		column[i] = int64(binary.LittleEndian.Uint64(d.buf[i*8:]))
+	}
+	d.buf = d.buf[n*8:]
+	return column
+}
+
+func (d *decoder) uint16s() []uint16 {
+	n := d.length(2)
+	column := make([]uint16, n)
+	for i := range column {
+		column[i] = binary.LittleEndian.Uint16(d.buf[i*2:])
+	}
+	d.buf = d.buf[n*2:]
+	return column
+}
+
+func (d *decoder) strings() []string {
+	n := d.length(1)
+	column := make([]string, n)
+	for i := range column {
+		size := d.length(1)
+		if d.err != nil {
+			return nil
+		}
+		column[i] = string(d.buf[:size])
+		d.buf = d.buf[size:]
+	}
+	return column
+}
+
+// readFile loads a table file and checks its header
+func readFile(fname string, columns func(d *decoder)) error {
+	buf, err := os.ReadFile(fname)
+	if err != nil {
+		return fmt.Errorf("failed to read cache file %s with error %v", fname, err.Error())
+	}
+	d := &decoder{buf: buf}
+	if len(buf) < len(magic) || string(buf[:len(magic)]) != magic {
+		return fmt.Errorf("failed to read cache file %s with error %v", fname, errCorrupt)
+	}
+	d.buf = d.buf[len(magic):]
+	if version := d.uvarint(); d.err == nil && version != formatVersion {
+		return fmt.Errorf("cache file %s has format version %d, expected %d", fname, version, formatVersion)
+	}
+	columns(d)
+	if d.err == nil && len(d.buf) != 0 {
+		d.err = errCorrupt
+	}
+	if d.err != nil {
+		return fmt.Errorf("failed to read cache file %s with error %v", fname, d.err.Error())
+	}
+	return nil
+}
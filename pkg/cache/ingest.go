@@ -0,0 +1,296 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+	"gitlab.com/ansrivas/go-analyze-git/internal/fileops"
+	"gitlab.com/ansrivas/go-analyze-git/internal/flags"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+)
+
+// partial holds the rows parsed from one chunk of a csv file
+type partial[T any] struct {
+	table T
+	stats utils.RunStats
+}
+
+// parse reads fname with the given number of workers. parseLine adds
+// a line to the table of a chunk, and reports false for a malformed line.
+// The header row, if any, is counted as read but not parsed.
+func parse[T any](fname, header string, workers int, parseLine func(table *T, line []byte) bool) ([]partial[T], utils.RunStats, error) {
+	partials, err := utils.ReadParallel(fname, workers, func(batches <-chan *fileops.Batch) partial[T] {
+		var p partial[T]
+		for batch := range batches {
+			for i, line := range batch.Lines {
+				p.stats.RowsRead++
+				// Only the first line of the file can be a header
+				if i == 0 && batch.Offset == 0 && bytes.HasPrefix(line, []byte(header+",")) {
+					continue
+				}
+				if !parseLine(&p.table, line) {
+					p.stats.RowsSkipped++
+				}
+			}
+			batch.Release()
+		}
+		return p
+	})
+	stats := utils.RunStats{}
+	for _, p := range partials {
+		stats.Add(p.stats)
+	}
+	return partials, stats, err
+}
+
+// Ingest parses the csv files into tables and writes them with
+// a manifest of the sources to dir. Every file is parsed with the
+// given number of workers, empty paths are skipped.
+func Ingest(files Files, dir string, workers int) (*Tables, error) {
+	start := time.Now()
+	tables := &Tables{
+		Manifest: Manifest{Version: formatVersion, Created: start.UTC()},
+		IDs:      utils.NewIDs(),
+	}
+	ids := tables.IDs
+
+	events := func() error {
+		if files.Events == "" {
+			return nil
+		}
+		source, err := describe(files.Events)
+		if err != nil {
+			return err
+		}
+		partials, stats, err := parse(files.Events, "id", workers, func(events *Events, line []byte) bool {
+			columns := bytes.Split(line, []byte(","))
+			if len(columns) != 4 {
+				return false
+			}
+			code, ok := events.TypeCode(string(columns[1]))
+			if !ok {
+				code = uint16(len(events.Types))
+				events.Types = append(events.Types, string(columns[1]))
+			}
+			events.ID = append(events.ID, ids.Parse(columns[0]))
+			events.Type = append(events.Type, code)
+			events.Actor = append(events.Actor, ids.Parse(columns[2]))
+			events.Repo = append(events.Repo, ids.Parse(columns[3]))
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		events := &tables.Events
+		for _, p := range partials {
+			// Every chunk has its own dictionary of event types
+			codes := make([]uint16, len(p.table.Types))
+			for code, name := range p.table.Types {
+				merged, ok := events.TypeCode(name)
+				if !ok {
+					merged = uint16(len(events.Types))
+					events.Types = append(events.Types, name)
+				}
+				codes[code] = merged
+			}
+			for _, code := range p.table.Type {
+				events.Type = append(events.Type, codes[code])
+			}
+			events.ID = append(events.ID, p.table.ID...)
+			events.Actor = append(events.Actor, p.table.Actor...)
+			events.Repo = append(events.Repo, p.table.Repo...)
+		}
+		source.RowsRead, source.RowsSkipped = stats.RowsRead, stats.RowsSkipped
+		tables.Manifest.Events = source
+		return nil
+	}
+
+	commits := func() error {
+		if files.Commits == "" {
+			return nil
+		}
+		source, err := describe(files.Commits)
+		if err != nil {
+			return err
+		}
+		partials, stats, err := parse(files.Commits, "sha", workers, func(commits *Commits, line []byte) bool {
+			// Commit messages may contain commas, the sha is always
+			// the first and the event id always the last column
+			first := bytes.IndexByte(line, ',')
+			last := bytes.LastIndexByte(line, ',')
+			if first < 0 {
+				return false
+			}
+			commits.SHA = append(commits.SHA, string(line[:first]))
+			commits.Message = append(commits.Message, string(line[first+1:last]))
+			commits.Event = append(commits.Event, ids.Parse(line[last+1:]))
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		for _, p := range partials {
+			tables.Commits.SHA = append(tables.Commits.SHA, p.table.SHA...)
+			tables.Commits.Message = append(tables.Commits.Message, p.table.Message...)
+			tables.Commits.Event = append(tables.Commits.Event, p.table.Event...)
+		}
+		source.RowsRead, source.RowsSkipped = stats.RowsRead, stats.RowsSkipped
+		tables.Manifest.Commits = source
+		return nil
+	}
+
+	names := func(fname string, table *Names, manifest *Source) func() error {
+		return func() error {
+			if fname == "" {
+				return nil
+			}
+			source, err := describe(fname)
+			if err != nil {
+				return err
+			}
+			partials, stats, err := parse(fname, "id", workers, func(names *Names, line []byte) bool {
+				columns := bytes.Split(line, []byte(","))
+				if len(columns) != 2 {
+					return false
+				}
+				names.ID = append(names.ID, ids.Parse(columns[0]))
+				names.Name = append(names.Name, string(columns[1]))
+				return true
+			})
+			if err != nil {
+				return err
+			}
+			for _, p := range partials {
+				table.ID = append(table.ID, p.table.ID...)
+				table.Name = append(table.Name, p.table.Name...)
+			}
+			source.RowsRead, source.RowsSkipped = stats.RowsRead, stats.RowsSkipped
+			*manifest = source
+			return nil
+		}
+	}
+
+	// All the files are parsed concurrently, every one of them has to succeed
+	readers := []func() error{
+		events,
+		commits,
+		names(files.Repos, &tables.Repos, &tables.Manifest.Repos),
+		names(files.Actors, &tables.Actors, &tables.Manifest.Actors),
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, len(readers))
+	for i, reader := range readers {
+		wg.Add(1)
+		go func(i int, reader func() error) {
+			defer wg.Done()
+			errs[i] = reader()
+		}(i, reader)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := Write(dir, tables); err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+// CmdIngest converts the csv files into a cache
+func CmdIngest() *cli.Command {
+	cmdName := "ingest"
+	return &cli.Command{
+		Name:  cmdName,
+		Usage: "Convert the csv files into a binary columnar cache, used by all the analyses while it is fresh",
+		Flags: []cli.Flag{
+			flags.DatasetFlag,
+			flags.ReposFileFlag,
+			flags.EventsFileFlag,
+			flags.CommitsFileFlag,
+			flags.ActorsFileFlag,
+			flags.CacheDirFlag,
+			flags.WorkersFlag,
+			flags.JsonFlag,
+		},
+		Action: func(c *cli.Context) error {
+			if err := flags.Required(c, "repos-file", "events-file", "commits-file", "actors-file"); err != nil {
+				return err
+			}
+			dir := c.String("cache-dir")
+			if dir == "" {
+				return fmt.Errorf("--cache-dir is required to ingest the csv files")
+			}
+			start := time.Now()
+			tables, err := Ingest(Files{
+				Events:  c.String("events-file"),
+				Commits: c.String("commits-file"),
+				Repos:   c.String("repos-file"),
+				Actors:  c.String("actors-file"),
+			}, dir, c.Int("workers"))
+			if err != nil {
+				return err
+			}
+
+			manifest := tables.Manifest
+			if c.Bool("json") {
+				payload, err := json.MarshalIndent(manifest, "", "    ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(payload))
+			} else {
+				table := tablewriter.NewWriter(os.Stdout)
+				table.SetBorder(true)
+				table.SetAutoWrapText(false)
+				table.SetHeader([]string{"File", "Rows", "Skipped", "SHA256"})
+				for _, source := range []Source{manifest.Events, manifest.Commits, manifest.Repos, manifest.Actors} {
+					if source.Path == "" {
+						continue
+					}
+					table.Append([]string{
+						source.Path,
+						strconv.FormatInt(source.RowsRead, 10),
+						strconv.FormatInt(source.RowsSkipped, 10),
+						source.SHA256[:12],
+					})
+				}
+				table.Render()
+			}
+
+			log.Debug().Msgf("[%s] wrote %s in %v", cmdName, dir, time.Since(start))
+			return nil
+		},
+	}
+}
@@ -0,0 +1,131 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cache
+
+import (
+	"github.com/rs/zerolog/log"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dataflow"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dedup"
+	"gitlab.com/ansrivas/go-analyze-git/internal/ranking"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+)
+
+// eventsTable writes the cached events as the rows of events.csv
+type eventsTable struct {
+	ids    *utils.IDs
+	events *Events
+}
+
+func (t eventsTable) Len() int {
+	return len(t.events.ID)
+}
+
+func (t eventsTable) AppendRow(dst []byte, i int) []byte {
+	dst = append(dst, t.ids.String(t.events.ID[i])...)
+	dst = append(dst, ',')
+	dst = append(dst, t.events.Types[t.events.Type[i]]...)
+	dst = append(dst, ',')
+	dst = append(dst, t.ids.String(t.events.Actor[i])...)
+	dst = append(dst, ',')
+	return append(dst, t.ids.String(t.events.Repo[i])...)
+}
+
+// commitsTable writes the cached commits as the rows of commits.csv,
+// with an empty SHA and message unless they were loaded
+type commitsTable struct {
+	ids     *utils.IDs
+	commits *Commits
+}
+
+func (t commitsTable) Len() int {
+	return len(t.commits.Event)
+}
+
+func (t commitsTable) AppendRow(dst []byte, i int) []byte {
+	if t.commits.SHA != nil {
+		dst = append(dst, t.commits.SHA[i]...)
+		dst = append(dst, ',')
+		dst = append(dst, t.commits.Message[i]...)
+		dst = append(dst, ',')
+	} else {
+		dst = append(dst, ',', ',')
+	}
+	return append(dst, t.ids.String(t.commits.Event[i])...)
+}
+
+// namesTable writes the cached names as the rows of repos.csv or actors.csv
+type namesTable struct {
+	ids   *utils.IDs
+	names *Names
+}
+
+func (t namesTable) Len() int {
+	return len(t.names.ID)
+}
+
+func (t namesTable) AppendRow(dst []byte, i int) []byte {
+	dst = append(dst, t.ids.String(t.names.ID[i])...)
+	dst = append(dst, ',')
+	return append(dst, t.names.Name[i]...)
+}
+
+// Inputs returns the tables loaded as the inputs of the rankings
+func (t *Tables) Inputs() ranking.Inputs {
+	stats := t.Stats()
+	inputs := ranking.Inputs{Stats: &stats}
+	if t.files.Events != "" {
+		inputs.Events = dataflow.Input{Table: eventsTable{t.IDs, &t.Events}}
+	}
+	if t.files.Commits != "" {
+		inputs.Commits = dataflow.Input{Table: commitsTable{t.IDs, &t.Commits}}
+	}
+	if t.files.Repos != "" {
+		inputs.Repos = dataflow.Input{Table: namesTable{t.IDs, &t.Repos}}
+	}
+	if t.files.Actors != "" {
+		inputs.Actors = dataflow.Input{Table: namesTable{t.IDs, &t.Actors}}
+	}
+	return inputs
+}
+
+// Inputs returns the inputs of the rankings over the given files, or
+// over their tables cached in dir while they are fresh. A broken cache
+// is logged and skipped, the csv files can always be read instead. The
+// commit SHAs are only loaded if the dedup mode needs them.
+func Inputs(dir string, files Files, mode string) ranking.Inputs {
+	texts := files.Commits != "" && mode != dedup.None
+	tables, err := Open(dir, files, texts)
+	if err != nil {
+		log.Warn().Msgf("Ignoring the cache in %s: %v", dir, err)
+	}
+	if tables != nil {
+		log.Debug().Msgf("Using the cache in %s", dir)
+		return tables.Inputs()
+	}
+	return ranking.Inputs{
+		Events:  dataflow.Input{File: files.Events},
+		Commits: dataflow.Input{File: files.Commits},
+		Repos:   dataflow.Input{File: files.Repos},
+		Actors:  dataflow.Input{File: files.Actors},
+	}
+}
@@ -0,0 +1,131 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package config
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+	"gitlab.com/ansrivas/go-analyze-git/internal/flags"
+)
+
+// Install adds the --config and --profile flags and the config command
+// to the app. The profile is selected before any command runs and fills
+// in the flags of every command, so Install is called once all the
+// commands are registered.
+func Install(app *cli.App) {
+	var selected Selection
+	app.Flags = append(app.Flags, ConfigFlag, ProfileFlag)
+	before := app.Before
+	app.Before = func(c *cli.Context) error {
+		if before != nil {
+			if err := before(c); err != nil {
+				return err
+			}
+		}
+		config, err := Load(c.String(ConfigFlag.Name), c.IsSet(ConfigFlag.Name))
+		if err != nil {
+			return err
+		}
+		selected, err = config.Select(c.String(ProfileFlag.Name))
+		return err
+	}
+	for _, command := range app.Commands {
+		withProfile(command, &selected)
+	}
+	app.Commands = append(app.Commands, command(&selected))
+}
+
+// withProfile applies the profile to the flags of
+// the command and all its subcommands before they run
+func withProfile(command *cli.Command, selected *Selection) {
+	before := command.Before
+	command.Before = func(c *cli.Context) error {
+		if _, err := apply(c, selected.Profile); err != nil {
+			return err
+		}
+		if before != nil {
+			return before(c)
+		}
+		return nil
+	}
+	for _, sub := range command.Subcommands {
+		withProfile(sub, selected)
+	}
+}
+
+// command returns the config command
+func command(selected *Selection) *cli.Command {
+	return &cli.Command{
+		Name:  "config",
+		Usage: "Commands related to the configuration file and its profiles",
+		Action: func(*cli.Context) error {
+			return nil
+		},
+		Subcommands: []*cli.Command{{
+			Name:  "show",
+			Usage: "Show the resolved values of the input files and defaults, and where they come from",
+			Flags: []cli.Flag{
+				flags.DatasetFlag,
+				flags.EventsFileFlag,
+				flags.CommitsFileFlag,
+				flags.ReposFileFlag,
+				flags.ActorsFileFlag,
+				flags.CountFlag,
+				flags.OutputFlag,
+				flags.EventTypeFlag,
+			},
+			Action: func(c *cli.Context) error {
+				sources, err := apply(c, selected.Profile)
+				if err != nil {
+					return err
+				}
+				name := selected.Name
+				if name == "" {
+					name = "none"
+				}
+				fmt.Fprintf(c.App.Writer, "Config: %s\nProfile: %s\n", c.String(ConfigFlag.Name), name)
+				show(c.App.Writer, c, sources)
+				return nil
+			},
+		}},
+	}
+}
+
+// show writes the value and source of every flag of the command
+func show(out io.Writer, c *cli.Context, sources map[string]string) {
+	table := tablewriter.NewWriter(out)
+	table.SetBorder(true)
+	table.SetAutoWrapText(false)
+	table.SetHeader([]string{"Setting", "Value", "Source"})
+	for _, flag := range c.Command.Flags {
+		if flag == cli.HelpFlag {
+			continue
+		}
+		name := flag.Names()[0]
+		table.Append([]string{name, fmt.Sprint(c.Value(name)), sources[name]})
+	}
+	table.Render()
+}
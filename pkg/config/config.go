@@ -0,0 +1,246 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package config reads the configuration file, whose named profiles
+// supply the input files and defaults of every command.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/urfave/cli/v2"
+	"gitlab.com/ansrivas/go-analyze-git/internal/flags"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/dataset"
+	"gopkg.in/yaml.v3"
+)
+
+// Profile holds the values of a dataset, each one set only
+// fills in its flag if neither the flag nor its env var is set
+type Profile struct {
+	// Dataset is a manifest or dataset directory supplying the
+	// input files, it wins over the files of the profile
+	Dataset   string `yaml:"dataset" json:"dataset"`
+	Events    string `yaml:"events-file" json:"events-file"`
+	Commits   string `yaml:"commits-file" json:"commits-file"`
+	Repos     string `yaml:"repos-file" json:"repos-file"`
+	Actors    string `yaml:"actors-file" json:"actors-file"`
+	Count     int    `yaml:"count" json:"count"`
+	Output    string `yaml:"output" json:"output"`
+	EventType string `yaml:"event-type" json:"event-type"`
+}
+
+// values maps the names of the flags to the values the profile sets
+func (p Profile) values() map[string]string {
+	values := map[string]string{
+		flags.DatasetFlag.Name:     p.Dataset,
+		flags.EventsFileFlag.Name:  p.Events,
+		flags.CommitsFileFlag.Name: p.Commits,
+		flags.ReposFileFlag.Name:   p.Repos,
+		flags.ActorsFileFlag.Name:  p.Actors,
+		flags.OutputFlag.Name:      p.Output,
+		flags.EventTypeFlag.Name:   p.EventType,
+	}
+	if p.Count != 0 {
+		values[flags.CountFlag.Name] = strconv.Itoa(p.Count)
+	}
+	for name, value := range values {
+		if value == "" {
+			delete(values, name)
+		}
+	}
+	return values
+}
+
+// Config is the layout of the configuration file
+type Config struct {
+	// Profile is used when no --profile is given
+	Profile  string             `yaml:"profile" json:"profile"`
+	Profiles map[string]Profile `yaml:"profiles" json:"profiles"`
+}
+
+// Flags of the configuration, global to all commands
+var (
+	ConfigFlag = &cli.StringFlag{
+		Name:    "config",
+		Usage:   "Path of the yaml or json configuration file",
+		Value:   DefaultPath(),
+		EnvVars: []string{"CONFIG"},
+	}
+	ProfileFlag = &cli.StringFlag{
+		Name:    "profile",
+		Usage:   "Profile of the configuration file supplying the input files and defaults",
+		EnvVars: []string{"PROFILE"},
+	}
+)
+
+// DefaultPath returns the path of the configuration file of the
+// user, or no path if the user has no configuration directory
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "go-analyze-git", "config.yaml")
+}
+
+// Load reads the configuration file at path. A missing file
+// is an empty configuration unless it was asked for explicitly.
+func Load(path string, explicit bool) (Config, error) {
+	var config Config
+	if path == "" {
+		return config, nil
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	// yaml is a superset of json
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return config, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if config.Profile != "" {
+		if _, err := config.Select(""); err != nil {
+			return config, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return config, nil
+}
+
+// Selection is the profile a run uses
+type Selection struct {
+	// Name is empty if no profile is used
+	Name    string
+	Profile Profile
+}
+
+// Select returns the profile of the given name,
+// or the default profile of the configuration
+func (c Config) Select(name string) (Selection, error) {
+	if name == "" {
+		name = c.Profile
+	}
+	if name == "" {
+		return Selection{}, nil
+	}
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return Selection{}, fmt.Errorf("unknown profile %q, expected one of %q", name, c.names())
+	}
+	return Selection{Name: name, Profile: profile}, nil
+}
+
+// names returns the sorted names of the profiles
+func (c Config) names() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Sources of a resolved value, from the highest precedence to the lowest
+const (
+	SourceFlag    = "flag"
+	SourceEnv     = "env"
+	SourceDataset = "dataset"
+	SourceProfile = "profile"
+	SourceDefault = "default"
+)
+
+// apply sets the flags of the command which the profile has a value for,
+// unless they are set on the command line or by their env var. It returns
+// the source of the value of every flag of the command.
+func apply(c *cli.Context, profile Profile) (map[string]string, error) {
+	values := profile.values()
+	sources := map[string]string{}
+	for _, flag := range c.Command.Flags {
+		name := flag.Names()[0]
+		switch {
+		case c.IsSet(name) && fromEnv(c, flag):
+			sources[name] = SourceEnv
+		case c.IsSet(name):
+			sources[name] = SourceFlag
+		case values[name] != "":
+			if err := c.Set(name, values[name]); err != nil {
+				return nil, fmt.Errorf("profile value of --%s: %w", name, err)
+			}
+			sources[name] = SourceProfile
+		default:
+			sources[name] = SourceDefault
+		}
+	}
+	if sources[flags.DatasetFlag.Name] == "" || c.String(flags.DatasetFlag.Name) == "" {
+		return sources, nil
+	}
+
+	// The files of the dataset win over the ones of the profile
+	path := c.String(flags.DatasetFlag.Name)
+	manifest, err := dataset.LoadManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	files, err := manifest.Files(dataset.MaterializedDir())
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range map[string]string{
+		flags.EventsFileFlag.Name:  files.Events,
+		flags.CommitsFileFlag.Name: files.Commits,
+		flags.ReposFileFlag.Name:   files.Repos,
+		flags.ActorsFileFlag.Name:  files.Actors,
+	} {
+		source, ok := sources[name]
+		if !ok || value == "" || source == SourceFlag || source == SourceEnv {
+			continue
+		}
+		if err := c.Set(name, value); err != nil {
+			return nil, err
+		}
+		sources[name] = SourceDataset
+	}
+	return sources, nil
+}
+
+// fromEnv tells if the value of a set flag is the one of its env var.
+// A flag set on the command line to the value of its env var counts
+// as set by the env var, the two can't be told apart.
+func fromEnv(c *cli.Context, flag cli.Flag) bool {
+	withEnv, ok := flag.(interface{ GetEnvVars() []string })
+	if !ok {
+		return false
+	}
+	for _, env := range withEnv.GetEnvVars() {
+		if value, ok := os.LookupEnv(env); ok {
+			return fmt.Sprint(c.Value(flag.Names()[0])) == value
+		}
+	}
+	return false
+}
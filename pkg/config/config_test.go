@@ -0,0 +1,148 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+	"gitlab.com/ansrivas/go-analyze-git/internal/flags"
+)
+
+const testConfig = `
+profile: small
+profiles:
+  small:
+    events-file: small/events.csv
+    repos-file: small/repos.csv
+    count: 3
+  large:
+    events-file: large/events.csv
+    output: json
+    event-type: ForkEvent
+  snapshot:
+    dataset: ../dataset/testdata
+    repos-file: small/repos.csv
+`
+
+// run runs an app with a command recording the values of its flags
+func run(args ...string) (map[string]string, error) {
+	values := map[string]string{}
+	app := cli.NewApp()
+	app.Writer = &bytes.Buffer{}
+	app.Commands = []*cli.Command{{
+		Name: "rank",
+		Flags: []cli.Flag{
+			flags.DatasetFlag,
+			flags.EventsFileFlag,
+			flags.ReposFileFlag,
+			flags.CountFlag,
+			flags.OutputFlag,
+			flags.EventTypeFlag,
+		},
+		Action: func(c *cli.Context) error {
+			for _, name := range []string{"events-file", "repos-file", "count", "output", "event-type"} {
+				values[name] = c.String(name)
+			}
+			return nil
+		},
+	}}
+	Install(app)
+	return values, app.Run(append([]string{"go-analyze-git"}, args...))
+}
+
+func TestLoad(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.Nil(os.WriteFile(path, []byte(testConfig), 0o644))
+	config, err := Load(path, true)
+	assert.Nil(err)
+	selected, err := config.Select("")
+	assert.Nil(err)
+	assert.Equal(Selection{Name: "small", Profile: Profile{Events: "small/events.csv", Repos: "small/repos.csv", Count: 3}}, selected)
+	selected, err = config.Select("large")
+	assert.Nil(err)
+	assert.Equal("large", selected.Name)
+	_, err = config.Select("medium")
+	assert.NotNil(err)
+
+	missing := filepath.Join(t.TempDir(), "config.yaml")
+	config, err = Load(missing, false)
+	assert.Nil(err)
+	assert.Equal(Config{}, config)
+	_, err = Load(missing, true)
+	assert.NotNil(err)
+
+	assert.Nil(os.WriteFile(path, []byte("profile: medium\nprofiles: {small: {count: 3}}"), 0o644))
+	_, err = Load(path, true)
+	assert.NotNil(err)
+}
+
+func TestPrecedence(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.Nil(os.WriteFile(path, []byte(testConfig), 0o644))
+
+	// The default profile fills in what is not set
+	values, err := run("--config", path, "rank")
+	assert.Nil(err)
+	assert.Equal(map[string]string{
+		"events-file": "small/events.csv",
+		"repos-file":  "small/repos.csv",
+		"count":       "3",
+		"output":      "table",
+		"event-type":  "WatchEvent",
+	}, values)
+
+	// Flags win over env vars, which win over the profile
+	t.Setenv("EVENTS_FILE", "env/events.csv")
+	t.Setenv("COUNT", "5")
+	values, err = run("--config", path, "--profile", "large", "rank", "--count", "7")
+	assert.Nil(err)
+	assert.Equal(map[string]string{
+		"events-file": "env/events.csv",
+		"repos-file":  "",
+		"count":       "7",
+		"output":      "json",
+		"event-type":  "ForkEvent",
+	}, values)
+
+	_, err = run("--config", path, "--profile", "medium", "rank")
+	assert.NotNil(err)
+
+	// The files of a dataset win over the ones of the profile
+	values, err = run("--config", path, "--profile", "snapshot", "rank")
+	assert.Nil(err)
+	assert.Equal("env/events.csv", values["events-file"])
+	assert.Equal(filepath.Join("..", "dataset", "testdata", "repos.csv"), values["repos-file"])
+	values, err = run("--config", path, "rank", "--dataset", "../dataset/testdata", "--repos-file", "mine.csv")
+	assert.Nil(err)
+	assert.Equal("mine.csv", values["repos-file"])
+	assert.Equal("5", values["count"])
+}
@@ -0,0 +1,323 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package custom
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dataflow"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dedup"
+	"gitlab.com/ansrivas/go-analyze-git/internal/flags"
+	"gitlab.com/ansrivas/go-analyze-git/internal/match"
+	"gitlab.com/ansrivas/go-analyze-git/internal/sketch"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/bots"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/cache"
+)
+
+// Analysis runs a definition
+type Analysis struct {
+	Definition
+	// Workers is the number of workers parsing an input file in parallel
+	Workers int
+	// MaxMemory is the budget in bytes of the join state, which is
+	// spilled to disk once exceeded. Zero keeps it all in memory.
+	MaxMemory int64
+	// Counters is the number of counters of the sketch counting
+	// approximately. Zero counts exactly.
+	Counters int
+	// Precision is the precision of the HyperLogLog sketches counting
+	// distinct values approximately. Zero counts exactly.
+	Precision int
+	// Dedup is the dedup.Filter mode dropping duplicated rows,
+	// DedupMemory the size of its Bloom filter
+	Dedup       string
+	DedupMemory int64
+	// Selection tells which rows of the ranking are returned
+	Selection utils.Selection
+	// Filter drops the repositories or actors grouped by by their
+	// name or ID before they are ranked, nil keeps every one
+	Filter *match.Filter
+	// Bots are dropped before ranking the actors, see bots.Excluded
+	Bots bots.Suspects
+	// CacheDir holds the cached tables read instead of the files
+	// while they are fresh, see cache.Inputs
+	CacheDir string
+	// Stats of the most recent run
+	Stats utils.RunStats
+}
+
+// required returns an error if an input file the definition needs is missing
+func (a *Analysis) required(files Inputs) error {
+	needed := [][2]string{{flags.EventsFileFlag.Name, files.Events}}
+	if a.Metric == MetricCommits {
+		needed = append(needed, [2]string{flags.CommitsFileFlag.Name, files.Commits})
+	}
+	if a.Names != NamesNone {
+		if a.GroupBy == GroupByActor {
+			needed = append(needed, [2]string{flags.ActorsFileFlag.Name, files.Actors})
+		} else {
+			needed = append(needed, [2]string{flags.ReposFileFlag.Name, files.Repos})
+		}
+	}
+	for _, n := range needed {
+		if n[1] == "" {
+			return fmt.Errorf("--%s is required by %s", n[0], a.Name)
+		}
+	}
+	return nil
+}
+
+// topK returns the count highest ranked entities of the definition
+func (a *Analysis) topK(count int, files Inputs) (utils.GenericDictHeap, error) {
+	start := time.Now()
+	flow, err := dataflow.New(a.Workers, a.MaxMemory)
+	if err != nil {
+		return nil, err
+	}
+	defer flow.Close()
+	filter, err := dedup.New(a.Dedup, a.DedupMemory)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := cache.Inputs(a.CacheDir, cache.Files{Events: files.Events, Commits: files.Commits, Repos: files.Repos, Actors: files.Actors}, a.Dedup)
+	events := inputs.Events.Source(4, []dataflow.Op{dataflow.NotHeader("id")})
+	if len(a.EventTypes) > 0 {
+		events.Ops = append(events.Ops, dataflow.Where(1, a.EventTypes...))
+	}
+	var counts *sketch.Counter
+	switch a.Metric {
+	case MetricEvents:
+		// Duplicated events share their id and type, the leading columns
+		events.Ops = append(events.Ops, dataflow.Unique(filter, dataflow.Prefix(2)))
+		byKey := dataflow.NewCount(dataflow.Field(a.keyField()), a.Counters)
+		events.Sinks = []dataflow.Sink{byKey}
+		if err := flow.Scan(events); err != nil {
+			return nil, err
+		}
+		counts = byKey.Counts
+	case MetricDistinctActors:
+		events.Ops = append(events.Ops, dataflow.Unique(filter, dataflow.Prefix(2)))
+		actors := dataflow.NewDistinct(dataflow.Field(a.keyField()), dataflow.Field(2), a.Precision)
+		events.Sinks = []dataflow.Sink{actors}
+		if err := flow.Scan(events); err != nil {
+			return nil, err
+		}
+		counts = actors.Counter()
+	case MetricCommits:
+		byEvent := dataflow.NewIndex(dataflow.Field(0), dataflow.Field(a.keyField()))
+		events.Sinks = []dataflow.Sink{byEvent}
+		if err := flow.Scan(events); err != nil {
+			return nil, err
+		}
+		// Every entity of the events is ranked, even without commits
+		commits := dataflow.NewCount(byEvent.Join(-1), a.Counters)
+		commits.AddValues(byEvent)
+		err := flow.Scan(inputs.Commits.Source(0,
+			[]dataflow.Op{dataflow.NotHeader("sha"), dataflow.Known(-1), dataflow.Unique(filter, dataflow.Fields(0, -1))},
+			commits))
+		if err != nil {
+			return nil, err
+		}
+		counts = commits.Counts
+	}
+	if filter.Enabled() {
+		log.Debug().Msgf("Dropped %d duplicated %s", filter.Dropped(), a.Metric)
+	}
+
+	names := map[int64]string{}
+	if a.Names != NamesNone {
+		namesInput := inputs.Repos
+		if a.GroupBy == GroupByActor {
+			namesInput = inputs.Actors
+		}
+		counted := func(id int64) bool {
+			_, _, ok := counts.Get(id)
+			return ok
+		}
+		sink := dataflow.LastNames(dataflow.KnownField(0), 1, counted)
+		if a.Names == NamesFirst {
+			sink = dataflow.FirstNames(dataflow.KnownField(0), 1, counted)
+		}
+		if err := flow.Scan(namesInput.Source(2, nil, sink)); err != nil {
+			return nil, err
+		}
+		names = sink.Names
+	}
+
+	a.Stats = flow.Stats
+	if inputs.Stats != nil {
+		a.Stats = *inputs.Stats
+	}
+	a.Stats.ErrorBound = counts.Bound()
+	a.Stats.Duration = time.Since(start)
+	// A key without a name keeps its ID
+	return dataflow.TopK(counts, count, a.Selection, func(id int64, row *utils.GenericDict) bool {
+		name, ok := names[id]
+		key := flow.IDs.String(id)
+		if !a.Filter.Match(key, name) || a.Bots.Has(key) {
+			return false
+		}
+		if !ok {
+			name = key
+		}
+		row.Key = name
+		return true
+	}), nil
+}
+
+// report describes the rows of the definition
+func (a *Analysis) report(rows utils.GenericDictHeap) utils.Report {
+	report := utils.Report{
+		Command:  a.Name,
+		Rows:     rows,
+		KeyLabel: a.GroupBy,
+		Labels:   map[string]string{"analysis": a.Name},
+		Stats:    a.Stats,
+	}
+	entity, key := "repo", "Repo"
+	if a.GroupBy == GroupByActor {
+		entity, key = "user", "Actor"
+	}
+	switch a.Metric {
+	case MetricEvents:
+		report.Headers = []string{key, "Events"}
+		report.Metric = fmt.Sprintf("git_%s_events_total", entity)
+		report.Help = fmt.Sprintf("Number of events per %s.", a.GroupBy)
+	case MetricCommits:
+		report.Headers = []string{key, "Commits"}
+		report.Metric = fmt.Sprintf("git_%s_commits_total", entity)
+		report.Help = fmt.Sprintf("Number of commits pushed per %s.", a.GroupBy)
+	case MetricDistinctActors:
+		report.Headers = []string{key, "Actors"}
+		report.Metric = fmt.Sprintf("git_%s_distinct_actors", entity)
+		report.Help = fmt.Sprintf("Number of distinct actors of the events per %s.", a.GroupBy)
+	}
+	if a.Metric == MetricDistinctActors && a.Precision > 0 {
+		report.Estimated(a.Precision)
+	} else if a.Metric != MetricDistinctActors && a.Counters > 0 {
+		report.Approximate()
+	}
+	return report
+}
+
+// withDefault copies a file flag, defaulting it to value
+func withDefault(flag *cli.StringFlag, value string) *cli.StringFlag {
+	copied := *flag
+	copied.Value = value
+	return &copied
+}
+
+// Command returns the subcommand running the definition
+func (d Definition) Command() *cli.Command {
+	count := *flags.CountFlag
+	count.Value = d.K
+	include, exclude, filter := flags.RepoIncludeFlag, flags.RepoExcludeFlag, flags.RepoFilter
+	var botFlags []cli.Flag
+	if d.GroupBy == GroupByActor {
+		include, exclude, filter = flags.UserIncludeFlag, flags.UserExcludeFlag, flags.UserFilter
+		botFlags = []cli.Flag{flags.ExcludeBotsFlag, flags.BotsFileFlag, flags.BotCommitsFlag, flags.BotRepeatedFlag}
+	}
+	return &cli.Command{
+		Name:     d.Name,
+		Usage:    d.Usage,
+		Category: "custom analyses",
+		Flags: append([]cli.Flag{
+			flags.DatasetFlag,
+			withDefault(flags.EventsFileFlag, d.Inputs.Events),
+			withDefault(flags.CommitsFileFlag, d.Inputs.Commits),
+			withDefault(flags.ReposFileFlag, d.Inputs.Repos),
+			withDefault(flags.ActorsFileFlag, d.Inputs.Actors),
+			&count,
+			flags.OffsetFlag,
+			flags.OrderFlag,
+			flags.MinFlag,
+			flags.MaxFlag,
+			flags.TiesFlag,
+			include,
+			exclude,
+			flags.WorkersFlag,
+			flags.MaxMemoryFlag,
+			flags.ApproxFlag,
+			flags.ApproxMemoryFlag,
+			flags.HLLPrecisionFlag,
+			flags.DedupFlag,
+			flags.DedupMemoryFlag,
+			flags.CacheDirFlag,
+			flags.JsonFlag,
+			flags.OutputFlag,
+			flags.ChartFlag,
+		}, botFlags...),
+		Action: func(c *cli.Context) error {
+			a := &Analysis{Definition: d, Workers: c.Int(flags.WorkersFlag.Name), CacheDir: c.String(flags.CacheDirFlag.Name)}
+			files := Inputs{
+				Events:  c.String(flags.EventsFileFlag.Name),
+				Commits: c.String(flags.CommitsFileFlag.Name),
+				Repos:   c.String(flags.ReposFileFlag.Name),
+				Actors:  c.String(flags.ActorsFileFlag.Name),
+			}
+			if err := a.required(files); err != nil {
+				return err
+			}
+			var err error
+			if a.MaxMemory, err = flags.MaxMemory(c); err != nil {
+				return err
+			}
+			if a.Counters, err = flags.Counters(c); err != nil {
+				return err
+			}
+			if a.Precision, err = flags.Precision(c); err != nil {
+				return err
+			}
+			if a.Dedup, a.DedupMemory, err = flags.Dedup(c); err != nil {
+				return err
+			}
+			if a.Selection, err = flags.Selection(c); err != nil {
+				return err
+			}
+			if a.Filter, err = filter(c); err != nil {
+				return err
+			}
+			if d.GroupBy == GroupByActor {
+				if a.Bots, err = bots.Excluded(c, files.Events, files.Commits, files.Actors); err != nil {
+					return err
+				}
+			}
+
+			start := time.Now()
+			rows, err := a.topK(c.Int(count.Name), files)
+			if err != nil {
+				return err
+			}
+			if err := a.report(rows).Render(flags.OutputFormat(c), c.Bool(flags.ChartFlag.Name)); err != nil {
+				return err
+			}
+			log.Debug().Msgf("[%s] took %v", d.Name, time.Since(start))
+			return nil
+		},
+	}
+}
@@ -0,0 +1,210 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package custom runs the analyses users declare in a yaml or json
+// file, every definition is registered as a subcommand at startup.
+package custom
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Entities a definition groups by
+const (
+	GroupByRepo  = "repo"
+	GroupByActor = "actor"
+)
+
+// Metrics a definition ranks by
+const (
+	MetricEvents         = "events"
+	MetricCommits        = "commits"
+	MetricDistinctActors = "distinct-actors"
+)
+
+// Joins resolving the names of the ranked keys
+const (
+	NamesFirst = "first"
+	NamesLast  = "last"
+	NamesNone  = "none"
+)
+
+// Inputs are the default input files of a definition,
+// the flags of its subcommand override them
+type Inputs struct {
+	Events  string `yaml:"events" json:"events"`
+	Commits string `yaml:"commits" json:"commits"`
+	Repos   string `yaml:"repos" json:"repos"`
+	Actors  string `yaml:"actors" json:"actors"`
+}
+
+// Definition declares a leaderboard
+type Definition struct {
+	// Name is the name of the subcommand
+	Name  string `yaml:"name" json:"name"`
+	Usage string `yaml:"usage" json:"usage"`
+	// Inputs default the input files
+	Inputs Inputs `yaml:"inputs" json:"inputs"`
+	// EventTypes keeps only the events of these types, all if empty
+	EventTypes []string `yaml:"event-types" json:"event-types"`
+	// GroupBy is the entity ranked, repo or actor
+	GroupBy string `yaml:"group-by" json:"group-by"`
+	// Metric is what the entities are ranked by, the number of their
+	// events, the number of commits pushed by their events, or the
+	// number of distinct actors of a repository
+	Metric string `yaml:"metric" json:"metric"`
+	// Names joins the keys with the repos or actors file, the first or
+	// last name of a key wins, none keeps the IDs. Defaults to last.
+	Names string `yaml:"names" json:"names"`
+	// K is the default number of rows, 10 if not set
+	K int `yaml:"k" json:"k"`
+}
+
+// definitionsFile is the layout of an analyses file
+type definitionsFile struct {
+	Analyses []Definition `yaml:"analyses"`
+}
+
+// validName is the form of a subcommand name
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// FileFlag names the global flag of the analyses file
+const FileFlag = "analyses-file"
+
+// Path returns the analyses file named by the --analyses-file flag
+// among the global flags in args, by $ANALYSES_FILE, or else the
+// analyses.yaml in the user config dir. The subcommands are registered
+// before the flags are parsed, so they are looked up by hand.
+func Path(args []string) string {
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if name != FileFlag {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	if path := os.Getenv("ANALYSES_FILE"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "go-analyze-git", "analyses.yaml")
+}
+
+// Load reads the definitions of a yaml or json file, which lists them
+// under the key analyses. A missing file defines nothing.
+func Load(path string) ([]Definition, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file definitionsFile
+	// yaml is a superset of json
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	names := make(map[string]bool)
+	for i := range file.Analyses {
+		d := &file.Analyses[i]
+		if err := d.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if names[d.Name] {
+			return nil, fmt.Errorf("%s: analysis %q is defined twice", path, d.Name)
+		}
+		names[d.Name] = true
+	}
+	return file.Analyses, nil
+}
+
+// validate checks the definition and fills in its defaults
+func (d *Definition) validate() error {
+	if !validName.MatchString(d.Name) {
+		return fmt.Errorf("invalid analysis name %q, expected lowercase letters, digits and dashes", d.Name)
+	}
+	switch d.GroupBy {
+	case GroupByRepo, GroupByActor:
+	default:
+		return fmt.Errorf("analysis %s: unknown group-by %q, expected one of %q", d.Name, d.GroupBy,
+			[]string{GroupByRepo, GroupByActor})
+	}
+	switch d.Metric {
+	case MetricEvents, MetricCommits:
+	case MetricDistinctActors:
+		if d.GroupBy != GroupByRepo {
+			return fmt.Errorf("analysis %s: %s needs group-by %s", d.Name, MetricDistinctActors, GroupByRepo)
+		}
+	default:
+		return fmt.Errorf("analysis %s: unknown metric %q, expected one of %q", d.Name, d.Metric,
+			[]string{MetricEvents, MetricCommits, MetricDistinctActors})
+	}
+	switch d.Names {
+	case "":
+		d.Names = NamesLast
+	case NamesFirst, NamesLast, NamesNone:
+	default:
+		return fmt.Errorf("analysis %s: unknown names %q, expected one of %q", d.Name, d.Names,
+			[]string{NamesFirst, NamesLast, NamesNone})
+	}
+	if d.K < 0 {
+		return fmt.Errorf("analysis %s: k must not be negative, got %d", d.Name, d.K)
+	}
+	if d.K == 0 {
+		d.K = 10
+	}
+	if d.Usage == "" {
+		d.Usage = fmt.Sprintf("Top K %ss by %s", d.GroupBy, strings.ReplaceAll(d.Metric, "-", " "))
+		if len(d.EventTypes) > 0 {
+			d.Usage += " of type " + strings.Join(d.EventTypes, ", ")
+		}
+	}
+	return nil
+}
+
+// keyField is the column of the events holding the ranked entity
+func (d *Definition) keyField() int {
+	if d.GroupBy == GroupByActor {
+		return 2
+	}
+	return 3
+}
@@ -0,0 +1,190 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package custom
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/cache"
+)
+
+var testFiles = Inputs{
+	Events:  "testdata/events.csv",
+	Commits: "testdata/commits.csv",
+	Repos:   "testdata/repos.csv",
+	Actors:  "testdata/actors.csv",
+}
+
+// values maps the keys of rows to their values
+func values(rows utils.GenericDictHeap) map[string]int {
+	m := map[string]int{}
+	for _, row := range rows {
+		m[row.Key] = row.Value
+	}
+	return m
+}
+
+func TestLoad(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "analyses.yaml")
+	content := `
+analyses:
+  - name: stars
+    event-types: [WatchEvent]
+    group-by: repo
+    metric: events
+  - name: pushers
+    usage: Users pushing the most commits
+    group-by: actor
+    metric: commits
+    names: none
+    k: 3
+`
+	assert.Nil(os.WriteFile(path, []byte(content), 0o644))
+	definitions, err := Load(path)
+	assert.Nil(err)
+	assert.Equal([]Definition{{
+		Name:       "stars",
+		Usage:      "Top K repos by events of type WatchEvent",
+		EventTypes: []string{"WatchEvent"},
+		GroupBy:    GroupByRepo,
+		Metric:     MetricEvents,
+		Names:      NamesLast,
+		K:          10,
+	}, {
+		Name:    "pushers",
+		Usage:   "Users pushing the most commits",
+		GroupBy: GroupByActor,
+		Metric:  MetricCommits,
+		Names:   NamesNone,
+		K:       3,
+	}}, definitions)
+
+	definitions, err = Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Nil(err)
+	assert.Nil(definitions)
+
+	for _, content := range []string{
+		`analyses: [{name: Stars, group-by: repo, metric: events}]`,
+		`analyses: [{name: stars, group-by: org, metric: events}]`,
+		`analyses: [{name: stars, group-by: repo, metric: forks}]`,
+		`analyses: [{name: stars, group-by: actor, metric: distinct-actors}]`,
+		`analyses: [{name: stars, group-by: repo, metric: events, names: middle}]`,
+		`analyses: [{name: stars, group-by: repo, metric: events, k: -1}]`,
+		`analyses: [{name: stars, group-by: repo, metric: events}, {name: stars, group-by: actor, metric: events}]`,
+		`analyses: {name: stars}`,
+	} {
+		assert.Nil(os.WriteFile(path, []byte(content), 0o644))
+		_, err := Load(path)
+		assert.NotNil(err, content)
+	}
+}
+
+func TestPath(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("a.yaml", Path([]string{"go-analyze-git", "--debug", "--analyses-file", "a.yaml", "run"}))
+	assert.Equal("b.yaml", Path([]string{"go-analyze-git", "--analyses-file=b.yaml"}))
+
+	// Flags of a subcommand are not global
+	t.Setenv("ANALYSES_FILE", "env.yaml")
+	assert.Equal("env.yaml", Path([]string{"go-analyze-git", "stars", "--analyses-file", "a.yaml"}))
+}
+
+func TestTopK(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, test := range []struct {
+		definition Definition
+		expected   map[string]int
+	}{{
+		Definition{EventTypes: []string{"WatchEvent"}, GroupBy: GroupByRepo, Metric: MetricEvents},
+		map[string]int{"testrepo2": 3, "testrepo1": 2, "testrepo3": 1},
+	}, {
+		Definition{EventTypes: []string{"WatchEvent"}, GroupBy: GroupByRepo, Metric: MetricDistinctActors},
+		map[string]int{"testrepo2": 3, "testrepo1": 2, "testrepo3": 1},
+	}, {
+		Definition{EventTypes: []string{"PushEvent"}, GroupBy: GroupByRepo, Metric: MetricCommits},
+		map[string]int{"testrepo2": 5, "repowithpushevent1": 2, "129750935": 1},
+	}, {
+		Definition{EventTypes: []string{"PushEvent", "CreateEvent"}, GroupBy: GroupByActor, Metric: MetricEvents, Names: NamesNone},
+		map[string]int{"38429025": 4, "52553888": 3, "52553915": 2},
+	}} {
+		d := test.definition
+		d.Name = "test"
+		assert.Nil(d.validate())
+		a := &Analysis{Definition: d, Workers: 2}
+		assert.Nil(a.required(testFiles))
+		rows, err := a.topK(3, testFiles)
+		assert.Nil(err)
+		assert.Equal(test.expected, values(rows), d.Usage)
+	}
+}
+
+func TestTopKAllEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	// The cached tables rank the same rows
+	dir := t.TempDir()
+	_, err := cache.Ingest(cache.Files{Events: testFiles.Events, Commits: testFiles.Commits, Repos: testFiles.Repos, Actors: testFiles.Actors}, dir, 1)
+	assert.Nil(err)
+	// Without event types the header rows must not be counted either
+	for _, test := range []struct {
+		definition Definition
+		expected   map[string]int
+	}{{
+		Definition{GroupBy: GroupByRepo, Metric: MetricEvents},
+		map[string]int{"testrepo2": 9, "repowithpushevent1": 3, "testrepo1": 2, "testrepo3": 2, "129750935": 1},
+	}, {
+		Definition{GroupBy: GroupByActor, Metric: MetricCommits},
+		map[string]int{"Apexal": 4, "anggi1234": 3, "onosendi": 2, "alice": 0, "bob": 0, "carol": 0},
+	}} {
+		d := test.definition
+		d.Name = "test"
+		assert.Nil(d.validate())
+		for _, cacheDir := range []string{"", dir} {
+			a := &Analysis{Definition: d, Workers: 2, CacheDir: cacheDir}
+			rows, err := a.topK(10, testFiles)
+			assert.Nil(err)
+			assert.Equal(test.expected, values(rows), "%s by %s, cache %q", d.Metric, d.GroupBy, cacheDir)
+		}
+	}
+}
+
+func TestRequired(t *testing.T) {
+	assert := assert.New(t)
+
+	a := &Analysis{Definition: Definition{Name: "test", GroupBy: GroupByActor, Metric: MetricCommits}}
+	assert.Nil(a.validate())
+	assert.Nil(a.required(testFiles))
+	assert.NotNil(a.required(Inputs{Events: testFiles.Events, Actors: testFiles.Actors}))
+	assert.NotNil(a.required(Inputs{Events: testFiles.Events, Commits: testFiles.Commits}))
+
+	a.Names = NamesNone
+	assert.Nil(a.required(Inputs{Events: testFiles.Events, Commits: testFiles.Commits}))
+}
@@ -0,0 +1,299 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dataset
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/oklog/run"
+	"github.com/rs/zerolog/log"
+	"gitlab.com/ansrivas/go-analyze-git/internal/fileops"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/cache"
+)
+
+// Event is a single row of events.csv
+type Event struct {
+	ID      string
+	Type    string
+	ActorID string
+	RepoID  string
+}
+
+// Commit is a single row of commits.csv
+type Commit struct {
+	SHA     string
+	Message string
+	EventID string
+}
+
+// Repo is a single row of repos.csv
+type Repo struct {
+	ID   string
+	Name string
+}
+
+// Actor is a single row of actors.csv
+type Actor struct {
+	ID    string
+	Login string
+}
+
+// Files holds the paths of the csv files making up a dataset.
+// Empty paths are skipped while loading.
+type Files struct {
+	Events  string
+	Commits string
+	Repos   string
+	Actors  string
+	// CacheDir is the directory of an ingested cache, which is
+	// read instead of the csv files while it is fresh
+	CacheDir string
+}
+
+// Dataset holds all the rows of a git data set in memory,
+// so that several questions can be answered without re-reading
+// the csv files
+type Dataset struct {
+	Events  []Event
+	Commits []Commit
+	Repos   []Repo
+	Actors  []Actor
+
+	// Stats of loading the dataset
+	Stats utils.RunStats
+
+	repoNames  map[string]string
+	repoIDs    map[string]string
+	actorNames map[string]string
+	actorIDs   map[string]string
+}
+
+// Load reads all the given files concurrently into a new Dataset
+func Load(files Files) (*Dataset, error) {
+	start := time.Now()
+	if d := loadCached(files); d != nil {
+		d.Stats.Duration = time.Since(start)
+		log.Debug().Msgf("Loaded %d events, %d commits, %d repos and %d actors from the cache in %v",
+			len(d.Events), len(d.Commits), len(d.Repos), len(d.Actors), d.Stats.Duration)
+		return d, nil
+	}
+	d := &Dataset{}
+	// One stats instance per file, since they are filled concurrently
+	stats := make([]utils.RunStats, 4)
+
+	var g run.Group
+	// header is the first column of the optional header row of the file.
+	// The lines of a batch are only valid until it is released, parse
+	// copies what it keeps.
+	addFile := func(fname, header string, stats *utils.RunStats, parse func(line []byte) bool) {
+		if fname == "" {
+			return
+		}
+		prefix := []byte(header + ",")
+		g.Add(func() error {
+			_, err := utils.ReadParallel(fname, 1, func(batches <-chan *fileops.Batch) struct{} {
+				first := true
+				for batch := range batches {
+					for _, line := range batch.Lines {
+						stats.RowsRead++
+						if first && bytes.HasPrefix(line, prefix) {
+							first = false
+							continue
+						}
+						first = false
+						if !parse(line) {
+							stats.RowsSkipped++
+						}
+					}
+					batch.Release()
+				}
+				return struct{}{}
+			})
+			return err
+		}, utils.InterruptFunc("The "+fname+" reader was interrupted with: %v\n"))
+	}
+
+	// Every file is parsed by a single goroutine, which owns its fields
+	var eventFields, repoFields, actorFields [][]byte
+	addFile(files.Events, "id", &stats[0], func(line []byte) bool {
+		eventFields = fileops.SplitFields(line, ',', eventFields)
+		if len(eventFields) != 4 {
+			return false
+		}
+		d.Events = append(d.Events, Event{
+			ID:      string(eventFields[0]),
+			Type:    string(eventFields[1]),
+			ActorID: string(eventFields[2]),
+			RepoID:  string(eventFields[3]),
+		})
+		return true
+	})
+	addFile(files.Commits, "sha", &stats[1], func(line []byte) bool {
+		// Commit messages may contain commas, the sha is always
+		// the first and the event id always the last column. A row
+		// of fewer than 3 columns is skipped.
+		first := bytes.IndexByte(line, ',')
+		last := bytes.LastIndexByte(line, ',')
+		if first < 0 || last == first {
+			return false
+		}
+		d.Commits = append(d.Commits, Commit{
+			SHA:     string(line[:first]),
+			Message: string(line[first+1 : last]),
+			EventID: string(line[last+1:]),
+		})
+		return true
+	})
+	addFile(files.Repos, "id", &stats[2], func(line []byte) bool {
+		repoFields = fileops.SplitFields(line, ',', repoFields)
+		if len(repoFields) != 2 {
+			return false
+		}
+		d.Repos = append(d.Repos, Repo{ID: string(repoFields[0]), Name: string(repoFields[1])})
+		return true
+	})
+	addFile(files.Actors, "id", &stats[3], func(line []byte) bool {
+		actorFields = fileops.SplitFields(line, ',', actorFields)
+		if len(actorFields) != 2 {
+			return false
+		}
+		d.Actors = append(d.Actors, Actor{ID: string(actorFields[0]), Login: string(actorFields[1])})
+		return true
+	})
+
+	if err := g.Run(); err != nil {
+		return nil, err
+	}
+
+	for _, s := range stats {
+		d.Stats.RowsRead += s.RowsRead
+		d.Stats.RowsSkipped += s.RowsSkipped
+	}
+	d.buildIndexes()
+	d.Stats.Duration = time.Since(start)
+	log.Debug().Msgf("Loaded %d events, %d commits, %d repos and %d actors in %v",
+		len(d.Events), len(d.Commits), len(d.Repos), len(d.Actors), d.Stats.Duration)
+	return d, nil
+}
+
+// loadCached returns the dataset from the cache of the files,
+// or nil if there is no fresh cache
+func loadCached(files Files) *Dataset {
+	tables, err := cache.Open(files.CacheDir, cache.Files{
+		Events:  files.Events,
+		Commits: files.Commits,
+		Repos:   files.Repos,
+		Actors:  files.Actors,
+	}, true)
+	if err != nil {
+		log.Warn().Msgf("Ignoring the cache in %s: %v", files.CacheDir, err)
+		return nil
+	}
+	if tables == nil {
+		return nil
+	}
+
+	ids := tables.IDs
+	d := &Dataset{
+		Events:  make([]Event, len(tables.Events.ID)),
+		Commits: make([]Commit, len(tables.Commits.Event)),
+		Repos:   make([]Repo, len(tables.Repos.ID)),
+		Actors:  make([]Actor, len(tables.Actors.ID)),
+		Stats:   tables.Stats(),
+	}
+	for i := range d.Events {
+		d.Events[i] = Event{
+			ID:      ids.String(tables.Events.ID[i]),
+			Type:    tables.Events.Types[tables.Events.Type[i]],
+			ActorID: ids.String(tables.Events.Actor[i]),
+			RepoID:  ids.String(tables.Events.Repo[i]),
+		}
+	}
+	for i := range d.Commits {
+		d.Commits[i] = Commit{
+			SHA:     tables.Commits.SHA[i],
+			Message: tables.Commits.Message[i],
+			EventID: ids.String(tables.Commits.Event[i]),
+		}
+	}
+	for i := range d.Repos {
+		d.Repos[i] = Repo{ID: ids.String(tables.Repos.ID[i]), Name: tables.Repos.Name[i]}
+	}
+	for i := range d.Actors {
+		d.Actors[i] = Actor{ID: ids.String(tables.Actors.ID[i]), Login: tables.Actors.Name[i]}
+	}
+	d.buildIndexes()
+	return d
+}
+
+// buildIndexes builds the id <-> name lookups. The first
+// occurrence wins for duplicated ids and names.
+func (d *Dataset) buildIndexes() {
+	d.repoNames = make(map[string]string, len(d.Repos))
+	d.repoIDs = make(map[string]string, len(d.Repos))
+	for _, repo := range d.Repos {
+		if _, ok := d.repoNames[repo.ID]; !ok {
+			d.repoNames[repo.ID] = repo.Name
+		}
+		if _, ok := d.repoIDs[repo.Name]; !ok {
+			d.repoIDs[repo.Name] = repo.ID
+		}
+	}
+
+	d.actorNames = make(map[string]string, len(d.Actors))
+	d.actorIDs = make(map[string]string, len(d.Actors))
+	for _, actor := range d.Actors {
+		if _, ok := d.actorNames[actor.ID]; !ok {
+			d.actorNames[actor.ID] = actor.Login
+		}
+		if _, ok := d.actorIDs[actor.Login]; !ok {
+			d.actorIDs[actor.Login] = actor.ID
+		}
+	}
+}
+
+// RepoName returns the name of the repository with the given id
+func (d *Dataset) RepoName(id string) (string, bool) {
+	name, ok := d.repoNames[id]
+	return name, ok
+}
+
+// RepoID returns the id of the repository with the given name
+func (d *Dataset) RepoID(name string) (string, bool) {
+	id, ok := d.repoIDs[name]
+	return id, ok
+}
+
+// ActorLogin returns the login of the actor with the given id
+func (d *Dataset) ActorLogin(id string) (string, bool) {
+	login, ok := d.actorNames[id]
+	return login, ok
+}
+
+// ActorID returns the id of the actor with the given login
+func (d *Dataset) ActorID(login string) (string, bool) {
+	id, ok := d.actorIDs[login]
+	return id, ok
+}
@@ -0,0 +1,187 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dataset
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dedup"
+	"gitlab.com/ansrivas/go-analyze-git/internal/ranking"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/cache"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/events"
+)
+
+var testFiles = Files{
+	Events:  "testdata/events.csv",
+	Commits: "testdata/commits.csv",
+	Repos:   "testdata/repos.csv",
+	Actors:  "testdata/actors.csv",
+}
+
+func TestLoad(t *testing.T) {
+	assert := assert.New(t)
+
+	d, err := Load(testFiles)
+	assert.Nil(err)
+
+	// Header rows are skipped
+	assert.Len(d.Events, 17)
+	assert.Len(d.Commits, 9)
+	assert.Len(d.Repos, 4)
+	assert.Len(d.Actors, 6)
+	assert.Equal(int64(41), d.Stats.RowsRead)
+	assert.Equal(int64(1), d.Stats.RowsSkipped)
+
+	assert.Equal(Commit{
+		SHA:     "bf7296401598660b44d8923787a2600f346f9a81",
+		Message: "Fix, with a comma",
+		EventID: "11185452664",
+	}, d.Commits[1])
+
+	name, ok := d.RepoName("231065965")
+	assert.True(ok)
+	assert.Equal("testrepo2", name)
+	id, ok := d.ActorID("Apexal")
+	assert.True(ok)
+	assert.Equal("38429025", id)
+
+	_, err = Load(Files{Events: "testdata/missing.csv"})
+	assert.NotNil(err)
+}
+
+func TestLoadMalformedCommits(t *testing.T) {
+	assert := assert.New(t)
+
+	// Rows of fewer than 3 columns are skipped like by the csv readers
+	commitsFile := filepath.Join(t.TempDir(), "commits.csv")
+	content := "sha,message,event_id\nabc,11185452664\nabc\ndef,Fix, with a comma,11185452664\n"
+	assert.Nil(os.WriteFile(commitsFile, []byte(content), 0o600))
+
+	d, err := Load(Files{Commits: commitsFile})
+	assert.Nil(err)
+	assert.Equal([]Commit{{SHA: "def", Message: "Fix, with a comma", EventID: "11185452664"}}, d.Commits)
+	assert.Equal(int64(4), d.Stats.RowsRead)
+	assert.Equal(int64(2), d.Stats.RowsSkipped)
+}
+
+func TestLoadCached(t *testing.T) {
+	assert := assert.New(t)
+
+	expected, err := Load(testFiles)
+	assert.Nil(err)
+
+	files := testFiles
+	files.CacheDir = t.TempDir()
+	_, err = cache.Ingest(cache.Files{
+		Events:  files.Events,
+		Commits: files.Commits,
+		Repos:   files.Repos,
+		Actors:  files.Actors,
+	}, files.CacheDir, 2)
+	assert.Nil(err)
+
+	d, err := Load(files)
+	assert.Nil(err)
+	assert.Equal(expected.Events, d.Events)
+	assert.Equal(expected.Commits, d.Commits)
+	assert.Equal(expected.Repos, d.Repos)
+	assert.Equal(expected.Actors, d.Actors)
+	assert.Equal(expected.Stats.RowsRead, d.Stats.RowsRead)
+	assert.Equal(expected.Stats.RowsSkipped, d.Stats.RowsSkipped)
+	name, ok := d.RepoName("231065965")
+	assert.True(ok)
+	assert.Equal("testrepo2", name)
+}
+
+func TestRankings(t *testing.T) {
+	assert := assert.New(t)
+
+	d, err := Load(testFiles)
+	assert.Nil(err)
+
+	results, err := d.Rank(&ranking.Engine{Workers: 2, Dedup: dedup.Exact},
+		ranking.Ranking{Analysis: ranking.ReposByEvents, Count: 3},
+		ranking.Ranking{Analysis: ranking.ReposByCommits, Count: 3},
+		ranking.Ranking{Analysis: ranking.UsersByCommits, Count: 3},
+	)
+	assert.Nil(err)
+	assert.Equal(utils.GenericDictHeap{
+		{Key: "testrepo2", Value: 3},
+		{Key: "testrepo1", Value: 2},
+		{Key: "testrepo3", Value: 1},
+	}, results[0].Rows)
+
+	assert.Equal(utils.GenericDictHeap{
+		{Key: "testrepo2", Value: 5, Extra: map[string]int{ranking.ContributorsHeader: 2}},
+		{Key: "repowithpushevent1", Value: 2, Extra: map[string]int{ranking.ContributorsHeader: 1}},
+		{Key: "129750935", Value: 1, Extra: map[string]int{ranking.ContributorsHeader: 1}},
+	}, results[1].Rows)
+
+	assert.Equal(utils.GenericDictHeap{
+		{Key: "Apexal", Value: 4, Extra: map[string]int{ranking.ReposHeader: 2}},
+		{Key: "anggi1234", Value: 3, Extra: map[string]int{ranking.ReposHeader: 1}},
+		{Key: "onosendi", Value: 2, Extra: map[string]int{ranking.ReposHeader: 1}},
+	}, results[2].Rows)
+	assert.Equal(d.Stats.RowsRead, results[0].Stats.RowsRead)
+
+	assert.Equal(utils.GenericDictHeap{
+		{Key: "onosendi", Value: 4},
+		{Key: "anggi1234", Value: 3},
+	}, d.RepoContributors(2, "231065965"))
+
+	assert.Equal(utils.GenericDictHeap{
+		{Key: "repowithpushevent1", Value: 3},
+	}, d.UserRepos(10, "38429025"))
+}
+
+func TestSearch(t *testing.T) {
+	assert := assert.New(t)
+
+	d, err := Load(testFiles)
+	assert.Nil(err)
+
+	assert.Equal([]SearchResult{
+		{Kind: "repo", ID: "129750934", Name: "repowithpushevent1"},
+		{Kind: "repo", ID: "212382045", Name: "testrepo1"},
+	}, d.Search(2, "REPO"))
+	assert.Equal([]SearchResult{
+		{Kind: "user", ID: "52553915", Name: "anggi1234"},
+	}, d.Search(10, "gi"))
+}
+
+func TestUserActivity(t *testing.T) {
+	assert := assert.New(t)
+
+	d, err := Load(testFiles)
+	assert.Nil(err)
+
+	assert.Equal(Activity{
+		Events:  map[string]int{events.Push: 3, events.Create: 1},
+		Commits: 3,
+	}, d.UserActivity("38429025"))
+	assert.Equal(Activity{Events: map[string]int{}}, d.UserActivity("0"))
+}
@@ -0,0 +1,435 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dataset
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gitlab.com/ansrivas/go-analyze-git/internal/flags"
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestName is the manifest looked up in a dataset directory
+const ManifestName = "dataset.yaml"
+
+// Tables of a dataset
+const (
+	TableEvents  = "events"
+	TableCommits = "commits"
+	TableRepos   = "repos"
+	TableActors  = "actors"
+)
+
+// Columns are the columns of every table in the order the analyses read them
+var Columns = map[string][]string{
+	TableEvents:  {"id", "type", "actor_id", "repo_id"},
+	TableCommits: {"sha", "message", "event_id"},
+	TableRepos:   {"id", "name"},
+	TableActors:  {"id", "username"},
+}
+
+// Tables lists the tables in a fixed order
+var Tables = []string{TableEvents, TableCommits, TableRepos, TableActors}
+
+// File is a single file of a table. A manifest may list it
+// by its path alone, a path may be a glob matching shards.
+type File struct {
+	Path string `yaml:"path" json:"path"`
+	// SHA256 is the hex checksum of the file, checked by validate
+	SHA256 string `yaml:"sha256" json:"sha256"`
+	// Size is the size of the file in bytes, checked on every use
+	Size int64 `yaml:"size" json:"size"`
+}
+
+// UnmarshalYAML accepts a file given by its path alone
+func (f *File) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		f.Path = value.Value
+		return nil
+	}
+	type plain File
+	return value.Decode((*plain)(f))
+}
+
+// Table is one of the csv tables of a dataset, possibly sharded
+// into several files which may be gzip compressed
+type Table struct {
+	Files []File `yaml:"files" json:"files"`
+	// Columns names the columns of the files in their order. Columns
+	// the analyses don't read are dropped, empty means the usual order.
+	Columns []string `yaml:"columns" json:"columns"`
+}
+
+// TimeRange is the period a dataset covers
+type TimeRange struct {
+	From time.Time `yaml:"from" json:"from"`
+	To   time.Time `yaml:"to" json:"to"`
+}
+
+// Manifest describes a snapshot of the four tables
+type Manifest struct {
+	Name      string    `yaml:"name" json:"name"`
+	TimeRange TimeRange `yaml:"time-range" json:"time-range"`
+	Events    Table     `yaml:"events" json:"events"`
+	Commits   Table     `yaml:"commits" json:"commits"`
+	Repos     Table     `yaml:"repos" json:"repos"`
+	Actors    Table     `yaml:"actors" json:"actors"`
+	// Dir is the directory relative paths are resolved against
+	Dir string `yaml:"-" json:"-"`
+}
+
+// Table returns the table of the given name
+func (m *Manifest) Table(name string) *Table {
+	switch name {
+	case TableEvents:
+		return &m.Events
+	case TableCommits:
+		return &m.Commits
+	case TableRepos:
+		return &m.Repos
+	case TableActors:
+		return &m.Actors
+	}
+	return nil
+}
+
+// LoadManifest reads the manifest at path, which is either a yaml or
+// json file or a directory. A directory without a dataset.yaml is
+// described by the files named after the tables in it, like events.csv,
+// events-0001.csv.gz or events/part-0001.csv.
+func LoadManifest(path string) (*Manifest, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		manifest := filepath.Join(path, ManifestName)
+		if _, err := os.Stat(manifest); errors.Is(err, os.ErrNotExist) {
+			return discover(path)
+		}
+		path = manifest
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{Dir: filepath.Dir(path)}
+	// yaml is a superset of json
+	if err := yaml.Unmarshal(content, m); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// discover describes the tables of a directory by their file names
+func discover(dir string) (*Manifest, error) {
+	m := &Manifest{Name: filepath.Base(dir), Dir: dir}
+	for _, name := range Tables {
+		var paths []string
+		for _, pattern := range []string{"%s.csv", "%s.csv.gz", "%s-*.csv", "%s-*.csv.gz", "%s/*.csv", "%s/*.csv.gz"} {
+			matches, err := filepath.Glob(filepath.Join(dir, fmt.Sprintf(pattern, name)))
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, matches...)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			rel, _ := filepath.Rel(dir, path)
+			m.Table(name).Files = append(m.Table(name).Files, File{Path: rel})
+		}
+	}
+	if m.Events.Files == nil {
+		return nil, fmt.Errorf("found neither %s nor an events file in %s", ManifestName, dir)
+	}
+	return m, nil
+}
+
+// validate checks the column mappings and the time range
+func (m *Manifest) validate() error {
+	for _, name := range Tables {
+		table := m.Table(name)
+		if len(table.Columns) == 0 {
+			continue
+		}
+		for _, column := range Columns[name] {
+			if index(table.Columns, column) < 0 {
+				return fmt.Errorf("the columns of %s miss %s", name, column)
+			}
+		}
+		for _, file := range table.Files {
+			if file.Path == "" {
+				return fmt.Errorf("a file of %s has no path", name)
+			}
+		}
+	}
+	if !m.TimeRange.To.IsZero() && m.TimeRange.To.Before(m.TimeRange.From) {
+		return fmt.Errorf("the time range ends at %s before it starts at %s", m.TimeRange.To, m.TimeRange.From)
+	}
+	return nil
+}
+
+// index returns the position of value in values, or -1
+func index(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// paths returns the paths of the files of a table with
+// their globs expanded, relative to the manifest
+func (m *Manifest) paths(table *Table) ([]string, error) {
+	var paths []string
+	for _, file := range table.Files {
+		path := file.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(m.Dir, path)
+		}
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no file matches %s", path)
+		}
+		if file.Size > 0 || file.SHA256 != "" {
+			if len(matches) > 1 {
+				return nil, fmt.Errorf("%s matches %d files, a size or checksum belongs to a single file", path, len(matches))
+			}
+		}
+		if file.Size > 0 {
+			info, err := os.Stat(matches[0])
+			if err != nil {
+				return nil, err
+			}
+			if info.Size() != file.Size {
+				return nil, fmt.Errorf("%s has %d bytes, the manifest expects %d. Is it from another snapshot?", matches[0], info.Size(), file.Size)
+			}
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// Files returns the csv files the analyses read. A table made of a
+// single plain file in the usual column order is read in place, any
+// other is written once as such a file into dir and reused while none
+// of its files change.
+func (m *Manifest) Files(dir string) (Files, error) {
+	var files Files
+	for _, name := range Tables {
+		table := m.Table(name)
+		if len(table.Files) == 0 {
+			continue
+		}
+		paths, err := m.paths(table)
+		if err != nil {
+			return Files{}, fmt.Errorf("%s of %s: %w", name, m.Name, err)
+		}
+		path := paths[0]
+		if len(paths) > 1 || compressed(path) || !usual(name, table.Columns) {
+			if path, err = materialize(dir, name, table.Columns, paths); err != nil {
+				return Files{}, fmt.Errorf("%s of %s: %w", name, m.Name, err)
+			}
+		}
+		switch name {
+		case TableEvents:
+			files.Events = path
+		case TableCommits:
+			files.Commits = path
+		case TableRepos:
+			files.Repos = path
+		case TableActors:
+			files.Actors = path
+		}
+	}
+	return files, nil
+}
+
+// MaterializedDir is the directory receiving the normalised files of
+// sharded, compressed or remapped tables, inside the default cache dir
+func MaterializedDir() string {
+	dir := flags.CacheDirFlag.Value
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "datasets")
+}
+
+// compressed tells if the file at path is gzip compressed
+func compressed(path string) bool {
+	return strings.HasSuffix(path, ".gz")
+}
+
+// usual tells if columns are the usual columns of the table
+func usual(name string, columns []string) bool {
+	if len(columns) == 0 {
+		return true
+	}
+	return strings.Join(columns, ",") == strings.Join(Columns[name], ",")
+}
+
+// materialize writes the files of a table into a single plain csv file
+// with the usual header and columns, and returns its path. Its name
+// hashes the files and their mapping, so that it is reused until they change.
+func materialize(dir, name string, columns []string, paths []string) (string, error) {
+	hash := sha256.New()
+	fmt.Fprintln(hash, strings.Join(columns, ","))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintln(hash, abs, info.Size(), info.ModTime().UnixNano())
+	}
+	target := filepath.Join(dir, fmt.Sprintf("%s-%s.csv", name, hex.EncodeToString(hash.Sum(nil))[:16]))
+	if _, err := os.Stat(target); err == nil {
+		return target, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(dir, name+"-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	out := bufio.NewWriter(tmp)
+	fmt.Fprintln(out, strings.Join(Columns[name], ","))
+	for _, path := range paths {
+		if err := copyTable(out, path, name, columns); err != nil {
+			tmp.Close()
+			return "", fmt.Errorf("reading %s: %w", path, err)
+		}
+	}
+	if err := out.Flush(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return target, os.Rename(tmp.Name(), target)
+}
+
+// copyTable appends the rows of the file at path to out in the usual
+// order of columns, dropping the header row of the file
+func copyTable(out io.Writer, path, name string, columns []string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var reader io.Reader = f
+	if compressed(path) {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	header := Columns[name][0]
+	if len(columns) > 0 {
+		header = columns[0]
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	first := true
+	for scanner.Scan() {
+		line := scanner.Text()
+		if first && strings.HasPrefix(line, header+",") {
+			first = false
+			continue
+		}
+		first = false
+		if !usual(name, columns) {
+			line = reorder(line, name, columns)
+		}
+		if _, err := io.WriteString(out, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// reorder moves the fields of line from the order of columns into the
+// usual order. A commit message may contain commas, the fields exceeding
+// the columns belong to it.
+func reorder(line, name string, columns []string) string {
+	fields := strings.Split(line, ",")
+	if extra := len(fields) - len(columns); extra > 0 {
+		message := index(columns, "message")
+		if name != TableCommits || message < 0 {
+			return line
+		}
+		joined := strings.Join(fields[message:message+extra+1], ",")
+		fields = append(append(fields[:message:message], joined), fields[message+extra+1:]...)
+	} else if extra < 0 {
+		// Malformed rows are left to the analyses to skip
+		return line
+	}
+	usual := Columns[name]
+	out := make([]string, len(usual))
+	for i, column := range usual {
+		out[i] = fields[index(columns, column)]
+	}
+	return strings.Join(out, ",")
+}
+
+// Checksum returns the hex SHA256 checksum of the file at path
+func Checksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,217 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dataset
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeFile writes lines to path, gzip compressed if its name says so
+func writeFile(t *testing.T, path string, lines []string) {
+	assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	f, err := os.Create(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	content := strings.Join(lines, "\n") + "\n"
+	if strings.HasSuffix(path, ".gz") {
+		gz := gzip.NewWriter(f)
+		_, err = gz.Write([]byte(content))
+		assert.Nil(t, err)
+		assert.Nil(t, gz.Close())
+		return
+	}
+	_, err = f.WriteString(content)
+	assert.Nil(t, err)
+}
+
+// readLines returns the lines of a test file
+func readLines(t *testing.T, path string) []string {
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+func TestLoadManifest(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, ManifestName)
+	assert.Nil(os.WriteFile(path, []byte(`
+name: 2020-q1
+time-range: {from: 2020-01-01T00:00:00Z, to: 2020-04-01T00:00:00Z}
+events:
+  files: [events-*.csv.gz]
+repos:
+  columns: [name, id]
+  files:
+    - path: repos.csv
+      size: 42
+      sha256: abc
+`), 0o644))
+	m, err := LoadManifest(dir)
+	assert.Nil(err)
+	assert.Equal("2020-q1", m.Name)
+	assert.Equal(dir, m.Dir)
+	assert.Equal([]File{{Path: "events-*.csv.gz"}}, m.Events.Files)
+	assert.Equal([]File{{Path: "repos.csv", Size: 42, SHA256: "abc"}}, m.Repos.Files)
+	assert.Equal([]string{"name", "id"}, m.Repos.Columns)
+	assert.Equal(2020, m.TimeRange.From.Year())
+
+	for _, content := range []string{
+		`repos: {columns: [name, login], files: [repos.csv]}`,
+		`time-range: {from: 2020-04-01T00:00:00Z, to: 2020-01-01T00:00:00Z}`,
+		`events: [events.csv]`,
+	} {
+		assert.Nil(os.WriteFile(path, []byte(content), 0o644))
+		_, err := LoadManifest(path)
+		assert.NotNil(err, content)
+	}
+
+	_, err = LoadManifest(t.TempDir())
+	assert.NotNil(err)
+}
+
+func TestManifestFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	// A directory of plain files is read in place
+	m, err := LoadManifest("testdata")
+	assert.Nil(err)
+	files, err := m.Files(t.TempDir())
+	assert.Nil(err)
+	assert.Equal(testFiles, files)
+
+	// Shards, compression and column mappings are
+	// normalised into the usual files once
+	events := readLines(t, testFiles.Events)
+	commits := readLines(t, testFiles.Commits)
+	repos := readLines(t, testFiles.Repos)
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "events-1.csv.gz"), events[:8])
+	writeFile(t, filepath.Join(dir, "events-2.csv"), append([]string{events[0]}, events[8:]...))
+	var remapped []string
+	for _, line := range commits {
+		first, last := strings.IndexByte(line, ','), strings.LastIndexByte(line, ',')
+		remapped = append(remapped, fmt.Sprintf("%s,%s,%s", line[last+1:], line[:first], line[first+1:last]))
+	}
+	writeFile(t, filepath.Join(dir, "commits", "part-1.csv"), remapped)
+	var swapped []string
+	for _, line := range repos {
+		id, name, _ := strings.Cut(line, ",")
+		swapped = append(swapped, name+","+id)
+	}
+	writeFile(t, filepath.Join(dir, "repos.csv"), swapped)
+	assert.Nil(os.WriteFile(filepath.Join(dir, ManifestName), []byte(`
+events:
+  files: [events-1.csv.gz, events-2.csv]
+commits:
+  columns: [event_id, sha, message]
+  files: [commits/*.csv]
+repos:
+  columns: [name, id]
+  files: [repos.csv]
+`), 0o644))
+
+	m, err = LoadManifest(dir)
+	assert.Nil(err)
+	out := t.TempDir()
+	files, err = m.Files(out)
+	assert.Nil(err)
+	assert.Equal(out, filepath.Dir(files.Events))
+	assert.Equal(events, readLines(t, files.Events))
+	assert.Equal(commits, readLines(t, files.Commits))
+	assert.Equal(repos, readLines(t, files.Repos))
+	assert.Empty(files.Actors)
+
+	// The normalised files are reused until the dataset changes
+	again, err := m.Files(out)
+	assert.Nil(err)
+	assert.Equal(files, again)
+	writeFile(t, filepath.Join(dir, "events-2.csv"), events[:1])
+	again, err = m.Files(out)
+	assert.Nil(err)
+	assert.NotEqual(files.Events, again.Events)
+	assert.Equal(events[:8], readLines(t, again.Events))
+
+	// A size which doesn't match is a file of another snapshot
+	m.Repos.Files[0].Size = 1
+	_, err = m.Files(out)
+	assert.NotNil(err)
+}
+
+func TestDiscover(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "events-2.csv.gz"), []string{"2,WatchEvent,1,1"})
+	writeFile(t, filepath.Join(dir, "events-1.csv"), []string{"1,WatchEvent,1,1"})
+	writeFile(t, filepath.Join(dir, "actors", "part-1.csv"), []string{"1,someone"})
+	writeFile(t, filepath.Join(dir, "notes.csv"), []string{"unrelated"})
+
+	m, err := LoadManifest(dir)
+	assert.Nil(err)
+	assert.Equal(filepath.Base(dir), m.Name)
+	assert.Equal([]File{{Path: "events-1.csv"}, {Path: "events-2.csv.gz"}}, m.Events.Files)
+	assert.Equal([]File{{Path: filepath.Join("actors", "part-1.csv")}}, m.Actors.Files)
+	assert.Empty(m.Commits.Files)
+	assert.Empty(m.Repos.Files)
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	sum, err := Checksum(testFiles.Repos)
+	assert.Nil(err)
+	m := &Manifest{Dir: "testdata", Repos: Table{Files: []File{{Path: "repos.csv", SHA256: sum}}}}
+	checks, err := m.VerifyChecksums()
+	assert.Nil(err)
+	assert.Equal([]Check{{Name: "checksum of repos.csv", Checked: 1, Strict: true}}, checks)
+	m.Repos.Files[0].SHA256 = strings.Repeat("0", 64)
+	checks, err = m.VerifyChecksums()
+	assert.Nil(err)
+	assert.False(checks[0].Passed(1))
+
+	d, err := Load(testFiles)
+	assert.Nil(err)
+	assert.Equal([]Check{
+		{Name: "commits of a known PushEvent", Checked: 9, Failed: 1},
+		{Name: "events of a known repo", Checked: 17, Failed: 1},
+		{Name: "events of a known actor", Checked: 17},
+	}, d.CheckReferences())
+
+	// Actors of another snapshot are unknown to the events
+	other := filepath.Join(t.TempDir(), "actors.csv")
+	writeFile(t, other, []string{"id,username", "1,someone", "2,else"})
+	d, err = Load(Files{Events: testFiles.Events, Actors: other})
+	assert.Nil(err)
+	checks = d.CheckReferences()
+	assert.Equal([]Check{{Name: "events of a known actor", Checked: 17, Failed: 17}}, checks)
+	assert.False(checks[0].Passed(0.5))
+}
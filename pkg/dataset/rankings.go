@@ -0,0 +1,128 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dataset
+
+import (
+	"sort"
+	"strings"
+
+	"gitlab.com/ansrivas/go-analyze-git/internal/ranking"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/events"
+)
+
+// Rank computes the rankings over the rows of the dataset, reporting
+// the rows read and skipped while loading it
+func (d *Dataset) Rank(engine *ranking.Engine, rankings ...ranking.Ranking) ([]ranking.Result, error) {
+	inputs := d.Inputs()
+	inputs.Stats = &d.Stats
+	return engine.Run(inputs, rankings...)
+}
+
+// RepoContributors returns the top K users sorted by the
+// amount of events they caused on the given repository
+func (d *Dataset) RepoContributors(count int, repoID string) utils.GenericDictHeap {
+	userToEventsCount := make(map[string]int)
+	for _, event := range d.Events {
+		if event.RepoID == repoID {
+			userToEventsCount[event.ActorID]++
+		}
+	}
+	return utils.TopK(userToEventsCount, count, utils.Selection{}, d.ActorLogin)
+}
+
+// UserRepos returns the top K repositories sorted by the
+// amount of events the given user caused on them
+func (d *Dataset) UserRepos(count int, actorID string) utils.GenericDictHeap {
+	repoToEventsCount := make(map[string]int)
+	for _, event := range d.Events {
+		if event.ActorID == actorID {
+			repoToEventsCount[event.RepoID]++
+		}
+	}
+	return utils.TopK(repoToEventsCount, count, utils.Selection{}, d.RepoName)
+}
+
+// SearchResult is a repository or user whose name matched a search
+type SearchResult struct {
+	// Kind is either "repo" or "user"
+	Kind string
+	ID   string
+	Name string
+}
+
+// Search returns up to count repositories and users whose
+// name contains the query, case insensitively
+func (d *Dataset) Search(count int, query string) []SearchResult {
+	query = strings.ToLower(query)
+	var results []SearchResult
+	for name, id := range d.repoIDs {
+		if strings.Contains(strings.ToLower(name), query) {
+			results = append(results, SearchResult{Kind: "repo", ID: id, Name: name})
+		}
+	}
+	for login, id := range d.actorIDs {
+		if strings.Contains(strings.ToLower(login), query) {
+			results = append(results, SearchResult{Kind: "user", ID: id, Name: login})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Kind != results[j].Kind {
+			return results[i].Kind < results[j].Kind
+		}
+		return results[i].Name < results[j].Name
+	})
+	if len(results) > count {
+		results = results[:count]
+	}
+	return results
+}
+
+// Activity summarizes what a single user did
+type Activity struct {
+	// Events counts the events of the user by type
+	Events map[string]int
+	// Commits is the amount of commits pushed by the user
+	Commits int
+}
+
+// UserActivity returns the activity of the user with the given id
+func (d *Dataset) UserActivity(actorID string) Activity {
+	activity := Activity{Events: map[string]int{}}
+	pushes := make(map[string]struct{})
+	for _, event := range d.Events {
+		if event.ActorID != actorID {
+			continue
+		}
+		activity.Events[event.Type]++
+		if event.Type == events.Push {
+			pushes[event.ID] = struct{}{}
+		}
+	}
+	for _, commit := range d.Commits {
+		if _, ok := pushes[commit.EventID]; ok {
+			activity.Commits++
+		}
+	}
+	return activity
+}
@@ -0,0 +1,98 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dataset
+
+import (
+	"gitlab.com/ansrivas/go-analyze-git/internal/dataflow"
+	"gitlab.com/ansrivas/go-analyze-git/internal/ranking"
+)
+
+// eventsTable writes the events as the rows of events.csv
+type eventsTable []Event
+
+func (t eventsTable) Len() int {
+	return len(t)
+}
+
+func (t eventsTable) AppendRow(dst []byte, i int) []byte {
+	e := &t[i]
+	dst = append(dst, e.ID...)
+	dst = append(dst, ',')
+	dst = append(dst, e.Type...)
+	dst = append(dst, ',')
+	dst = append(dst, e.ActorID...)
+	dst = append(dst, ',')
+	return append(dst, e.RepoID...)
+}
+
+// commitsTable writes the commits as the rows of commits.csv
+type commitsTable []Commit
+
+func (t commitsTable) Len() int {
+	return len(t)
+}
+
+func (t commitsTable) AppendRow(dst []byte, i int) []byte {
+	c := &t[i]
+	dst = append(dst, c.SHA...)
+	dst = append(dst, ',')
+	dst = append(dst, c.Message...)
+	dst = append(dst, ',')
+	return append(dst, c.EventID...)
+}
+
+// reposTable writes the repositories as the rows of repos.csv
+type reposTable []Repo
+
+func (t reposTable) Len() int {
+	return len(t)
+}
+
+func (t reposTable) AppendRow(dst []byte, i int) []byte {
+	dst = append(dst, t[i].ID...)
+	dst = append(dst, ',')
+	return append(dst, t[i].Name...)
+}
+
+// actorsTable writes the actors as the rows of actors.csv
+type actorsTable []Actor
+
+func (t actorsTable) Len() int {
+	return len(t)
+}
+
+func (t actorsTable) AppendRow(dst []byte, i int) []byte {
+	dst = append(dst, t[i].ID...)
+	dst = append(dst, ',')
+	return append(dst, t[i].Login...)
+}
+
+// Inputs returns the rows of the dataset as the inputs of the rankings
+func (d *Dataset) Inputs() ranking.Inputs {
+	return ranking.Inputs{
+		Events:  dataflow.Input{Table: eventsTable(d.Events)},
+		Commits: dataflow.Input{Table: commitsTable(d.Commits)},
+		Repos:   dataflow.Input{Table: reposTable(d.Repos)},
+		Actors:  dataflow.Input{Table: actorsTable(d.Actors)},
+	}
+}
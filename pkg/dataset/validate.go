@@ -0,0 +1,220 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dataset
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+	"gitlab.com/ansrivas/go-analyze-git/internal/flags"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/events"
+)
+
+// Check is the outcome of one of the checks of validate
+type Check struct {
+	Name    string `json:"name"`
+	Checked int    `json:"checked"`
+	Failed  int    `json:"failed"`
+	// Strict checks fail on a single failed row, the others only if
+	// more than the tolerated ratio of their rows failed
+	Strict bool `json:"strict"`
+}
+
+// Passed tells if the check passed, tolerating
+// the given ratio of failed rows unless it is strict
+func (c Check) Passed(tolerance float64) bool {
+	if c.Strict || c.Checked == 0 {
+		return c.Failed == 0
+	}
+	return float64(c.Failed)/float64(c.Checked) <= tolerance
+}
+
+// VerifyChecksums compares the files of the manifest
+// with the sizes and checksums it lists for them
+func (m *Manifest) VerifyChecksums() ([]Check, error) {
+	var checks []Check
+	for _, name := range Tables {
+		for _, file := range m.Table(name).Files {
+			if file.SHA256 == "" && file.Size == 0 {
+				continue
+			}
+			path := file.Path
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(m.Dir, path)
+			}
+			check := Check{Name: "checksum of " + file.Path, Checked: 1, Strict: true}
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			if file.Size > 0 && info.Size() != file.Size {
+				check.Failed = 1
+			}
+			if file.SHA256 != "" {
+				sum, err := Checksum(path)
+				if err != nil {
+					return nil, err
+				}
+				if sum != file.SHA256 {
+					check.Failed = 1
+				}
+			}
+			checks = append(checks, check)
+		}
+	}
+	return checks, nil
+}
+
+// CheckReferences counts the rows referring to rows missing from another
+// table. Files of different snapshots refer to each other only by chance.
+func (d *Dataset) CheckReferences() []Check {
+	var checks []Check
+	if len(d.Commits) > 0 {
+		pushes := map[string]bool{}
+		for _, e := range d.Events {
+			if e.Type == events.Push {
+				pushes[e.ID] = true
+			}
+		}
+		check := Check{Name: "commits of a known PushEvent", Checked: len(d.Commits)}
+		for _, c := range d.Commits {
+			if !pushes[c.EventID] {
+				check.Failed++
+			}
+		}
+		checks = append(checks, check)
+	}
+	if len(d.Repos) > 0 {
+		check := Check{Name: "events of a known repo", Checked: len(d.Events)}
+		for _, e := range d.Events {
+			if _, ok := d.repoNames[e.RepoID]; !ok {
+				check.Failed++
+			}
+		}
+		checks = append(checks, check)
+	}
+	if len(d.Actors) > 0 {
+		check := Check{Name: "events of a known actor", Checked: len(d.Events)}
+		for _, e := range d.Events {
+			if _, ok := d.actorNames[e.ActorID]; !ok {
+				check.Failed++
+			}
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// writeChecks writes the checks as a table
+func writeChecks(out io.Writer, checks []Check, tolerance float64) {
+	table := tablewriter.NewWriter(out)
+	table.SetBorder(true)
+	table.SetAutoWrapText(false)
+	table.SetHeader([]string{"Check", "Checked", "Failed", "Result"})
+	for _, check := range checks {
+		result := "ok"
+		if !check.Passed(tolerance) {
+			result = "FAILED"
+		}
+		table.Append([]string{check.Name, strconv.Itoa(check.Checked), strconv.Itoa(check.Failed), result})
+	}
+	table.Render()
+}
+
+// CmdValidate checks that the files of a dataset belong together
+func CmdValidate() *cli.Command {
+	return &cli.Command{
+		Name:  "validate",
+		Usage: "Check the checksums of a dataset and that its files refer to each other like files of a single snapshot",
+		Flags: []cli.Flag{
+			flags.DatasetFlag,
+			flags.ReposFileFlag,
+			flags.EventsFileFlag,
+			flags.CommitsFileFlag,
+			flags.ActorsFileFlag,
+			&cli.Float64Flag{
+				Name:    "max-missing",
+				Usage:   "Ratio of rows which may refer to rows missing from another file",
+				Value:   0.01,
+				EnvVars: []string{"MAX_MISSING"},
+			},
+			flags.JsonFlag,
+		},
+		Action: func(c *cli.Context) error {
+			if err := flags.Required(c, "events-file"); err != nil {
+				return err
+			}
+			tolerance := c.Float64("max-missing")
+			var checks []Check
+			if path := c.String("dataset"); path != "" {
+				manifest, err := LoadManifest(path)
+				if err != nil {
+					return err
+				}
+				if checks, err = manifest.VerifyChecksums(); err != nil {
+					return err
+				}
+				if r := manifest.TimeRange; !r.From.IsZero() {
+					fmt.Fprintf(c.App.Writer, "Dataset %s covers %s to %s\n", manifest.Name, r.From.Format("2006-01-02 15:04:05"), r.To.Format("2006-01-02 15:04:05"))
+				}
+			}
+
+			d, err := Load(Files{
+				Events:  c.String("events-file"),
+				Commits: c.String("commits-file"),
+				Repos:   c.String("repos-file"),
+				Actors:  c.String("actors-file"),
+			})
+			if err != nil {
+				return err
+			}
+			checks = append(checks, d.CheckReferences()...)
+
+			if c.Bool("json") {
+				payload, err := json.MarshalIndent(checks, "", "    ")
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(c.App.Writer, string(payload))
+			} else {
+				writeChecks(c.App.Writer, checks, tolerance)
+			}
+			failed := 0
+			for _, check := range checks {
+				if !check.Passed(tolerance) {
+					failed++
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,202 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+	"gitlab.com/ansrivas/go-analyze-git/internal/flags"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/bots"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/dataset"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/multi"
+)
+
+// Result is the json form of a diff
+type Result struct {
+	Analysis string   `json:"analysis"`
+	Before   string   `json:"before"`
+	After    string   `json:"after"`
+	Changes  []Change `json:"changes"`
+}
+
+// rank runs the query on the dataset at path and returns its complete ranking
+func rank(c *cli.Context, runner *multi.Runner, query multi.Query, path string) (utils.GenericDictHeap, error) {
+	manifest, err := dataset.LoadManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	files, err := manifest.Files(dataset.MaterializedDir())
+	if err != nil {
+		return nil, err
+	}
+	inputs := multi.Files{Events: files.Events, Commits: files.Commits, Repos: files.Repos, Actors: files.Actors}
+	if err := inputs.Required([]multi.Query{query}); err != nil {
+		return nil, fmt.Errorf("dataset %s: %w", path, err)
+	}
+	// Every dataset has bots of its own
+	if multi.RanksUsers([]multi.Query{query}) {
+		if runner.Bots, err = bots.Excluded(c, inputs.Events, inputs.Commits, inputs.Actors); err != nil {
+			return nil, fmt.Errorf("dataset %s: %w", path, err)
+		}
+	}
+	reports, err := runner.Run([]multi.Query{query}, inputs)
+	if err != nil {
+		return nil, err
+	}
+	return reports[0].Rows, nil
+}
+
+// movement describes the change of an entry like "up 3"
+func movement(c Change) string {
+	switch c.Status {
+	case StatusUp:
+		return fmt.Sprintf("up %d", c.Moved)
+	case StatusDown:
+		return fmt.Sprintf("down %d", -c.Moved)
+	case StatusSame:
+		return "-"
+	}
+	return c.Status
+}
+
+// rankCell renders a rank, or - for an unranked entry
+func rankCell(rank int) string {
+	if rank == 0 {
+		return "-"
+	}
+	return strconv.Itoa(rank)
+}
+
+// writeChanges writes the changes as a table
+func writeChanges(out io.Writer, changes []Change) {
+	table := tablewriter.NewWriter(out)
+	table.SetBorder(true)
+	table.SetAutoWrapText(false)
+	table.SetHeader([]string{"Rank", "Before", "Key", "Change", "Value", "Delta"})
+	for _, c := range changes {
+		table.Append([]string{
+			rankCell(c.Rank),
+			rankCell(c.PreviousRank),
+			c.Key,
+			movement(c),
+			strconv.Itoa(c.Value),
+			fmt.Sprintf("%+d", c.Delta),
+		})
+	}
+	table.Render()
+}
+
+// CmdDiff compares a leaderboard of two datasets
+func CmdDiff() *cli.Command {
+	return &cli.Command{
+		Name:  "diff",
+		Usage: "Compare a leaderboard of two datasets, like two snapshots or two time windows",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "before",
+				Usage:    "Path of the manifest or directory of the earlier dataset",
+				EnvVars:  []string{"BEFORE"},
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     "after",
+				Usage:    "Path of the manifest or directory of the later dataset",
+				EnvVars:  []string{"AFTER"},
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:    "analysis",
+				Usage:   fmt.Sprintf("Leaderboard to compare, one of %q", []string{multi.TopKReposByEvents, multi.TopKReposByCommits, multi.TopKUsersByPRs}),
+				Value:   multi.TopKReposByEvents,
+				EnvVars: []string{"ANALYSIS"},
+			},
+			flags.DistinctActorsFlag,
+			flags.CountFlag,
+			flags.RepoIncludeFlag,
+			flags.RepoExcludeFlag,
+			flags.UserIncludeFlag,
+			flags.UserExcludeFlag,
+			flags.ExcludeBotsFlag,
+			flags.BotsFileFlag,
+			flags.BotCommitsFlag,
+			flags.BotRepeatedFlag,
+			flags.WorkersFlag,
+			flags.DedupFlag,
+			flags.DedupMemoryFlag,
+			flags.CacheDirFlag,
+			flags.JsonFlag,
+		},
+		Action: func(c *cli.Context) error {
+			query, err := multi.ParseQuery(fmt.Sprintf("%s,distinct-actors=%t", c.String("analysis"), c.Bool(flags.DistinctActorsFlag.Name)))
+			if err != nil {
+				return err
+			}
+			// Entries outside the top k are ranked too, to tell how far they moved
+			query.Count = math.MaxInt32
+			// The cache holds a single dataset, the other one is read from its files
+			runner := &multi.Runner{Workers: c.Int(flags.WorkersFlag.Name), CacheDir: c.String(flags.CacheDirFlag.Name)}
+			if runner.Dedup, runner.DedupMemory, err = flags.Dedup(c); err != nil {
+				return err
+			}
+			if runner.Repos, err = flags.RepoFilter(c); err != nil {
+				return err
+			}
+			if runner.Users, err = flags.UserFilter(c); err != nil {
+				return err
+			}
+
+			before, err := rank(c, runner, query, c.String("before"))
+			if err != nil {
+				return err
+			}
+			after, err := rank(c, runner, query, c.String("after"))
+			if err != nil {
+				return err
+			}
+			changes := Compare(before, after, c.Int(flags.CountFlag.Name))
+
+			if c.Bool(flags.JsonFlag.Name) {
+				payload, err := json.MarshalIndent(Result{
+					Analysis: query.Name,
+					Before:   c.String("before"),
+					After:    c.String("after"),
+					Changes:  changes,
+				}, "", "    ")
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(c.App.Writer, string(payload))
+				return nil
+			}
+			writeChanges(c.App.Writer, changes)
+			return nil
+		},
+	}
+}
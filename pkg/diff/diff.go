@@ -0,0 +1,142 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package diff compares the leaderboards of two snapshots of a dataset.
+package diff
+
+import (
+	"sort"
+
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+)
+
+// Statuses of a Change
+const (
+	StatusNew     = "new"
+	StatusDropped = "dropped"
+	StatusUp      = "up"
+	StatusDown    = "down"
+	StatusSame    = "same"
+)
+
+// Change is how an entry of either top k moved between two rankings
+type Change struct {
+	Key    string `json:"key"`
+	Status string `json:"status"`
+	// Rank and PreviousRank are the ranks after and before, 0 if the
+	// key isn't ranked at all. Entries of equal value share their rank.
+	Rank         int `json:"rank"`
+	PreviousRank int `json:"previous_rank"`
+	// Moved is how many ranks the entry climbed, negative if it fell
+	Moved         int `json:"moved"`
+	Value         int `json:"value"`
+	PreviousValue int `json:"previous_value"`
+	Delta         int `json:"delta"`
+}
+
+// entry is a key of a ranking
+type entry struct {
+	rank, value int
+}
+
+// ranks returns the rank and value of every key of rows, which are
+// ordered highest first. Entries of equal value share the highest of
+// their ranks, so that the order of ties doesn't show up as a move.
+// A key ranked twice keeps its first rank.
+func ranks(rows utils.GenericDictHeap) map[string]entry {
+	ranked := make(map[string]entry, len(rows))
+	rank := 0
+	for i, row := range rows {
+		if i == 0 || row.Value != rows[i-1].Value {
+			rank = i + 1
+		}
+		if _, ok := ranked[row.Key]; !ok {
+			ranked[row.Key] = entry{rank: rank, value: row.Value}
+		}
+	}
+	return ranked
+}
+
+// Compare returns the changes of the top k entries of two complete
+// rankings, ordered highest first. The entries of the top k after are
+// followed by the ones which dropped out of the top k before. An entry
+// is new if it wasn't in the top k before, even if it was ranked lower.
+func Compare(before, after utils.GenericDictHeap, k int) []Change {
+	previous, current := ranks(before), ranks(after)
+	var changes []Change
+	for _, rows := range []utils.GenericDictHeap{after, before} {
+		for _, row := range rows {
+			now, ranked := current[row.Key]
+			then, wasRanked := previous[row.Key]
+			inTop := ranked && now.rank <= k
+			wasInTop := wasRanked && then.rank <= k
+			if !inTop && !wasInTop {
+				continue
+			}
+			change := Change{
+				Key:           row.Key,
+				Rank:          now.rank,
+				PreviousRank:  then.rank,
+				Value:         now.value,
+				PreviousValue: then.value,
+				Delta:         now.value - then.value,
+			}
+			switch {
+			case !wasInTop:
+				change.Status = StatusNew
+			case !inTop:
+				change.Status = StatusDropped
+			case now.rank < then.rank:
+				change.Status = StatusUp
+			case now.rank > then.rank:
+				change.Status = StatusDown
+			default:
+				change.Status = StatusSame
+			}
+			if ranked && wasRanked {
+				change.Moved = then.rank - now.rank
+			}
+			changes = append(changes, change)
+		}
+	}
+
+	// Keys ranked in both appear twice, the first occurrence wins
+	seen := make(map[string]bool, len(changes))
+	unique := changes[:0]
+	for _, change := range changes {
+		if !seen[change.Key] {
+			seen[change.Key] = true
+			unique = append(unique, change)
+		}
+	}
+	sort.SliceStable(unique, func(i, j int) bool {
+		a, b := unique[i], unique[j]
+		if (a.Status == StatusDropped) != (b.Status == StatusDropped) {
+			return b.Status == StatusDropped
+		}
+		if a.Status == StatusDropped {
+			return a.PreviousRank < b.PreviousRank
+		}
+		return a.Rank < b.Rank
+	})
+	return unique
+}
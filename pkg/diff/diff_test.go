@@ -0,0 +1,79 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package diff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+)
+
+// rows returns a ranking of alternating keys and values
+func rows(pairs ...interface{}) utils.GenericDictHeap {
+	var ranking utils.GenericDictHeap
+	for i := 0; i < len(pairs); i += 2 {
+		ranking = append(ranking, utils.GenericDict{Key: pairs[i].(string), Value: pairs[i+1].(int)})
+	}
+	return ranking
+}
+
+func TestCompare(t *testing.T) {
+	assert := assert.New(t)
+
+	before := rows("a", 10, "b", 8, "c", 5, "d", 3, "e", 1)
+	after := rows("c", 12, "a", 11, "f", 9, "b", 2, "d", 1)
+	assert.Equal([]Change{
+		{Key: "c", Status: StatusUp, Rank: 1, PreviousRank: 3, Moved: 2, Value: 12, PreviousValue: 5, Delta: 7},
+		{Key: "a", Status: StatusDown, Rank: 2, PreviousRank: 1, Moved: -1, Value: 11, PreviousValue: 10, Delta: 1},
+		{Key: "f", Status: StatusNew, Rank: 3, Value: 9, Delta: 9},
+		{Key: "b", Status: StatusDropped, Rank: 4, PreviousRank: 2, Moved: -2, Value: 2, PreviousValue: 8, Delta: -6},
+	}, Compare(before, after, 3))
+
+	// An entry climbing into the top k from below is new, one
+	// falling out of the ranking altogether is dropped
+	before = rows("a", 10, "b", 8, "c", 5)
+	after = rows("a", 10, "c", 9)
+	assert.Equal([]Change{
+		{Key: "a", Status: StatusSame, Rank: 1, PreviousRank: 1, Value: 10, PreviousValue: 10},
+		{Key: "c", Status: StatusNew, Rank: 2, PreviousRank: 3, Moved: 1, Value: 9, PreviousValue: 5, Delta: 4},
+		{Key: "b", Status: StatusDropped, PreviousRank: 2, PreviousValue: 8, Delta: -8},
+	}, Compare(before, after, 2))
+}
+
+func TestCompareTies(t *testing.T) {
+	assert := assert.New(t)
+
+	// Ties share their rank, a different order of ties is no move
+	before := rows("a", 5, "b", 5, "c", 5, "d", 1)
+	after := rows("c", 5, "b", 5, "a", 5, "d", 2)
+	changes := Compare(before, after, 4)
+	assert.Len(changes, 4)
+	for _, change := range changes {
+		assert.Equal(StatusSame, change.Status, change.Key)
+	}
+	assert.Equal(4, changes[3].Rank)
+
+	// A tie at the boundary of the top k is in the top k
+	assert.Len(Compare(before, after, 2), 3)
+}
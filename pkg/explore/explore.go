@@ -0,0 +1,354 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package explore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dedup"
+	"gitlab.com/ansrivas/go-analyze-git/internal/flags"
+	"gitlab.com/ansrivas/go-analyze-git/internal/ranking"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/bots"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/dataset"
+)
+
+const (
+	reposView = iota
+	usersView
+	repoView
+	userView
+	searchView
+)
+
+const helpText = `[r]epos  [u]sers  by events|commits  [t]ype <EventType>  [k] <n>
+<n> open row n  /<text> search  [b]ack  [q]uit`
+
+// screen is a single place the user navigated to
+type screen struct {
+	view int
+	// id and name of the repository or user shown in repoView and userView
+	id   string
+	name string
+	// query of the searchView
+	query string
+}
+
+// row links a line of the rendered table to the entity it shows
+type row struct {
+	kind string
+	id   string
+	name string
+}
+
+// Explorer is an interactive terminal browser over an in-memory dataset
+type Explorer struct {
+	data *dataset.Dataset
+	in   *bufio.Scanner
+	out  io.Writer
+	// clearScreen redraws every screen from the top of the terminal
+	clearScreen bool
+
+	eventType string
+	by        string
+	count     int
+	// Bots are dropped from the users view
+	Bots bots.Suspects
+
+	current screen
+	history []screen
+	rows    []row
+	message string
+}
+
+// New returns an Explorer reading commands from in and drawing to out
+func New(data *dataset.Dataset, in io.Reader, out io.Writer) *Explorer {
+	return &Explorer{
+		data:      data,
+		in:        bufio.NewScanner(in),
+		out:       out,
+		eventType: flags.EventTypeFlag.Value,
+		by:        "events",
+		count:     flags.CountFlag.Value,
+		current:   screen{view: reposView},
+	}
+}
+
+// Run draws screens and handles commands until the user quits
+// or the input is exhausted
+func (e *Explorer) Run() error {
+	for {
+		e.render()
+		if !e.in.Scan() {
+			fmt.Fprintln(e.out)
+			return e.in.Err()
+		}
+		if quit := e.handle(strings.TrimSpace(e.in.Text())); quit {
+			return nil
+		}
+	}
+}
+
+// handle executes a single command and reports if the user asked to quit
+func (e *Explorer) handle(command string) bool {
+	e.message = ""
+	if command == "" {
+		return false
+	}
+
+	if strings.HasPrefix(command, "/") {
+		e.navigate(screen{view: searchView, query: strings.TrimSpace(command[1:])})
+		return false
+	}
+	if n, err := strconv.Atoi(command); err == nil {
+		e.open(n)
+		return false
+	}
+
+	fields := strings.Fields(command)
+	arg := ""
+	if len(fields) > 1 {
+		arg = fields[1]
+	}
+	switch fields[0] {
+	case "q", "quit":
+		return true
+	case "r", "repos":
+		e.navigate(screen{view: reposView})
+	case "u", "users":
+		e.navigate(screen{view: usersView})
+	case "b", "back":
+		if len(e.history) == 0 {
+			e.message = "Nothing to go back to"
+			break
+		}
+		e.current = e.history[len(e.history)-1]
+		e.history = e.history[:len(e.history)-1]
+	case "by":
+		if arg != "events" && arg != "commits" {
+			e.message = "Repositories can be ranked by 'events' or 'commits'"
+			break
+		}
+		e.by = arg
+	case "t", "type":
+		if arg == "" {
+			e.message = "Missing event type, e.g. 't ForkEvent'"
+			break
+		}
+		e.eventType = arg
+	case "k":
+		k, err := strconv.Atoi(arg)
+		if err != nil || k <= 0 {
+			e.message = "K must be a positive number"
+			break
+		}
+		e.count = k
+	case "h", "help", "?":
+		e.message = helpText
+	default:
+		e.message = fmt.Sprintf("Unknown command %q", command)
+	}
+	return false
+}
+
+func (e *Explorer) navigate(s screen) {
+	e.history = append(e.history, e.current)
+	e.current = s
+}
+
+// open drills into the n-th row of the current screen
+func (e *Explorer) open(n int) {
+	if n < 1 || n > len(e.rows) {
+		e.message = fmt.Sprintf("No row %d on this screen", n)
+		return
+	}
+	r := e.rows[n-1]
+	if r.kind == "repo" {
+		e.navigate(screen{view: repoView, id: r.id, name: r.name})
+	} else {
+		e.navigate(screen{view: userView, id: r.id, name: r.name})
+	}
+}
+
+// repoRow resolves a ranked repository name back to its id. Repositories
+// missing from repos.csv are ranked by id already.
+func (e *Explorer) repoRow(name string) row {
+	if id, ok := e.data.RepoID(name); ok {
+		return row{kind: "repo", id: id, name: name}
+	}
+	return row{kind: "repo", id: name, name: name}
+}
+
+func (e *Explorer) userRow(login string) row {
+	id, _ := e.data.ActorID(login)
+	return row{kind: "user", id: id, name: login}
+}
+
+// rank computes a ranking over the dataset, a failure is shown
+// as the message of the screen
+func (e *Explorer) rank(rk ranking.Ranking) utils.GenericDictHeap {
+	results, err := e.data.Rank(&ranking.Engine{Workers: runtime.NumCPU(), Dedup: dedup.Exact}, rk)
+	if err != nil {
+		e.message = fmt.Sprintf("failed to rank the dataset: %v", err)
+		return nil
+	}
+	return results[0].Rows
+}
+
+func (e *Explorer) render() {
+	if e.clearScreen {
+		fmt.Fprint(e.out, "\033[H\033[2J")
+	}
+	fmt.Fprintf(e.out, "go-analyze-git explorer | %d events, %d commits, %d repos, %d users | K=%d\n",
+		len(e.data.Events), len(e.data.Commits), len(e.data.Repos), len(e.data.Actors), e.count)
+
+	var title string
+	headers := []string{"#", "Name", "Count"}
+	var data [][]string
+	e.rows = nil
+
+	switch e.current.view {
+	case reposView, usersView, repoView, userView:
+		var kind string
+		output := func(count int) utils.GenericDictHeap {
+			return e.rank(ranking.Ranking{Analysis: ranking.UsersByCommits, Count: count, Exclude: e.Bots.Has})
+		}
+		switch e.current.view {
+		case reposView:
+			kind = "repo"
+			if e.by == "commits" {
+				title = "Top repositories by commits pushed"
+				output = func(count int) utils.GenericDictHeap {
+					return e.rank(ranking.Ranking{Analysis: ranking.ReposByCommits, Count: count})
+				}
+			} else {
+				title = fmt.Sprintf("Top repositories by %s", e.eventType)
+				output = func(count int) utils.GenericDictHeap {
+					return e.rank(ranking.Ranking{Analysis: ranking.ReposByEvents, EventType: e.eventType, Count: count})
+				}
+			}
+		case usersView:
+			kind = "user"
+			title = "Top users by PRs created and commits pushed"
+		case repoView:
+			kind = "user"
+			title = fmt.Sprintf("Contributors of %s by events", e.current.name)
+			output = func(count int) utils.GenericDictHeap { return e.data.RepoContributors(count, e.current.id) }
+		case userView:
+			kind = "repo"
+			title = fmt.Sprintf("Repositories of %s by events", e.current.name)
+			output = func(count int) utils.GenericDictHeap { return e.data.UserRepos(count, e.current.id) }
+		}
+		for i, gd := range output(e.count) {
+			if kind == "repo" {
+				e.rows = append(e.rows, e.repoRow(gd.Key))
+			} else {
+				e.rows = append(e.rows, e.userRow(gd.Key))
+			}
+			data = append(data, []string{strconv.Itoa(i + 1), gd.Key, strconv.Itoa(gd.Value)})
+		}
+	case searchView:
+		title = fmt.Sprintf("Repositories and users matching %q", e.current.query)
+		headers = []string{"#", "Kind", "Name"}
+		for i, result := range e.data.Search(e.count, e.current.query) {
+			e.rows = append(e.rows, row{kind: result.Kind, id: result.ID, name: result.Name})
+			data = append(data, []string{strconv.Itoa(i + 1), result.Kind, result.Name})
+		}
+	}
+
+	fmt.Fprintf(e.out, "\n%s\n", title)
+	table := tablewriter.NewWriter(e.out)
+	table.SetBorder(true)
+	table.SetAutoWrapText(false)
+	table.SetHeader(headers)
+	table.AppendBulk(data)
+	table.Render()
+
+	if e.message != "" {
+		fmt.Fprintf(e.out, "%s\n", e.message)
+	}
+	fmt.Fprintf(e.out, "%s\n> ", helpText)
+}
+
+// CmdExplore opens the explorer over the given files
+func CmdExplore() *cli.Command {
+	return &cli.Command{
+		Name:    "explore",
+		Aliases: []string{"x"},
+		Usage:   "Load the dataset once and browse the results interactively",
+		Flags: []cli.Flag{
+			flags.DatasetFlag,
+			flags.ReposFileFlag,
+			flags.EventsFileFlag,
+			flags.CommitsFileFlag,
+			flags.ActorsFileFlag,
+			flags.CacheDirFlag,
+			flags.CountFlag,
+			flags.EventTypeFlag,
+			flags.ExcludeBotsFlag,
+			flags.BotsFileFlag,
+			flags.BotCommitsFlag,
+			flags.BotRepeatedFlag,
+		},
+		Action: func(c *cli.Context) error {
+			if err := flags.Required(c, "repos-file", "events-file", "commits-file", "actors-file"); err != nil {
+				return err
+			}
+			fmt.Fprintln(c.App.Writer, "Loading the dataset ...")
+			data, err := dataset.Load(dataset.Files{
+				Events:   c.String("events-file"),
+				Commits:  c.String("commits-file"),
+				Repos:    c.String("repos-file"),
+				Actors:   c.String("actors-file"),
+				CacheDir: c.String("cache-dir"),
+			})
+			if err != nil {
+				return err
+			}
+
+			explorer := New(data, os.Stdin, c.App.Writer)
+			if c.Bool(flags.ExcludeBotsFlag.Name) {
+				classifier, err := bots.FromFlags(c)
+				if err != nil {
+					return err
+				}
+				if explorer.Bots, err = classifier.ClassifyDataset(data); err != nil {
+					return err
+				}
+			}
+			explorer.clearScreen = isatty.IsTerminal(os.Stdout.Fd())
+			explorer.count = c.Int("count")
+			explorer.eventType = c.String("event-type")
+			return explorer.Run()
+		},
+	}
+}
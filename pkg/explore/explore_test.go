@@ -0,0 +1,106 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package explore
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/bots"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/dataset"
+)
+
+func runExplorer(t *testing.T, commands ...string) []string {
+	return runExplorerExcluding(t, nil, commands...)
+}
+
+// runExplorerExcluding runs the explorer dropping the given bots
+func runExplorerExcluding(t *testing.T, suspects bots.Suspects, commands ...string) []string {
+	data, err := dataset.Load(dataset.Files{
+		Events:  "../dataset/testdata/events.csv",
+		Commits: "../dataset/testdata/commits.csv",
+		Repos:   "../dataset/testdata/repos.csv",
+		Actors:  "../dataset/testdata/actors.csv",
+	})
+	assert.Nil(t, err)
+
+	var out bytes.Buffer
+	in := strings.NewReader(strings.Join(commands, "\n") + "\n")
+	explorer := New(data, in, &out)
+	explorer.Bots = suspects
+	assert.Nil(t, explorer.Run())
+	// Every screen ends with the prompt
+	screens := strings.Split(out.String(), "\n> ")
+	return screens[:len(screens)-1]
+}
+
+func TestExplorerNavigation(t *testing.T) {
+	assert := assert.New(t)
+
+	screens := runExplorer(t, "1", "1", "b", "b", "u", "by commits", "r", "q")
+	assert.Len(screens, 8)
+
+	assert.Contains(screens[0], "Top repositories by WatchEvent")
+	assert.Contains(screens[0], "| 1 | testrepo2 |     3 |")
+
+	assert.Contains(screens[1], "Contributors of testrepo2 by events")
+	assert.Contains(screens[1], "| 1 | onosendi  |     4 |")
+
+	assert.Contains(screens[2], "Repositories of onosendi by events")
+	assert.Contains(screens[3], "Contributors of testrepo2 by events")
+	assert.Contains(screens[4], "Top repositories by WatchEvent")
+	assert.Contains(screens[5], "Top users by PRs created and commits pushed")
+	assert.Contains(screens[5], "Apexal")
+	// Changing the ranking keeps the current screen
+	assert.Contains(screens[6], "Top users by PRs created and commits pushed")
+	assert.Contains(screens[7], "Top repositories by commits pushed")
+	assert.Contains(screens[7], "testrepo2          |     5 |")
+}
+
+func TestExplorerSettingsAndSearch(t *testing.T) {
+	assert := assert.New(t)
+
+	screens := runExplorer(t, "t ForkEvent", "k 1", "/GI", "1", "k x", "7")
+	assert.Len(screens, 7)
+
+	assert.Contains(screens[1], "Top repositories by ForkEvent")
+	assert.Contains(screens[1], "testrepo3")
+	assert.Contains(screens[2], "K=1")
+	assert.Contains(screens[3], `matching "GI"`)
+	assert.Contains(screens[3], "| 1 | user | anggi1234 |")
+	assert.Contains(screens[4], "Repositories of anggi1234 by events")
+	assert.Contains(screens[5], "K must be a positive number")
+	assert.Contains(screens[6], "No row 7 on this screen")
+}
+
+func TestExplorerExcludeBots(t *testing.T) {
+	assert := assert.New(t)
+
+	screens := runExplorerExcluding(t, bots.Suspects{"38429025": {ID: "38429025", Login: "Apexal"}}, "u", "q")
+	assert.Len(screens, 2)
+	assert.Contains(screens[1], "Top users by PRs created and commits pushed")
+	assert.Contains(screens[1], "anggi1234")
+	assert.NotContains(screens[1], "Apexal")
+}
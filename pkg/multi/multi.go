@@ -0,0 +1,168 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package multi runs several analyses in a single streaming pass over
+// each input file, sharing the join state between them.
+package multi
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gitlab.com/ansrivas/go-analyze-git/internal/ranking"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// Analyses supported by a Query
+const (
+	TopKReposByEvents  = ranking.ReposByEvents
+	TopKReposByCommits = ranking.ReposByCommits
+	TopKUsersByPRs     = ranking.UsersByCommits
+)
+
+// Query is a single analysis of a shared run
+type Query struct {
+	// Name heads the output section, defaults to the analysis
+	Name     string `yaml:"name" json:"name"`
+	Analysis string `yaml:"analysis" json:"analysis"`
+	// Count is the number of rows, 10 if not set
+	Count int `yaml:"count" json:"count"`
+	// EventType is the type of the events ranked by topk-by-events,
+	// WatchEvent if not set
+	EventType string `yaml:"event-type" json:"event-type"`
+	// DistinctActors ranks topk-by-events by distinct actors
+	DistinctActors bool `yaml:"distinct-actors" json:"distinct-actors"`
+	// Selection holds the offset, order, min, max and ties keys
+	// selecting the rows of the ranking
+	utils.Selection `yaml:",inline"`
+	// Output is the format of the result, a table if not set
+	Output string `yaml:"output" json:"output"`
+	// File receives the result instead of stdout
+	File string `yaml:"file" json:"file"`
+}
+
+// queriesFile is the layout of a --queries-file
+type queriesFile struct {
+	Queries []Query `yaml:"queries"`
+}
+
+// ParseQuery parses a --query like
+// "topk-by-commits,count=5,output=json,file=commits.json"
+func ParseQuery(spec string) (Query, error) {
+	parts := strings.Split(spec, ",")
+	query := Query{Analysis: strings.TrimSpace(parts[0])}
+	for _, part := range parts[1:] {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return Query{}, fmt.Errorf("expected key=value in query %q, got %q", spec, part)
+		}
+		var err error
+		switch strings.TrimSpace(key) {
+		case "name":
+			query.Name = value
+		case "count":
+			query.Count, err = strconv.Atoi(value)
+		case "event-type":
+			query.EventType = value
+		case "distinct-actors":
+			query.DistinctActors, err = strconv.ParseBool(value)
+		case "offset":
+			query.Offset, err = strconv.Atoi(value)
+		case "order":
+			query.Order = value
+		case "min", "max":
+			var bound int
+			bound, err = strconv.Atoi(value)
+			if strings.TrimSpace(key) == "min" {
+				query.Min = &bound
+			} else {
+				query.Max = &bound
+			}
+		case "ties":
+			query.Ties = value
+		case "output":
+			query.Output = value
+		case "file":
+			query.File = value
+		default:
+			return Query{}, fmt.Errorf("unknown key %q in query %q", key, spec)
+		}
+		if err != nil {
+			return Query{}, fmt.Errorf("invalid %s in query %q: %w", key, spec, err)
+		}
+	}
+	return query, query.validate()
+}
+
+// LoadQueries reads the queries of a yaml or json file,
+// which lists them under the key queries
+func LoadQueries(path string) ([]Query, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file queriesFile
+	// yaml is a superset of json
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for i := range file.Queries {
+		if err := file.Queries[i].validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return file.Queries, nil
+}
+
+// validate checks the query and fills in its defaults
+func (q *Query) validate() error {
+	switch q.Analysis {
+	case TopKReposByEvents, TopKReposByCommits, TopKUsersByPRs:
+	default:
+		return fmt.Errorf("unknown analysis %q, expected one of %q", q.Analysis,
+			[]string{TopKReposByEvents, TopKReposByCommits, TopKUsersByPRs})
+	}
+	if q.DistinctActors && q.Analysis != TopKReposByEvents {
+		return fmt.Errorf("distinct-actors is only supported by %s", TopKReposByEvents)
+	}
+	if q.EventType != "" && q.Analysis != TopKReposByEvents {
+		return fmt.Errorf("event-type is only supported by %s", TopKReposByEvents)
+	}
+	if err := q.Selection.Validate(); err != nil {
+		return err
+	}
+	switch q.Output {
+	case "", utils.OutputTable, utils.OutputJSON, utils.OutputOpenMetrics:
+	default:
+		return fmt.Errorf("unknown output format %q", q.Output)
+	}
+	if q.Name == "" {
+		q.Name = q.Analysis
+	}
+	if q.Count == 0 {
+		q.Count = 10
+	}
+	return nil
+}
@@ -0,0 +1,425 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package multi
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dedup"
+	"gitlab.com/ansrivas/go-analyze-git/internal/match"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/cache"
+)
+
+var testFiles = Files{
+	Events:  "testdata/events.csv",
+	Commits: "testdata/commits.csv",
+	Repos:   "testdata/repos.csv",
+	Actors:  "testdata/actors.csv",
+}
+
+func TestParseQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	query, err := ParseQuery("topk-by-commits,count=5,output=json,file=commits.json")
+	assert.Nil(err)
+	assert.Equal(Query{
+		Name:     TopKReposByCommits,
+		Analysis: TopKReposByCommits,
+		Count:    5,
+		Output:   utils.OutputJSON,
+		File:     "commits.json",
+	}, query)
+
+	query, err = ParseQuery("topk-by-events,distinct-actors=true,name=stargazers")
+	assert.Nil(err)
+	assert.Equal(Query{Name: "stargazers", Analysis: TopKReposByEvents, Count: 10, DistinctActors: true}, query)
+
+	query, err = ParseQuery("topk-by-events,event-type=ForkEvent")
+	assert.Nil(err)
+	assert.Equal(Query{Name: TopKReposByEvents, Analysis: TopKReposByEvents, Count: 10, EventType: "ForkEvent"}, query)
+
+	query, err = ParseQuery("topk-by-pc,offset=10,order=asc,min=1,max=5,ties=include")
+	assert.Nil(err)
+	lowest, highest := 1, 5
+	assert.Equal(utils.Selection{Offset: 10, Order: utils.OrderAsc, Min: &lowest, Max: &highest, Ties: utils.TiesInclude}, query.Selection)
+
+	for _, spec := range []string{
+		"topk-by-nothing",
+		"topk-by-pc,count",
+		"topk-by-pc,count=many",
+		"topk-by-pc,colour=red",
+		"topk-by-pc,output=xml",
+		"topk-by-pc,distinct-actors=true",
+		"topk-by-commits,event-type=PushEvent",
+		"topk-by-pc,ties=some",
+		"topk-by-pc,offset=-1",
+		"topk-by-pc,order=up",
+		"topk-by-pc,min=3,max=2",
+	} {
+		_, err := ParseQuery(spec)
+		assert.NotNil(err, spec)
+	}
+}
+
+func TestLoadQueries(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "queries.yaml")
+	content := `
+queries:
+  - analysis: topk-by-events
+    count: 3
+  - name: forks
+    analysis: topk-by-events
+    event-type: ForkEvent
+  - name: users
+    analysis: topk-by-pc
+    output: json
+    file: users.json
+`
+	assert.Nil(os.WriteFile(path, []byte(content), 0o644))
+	queries, err := LoadQueries(path)
+	assert.Nil(err)
+	assert.Equal([]Query{
+		{Name: TopKReposByEvents, Analysis: TopKReposByEvents, Count: 3},
+		{Name: "forks", Analysis: TopKReposByEvents, Count: 10, EventType: "ForkEvent"},
+		{Name: "users", Analysis: TopKUsersByPRs, Count: 10, Output: utils.OutputJSON, File: "users.json"},
+	}, queries)
+
+	assert.Nil(os.WriteFile(path, []byte(`{"queries": [{"analysis": "topk-by-stars"}]}`), 0o644))
+	_, err = LoadQueries(path)
+	assert.NotNil(err)
+}
+
+func TestRun(t *testing.T) {
+	assert := assert.New(t)
+
+	queries := []Query{
+		{Name: "events", Analysis: TopKReposByEvents, Count: 3},
+		{Name: "commits", Analysis: TopKReposByCommits, Count: 3},
+		{Name: "users", Analysis: TopKUsersByPRs, Count: 3},
+		{Name: "actors", Analysis: TopKReposByEvents, Count: 1, DistinctActors: true},
+		{Name: "forks", Analysis: TopKReposByEvents, Count: 3, EventType: "ForkEvent"},
+	}
+	// The same results as running every analysis alone, whatever
+	// the number of workers parsing the files
+	for workers := 1; workers <= 3; workers++ {
+		runner := &Runner{Workers: workers, Dedup: dedup.None}
+		reports, err := runner.Run(queries, testFiles)
+		assert.Nil(err)
+		assert.Len(reports, len(queries))
+
+		assert.Equal(utils.GenericDictHeap{
+			{Key: "testrepo2", Value: 3},
+			{Key: "testrepo1", Value: 2},
+			{Key: "testrepo3", Value: 1},
+		}, reports[0].Rows)
+		assert.Equal("git_repo_events_total", reports[0].Metric)
+
+		assert.Equal(utils.GenericDictHeap{
+			{Key: "testrepo2", Value: 5, Extra: map[string]int{"Contributors": 2}},
+			{Key: "repowithpushevent1", Value: 2, Extra: map[string]int{"Contributors": 1}},
+			{Key: "129750935", Value: 1, Extra: map[string]int{"Contributors": 1}},
+		}, reports[1].Rows)
+		assert.Equal("Contributors", reports[1].Columns[0].Header)
+
+		assert.Equal(utils.GenericDictHeap{
+			{Key: "Apexal", Value: 4, Extra: map[string]int{"Repos": 2}},
+			{Key: "anggi1234", Value: 3, Extra: map[string]int{"Repos": 1}},
+			{Key: "onosendi", Value: 2, Extra: map[string]int{"Repos": 1}},
+		}, reports[2].Rows)
+
+		assert.Len(reports[3].Rows, 1)
+		assert.Equal("git_repo_distinct_actors", reports[3].Metric)
+
+		assert.Equal(utils.GenericDictHeap{{Key: "testrepo3", Value: 1}}, reports[4].Rows)
+
+		// Every file is read once for all the queries
+		assert.Equal(runner.Stats, reports[0].Stats)
+		assert.Equal(runner.Stats, reports[2].Stats)
+	}
+}
+
+func TestRunEngineOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	queries := []Query{
+		{Name: "events", Analysis: TopKReposByEvents, Count: 3},
+		{Name: "commits", Analysis: TopKReposByCommits, Count: 3},
+		{Name: "users", Analysis: TopKUsersByPRs, Count: 3},
+	}
+	expected, err := (&Runner{Workers: 2, Dedup: dedup.Exact}).Run(queries, testFiles)
+	assert.Nil(err)
+
+	// A cache and a spilled join come to the same rows
+	dir := t.TempDir()
+	_, err = cache.Ingest(cache.Files{Events: testFiles.Events, Commits: testFiles.Commits, Repos: testFiles.Repos, Actors: testFiles.Actors}, dir, 1)
+	assert.Nil(err)
+	for _, runner := range []*Runner{
+		{Workers: 2, Dedup: dedup.Exact, CacheDir: dir},
+		{Workers: 2, Dedup: dedup.Exact, MaxMemory: 1},
+	} {
+		reports, err := runner.Run(queries, testFiles)
+		assert.Nil(err)
+		for i := range queries {
+			assert.Equal(expected[i].Rows, reports[i].Rows, queries[i].Name)
+		}
+	}
+
+	// Approximate counts are labelled as such and can't be carried over
+	runner := &Runner{Workers: 2, Dedup: dedup.Exact, Counters: 100, Precision: 10}
+	reports, err := runner.Run(queries, testFiles)
+	assert.Nil(err)
+	assert.Equal(utils.GenericDict{Key: "testrepo2", Value: 3, Extra: map[string]int{utils.ErrorHeader: 0}}, reports[0].Rows[0])
+	_, err = runner.Update(queries, testFiles, filepath.Join(t.TempDir(), "state"))
+	assert.NotNil(err)
+}
+
+func TestRunFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	queries := []Query{
+		{Name: "events", Analysis: TopKReposByEvents, Count: 2},
+		{Name: "commits", Analysis: TopKReposByCommits, Count: 2},
+		{Name: "users", Analysis: TopKUsersByPRs, Count: 2},
+	}
+	runner := &Runner{Workers: 2, Dedup: dedup.None}
+	var err error
+	runner.Repos, err = match.New([]string{"testrepo*", "129750935"}, []string{"testrepo2"})
+	assert.Nil(err)
+	runner.Users, err = match.New(nil, []string{"re:(?i)^apex"})
+	assert.Nil(err)
+	reports, err := runner.Run(queries, testFiles)
+	assert.Nil(err)
+
+	assert.Equal(utils.GenericDictHeap{
+		{Key: "testrepo1", Value: 2},
+		{Key: "testrepo3", Value: 1},
+	}, reports[0].Rows)
+	assert.Equal(utils.GenericDictHeap{
+		{Key: "129750935", Value: 1, Extra: map[string]int{"Contributors": 1}},
+	}, reports[1].Rows)
+	assert.Equal(utils.GenericDictHeap{
+		{Key: "anggi1234", Value: 3, Extra: map[string]int{"Repos": 1}},
+		{Key: "onosendi", Value: 2, Extra: map[string]int{"Repos": 1}},
+	}, reports[2].Rows)
+}
+
+func TestParseRunFlags(t *testing.T) {
+	assert := assert.New(t)
+
+	var queries []Query
+	var runner *Runner
+	app := &cli.App{Commands: []*cli.Command{{
+		Name:  "run",
+		Flags: runFlags(),
+		Action: func(c *cli.Context) error {
+			var err error
+			queries, _, runner, err = parseRun(c)
+			return err
+		},
+	}}}
+	// The commas of a query separate its options, the ones of
+	// the other slice flags separate several patterns
+	err := app.Run([]string{"app", "run", "--query", "topk-by-commits,count=5", "--query", "topk-by-pc",
+		"--repo-include", "testrepo1,testrepo3"})
+	assert.Nil(err)
+	assert.Len(queries, 2)
+	assert.Equal(5, queries[0].Count)
+	assert.True(runner.Repos.Match("", "testrepo3"))
+	assert.False(runner.Repos.Match("", "testrepo2"))
+}
+
+func TestRunOnlyReadsNeededFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	runner := &Runner{Workers: 1}
+	files := Files{Events: testFiles.Events, Repos: testFiles.Repos}
+	reports, err := runner.Run([]Query{{Name: "events", Analysis: TopKReposByEvents, Count: 1}}, files)
+	assert.Nil(err)
+	assert.Len(reports, 1)
+
+	assert.NotNil(files.Required([]Query{{Name: "users", Analysis: TopKUsersByPRs}}))
+	assert.Nil(testFiles.Required([]Query{{Name: "users", Analysis: TopKUsersByPRs}}))
+}
+
+func TestWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	file := filepath.Join(t.TempDir(), "users.json")
+	queries := []Query{
+		{Name: "events", Analysis: TopKReposByEvents, Count: 3},
+		{Name: "users", Analysis: TopKUsersByPRs, Count: 3, Output: utils.OutputJSON, File: file},
+	}
+	runner := &Runner{Workers: 2, Dedup: dedup.None}
+	reports, err := runner.Run(queries, testFiles)
+	assert.Nil(err)
+
+	var out bytes.Buffer
+	assert.Nil(Write(&out, queries, reports, false))
+	assert.Contains(out.String(), "== events ==")
+	assert.Contains(out.String(), "testrepo2")
+	assert.NotContains(out.String(), "users")
+
+	content, err := os.ReadFile(file)
+	assert.Nil(err)
+	var rows utils.GenericDictHeap
+	assert.Nil(json.Unmarshal(content, &rows))
+	assert.Equal(reports[1].Rows, rows)
+}
+
+// splitFiles writes the first part of the lines of every test file
+// into dir, returning the files and the remaining lines
+func splitFiles(t *testing.T, dir string, part func(lines int) int) (Files, map[string]string) {
+	files := Files{
+		Events:  filepath.Join(dir, "events.csv"),
+		Commits: filepath.Join(dir, "commits.csv"),
+		Repos:   filepath.Join(dir, "repos.csv"),
+		Actors:  filepath.Join(dir, "actors.csv"),
+	}
+	rest := make(map[string]string)
+	for _, fname := range []string{files.Events, files.Commits, files.Repos, files.Actors} {
+		content, err := os.ReadFile(filepath.Join("testdata", filepath.Base(fname)))
+		assert.Nil(t, err)
+		lines := strings.SplitAfter(string(content), "\n")
+		n := part(len(lines))
+		assert.Nil(t, os.WriteFile(fname, []byte(strings.Join(lines[:n], "")), 0o644))
+		rest[fname] = strings.Join(lines[n:], "")
+	}
+	return files, rest
+}
+
+func appendTo(t *testing.T, fname, content string) {
+	f, err := os.OpenFile(fname, os.O_APPEND|os.O_WRONLY, 0)
+	assert.Nil(t, err)
+	_, err = f.WriteString(content)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+}
+
+// assertSameRows checks that the reports rank the same rows in the same order
+func assertSameRows(t *testing.T, expected, actual []utils.Report) {
+	assert.Len(t, actual, len(expected))
+	for i := range expected {
+		assert.Equal(t, expected[i].Rows, actual[i].Rows, expected[i].Command)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	assert := assert.New(t)
+
+	queries := []Query{
+		{Name: "events", Analysis: TopKReposByEvents, Count: 10},
+		{Name: "commits", Analysis: TopKReposByCommits, Count: 10},
+		{Name: "users", Analysis: TopKUsersByPRs, Count: 10},
+		{Name: "actors", Analysis: TopKReposByEvents, Count: 10, DistinctActors: true},
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state")
+	// The first update reads only a part of every file
+	files, rest := splitFiles(t, dir, func(lines int) int { return lines / 2 })
+	runner := &Runner{Workers: 2, Dedup: dedup.Exact}
+	_, err := runner.Update(queries, files, path)
+	assert.Nil(err)
+
+	// A line which is still being written is left for the next update
+	for fname, content := range rest {
+		appendTo(t, fname, content[:len(content)/2])
+	}
+	_, err = runner.Update(queries, files, path)
+	assert.Nil(err)
+	// The second half of the lines plus copies of the
+	// first lines, which are dropped as duplicates
+	for fname, content := range rest {
+		appendTo(t, fname, content[len(content)/2:])
+	}
+	header, err := os.ReadFile(files.Commits)
+	assert.Nil(err)
+	appendTo(t, files.Commits, strings.SplitAfterN(string(header), "\n", 3)[1])
+	updated, err := runner.Update(queries, files, path)
+	assert.Nil(err)
+
+	full := &Runner{Workers: 1, Dedup: dedup.Exact}
+	expected, err := full.Run(queries, files)
+	assert.Nil(err)
+	assertSameRows(t, expected, updated)
+	assert.Equal("testrepo2", updated[1].Rows[0].Key)
+
+	// Nothing new to read
+	again, err := runner.Update(queries, files, path)
+	assert.Nil(err)
+	assertSameRows(t, expected, again)
+	assert.Equal(int64(0), runner.Stats.RowsRead)
+}
+
+func TestUpdateRebuilds(t *testing.T) {
+	assert := assert.New(t)
+
+	queries := []Query{
+		{Name: "events", Analysis: TopKReposByEvents, Count: 10},
+		{Name: "users", Analysis: TopKUsersByPRs, Count: 10},
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state")
+	files, _ := splitFiles(t, dir, func(lines int) int { return lines })
+	runner := &Runner{Workers: 1, Dedup: dedup.None}
+	_, err := runner.Update(queries, files, path)
+	assert.Nil(err)
+
+	for _, change := range []func(){
+		// Truncated
+		func() { splitFiles(t, dir, func(lines int) int { return lines / 2 }) },
+		// Rotated, the new file is longer than the consumed part of the old one
+		func() {
+			content, err := os.ReadFile(filepath.Join("testdata", "events.csv"))
+			assert.Nil(err)
+			rotated := strings.Replace(string(content), "WatchEvent", "ForkEvent", 1)
+			assert.Nil(os.WriteFile(files.Events, []byte(rotated), 0o644))
+		},
+	} {
+		change()
+		updated, err := runner.Update(queries, files, path)
+		assert.Nil(err)
+		expected, err := (&Runner{Workers: 1, Dedup: dedup.None}).Run(queries, files)
+		assert.Nil(err)
+		assertSameRows(t, expected, updated)
+	}
+
+	// The state of another dedup mode is rebuilt as well
+	runner.Dedup = dedup.Exact
+	_, err = runner.Update(queries, files, path)
+	assert.Nil(err)
+	assert.Greater(runner.Stats.RowsRead, int64(0))
+
+	runner.Dedup = dedup.Bloom
+	_, err = runner.Update(queries, files, path)
+	assert.NotNil(err)
+}
@@ -0,0 +1,384 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package multi
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+	"gitlab.com/ansrivas/go-analyze-git/internal/flags"
+	"gitlab.com/ansrivas/go-analyze-git/internal/match"
+	"gitlab.com/ansrivas/go-analyze-git/internal/ranking"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/bots"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/cache"
+)
+
+// Files are the input files of a run, only the
+// ones needed by the queries have to be set
+type Files struct {
+	Events  string
+	Commits string
+	Repos   string
+	Actors  string
+}
+
+// Runner runs queries over the input files
+type Runner struct {
+	// Workers is the number of workers parsing an input file in parallel
+	Workers int
+	// MaxMemory is the budget in bytes of the join state, which is
+	// spilled to disk once exceeded. Zero keeps it all in memory.
+	MaxMemory int64
+	// Counters is the number of counters of the sketch counting
+	// approximately. Zero counts exactly.
+	Counters int
+	// Precision is the precision of the HyperLogLog sketches counting
+	// distinct values approximately. Zero counts exactly.
+	Precision int
+	// Dedup is the dedup.Filter mode dropping duplicated rows,
+	// DedupMemory the size of its Bloom filter
+	Dedup       string
+	DedupMemory int64
+	// Repos and Users drop the repositories and users by their name
+	// or ID before they are ranked, nil keeps every one
+	Repos *match.Filter
+	Users *match.Filter
+	// Bots are dropped from the user rankings, see bots.Excluded
+	Bots bots.Suspects
+	// CacheDir is the directory of an ingested cache, which Run
+	// reads instead of the csv files while it is fresh
+	CacheDir string
+	// Stats of the most recent run, shared by all its queries
+	Stats utils.RunStats
+}
+
+// engine returns the engine computing the rankings
+func (r *Runner) engine() *ranking.Engine {
+	return &ranking.Engine{
+		Workers:     r.Workers,
+		MaxMemory:   r.MaxMemory,
+		Counters:    r.Counters,
+		Precision:   r.Precision,
+		Dedup:       r.Dedup,
+		DedupMemory: r.DedupMemory,
+	}
+}
+
+// rankings returns the rankings of the queries
+func (r *Runner) rankings(queries []Query) []ranking.Ranking {
+	rankings := make([]ranking.Ranking, 0, len(queries))
+	for _, q := range queries {
+		rankings = append(rankings, ranking.Ranking{
+			Analysis:       q.Analysis,
+			EventType:      q.EventType,
+			DistinctActors: q.DistinctActors,
+			Count:          q.Count,
+			Selection:      q.Selection,
+			Repos:          r.Repos,
+			Users:          r.Users,
+			Exclude:        r.Bots.Has,
+		})
+	}
+	return rankings
+}
+
+// reports returns the reports of the results of the queries, the
+// reports match the ones of the commands running the query alone
+func (r *Runner) reports(queries []Query, results []ranking.Result) []utils.Report {
+	engine := r.engine()
+	rankings := r.rankings(queries)
+	reports := make([]utils.Report, 0, len(queries))
+	for i, result := range results {
+		reports = append(reports, engine.Report(rankings[i], result))
+	}
+	r.Stats = utils.RunStats{}
+	if len(results) > 0 {
+		r.Stats = results[0].Stats
+	}
+	return reports
+}
+
+// Run answers all the queries reading every input file only once,
+// or their cached tables while they are fresh
+func (r *Runner) Run(queries []Query, files Files) ([]utils.Report, error) {
+	inputs := cache.Inputs(r.CacheDir, cache.Files{
+		Events:  files.Events,
+		Commits: files.Commits,
+		Repos:   files.Repos,
+		Actors:  files.Actors,
+	}, r.Dedup)
+	results, err := r.engine().Run(inputs, r.rankings(queries)...)
+	if err != nil {
+		return nil, err
+	}
+	return r.reports(queries, results), nil
+}
+
+// Write writes every report to the file of its query,
+// or else as a section of out headed by the query name
+func Write(out io.Writer, queries []Query, reports []utils.Report, chart bool) error {
+	for i, q := range queries {
+		if q.File == "" {
+			fmt.Fprintf(out, "== %s ==\n", q.Name)
+			if err := reports[i].Write(out, q.Output, chart); err != nil {
+				return err
+			}
+			if q.Output == utils.OutputJSON {
+				fmt.Fprintln(out)
+			}
+			continue
+		}
+		f, err := os.Create(q.File)
+		if err != nil {
+			return err
+		}
+		err = reports[i].Write(f, q.Output, chart)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		log.Debug().Msgf("Wrote [%s] to %s", q.Name, q.File)
+	}
+	return nil
+}
+
+// Required returns an error if a file needed by the queries is missing
+func (f Files) Required(queries []Query) error {
+	for _, q := range queries {
+		needed := map[string]string{flags.EventsFileFlag.Name: f.Events}
+		switch q.Analysis {
+		case TopKReposByEvents:
+			needed[flags.ReposFileFlag.Name] = f.Repos
+		case TopKReposByCommits:
+			needed[flags.ReposFileFlag.Name] = f.Repos
+			needed[flags.CommitsFileFlag.Name] = f.Commits
+		case TopKUsersByPRs:
+			needed[flags.ActorsFileFlag.Name] = f.Actors
+			needed[flags.CommitsFileFlag.Name] = f.Commits
+		}
+		for flag, path := range needed {
+			if path == "" {
+				return fmt.Errorf("--%s is required by %s", flag, q.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// querySpecs collects the repeated --query flags. Unlike a
+// StringSliceFlag it doesn't split a value by commas, which
+// separate the options of a single query.
+type querySpecs []string
+
+func (q *querySpecs) Set(value string) error {
+	*q = append(*q, value)
+	return nil
+}
+
+func (q *querySpecs) String() string {
+	return strings.Join(*q, " ")
+}
+
+// runFlags are the flags shared by the run and update commands
+func runFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.GenericFlag{
+			Name:    "query",
+			Value:   &querySpecs{},
+			Usage:   "Analysis to run like 'topk-by-commits,count=5,output=json,file=commits.json' or 'topk-by-events,event-type=ForkEvent', can be repeated",
+			EnvVars: []string{"QUERY"},
+		},
+		&cli.StringFlag{
+			Name:    "queries-file",
+			Usage:   "Yaml or json file listing the analyses to run under 'queries'",
+			EnvVars: []string{"QUERIES_FILE"},
+		},
+		flags.DatasetFlag,
+		flags.ReposFileFlag,
+		flags.EventsFileFlag,
+		flags.CommitsFileFlag,
+		flags.ActorsFileFlag,
+		flags.RepoIncludeFlag,
+		flags.RepoExcludeFlag,
+		flags.UserIncludeFlag,
+		flags.UserExcludeFlag,
+		flags.ExcludeBotsFlag,
+		flags.BotsFileFlag,
+		flags.BotCommitsFlag,
+		flags.BotRepeatedFlag,
+		flags.WorkersFlag,
+		flags.MaxMemoryFlag,
+		flags.ApproxFlag,
+		flags.ApproxMemoryFlag,
+		flags.HLLPrecisionFlag,
+		flags.DedupFlag,
+		flags.DedupMemoryFlag,
+		flags.CacheDirFlag,
+		flags.ChartFlag,
+	}
+}
+
+// RanksUsers tells if one of the queries ranks the users
+func RanksUsers(queries []Query) bool {
+	for _, q := range queries {
+		if q.Analysis == TopKUsersByPRs {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRun returns the queries, input files and runner of the
+// run and update commands
+func parseRun(c *cli.Context) ([]Query, Files, *Runner, error) {
+	var queries []Query
+	if path := c.String("queries-file"); path != "" {
+		loaded, err := LoadQueries(path)
+		if err != nil {
+			return nil, Files{}, nil, err
+		}
+		queries = append(queries, loaded...)
+	}
+	if specs, ok := c.Generic("query").(*querySpecs); ok {
+		for _, spec := range *specs {
+			query, err := ParseQuery(spec)
+			if err != nil {
+				return nil, Files{}, nil, err
+			}
+			queries = append(queries, query)
+		}
+	}
+	if len(queries) == 0 {
+		return nil, Files{}, nil, fmt.Errorf("no analysis to run, pass --query or --queries-file")
+	}
+	files := Files{
+		Events:  c.String(flags.EventsFileFlag.Name),
+		Commits: c.String(flags.CommitsFileFlag.Name),
+		Repos:   c.String(flags.ReposFileFlag.Name),
+		Actors:  c.String(flags.ActorsFileFlag.Name),
+	}
+
+	runner := &Runner{Workers: c.Int(flags.WorkersFlag.Name), CacheDir: c.String(flags.CacheDirFlag.Name)}
+	var err error
+	if runner.MaxMemory, err = flags.MaxMemory(c); err != nil {
+		return nil, Files{}, nil, err
+	}
+	if runner.Counters, err = flags.Counters(c); err != nil {
+		return nil, Files{}, nil, err
+	}
+	if runner.Precision, err = flags.Precision(c); err != nil {
+		return nil, Files{}, nil, err
+	}
+	runner.Dedup, runner.DedupMemory, err = flags.Dedup(c)
+	if err != nil {
+		return nil, Files{}, nil, err
+	}
+	if runner.Repos, err = flags.RepoFilter(c); err != nil {
+		return nil, Files{}, nil, err
+	}
+	if runner.Users, err = flags.UserFilter(c); err != nil {
+		return nil, Files{}, nil, err
+	}
+	if RanksUsers(queries) {
+		if runner.Bots, err = bots.Excluded(c, files.Events, files.Commits, files.Actors); err != nil {
+			return nil, Files{}, nil, err
+		}
+	}
+	return queries, files, runner, nil
+}
+
+// CmdRun runs several queries sharing a single pass over every file
+func CmdRun() *cli.Command {
+	cmdName := "run"
+	return &cli.Command{
+		Name:  cmdName,
+		Usage: "Run several analyses sharing a single pass over every input file",
+		Flags: runFlags(),
+		Action: func(c *cli.Context) error {
+			queries, files, runner, err := parseRun(c)
+			if err != nil {
+				return err
+			}
+			if err := files.Required(queries); err != nil {
+				return err
+			}
+			start := time.Now()
+			reports, err := runner.Run(queries, files)
+			if err != nil {
+				return err
+			}
+			if err := Write(os.Stdout, queries, reports, c.Bool(flags.ChartFlag.Name)); err != nil {
+				return err
+			}
+			log.Debug().Msgf("[%s] ran %d analyses in %v", cmdName, len(queries), time.Since(start))
+			return nil
+		},
+	}
+}
+
+// CmdUpdate runs several queries like CmdRun, reading only the
+// lines appended to the input files since the last update
+func CmdUpdate() *cli.Command {
+	cmdName := "update"
+	return &cli.Command{
+		Name:  cmdName,
+		Usage: "Run several analyses like run, reading only what was appended to the input files since the last update",
+		Flags: append(runFlags(), &cli.StringFlag{
+			Name:     "state",
+			Usage:    "File keeping the counts and the consumed offsets of the input files between updates",
+			EnvVars:  []string{"STATE"},
+			Required: true,
+		}),
+		Action: func(c *cli.Context) error {
+			queries, files, runner, err := parseRun(c)
+			if err != nil {
+				return err
+			}
+			// The state counts for every analysis, a later update may run any
+			if err := files.Required([]Query{
+				{Name: cmdName, Analysis: TopKReposByCommits},
+				{Name: cmdName, Analysis: TopKUsersByPRs},
+			}); err != nil {
+				return err
+			}
+			start := time.Now()
+			reports, err := runner.Update(queries, files, c.String("state"))
+			if err != nil {
+				return err
+			}
+			if err := Write(os.Stdout, queries, reports, c.Bool(flags.ChartFlag.Name)); err != nil {
+				return err
+			}
+			log.Debug().Msgf("[%s] ran %d analyses in %v", cmdName, len(queries), time.Since(start))
+			return nil
+		},
+	}
+}
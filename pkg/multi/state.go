@@ -0,0 +1,244 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package multi
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dataflow"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dedup"
+	"gitlab.com/ansrivas/go-analyze-git/internal/fileops"
+	"gitlab.com/ansrivas/go-analyze-git/internal/ranking"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+)
+
+// stateVersion changes whenever the layout of a state file changes
+const stateVersion = 3
+
+// headSize is how much of the start of an input file is compared to
+// tell whether it was replaced since the last update
+const headSize = 64 << 10
+
+// Input is the part of an input file consumed by the updates so far
+type Input struct {
+	Path string
+	// Offset is the end of the last line read
+	Offset int64
+	// Head is the SHA-256 of the first headSize bytes before Offset,
+	// which changes when the file is rotated or rewritten
+	Head [sha256.Size]byte
+}
+
+// Inputs are the consumed parts of the input files
+type Inputs struct {
+	Events  Input
+	Commits Input
+	Repos   Input
+	Actors  Input
+}
+
+// snapshot is the persisted form of a state
+type snapshot struct {
+	Version int
+	Dedup   string
+	Inputs  Inputs
+	State   ranking.State
+}
+
+// head returns the checksum of the first headSize bytes of fname before offset
+func head(fname string, offset int64) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	f, err := os.Open(fname)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+	if offset > headSize {
+		offset = headSize
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, offset); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// changed returns why fname is not the file the input was read
+// from anymore, or an empty string if it only grew since
+func (in Input) changed(fname string) string {
+	path, err := filepath.Abs(fname)
+	if err != nil || path != in.Path {
+		return fmt.Sprintf("%s is a new input", fname)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err.Error()
+	}
+	if info.Size() < in.Offset {
+		return fmt.Sprintf("%s was truncated", fname)
+	}
+	sum, err := head(path, in.Offset)
+	if err != nil {
+		return err.Error()
+	}
+	if sum != in.Head {
+		return fmt.Sprintf("%s was rotated or rewritten", fname)
+	}
+	return ""
+}
+
+// loadState reads the state file at path. It returns an empty state
+// instead if there is none yet, or if it doesn't match the files or
+// the dedup mode anymore, so that everything is read again.
+func (r *Runner) loadState(path string, files Files) (*ranking.State, Inputs, error) {
+	var snap snapshot
+	reason := ""
+	f, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err):
+		log.Debug().Msgf("Creating the state in %s", path)
+	case err != nil:
+		return nil, Inputs{}, err
+	default:
+		err := gob.NewDecoder(bufio.NewReader(f)).Decode(&snap)
+		f.Close()
+		switch {
+		case err != nil:
+			reason = fmt.Sprintf("it can't be read: %v", err)
+		case snap.Version != stateVersion:
+			reason = fmt.Sprintf("it has version %d instead of %d", snap.Version, stateVersion)
+		case snap.Dedup != r.Dedup:
+			reason = fmt.Sprintf("it was deduplicated with %s instead of %s", snap.Dedup, r.Dedup)
+		default:
+			for _, check := range []struct {
+				in    Input
+				fname string
+			}{
+				{snap.Inputs.Events, files.Events},
+				{snap.Inputs.Commits, files.Commits},
+				{snap.Inputs.Repos, files.Repos},
+				{snap.Inputs.Actors, files.Actors},
+			} {
+				if reason = check.in.changed(check.fname); reason != "" {
+					break
+				}
+			}
+		}
+		if reason == "" {
+			return &snap.State, snap.Inputs, nil
+		}
+		log.Info().Msgf("Rebuilding the state in %s, %s", path, reason)
+	}
+	return &ranking.State{}, Inputs{}, nil
+}
+
+// saveState writes the state to path, replacing the file only
+// once it is complete so that an interrupted update loses nothing
+func (r *Runner) saveState(path string, s *ranking.State, inputs Inputs) error {
+	snap := snapshot{
+		Version: stateVersion,
+		Dedup:   r.Dedup,
+		Inputs:  inputs,
+		State:   *s,
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	err = gob.NewEncoder(w).Encode(&snap)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing the state to %s: %w", path, err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Update answers the queries like Run, but carries the counts over
+// between calls in the state file at path. Only the lines appended to
+// the input files since the last update are read. If an input file was
+// truncated, rotated or rewritten, everything is read again.
+//
+// A last line without a newline may still be being written, it is
+// left for the next update.
+func (r *Runner) Update(queries []Query, files Files, path string) ([]utils.Report, error) {
+	if r.Dedup == dedup.Bloom {
+		return nil, fmt.Errorf("updates can't persist a --dedup %s filter, use %s or %s", dedup.Bloom, dedup.Exact, dedup.None)
+	}
+
+	s, consumed, err := r.loadState(path, files)
+	if err != nil {
+		return nil, err
+	}
+	var inputs ranking.Inputs
+	for _, input := range []struct {
+		in    *Input
+		fname string
+		tail  *dataflow.Input
+	}{
+		{&consumed.Events, files.Events, &inputs.Events},
+		{&consumed.Commits, files.Commits, &inputs.Commits},
+		{&consumed.Repos, files.Repos, &inputs.Repos},
+		{&consumed.Actors, files.Actors, &inputs.Actors},
+	} {
+		chunks, end, err := fileops.SplitTail(input.fname, input.in.Offset, r.Workers)
+		if err != nil {
+			return nil, err
+		}
+		// No chunk is nothing new, not the whole file
+		if chunks == nil {
+			chunks = []fileops.Chunk{}
+		}
+		*input.tail = dataflow.Input{File: input.fname, Chunks: chunks}
+		log.Debug().Msgf("Reading %d new bytes of %s", end-input.in.Offset, input.fname)
+		if input.in.Path, err = filepath.Abs(input.fname); err != nil {
+			return nil, err
+		}
+		input.in.Offset = end
+		if input.in.Head, err = head(input.fname, end); err != nil {
+			return nil, err
+		}
+	}
+	results, err := r.engine().Update(s, inputs, r.rankings(queries)...)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.saveState(path, s, consumed); err != nil {
+		return nil, err
+	}
+	return r.reports(queries, results), nil
+}
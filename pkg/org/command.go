@@ -0,0 +1,106 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package org
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+	"gitlab.com/ansrivas/go-analyze-git/internal/flags"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/cache"
+)
+
+// Write writes the summary as two tables, the repositories and
+// the top contributors
+func (s Summary) Write(out io.Writer) {
+	fmt.Fprintln(out, "== repositories ==")
+	utils.WriteTable(out, s.Repos, []string{"Repo", EventsHeader, CommitsHeader})
+	fmt.Fprintln(out, "== top contributors ==")
+	utils.WriteTable(out, s.Contributors, []string{"User", CommitsHeader, EventsHeader})
+}
+
+// CmdShow lists the repositories and the top contributors of an owner
+func CmdShow() *cli.Command {
+	cmdName := "show"
+	return &cli.Command{
+		Name:      cmdName,
+		Usage:     "List the repositories of an organization or user namespace and its top contributors",
+		ArgsUsage: "<owner>",
+		Flags: []cli.Flag{
+			flags.DatasetFlag,
+			flags.EventsFileFlag,
+			flags.CommitsFileFlag,
+			flags.ReposFileFlag,
+			flags.ActorsFileFlag,
+			flags.CountFlag,
+			flags.WorkersFlag,
+			flags.DedupFlag,
+			flags.DedupMemoryFlag,
+			flags.CacheDirFlag,
+			flags.JsonFlag,
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return fmt.Errorf("expected a single owner, got %d arguments", c.NArg())
+			}
+			if err := flags.Required(c, "events-file", "commits-file", "repos-file", "actors-file"); err != nil {
+				return err
+			}
+			mode, memory, err := flags.Dedup(c)
+			if err != nil {
+				return err
+			}
+			start := time.Now()
+			files := cache.Files{
+				Events:  c.String(flags.EventsFileFlag.Name),
+				Commits: c.String(flags.CommitsFileFlag.Name),
+				Repos:   c.String(flags.ReposFileFlag.Name),
+				Actors:  c.String(flags.ActorsFileFlag.Name),
+			}
+			summary, err := Summarize(c.Args().First(), c.Int(flags.CountFlag.Name), cache.Inputs(c.String(flags.CacheDirFlag.Name), files, mode), c.Int(flags.WorkersFlag.Name), mode, memory)
+			if err != nil {
+				return err
+			}
+			if len(summary.Repos) == 0 {
+				log.Warn().Msgf("No repository of %s found in %s", summary.Owner, files.Repos)
+			}
+
+			if c.Bool(flags.JsonFlag.Name) {
+				payload, err := json.MarshalIndent(summary, "", "    ")
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(c.App.Writer, string(payload))
+			} else {
+				summary.Write(c.App.Writer)
+			}
+			log.Debug().Msgf("[%s] took %v", cmdName, time.Since(start))
+			return nil
+		},
+	}
+}
@@ -0,0 +1,145 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package org sums up the activity of the repositories of an owner, an
+// organization or a user namespace.
+package org
+
+import (
+	"strings"
+	"time"
+
+	"gitlab.com/ansrivas/go-analyze-git/internal/dataflow"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dedup"
+	"gitlab.com/ansrivas/go-analyze-git/internal/ranking"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+)
+
+// Headers of the extra columns of a summary
+const (
+	EventsHeader  = "Events"
+	CommitsHeader = "Commits"
+)
+
+// Summary is the activity of the repositories of an owner
+type Summary struct {
+	Owner string `json:"owner"`
+	// Repos are all the repositories of the owner, ranked by their
+	// events with their commits in the CommitsHeader column
+	Repos utils.GenericDictHeap `json:"repos"`
+	// Contributors are the top actors by the commits they pushed to
+	// the repositories, with their events in the EventsHeader column
+	Contributors utils.GenericDictHeap `json:"contributors"`
+	Stats        utils.RunStats        `json:"-"`
+}
+
+// Summarize returns the activity of the repositories of owner, which
+// matches regardless of case, over the inputs of cache.Inputs. count limits the contributors. The
+// duplicated events and commits are each dropped by their own
+// dedup.Filter of the given mode and memory.
+func Summarize(owner string, count int, inputs ranking.Inputs, workers int, mode string, memory int64) (Summary, error) {
+	start := time.Now()
+	summary := Summary{Owner: owner}
+	eventsFilter, err := dedup.New(mode, memory)
+	if err != nil {
+		return summary, err
+	}
+	commitsFilter, err := dedup.New(mode, memory)
+	if err != nil {
+		return summary, err
+	}
+	flow, err := dataflow.New(workers, 0)
+	if err != nil {
+		return summary, err
+	}
+	defer flow.Close()
+
+	// The first name of a repository wins, like in the repository rankings
+	names := dataflow.FirstNames(dataflow.Field(0), 1, func(int64) bool { return true })
+	err = flow.Scan(inputs.Repos.Source(2, []dataflow.Op{func(row *dataflow.Row) bool {
+		return strings.EqualFold(ranking.Owner(string(row.Field(1))), owner)
+	}}, names))
+	if err != nil {
+		return summary, err
+	}
+	owned := func(repoID int64) bool {
+		_, ok := names.Names[repoID]
+		return ok
+	}
+
+	repoEvents := dataflow.NewCount(dataflow.Field(3), 0)
+	actorEvents := dataflow.NewCount(dataflow.Field(2), 0)
+	byRepo := dataflow.NewIndex(dataflow.Field(0), dataflow.Field(3))
+	byActor := dataflow.NewIndex(dataflow.Field(0), dataflow.Field(2))
+	err = flow.Scan(inputs.Events.Source(4,
+		[]dataflow.Op{dataflow.In(3, owned), dataflow.Unique(eventsFilter, dataflow.Prefix(2))},
+		repoEvents, actorEvents, byRepo, byActor))
+	if err != nil {
+		return summary, err
+	}
+
+	// The event id of a commit is its last column
+	repoCommits := dataflow.NewCount(byRepo.Join(-1), 0)
+	actorCommits := dataflow.NewCount(byActor.Join(-1), 0)
+	err = flow.Scan(inputs.Commits.Source(0,
+		[]dataflow.Op{dataflow.Known(-1), dataflow.Unique(commitsFilter, dataflow.Fields(0, -1))},
+		repoCommits, actorCommits))
+	if err != nil {
+		return summary, err
+	}
+
+	logins := dataflow.LastNames(dataflow.KnownField(0), 1, func(actorID int64) bool {
+		commits, _, _ := actorCommits.Counts.Get(actorID)
+		return commits > 0
+	})
+	if err := flow.Scan(inputs.Actors.Source(2, nil, logins)); err != nil {
+		return summary, err
+	}
+
+	// Every repository is listed, even without any event
+	for repoID, name := range names.Names {
+		events, _, _ := repoEvents.Counts.Get(repoID)
+		commits, _, _ := repoCommits.Counts.Get(repoID)
+		row := utils.GenericDict{Key: name, Value: events}
+		row.SetExtra(CommitsHeader, commits)
+		summary.Repos = append(summary.Repos, row)
+	}
+	summary.Repos = utils.Rerank(summary.Repos, len(summary.Repos), utils.Selection{})
+
+	summary.Contributors = dataflow.TopK(actorCommits.Counts, count, utils.Selection{}, func(actorID int64, row *utils.GenericDict) bool {
+		login, ok := logins.Names[actorID]
+		if !ok {
+			login = flow.IDs.String(actorID)
+		}
+		row.Key = login
+		events, _, _ := actorEvents.Counts.Get(actorID)
+		row.SetExtra(EventsHeader, events)
+		return row.Value > 0
+	})
+
+	summary.Stats = flow.Stats
+	if inputs.Stats != nil {
+		summary.Stats = *inputs.Stats
+	}
+	summary.Stats.Duration = time.Since(start)
+	return summary, nil
+}
@@ -0,0 +1,79 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package org
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dedup"
+	"gitlab.com/ansrivas/go-analyze-git/internal/ranking"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/cache"
+)
+
+func TestSummarize(t *testing.T) {
+	assert := assert.New(t)
+
+	files := cache.Files{
+		Events:  "testdata/events.csv",
+		Commits: "testdata/commits.csv",
+		Repos:   "testdata/repos.csv",
+		Actors:  "testdata/actors.csv",
+	}
+	commits := func(n int) map[string]int { return map[string]int{CommitsHeader: n} }
+	events := func(n int) map[string]int { return map[string]int{EventsHeader: n} }
+	inputs := cache.Inputs("", files, dedup.Exact)
+	// The cached tables sum up the same activity
+	dir := t.TempDir()
+	_, err := cache.Ingest(files, dir, 1)
+	assert.Nil(err)
+	for _, in := range []ranking.Inputs{inputs, cache.Inputs(dir, files, dedup.Exact)} {
+		for workers := 1; workers <= 4; workers++ {
+			// The owner matches regardless of case, a repository without
+			// events is listed and the duplicated rows are dropped
+			summary, err := Summarize("ACME", 10, in, workers, dedup.Exact, 0)
+			assert.Nil(err)
+			assert.Equal("ACME", summary.Owner)
+			assert.Equal(utils.GenericDictHeap{
+				{Key: "acme/rockets", Value: 3, Extra: commits(3)},
+				{Key: "acme/anvils", Value: 1, Extra: commits(1)},
+				{Key: "Acme/traps", Value: 0, Extra: commits(0)},
+			}, summary.Repos, "workers: %d", workers)
+			assert.Equal(utils.GenericDictHeap{
+				{Key: "wile", Value: 3, Extra: events(2)},
+				{Key: "roadrunner", Value: 1, Extra: events(2)},
+			}, summary.Contributors, "workers: %d", workers)
+		}
+	}
+
+	summary, err := Summarize("acme", 1, inputs, 1, dedup.Exact, 0)
+	assert.Nil(err)
+	assert.Equal(utils.GenericDictHeap{{Key: "wile", Value: 3, Extra: events(2)}}, summary.Contributors)
+
+	// An unknown owner has nothing to show
+	summary, err = Summarize("initech", 10, inputs, 1, dedup.Exact, 0)
+	assert.Nil(err)
+	assert.Empty(summary.Repos)
+	assert.Empty(summary.Contributors)
+}
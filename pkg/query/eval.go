@@ -0,0 +1,546 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// value is nil, a string, an int64 or a bool
+type value interface{}
+
+func toString(v value) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(v)
+}
+
+func toInt(v value) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func truthy(v value) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case nil:
+		return false
+	case int64:
+		return v != 0
+	case string:
+		return v != ""
+	}
+	return false
+}
+
+// compare orders numbers numerically and everything else as strings.
+// NULL sorts first.
+func compare(a, b value) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+	if x, ok := toInt(a); ok {
+		if y, ok := toInt(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(toString(a), toString(b))
+}
+
+// likePattern translates a SQL LIKE pattern into a regular expression
+func likePattern(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			sb.WriteString(".*")
+		case '_':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+// accumulator holds the running state of one aggregate within a group
+type accumulator struct {
+	count    int64
+	sum      int64
+	extreme  value
+	distinct map[string]struct{}
+}
+
+func (a *accumulator) add(c *call, v value) {
+	if c.arg != nil && v == nil {
+		return
+	}
+	if c.distinct {
+		if a.distinct == nil {
+			a.distinct = map[string]struct{}{}
+		}
+		key := toString(v)
+		if _, seen := a.distinct[key]; seen {
+			return
+		}
+		a.distinct[key] = struct{}{}
+	}
+	a.count++
+	switch c.name {
+	case "sum":
+		n, _ := toInt(v)
+		a.sum += n
+	case "min":
+		if a.extreme == nil || compare(v, a.extreme) < 0 {
+			a.extreme = v
+		}
+	case "max":
+		if a.extreme == nil || compare(v, a.extreme) > 0 {
+			a.extreme = v
+		}
+	}
+}
+
+func (a *accumulator) result(c *call) value {
+	switch c.name {
+	case "count":
+		return a.count
+	case "sum":
+		return a.sum
+	}
+	return a.extreme
+}
+
+// group is a set of rows sharing the same GROUP BY values
+type group struct {
+	// first row of the group, used for non aggregated expressions
+	first int
+	accs  []accumulator
+}
+
+// evaluator evaluates bound expressions
+type evaluator struct {
+	likes map[string]*regexp.Regexp
+}
+
+// eval evaluates e for a row, taking aggregates from g if it is not nil
+func (ev *evaluator) eval(e expr, row int, g *group) (value, error) {
+	switch e := e.(type) {
+	case *literal:
+		return e.value, nil
+	case *path:
+		// Groups without any row have no row to read from
+		if row < 0 {
+			return nil, nil
+		}
+		return e.get(row), nil
+	case *call:
+		if g == nil {
+			return nil, fmt.Errorf("aggregate %s is not allowed here", e.name)
+		}
+		return g.accs[e.slot].result(e), nil
+	case *unary:
+		x, err := ev.eval(e.x, row, g)
+		if err != nil {
+			return nil, err
+		}
+		if e.op == "ISNULL" {
+			return x == nil, nil
+		}
+		return !truthy(x), nil
+	case *binary:
+		l, err := ev.eval(e.l, row, g)
+		if err != nil {
+			return nil, err
+		}
+		// Short circuit the logical operators
+		switch e.op {
+		case "AND":
+			if !truthy(l) {
+				return false, nil
+			}
+		case "OR":
+			if truthy(l) {
+				return true, nil
+			}
+		}
+		r, err := ev.eval(e.r, row, g)
+		if err != nil {
+			return nil, err
+		}
+		switch e.op {
+		case "AND", "OR":
+			return truthy(r), nil
+		case "LIKE":
+			pattern := toString(r)
+			re, ok := ev.likes[pattern]
+			if !ok {
+				if re, err = likePattern(pattern); err != nil {
+					return nil, err
+				}
+				ev.likes[pattern] = re
+			}
+			return l != nil && re.MatchString(toString(l)), nil
+		}
+		if l == nil || r == nil {
+			return false, nil
+		}
+		c := compare(l, r)
+		switch e.op {
+		case "=":
+			return c == 0, nil
+		case "!=":
+			return c != 0, nil
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		case ">=":
+			return c >= 0, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot evaluate %T", e)
+}
+
+// bind resolves all the paths of e against the base table
+func (s *schema) bind(e expr, base *table, aliases map[string][]string) error {
+	switch e := e.(type) {
+	case *path:
+		parts := e.parts
+		if len(parts) > 1 && parts[0] == base.name {
+			parts = parts[1:]
+		} else if prefix, ok := aliases[parts[0]]; ok {
+			parts = append(append([]string{}, prefix...), parts[1:]...)
+		}
+		get, err := s.resolve(base, parts)
+		if err != nil {
+			return err
+		}
+		e.get = get
+	case *call:
+		if e.arg != nil {
+			return s.bind(e.arg, base, aliases)
+		}
+	case *unary:
+		return s.bind(e.x, base, aliases)
+	case *binary:
+		if err := s.bind(e.l, base, aliases); err != nil {
+			return err
+		}
+		return s.bind(e.r, base, aliases)
+	}
+	return nil
+}
+
+func hasAggregate(e expr) bool {
+	switch e := e.(type) {
+	case *call:
+		return true
+	case *unary:
+		return hasAggregate(e.x)
+	case *binary:
+		return hasAggregate(e.l) || hasAggregate(e.r)
+	}
+	return false
+}
+
+// Result is the outcome of a query
+type Result struct {
+	Columns []string
+	Rows    [][]string
+}
+
+type resultRow struct {
+	values []value
+	sortBy []value
+}
+
+// selected replaces a reference to the alias of a select item
+// by the expression of that item
+func (stmt *statement) selected(e expr) expr {
+	if p, ok := e.(*path); ok && len(p.parts) == 1 {
+		for _, item := range stmt.items {
+			if item.alias == p.parts[0] {
+				return item.expr
+			}
+		}
+	}
+	return e
+}
+
+// substitute applies selected to every node of the expression tree
+func (stmt *statement) substitute(e expr) expr {
+	switch e := e.(type) {
+	case *unary:
+		e.x = stmt.substitute(e.x)
+	case *binary:
+		e.l = stmt.substitute(e.l)
+		e.r = stmt.substitute(e.r)
+	}
+	return stmt.selected(e)
+}
+
+func (s *schema) execute(stmt *statement) (*Result, error) {
+	base, err := s.table(stmt.from)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every JOIN is an alias for the chain of foreign keys leading to the
+	// joined table, rows without a match are dropped like in an inner join
+	aliases := map[string][]string{}
+	var joined []*path
+	for _, j := range stmt.joins {
+		if _, err := s.table(j.table); err != nil {
+			return nil, err
+		}
+		prefix, ok := s.relationPath(base, j.table)
+		if !ok {
+			return nil, fmt.Errorf("no known foreign key from %s to %s", base.name, j.table)
+		}
+		aliases[j.alias] = prefix
+		key := &path{parts: append(append([]string{}, prefix...), "id")}
+		if err := s.bind(key, base, aliases); err != nil {
+			return nil, err
+		}
+		joined = append(joined, key)
+	}
+
+	if stmt.star {
+		for _, column := range base.columns {
+			stmt.items = append(stmt.items, selectItem{expr: &path{parts: []string{column}}, text: column})
+		}
+	}
+
+	aggregated := len(stmt.groupBy) > 0
+	for _, item := range stmt.items {
+		if err := s.bind(item.expr, base, aliases); err != nil {
+			return nil, err
+		}
+		aggregated = aggregated || hasAggregate(item.expr)
+	}
+	for i, e := range stmt.groupBy {
+		stmt.groupBy[i] = stmt.selected(e)
+		if err := s.bind(stmt.groupBy[i], base, aliases); err != nil {
+			return nil, err
+		}
+	}
+	if stmt.where != nil {
+		if hasAggregate(stmt.where) {
+			return nil, fmt.Errorf("aggregates are not allowed in WHERE, use HAVING")
+		}
+		if err := s.bind(stmt.where, base, aliases); err != nil {
+			return nil, err
+		}
+	}
+	if stmt.having != nil {
+		stmt.having = stmt.substitute(stmt.having)
+		if err := s.bind(stmt.having, base, aliases); err != nil {
+			return nil, err
+		}
+		aggregated = true
+	}
+
+	// ORDER BY may refer to select items by position or alias
+	orderExprs := make([]expr, len(stmt.orderBy))
+	for i, item := range stmt.orderBy {
+		if item.position > 0 {
+			if item.position > len(stmt.items) {
+				return nil, fmt.Errorf("ORDER BY position %d is out of range", item.position)
+			}
+			orderExprs[i] = stmt.items[item.position-1].expr
+			continue
+		}
+		orderExprs[i] = stmt.selected(item.expr)
+		if err := s.bind(orderExprs[i], base, aliases); err != nil {
+			return nil, err
+		}
+		aggregated = aggregated || hasAggregate(orderExprs[i])
+	}
+
+	ev := &evaluator{likes: map[string]*regexp.Regexp{}}
+	var rows []resultRow
+	emit := func(row int, g *group) error {
+		if g != nil && stmt.having != nil {
+			keep, err := ev.eval(stmt.having, row, g)
+			if err != nil || !truthy(keep) {
+				return err
+			}
+		}
+		r := resultRow{}
+		for _, item := range stmt.items {
+			v, err := ev.eval(item.expr, row, g)
+			if err != nil {
+				return err
+			}
+			r.values = append(r.values, v)
+		}
+		for _, e := range orderExprs {
+			v, err := ev.eval(e, row, g)
+			if err != nil {
+				return err
+			}
+			r.sortBy = append(r.sortBy, v)
+		}
+		rows = append(rows, r)
+		return nil
+	}
+
+	groups := map[string]*group{}
+	var order []*group
+	for row := 0; row < base.size; row++ {
+		matched := true
+		for _, key := range joined {
+			if key.get(row) == nil {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		if stmt.where != nil {
+			keep, err := ev.eval(stmt.where, row, nil)
+			if err != nil {
+				return nil, err
+			}
+			if !truthy(keep) {
+				continue
+			}
+		}
+		if !aggregated {
+			if err := emit(row, nil); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		keyParts := make([]string, len(stmt.groupBy))
+		for i, e := range stmt.groupBy {
+			v, err := ev.eval(e, row, nil)
+			if err != nil {
+				return nil, err
+			}
+			keyParts[i] = toString(v)
+		}
+		key := strings.Join(keyParts, "\x00")
+		g, ok := groups[key]
+		if !ok {
+			g = &group{first: row, accs: make([]accumulator, len(stmt.calls))}
+			groups[key] = g
+			order = append(order, g)
+		}
+		for _, c := range stmt.calls {
+			var v value
+			if c.arg != nil {
+				var err error
+				if v, err = ev.eval(c.arg, row, nil); err != nil {
+					return nil, err
+				}
+			}
+			g.accs[c.slot].add(c, v)
+		}
+	}
+
+	// Aggregates over no rows and no GROUP BY still yield a single row
+	if aggregated && len(order) == 0 && len(stmt.groupBy) == 0 {
+		order = append(order, &group{first: -1, accs: make([]accumulator, len(stmt.calls))})
+	}
+	for _, g := range order {
+		if err := emit(g.first, g); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(stmt.orderBy) > 0 {
+		sort.SliceStable(rows, func(i, j int) bool {
+			for k, item := range stmt.orderBy {
+				c := compare(rows[i].sortBy[k], rows[j].sortBy[k])
+				if c == 0 {
+					continue
+				}
+				if item.desc {
+					return c > 0
+				}
+				return c < 0
+			}
+			return false
+		})
+	}
+	if stmt.limit >= 0 && len(rows) > stmt.limit {
+		rows = rows[:stmt.limit]
+	}
+
+	result := &Result{}
+	for _, item := range stmt.items {
+		if item.alias != "" {
+			result.Columns = append(result.Columns, item.alias)
+		} else {
+			result.Columns = append(result.Columns, item.text)
+		}
+	}
+	for _, r := range rows {
+		values := make([]string, len(r.values))
+		for i, v := range r.values {
+			values[i] = toString(v)
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	return result, nil
+}
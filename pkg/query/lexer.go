@@ -0,0 +1,121 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokKeyword
+	tokNumber
+	tokString
+	tokSymbol
+)
+
+var keywords = map[string]bool{
+	"SELECT": true, "FROM": true, "JOIN": true, "AS": true, "WHERE": true,
+	"GROUP": true, "BY": true, "HAVING": true, "ORDER": true, "LIMIT": true,
+	"ASC": true, "DESC": true, "AND": true, "OR": true, "NOT": true,
+	"LIKE": true, "DISTINCT": true, "NULL": true, "IS": true,
+}
+
+type token struct {
+	kind tokenKind
+	// text is upper cased for keywords, unquoted for strings
+	text string
+	pos  int
+	end  int
+}
+
+// lex splits the input into tokens, always ending with tokEOF
+func lex(input string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(input) {
+		c := rune(input[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(input) && (input[i] == '_' || unicode.IsLetter(rune(input[i])) || unicode.IsDigit(rune(input[i]))) {
+				i++
+			}
+			word := input[start:i]
+			if keywords[strings.ToUpper(word)] {
+				tokens = append(tokens, token{kind: tokKeyword, text: strings.ToUpper(word), pos: start, end: i})
+			} else {
+				tokens = append(tokens, token{kind: tokIdent, text: word, pos: start, end: i})
+			}
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(input) && unicode.IsDigit(rune(input[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: input[start:i], pos: start, end: i})
+		case c == '\'' || c == '"':
+			start := i
+			i++
+			var sb strings.Builder
+			for {
+				if i >= len(input) {
+					return nil, fmt.Errorf("unterminated string starting at %d", start)
+				}
+				if rune(input[i]) == c {
+					// A doubled quote escapes itself
+					if i+1 < len(input) && rune(input[i+1]) == c {
+						sb.WriteByte(input[i])
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				sb.WriteByte(input[i])
+				i++
+			}
+			tokens = append(tokens, token{kind: tokString, text: sb.String(), pos: start, end: i})
+		default:
+			start := i
+			for _, symbol := range []string{"<=", ">=", "!=", "<>", "=", "<", ">", "(", ")", ",", ".", "*", ";"} {
+				if strings.HasPrefix(input[i:], symbol) {
+					i += len(symbol)
+					break
+				}
+			}
+			if i == start {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, start)
+			}
+			tokens = append(tokens, token{kind: tokSymbol, text: input[start:i], pos: start, end: i})
+		}
+	}
+	tokens = append(tokens, token{kind: tokEOF, pos: len(input), end: len(input)})
+	return tokens, nil
+}
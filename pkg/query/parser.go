@@ -0,0 +1,427 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// expr is a node of an expression tree
+type expr interface{}
+
+type literal struct {
+	value value
+}
+
+// path is a possibly dotted column reference such as type or repo.name
+type path struct {
+	parts []string
+	// get is set while binding the statement to a table
+	get func(row int) value
+}
+
+type call struct {
+	name     string
+	distinct bool
+	// arg is nil for count(*)
+	arg expr
+	// slot is the index of the aggregate in the group state
+	slot int
+}
+
+type unary struct {
+	op string
+	x  expr
+}
+
+type binary struct {
+	op   string
+	l, r expr
+}
+
+type selectItem struct {
+	expr  expr
+	alias string
+	// text is the source of the expression, used as column header
+	text string
+}
+
+type orderItem struct {
+	expr expr
+	// position is the 1-based select item to order by, or 0
+	position int
+	desc     bool
+}
+
+type join struct {
+	table string
+	alias string
+}
+
+type statement struct {
+	items   []selectItem
+	star    bool
+	from    string
+	joins   []join
+	where   expr
+	groupBy []expr
+	having  expr
+	orderBy []orderItem
+	limit   int
+	// calls are all the aggregates used by the statement
+	calls []*call
+}
+
+var comparisons = map[string]bool{
+	"=": true, "!=": true, "<>": true, "<": true, "<=": true, ">": true, ">=": true,
+}
+
+type parser struct {
+	input  string
+	tokens []token
+	i      int
+	calls  []*call
+}
+
+// parse parses a single SELECT statement
+func parse(input string) (*statement, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{input: input, tokens: tokens}
+	stmt, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
+	p.accept(tokSymbol, ";")
+	if p.peek().kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.peek().text)
+	}
+	stmt.calls = p.calls
+	return stmt, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// accept consumes the next token if it matches
+func (p *parser) accept(kind tokenKind, text string) bool {
+	t := p.peek()
+	if t.kind == kind && t.text == text {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kind tokenKind, text string) error {
+	if !p.accept(kind, text) {
+		return p.errorf("expected %s", text)
+	}
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("syntax error at %d: %s", p.peek().pos, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) ident() (string, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return "", fmt.Errorf("syntax error at %d: expected a name", t.pos)
+	}
+	return t.text, nil
+}
+
+func (p *parser) statement() (*statement, error) {
+	stmt := &statement{limit: -1}
+	if err := p.expect(tokKeyword, "SELECT"); err != nil {
+		return nil, err
+	}
+
+	if p.accept(tokSymbol, "*") {
+		stmt.star = true
+	} else {
+		for {
+			start := p.peek().pos
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			item := selectItem{expr: e, text: strings.TrimSpace(p.input[start:p.tokens[p.i-1].end])}
+			if p.accept(tokKeyword, "AS") {
+				if item.alias, err = p.ident(); err != nil {
+					return nil, err
+				}
+			}
+			stmt.items = append(stmt.items, item)
+			if !p.accept(tokSymbol, ",") {
+				break
+			}
+		}
+	}
+
+	var err error
+	if err := p.expect(tokKeyword, "FROM"); err != nil {
+		return nil, err
+	}
+	if stmt.from, err = p.ident(); err != nil {
+		return nil, err
+	}
+	for p.accept(tokKeyword, "JOIN") {
+		j := join{}
+		if j.table, err = p.ident(); err != nil {
+			return nil, err
+		}
+		j.alias = j.table
+		if p.accept(tokKeyword, "AS") {
+			if j.alias, err = p.ident(); err != nil {
+				return nil, err
+			}
+		}
+		stmt.joins = append(stmt.joins, j)
+	}
+
+	if p.accept(tokKeyword, "WHERE") {
+		if stmt.where, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept(tokKeyword, "GROUP") {
+		if err := p.expect(tokKeyword, "BY"); err != nil {
+			return nil, err
+		}
+		for {
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			stmt.groupBy = append(stmt.groupBy, e)
+			if !p.accept(tokSymbol, ",") {
+				break
+			}
+		}
+	}
+	if p.accept(tokKeyword, "HAVING") {
+		if stmt.having, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept(tokKeyword, "ORDER") {
+		if err := p.expect(tokKeyword, "BY"); err != nil {
+			return nil, err
+		}
+		for {
+			item := orderItem{}
+			if t := p.peek(); t.kind == tokNumber {
+				p.next()
+				item.position, _ = strconv.Atoi(t.text)
+			} else if item.expr, err = p.expr(); err != nil {
+				return nil, err
+			}
+			if p.accept(tokKeyword, "DESC") {
+				item.desc = true
+			} else {
+				p.accept(tokKeyword, "ASC")
+			}
+			stmt.orderBy = append(stmt.orderBy, item)
+			if !p.accept(tokSymbol, ",") {
+				break
+			}
+		}
+	}
+	if p.accept(tokKeyword, "LIMIT") {
+		t := p.next()
+		if t.kind != tokNumber {
+			return nil, fmt.Errorf("syntax error at %d: LIMIT expects a number", t.pos)
+		}
+		stmt.limit, _ = strconv.Atoi(t.text)
+	}
+	return stmt, nil
+}
+
+func (p *parser) expr() (expr, error) {
+	return p.or()
+}
+
+func (p *parser) or() (expr, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(tokKeyword, "OR") {
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{op: "OR", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) and() (expr, error) {
+	l, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(tokKeyword, "AND") {
+		r, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{op: "AND", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) not() (expr, error) {
+	if p.accept(tokKeyword, "NOT") {
+		x, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{op: "NOT", x: x}, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (expr, error) {
+	l, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	switch {
+	case t.kind == tokSymbol && comparisons[t.text]:
+		p.next()
+		op := t.text
+		if op == "<>" {
+			op = "!="
+		}
+		r, err := p.primary()
+		if err != nil {
+			return nil, err
+		}
+		return &binary{op: op, l: l, r: r}, nil
+	case t.kind == tokKeyword && t.text == "LIKE":
+		p.next()
+		r, err := p.primary()
+		if err != nil {
+			return nil, err
+		}
+		return &binary{op: "LIKE", l: l, r: r}, nil
+	case t.kind == tokKeyword && t.text == "NOT" && p.tokens[p.i+1].text == "LIKE":
+		p.i += 2
+		r, err := p.primary()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{op: "NOT", x: &binary{op: "LIKE", l: l, r: r}}, nil
+	case t.kind == tokKeyword && t.text == "IS":
+		p.next()
+		negate := p.accept(tokKeyword, "NOT")
+		if err := p.expect(tokKeyword, "NULL"); err != nil {
+			return nil, err
+		}
+		var e expr = &unary{op: "ISNULL", x: l}
+		if negate {
+			e = &unary{op: "NOT", x: e}
+		}
+		return e, nil
+	}
+	return l, nil
+}
+
+func (p *parser) primary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("syntax error at %d: %v", t.pos, err)
+		}
+		return &literal{value: n}, nil
+	case tokString:
+		return &literal{value: t.text}, nil
+	case tokKeyword:
+		if t.text == "NULL" {
+			return &literal{value: nil}, nil
+		}
+	case tokSymbol:
+		if t.text == "(" {
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expect(tokSymbol, ")")
+		}
+	case tokIdent:
+		if p.accept(tokSymbol, "(") {
+			return p.call(strings.ToLower(t.text))
+		}
+		parts := []string{t.text}
+		for p.accept(tokSymbol, ".") {
+			part, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+		}
+		return &path{parts: parts}, nil
+	}
+	return nil, fmt.Errorf("syntax error at %d: unexpected %q", t.pos, t.text)
+}
+
+// call parses the arguments of an aggregate function
+func (p *parser) call(name string) (expr, error) {
+	switch name {
+	case "count", "sum", "min", "max":
+	default:
+		return nil, p.errorf("unknown function %s", name)
+	}
+	c := &call{name: name, slot: len(p.calls)}
+	if name == "count" && p.accept(tokSymbol, "*") {
+		p.calls = append(p.calls, c)
+		return c, p.expect(tokSymbol, ")")
+	}
+	c.distinct = p.accept(tokKeyword, "DISTINCT")
+	arg, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	c.arg = arg
+	p.calls = append(p.calls, c)
+	return c, p.expect(tokSymbol, ")")
+}
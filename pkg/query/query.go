@@ -0,0 +1,178 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package query
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+	"gitlab.com/ansrivas/go-analyze-git/internal/flags"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/dataset"
+)
+
+const replHelp = `Tables and their known foreign keys:
+  events(id, type, actor_id, repo_id)   repo -> repos, actor -> actors
+  commits(sha, message, event_id)       event -> events
+  repos(id, name)
+  actors(id, login)
+Foreign keys can be followed with dotted paths, e.g. repo.name or event.actor.login,
+or by joining the table, e.g. SELECT repos.name FROM events JOIN repos.
+Aggregates: count(*), count([DISTINCT] x), sum(x), min(x), max(x).
+Type \q to quit.`
+
+// Query runs SQL like queries over an in-memory dataset
+type Query struct {
+	schema *schema
+}
+
+// New returns a Query over the given dataset
+func New(data *dataset.Dataset) *Query {
+	return &Query{schema: newSchema(data)}
+}
+
+// Run parses and evaluates a single query
+func (q *Query) Run(input string) (*Result, error) {
+	stmt, err := parse(input)
+	if err != nil {
+		return nil, err
+	}
+	return q.schema.execute(stmt)
+}
+
+// REPL reads one query per line from in and writes the results to out
+// until the input is exhausted or the user quits
+func (q *Query) REPL(in io.Reader, out io.Writer, asJSON bool) error {
+	scanner := bufio.NewScanner(in)
+	fmt.Fprintf(out, "Type \\h for help.\n> ")
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch line {
+		case "":
+		case `\q`, "quit", "exit":
+			return nil
+		case `\h`, "help":
+			fmt.Fprintln(out, replHelp)
+		default:
+			start := time.Now()
+			result, err := q.Run(line)
+			if err != nil {
+				fmt.Fprintf(out, "Error: %v\n", err)
+				break
+			}
+			if err := result.Render(out, asJSON); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "%d rows in %v\n", len(result.Rows), time.Since(start))
+		}
+		fmt.Fprint(out, "> ")
+	}
+	fmt.Fprintln(out)
+	return scanner.Err()
+}
+
+// Render writes the result as a table, or as a json list of objects
+func (r *Result) Render(out io.Writer, asJSON bool) error {
+	if asJSON {
+		objects := make([]map[string]string, len(r.Rows))
+		for i, row := range r.Rows {
+			objects[i] = make(map[string]string, len(row))
+			for j, v := range row {
+				objects[i][r.Columns[j]] = v
+			}
+		}
+		payload, err := json.MarshalIndent(objects, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "%s\n", payload)
+		return err
+	}
+
+	table := tablewriter.NewWriter(out)
+	table.SetBorder(true)
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeader(r.Columns)
+	table.AppendBulk(r.Rows)
+	table.Render()
+	return nil
+}
+
+// CmdQuery runs a single query given as argument, or opens a REPL
+func CmdQuery() *cli.Command {
+	cmdName := "query"
+	return &cli.Command{
+		Name:      cmdName,
+		Aliases:   []string{"q"},
+		Usage:     "Run SQL like queries over the dataset, interactively if no query is given",
+		ArgsUsage: "[query]",
+		Flags: []cli.Flag{
+			flags.DatasetFlag,
+			flags.ReposFileFlag,
+			flags.EventsFileFlag,
+			flags.CommitsFileFlag,
+			flags.ActorsFileFlag,
+			flags.CacheDirFlag,
+			flags.JsonFlag,
+		},
+		Action: func(c *cli.Context) error {
+			if err := flags.Required(c, "repos-file", "events-file", "commits-file", "actors-file"); err != nil {
+				return err
+			}
+			start := time.Now()
+			data, err := dataset.Load(dataset.Files{
+				Events:   c.String("events-file"),
+				Commits:  c.String("commits-file"),
+				Repos:    c.String("repos-file"),
+				Actors:   c.String("actors-file"),
+				CacheDir: c.String("cache-dir"),
+			})
+			if err != nil {
+				return err
+			}
+			q := New(data)
+			json := c.Bool("json")
+
+			if c.NArg() == 0 {
+				return q.REPL(os.Stdin, c.App.Writer, json)
+			}
+			result, err := q.Run(strings.Join(c.Args().Slice(), " "))
+			if err != nil {
+				return err
+			}
+			if err := result.Render(c.App.Writer, json); err != nil {
+				return err
+			}
+			log.Debug().Msgf("[%s] took %v", cmdName, time.Since(start))
+			return nil
+		},
+	}
+}
@@ -0,0 +1,142 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package query
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/dataset"
+)
+
+func newTestQuery(t *testing.T) *Query {
+	data, err := dataset.Load(dataset.Files{
+		Events:  "../dataset/testdata/events.csv",
+		Commits: "../dataset/testdata/commits.csv",
+		Repos:   "../dataset/testdata/repos.csv",
+		Actors:  "../dataset/testdata/actors.csv",
+	})
+	assert.Nil(t, err)
+	return New(data)
+}
+
+func TestQueryGroupByForeignKey(t *testing.T) {
+	assert := assert.New(t)
+	q := newTestQuery(t)
+
+	result, err := q.Run("SELECT repo.name, count(*) FROM events WHERE type='WatchEvent' GROUP BY repo ORDER BY 2 DESC LIMIT 2")
+	assert.Nil(err)
+	assert.Equal([]string{"repo.name", "count(*)"}, result.Columns)
+	assert.Equal([][]string{
+		{"testrepo2", "3"},
+		{"testrepo1", "2"},
+	}, result.Rows)
+}
+
+func TestQueryNestedPathsAndAliases(t *testing.T) {
+	assert := assert.New(t)
+	q := newTestQuery(t)
+
+	result, err := q.Run(`SELECT event.actor.login AS user, count(*) AS commits, count(DISTINCT event.repo)
+		FROM commits GROUP BY user HAVING commits > 2 ORDER BY commits DESC`)
+	assert.Nil(err)
+	assert.Equal([]string{"user", "commits", "count(DISTINCT event.repo)"}, result.Columns)
+	assert.Equal([][]string{
+		{"Apexal", "4", "2"},
+		{"anggi1234", "3", "1"},
+	}, result.Rows)
+}
+
+func TestQueryJoin(t *testing.T) {
+	assert := assert.New(t)
+	q := newTestQuery(t)
+
+	// The push to repo 129750935 is dropped, it is missing from repos.csv
+	result, err := q.Run("SELECT r.name, count(*) FROM events JOIN repos AS r WHERE type = 'PushEvent' GROUP BY r.name ORDER BY r.name")
+	assert.Nil(err)
+	assert.Equal([][]string{
+		{"repowithpushevent1", "2"},
+		{"testrepo2", "4"},
+	}, result.Rows)
+
+	// Joins follow foreign keys through intermediate tables
+	result, err = q.Run("SELECT sha FROM commits JOIN actors WHERE actors.login LIKE 'anggi%' AND NOT message = 'Member list'")
+	assert.Nil(err)
+	assert.Len(result.Rows, 2)
+
+	_, err = q.Run("SELECT * FROM repos JOIN actors")
+	assert.EqualError(err, "no known foreign key from repos to actors")
+}
+
+func TestQueryProjection(t *testing.T) {
+	assert := assert.New(t)
+	q := newTestQuery(t)
+
+	result, err := q.Run("select * from repos where id >= 212382045 order by id desc;")
+	assert.Nil(err)
+	assert.Equal([]string{"id", "name"}, result.Columns)
+	assert.Equal([][]string{
+		{"231065965", "testrepo2"},
+		{"225972000", "testrepo3"},
+		{"212382045", "testrepo1"},
+	}, result.Rows)
+
+	result, err = q.Run("SELECT count(*), max(id), min(repo.name) FROM events WHERE repo.name IS NULL")
+	assert.Nil(err)
+	assert.Equal([][]string{{"1", "11185452669", ""}}, result.Rows)
+}
+
+func TestQueryErrors(t *testing.T) {
+	assert := assert.New(t)
+	q := newTestQuery(t)
+
+	for query, expected := range map[string]string{
+		"SELECT name FROM pulls":                     "unknown table pulls",
+		"SELECT name FROM events":                    "unknown column name in events",
+		"SELECT type FROM events WHERE":              "syntax error at 29",
+		"SELECT type FROM events WHERE count(*) > 1": "aggregates are not allowed in WHERE",
+		"SELECT avg(id) FROM events":                 "unknown function avg",
+		"SELECT type FROM events ORDER BY 3":         "ORDER BY position 3 is out of range",
+		"SELECT 'open FROM events":                   "unterminated string",
+	} {
+		_, err := q.Run(query)
+		if assert.NotNil(err, query) {
+			assert.Contains(err.Error(), expected, query)
+		}
+	}
+}
+
+func TestREPL(t *testing.T) {
+	assert := assert.New(t)
+	q := newTestQuery(t)
+
+	var out bytes.Buffer
+	in := strings.NewReader("SELECT count(*) FROM actors\nSELECT\n\\q\nSELECT 1 FROM repos\n")
+	assert.Nil(q.REPL(in, &out, true))
+	assert.Contains(out.String(), `"count(*)": "6"`)
+	assert.Contains(out.String(), "Error: syntax error")
+	// Nothing after \q is run
+	assert.Equal(3, strings.Count(out.String(), "> "))
+}
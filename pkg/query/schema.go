@@ -0,0 +1,227 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package query
+
+import (
+	"fmt"
+	"sort"
+
+	"gitlab.com/ansrivas/go-analyze-git/pkg/dataset"
+)
+
+// relation is a foreign key from a column of one table
+// to the id column of another table
+type relation struct {
+	column string
+	target string
+}
+
+type table struct {
+	name    string
+	size    int
+	columns []string
+	get     func(row int, column string) value
+	// relations by name, e.g. events.repo points to repos
+	relations map[string]relation
+	// index maps ids to rows, built on first use
+	index map[string]int
+}
+
+// schema describes the tables of a dataset and
+// their known foreign keys
+type schema struct {
+	tables map[string]*table
+}
+
+func newSchema(d *dataset.Dataset) *schema {
+	s := &schema{tables: map[string]*table{}}
+	s.tables["events"] = &table{
+		name:    "events",
+		size:    len(d.Events),
+		columns: []string{"id", "type", "actor_id", "repo_id"},
+		get: func(row int, column string) value {
+			e := d.Events[row]
+			switch column {
+			case "id":
+				return e.ID
+			case "type":
+				return e.Type
+			case "actor_id":
+				return e.ActorID
+			default:
+				return e.RepoID
+			}
+		},
+		relations: map[string]relation{
+			"repo":  {column: "repo_id", target: "repos"},
+			"actor": {column: "actor_id", target: "actors"},
+		},
+	}
+	s.tables["commits"] = &table{
+		name:    "commits",
+		size:    len(d.Commits),
+		columns: []string{"sha", "message", "event_id"},
+		get: func(row int, column string) value {
+			c := d.Commits[row]
+			switch column {
+			case "sha":
+				return c.SHA
+			case "message":
+				return c.Message
+			default:
+				return c.EventID
+			}
+		},
+		relations: map[string]relation{
+			"event": {column: "event_id", target: "events"},
+		},
+	}
+	s.tables["repos"] = &table{
+		name:    "repos",
+		size:    len(d.Repos),
+		columns: []string{"id", "name"},
+		get: func(row int, column string) value {
+			if column == "id" {
+				return d.Repos[row].ID
+			}
+			return d.Repos[row].Name
+		},
+	}
+	s.tables["actors"] = &table{
+		name:    "actors",
+		size:    len(d.Actors),
+		columns: []string{"id", "login"},
+		get: func(row int, column string) value {
+			if column == "id" {
+				return d.Actors[row].ID
+			}
+			return d.Actors[row].Login
+		},
+	}
+	return s
+}
+
+func (s *schema) table(name string) (*table, error) {
+	t, ok := s.tables[name]
+	if !ok {
+		names := make([]string, 0, len(s.tables))
+		for name := range s.tables {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown table %s, expected one of %v", name, names)
+	}
+	return t, nil
+}
+
+func (t *table) hasColumn(column string) bool {
+	for _, c := range t.columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the row with the given id. The first row wins
+// for duplicated ids.
+func (t *table) lookup(id value) (int, bool) {
+	if t.index == nil {
+		t.index = make(map[string]int, t.size)
+		for row := t.size - 1; row >= 0; row-- {
+			t.index[toString(t.get(row, "id"))] = row
+		}
+	}
+	row, ok := t.index[toString(id)]
+	return row, ok
+}
+
+// resolve returns an accessor for the dotted path, starting at table t.
+// A path may end in a relation name, which yields the foreign key.
+func (s *schema) resolve(t *table, parts []string) (func(row int) value, error) {
+	name := parts[0]
+	if len(parts) == 1 {
+		if name == "username" && t.name == "actors" {
+			name = "login"
+		}
+		if t.hasColumn(name) {
+			return func(row int) value { return t.get(row, name) }, nil
+		}
+		if rel, ok := t.relations[name]; ok {
+			return func(row int) value { return t.get(row, rel.column) }, nil
+		}
+		return nil, fmt.Errorf("unknown column %s in %s, expected one of %v", name, t.name, t.columns)
+	}
+
+	rel, ok := t.relations[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown relation %s in %s", name, t.name)
+	}
+	target, err := s.table(rel.target)
+	if err != nil {
+		return nil, err
+	}
+	get, err := s.resolve(target, parts[1:])
+	if err != nil {
+		return nil, err
+	}
+	return func(row int) value {
+		targetRow, ok := target.lookup(t.get(row, rel.column))
+		if !ok {
+			return nil
+		}
+		return get(targetRow)
+	}, nil
+}
+
+// relationPath finds the chain of relations leading from table t to the
+// target table, searching breadth first so that the shortest path wins
+func (s *schema) relationPath(t *table, target string) ([]string, bool) {
+	type step struct {
+		table *table
+		path  []string
+	}
+	queue := []step{{table: t}}
+	seen := map[string]bool{t.name: true}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		names := make([]string, 0, len(current.table.relations))
+		for name := range current.table.relations {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			rel := current.table.relations[name]
+			path := append(append([]string{}, current.path...), name)
+			if rel.target == target {
+				return path, true
+			}
+			if !seen[rel.target] {
+				seen[rel.target] = true
+				queue = append(queue, step{table: s.tables[rel.target], path: path})
+			}
+		}
+	}
+	return nil, false
+}
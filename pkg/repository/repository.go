@@ -22,13 +22,114 @@
 
 package repository
 
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/urfave/cli/v2"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dedup"
+	"gitlab.com/ansrivas/go-analyze-git/internal/flags"
+	"gitlab.com/ansrivas/go-analyze-git/internal/match"
+	"gitlab.com/ansrivas/go-analyze-git/internal/ranking"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/cache"
+)
+
 // Repository struct is responsible for all the operations on
 // repositories
-type Repository struct{}
+type Repository struct {
+	// Workers is the number of workers parsing an input file in parallel
+	Workers int
+	// MaxMemory is the budget in bytes of the join state, which is
+	// spilled to disk once exceeded. Zero keeps it all in memory.
+	MaxMemory int64
+	// Counters is the number of counters of the sketch counting
+	// approximately. Zero counts exactly.
+	Counters int
+	// Precision is the precision of the HyperLogLog sketches counting
+	// distinct values approximately. Zero counts exactly.
+	Precision int
+	// DistinctActors ranks the repositories by the number of their
+	// distinct actors instead of their events
+	DistinctActors bool
+	// Dedup is the dedup.Filter mode dropping duplicated rows,
+	// DedupMemory the size of its Bloom filter
+	Dedup       string
+	DedupMemory int64
+	// Selection tells which rows of the ranking are returned
+	Selection utils.Selection
+	// Filter drops the repositories by their name or ID before
+	// they are ranked, nil keeps every repository
+	Filter *match.Filter
+	// GroupBy is ranking.GroupByRepo to rank the repositories, or
+	// ranking.GroupByOwner to rank their owners by the sum of their
+	// repositories
+	GroupBy string
+	// CacheDir is the directory of an ingested cache, which is
+	// read instead of the csv files while it is fresh
+	CacheDir string
+	// Stats of the most recent analysis
+	Stats utils.RunStats
+}
+
+// groupBy returns the grouping of --group-by
+func groupBy(c *cli.Context) (string, error) {
+	switch group := c.String(flags.GroupByFlag.Name); group {
+	case ranking.GroupByRepo, "":
+		return ranking.GroupByRepo, nil
+	case ranking.GroupByOwner:
+		if c.Bool(flags.DistinctActorsFlag.Name) {
+			return "", fmt.Errorf("--distinct-actors can't be summed per owner")
+		}
+		return ranking.GroupByOwner, nil
+	default:
+		return "", fmt.Errorf("invalid group-by %q, expected %q or %q", group, ranking.GroupByRepo, ranking.GroupByOwner)
+	}
+}
+
+// ranking returns the ranking of the repositories by the analysis
+func (r *Repository) ranking(analysis string, count int, eventType string) ranking.Ranking {
+	return ranking.Ranking{
+		Analysis:       analysis,
+		EventType:      eventType,
+		DistinctActors: r.DistinctActors,
+		GroupBy:        r.GroupBy,
+		Count:          count,
+		Selection:      r.Selection,
+		Repos:          r.Filter,
+	}
+}
+
+// engine returns the engine computing the rankings
+func (r *Repository) engine() *ranking.Engine {
+	return &ranking.Engine{
+		Workers:     r.Workers,
+		MaxMemory:   r.MaxMemory,
+		Counters:    r.Counters,
+		Precision:   r.Precision,
+		Dedup:       r.Dedup,
+		DedupMemory: r.DedupMemory,
+	}
+}
+
+// rank computes the ranking over the given files, or over their
+// cached tables while they are fresh
+func (r *Repository) rank(rk ranking.Ranking, files cache.Files) (utils.GenericDictHeap, error) {
+	results, err := r.engine().Run(cache.Inputs(r.CacheDir, files, r.Dedup), rk)
+	if err != nil {
+		return nil, err
+	}
+	r.Stats = results[0].Stats
+	return results[0].Rows, nil
+}
 
 // New returns a new instance of repository
 func New() *Repository {
-	return &Repository{}
+	return &Repository{
+		Workers:     runtime.NumCPU(),
+		Dedup:       dedup.Exact,
+		DedupMemory: 64 << 20,
+	}
 }
 
 // func executeFunc(fname string, line chan string) func() error {
@@ -23,134 +23,21 @@
 package repository
 
 import (
-	"container/heap"
-	"os"
-	"strings"
 	"time"
 
-	"github.com/oklog/run"
 	"github.com/rs/zerolog/log"
 	"github.com/urfave/cli/v2"
 	"gitlab.com/ansrivas/go-analyze-git/internal/flags"
+	"gitlab.com/ansrivas/go-analyze-git/internal/ranking"
 	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
-	"gitlab.com/ansrivas/go-analyze-git/pkg/events"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/cache"
 )
 
 // topKReposByCommits returns Top K repositories by
 // the amount of commits pushed
 func (r *Repository) topKReposByCommits(count int, reposFile, eventsFile, commitsFile string) (utils.GenericDictHeap, error) {
-
-	reposChan := make(chan string, 10)
-	eventsChan := make(chan string, 10)
-	commitsChan := make(chan string, 10)
-	outputChan := make(chan utils.GenericDictHeap, 1)
-	var g run.Group
-
-	{
-		g.Add(
-			utils.ExecuteFunc(reposFile, reposChan),
-			utils.InterruptFunc("The reposFile actor was interrupted with: %v\n"))
-	}
-
-	{
-		g.Add(
-			utils.ExecuteFunc(eventsFile, eventsChan),
-			utils.InterruptFunc("The eventsFile actor was interrupted with: %v\n"))
-	}
-
-	{
-		g.Add(
-			utils.ExecuteFunc(commitsFile, commitsChan),
-			utils.InterruptFunc("The commitsFile actor was interrupted with: %v\n"))
-	}
-
-	{
-		g.Add(func() error {
-			eventsToRepoCache := make(map[string]string)
-
-			// Valid repos
-			repoToCommitsCountCache := make(map[string]int)
-
-			for line := range eventsChan {
-				columns := strings.Split(line, ",")
-				if len(columns) != 4 {
-					log.Debug().Msgf("Missing 4 columns in [%s]", line)
-					continue
-				}
-
-				eventType := columns[1]
-				// Filter out all the PushEvents
-				if eventType != events.Push {
-					continue
-				}
-				repoID := columns[3]
-				eventID := columns[0]
-				eventsToRepoCache[eventID] = repoID
-				repoToCommitsCountCache[repoID] = 0
-			}
-
-			for commitLine := range commitsChan {
-				// Although commits are supposed to be 3 entries, but
-				// there is a chance they might not be.
-				// We will make an assumption that in a line, we test
-				// if the entry is an integer - we use it.
-				// TODO (ansrivas): Benchmark with csv-scan split function as well
-				columns := strings.Split(commitLine, ",")
-				if len(columns) == 0 {
-					continue
-				}
-
-				commitID := columns[len(columns)-1]
-				// Check if this event_id is present in eventsToRepoCache and is a valid PushEvent
-				repoID, ok := eventsToRepoCache[commitID]
-				if !ok {
-					continue
-				}
-				repoToCommitsCountCache[repoID] += 1
-			}
-
-			gdHeap := &utils.GenericDictHeap{}
-			heap.Init(gdHeap)
-			// For each
-			for repoID, commitCount := range repoToCommitsCountCache {
-				heap.Push(gdHeap, utils.GenericDict{
-					Key:   repoID,
-					Value: commitCount,
-				})
-				// Maintaining only top-k elements
-				if gdHeap.Len() > count {
-					heap.Pop(gdHeap)
-				}
-			}
-
-			repoIDToNameCache := make(map[string]string)
-			for line := range reposChan {
-				columns := strings.Split(line, ",")
-				repoIDToNameCache[columns[0]] = columns[1]
-			}
-
-			initialHeapLen := gdHeap.Len()
-			result := make(utils.GenericDictHeap, initialHeapLen)
-			for i := initialHeapLen; i > 0; i-- {
-				gd := heap.Pop(gdHeap).(utils.GenericDict)
-				repoName, ok := repoIDToNameCache[gd.Key]
-				if !ok {
-					log.Error().Msgf("Couldn't find the reponame in cache. Keeping ID")
-				} else {
-					// mutate gd.Key to store the name, rather than id now
-					// Hacky but meh
-					gd.Key = repoName
-				}
-				result[i-1] = gd
-			}
-
-			outputChan <- result
-			return nil
-		}, utils.InterruptFunc("The worker actor was interrupted with: %v\n"))
-	}
-
-	err := g.Run()
-	return <-outputChan, err
+	files := cache.Files{Events: eventsFile, Commits: commitsFile, Repos: reposFile}
+	return r.rank(r.ranking(ranking.ReposByCommits, count, ""), files)
 }
 
 func (r *Repository) CmdTopKReposByCommits() *cli.Command {
@@ -161,29 +48,83 @@ func (r *Repository) CmdTopKReposByCommits() *cli.Command {
 		Aliases: []string{"tc"},
 		Usage:   "Top K repositories by the amount of commits pushed",
 		Flags: []cli.Flag{
+			flags.DatasetFlag,
 			flags.ReposFileFlag,
 			flags.EventsFileFlag,
 			flags.CommitsFileFlag,
 			flags.CountFlag,
+			flags.OffsetFlag,
+			flags.OrderFlag,
+			flags.MinFlag,
+			flags.MaxFlag,
+			flags.TiesFlag,
+			flags.RepoIncludeFlag,
+			flags.RepoExcludeFlag,
+			flags.GroupByFlag,
+			flags.WorkersFlag,
+			flags.MaxMemoryFlag,
+			flags.ApproxFlag,
+			flags.ApproxMemoryFlag,
+			flags.HLLPrecisionFlag,
+			flags.DedupFlag,
+			flags.DedupMemoryFlag,
+			flags.CacheDirFlag,
 			flags.JsonFlag,
+			flags.OutputFlag,
+			flags.ChartFlag,
 		},
 		Action: func(c *cli.Context) error {
+			if err := flags.Required(c, "repos-file", "events-file", "commits-file"); err != nil {
+				return err
+			}
 			reposFile := c.String("repos-file")
 			eventsFile := c.String("events-file")
 			commitsFile := c.String("commits-file")
 			count := c.Int("count")
-			json := c.Bool("json")
+			chart := c.Bool("chart")
+			r.Workers = c.Int("workers")
+			r.CacheDir = c.String("cache-dir")
+			maxMemory, err := flags.MaxMemory(c)
+			if err != nil {
+				return err
+			}
+			r.MaxMemory = maxMemory
+			counters, err := flags.Counters(c)
+			if err != nil {
+				return err
+			}
+			r.Counters = counters
+			precision, err := flags.Precision(c)
+			if err != nil {
+				return err
+			}
+			r.Precision = precision
+			r.Dedup, r.DedupMemory, err = flags.Dedup(c)
+			if err != nil {
+				return err
+			}
+			r.Selection, err = flags.Selection(c)
+			if err != nil {
+				return err
+			}
+			r.Filter, err = flags.RepoFilter(c)
+			if err != nil {
+				return err
+			}
+			r.GroupBy, err = groupBy(c)
+			if err != nil {
+				return err
+			}
 			start := time.Now()
 			output, err := r.topKReposByCommits(count, reposFile, eventsFile, commitsFile)
 			if err != nil {
 				return err
 			}
 
-			// If json, print and return
-			if json {
-				output.ToJson(os.Stdout) //nolint
-			} else {
-				utils.RenderTable(output, []string{"RepoID", "Count"})
+			rk := r.ranking(ranking.ReposByCommits, count, "")
+			report := r.engine().Report(rk, ranking.Result{Rows: output, Stats: r.Stats})
+			if err := report.Render(flags.OutputFormat(c), chart); err != nil {
+				return err
 			}
 
 			log.Debug().Msgf("[%s] took %v", cmdName, time.Since(start))
@@ -23,103 +23,27 @@
 package repository
 
 import (
-	"container/heap"
-	"os"
-	"strings"
 	"time"
 
-	"github.com/oklog/run"
 	"github.com/rs/zerolog/log"
 	"github.com/urfave/cli/v2"
 	"gitlab.com/ansrivas/go-analyze-git/internal/flags"
+	"gitlab.com/ansrivas/go-analyze-git/internal/ranking"
 	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
-	"gitlab.com/ansrivas/go-analyze-git/pkg/events"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/cache"
 )
 
 // topKReposByEvents returns Top K repositories
-// sorted by watch events
+// sorted by the events of the given type
 func (r *Repository) topKReposByEvents(count int, event, eventsFile, reposFile string) (utils.GenericDictHeap, error) {
+	return r.rank(r.ranking(ranking.ReposByEvents, count, event), cache.Files{Events: eventsFile, Repos: reposFile})
+}
 
-	reposChan := make(chan string, 10)
-	eventsChan := make(chan string, 10)
-	outputChan := make(chan utils.GenericDictHeap, 1)
-
-	var g run.Group
-	{
-		g.Add(func() error {
-			watchEventsCache := make(map[string]int)
-			for line := range eventsChan {
-				columns := strings.Split(line, ",")
-				if len(columns) != 4 {
-					log.Debug().Msgf("Missing 4 columns in [%s]", line)
-					continue
-				}
-
-				eventType := columns[1]
-				eventID := columns[3]
-				// Filter out all the WatchEvents
-				if eventType != events.Watch {
-					continue
-				}
-				watchEventsCache[eventID] += 1
-			}
-
-			// Now iterate over reposChan to filterout the names
-			// from watchEventsCache
-			gdHeap := &utils.GenericDictHeap{}
-			heap.Init(gdHeap)
-
-			for line := range reposChan {
-				columns := strings.Split(line, ",")
-				if len(columns) != 2 {
-					log.Debug().Msgf("ReposChan: Missing 2 columns in [%s]", line)
-					continue
-				}
-				repoName := columns[1]
-				repoID := columns[0]
-
-				watchEventCount, exists := watchEventsCache[repoID]
-				if !exists {
-					continue
-				}
-
-				heap.Push(gdHeap, utils.GenericDict{
-					Key:   repoName,
-					Value: watchEventCount,
-				})
-				// Maintaining only top-k elements
-				if gdHeap.Len() > count {
-					heap.Pop(gdHeap)
-				}
-				delete(watchEventsCache, repoID)
-
-			}
-
-			initialHeapLen := gdHeap.Len()
-			result := make(utils.GenericDictHeap, initialHeapLen)
-			for i := initialHeapLen; i > 0; i-- {
-				result[i-1] = heap.Pop(gdHeap).(utils.GenericDict)
-			}
-			outputChan <- result
-			return nil
-
-		}, utils.InterruptFunc("The final goroutine actor was interrupted with: %v\n"))
-	}
-
-	{
-		g.Add(
-			utils.ExecuteFunc(reposFile, reposChan),
-			utils.InterruptFunc("The reposFile actor was interrupted with: %v\n"))
-	}
-
-	{
-		g.Add(
-			utils.ExecuteFunc(eventsFile, eventsChan),
-			utils.InterruptFunc("The eventsFile actor was interrupted with: %v\n"))
-	}
-
-	err := g.Run()
-	return <-outputChan, err
+// eventsReport returns the report of the repositories ranked by
+// topKReposByEvents, labeled with the type of their events
+func (r *Repository) eventsReport(eventType string, output utils.GenericDictHeap) utils.Report {
+	rk := r.ranking(ranking.ReposByEvents, len(output), eventType)
+	return r.engine().Report(rk, ranking.Result{Rows: output, Stats: r.Stats})
 }
 
 func (r *Repository) CmdTopKReposByWatchEvents() *cli.Command {
@@ -129,29 +53,78 @@ func (r *Repository) CmdTopKReposByWatchEvents() *cli.Command {
 		Aliases: []string{"tw"},
 		Usage:   "Top K repositories sorted by events",
 		Flags: []cli.Flag{
+			flags.DatasetFlag,
 			flags.ReposFileFlag,
 			flags.EventsFileFlag,
 			flags.CountFlag,
+			flags.OffsetFlag,
+			flags.OrderFlag,
+			flags.MinFlag,
+			flags.MaxFlag,
+			flags.TiesFlag,
+			flags.RepoIncludeFlag,
+			flags.RepoExcludeFlag,
+			flags.GroupByFlag,
 			flags.EventTypeFlag,
+			flags.WorkersFlag,
+			flags.ApproxFlag,
+			flags.ApproxMemoryFlag,
+			flags.HLLPrecisionFlag,
+			flags.DistinctActorsFlag,
+			flags.DedupFlag,
+			flags.DedupMemoryFlag,
+			flags.CacheDirFlag,
 			flags.JsonFlag,
+			flags.OutputFlag,
+			flags.ChartFlag,
 		},
 		Action: func(c *cli.Context) error {
+			if err := flags.Required(c, "repos-file", "events-file"); err != nil {
+				return err
+			}
 			reposFile := c.String("repos-file")
 			eventsFile := c.String("events-file")
 			eventType := c.String("event-type")
 			count := c.Int("count")
-			json := c.Bool("json")
+			chart := c.Bool("chart")
+			r.Workers = c.Int("workers")
+			r.CacheDir = c.String("cache-dir")
+			counters, err := flags.Counters(c)
+			if err != nil {
+				return err
+			}
+			r.Counters = counters
+			precision, err := flags.Precision(c)
+			if err != nil {
+				return err
+			}
+			r.Precision = precision
+			r.DistinctActors = c.Bool(flags.DistinctActorsFlag.Name)
+			r.Dedup, r.DedupMemory, err = flags.Dedup(c)
+			if err != nil {
+				return err
+			}
+			r.Selection, err = flags.Selection(c)
+			if err != nil {
+				return err
+			}
+			r.Filter, err = flags.RepoFilter(c)
+			if err != nil {
+				return err
+			}
+			r.GroupBy, err = groupBy(c)
+			if err != nil {
+				return err
+			}
 			start := time.Now()
 			output, err := r.topKReposByEvents(count, eventType, eventsFile, reposFile)
 			if err != nil {
 				return err
 			}
 
-			// If json, print and return
-			if json {
-				output.ToJson(os.Stdout) //nolint
-			} else {
-				utils.RenderTable(output, []string{"RepoID", "Count"})
+			report := r.eventsReport(eventType, output)
+			if err := report.Render(flags.OutputFormat(c), chart); err != nil {
+				return err
 			}
 
 			log.Debug().Msgf("[%s] took %v", cmdName, time.Since(start))
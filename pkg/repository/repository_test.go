@@ -23,10 +23,19 @@
 package repository
 
 import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dedup"
+	"gitlab.com/ansrivas/go-analyze-git/internal/match"
+	"gitlab.com/ansrivas/go-analyze-git/internal/ranking"
 	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/cache"
 	"gitlab.com/ansrivas/go-analyze-git/pkg/events"
 )
 
@@ -34,6 +43,8 @@ func TestTopKReposByEvents(t *testing.T) {
 	assert := assert.New(t)
 
 	repos := New()
+	// Count every copy of the duplicated events
+	repos.Dedup = dedup.None
 	count := 3
 	event := events.Watch
 	eventsFile := "testdata/events.csv"
@@ -47,19 +58,182 @@ func TestTopKReposByEvents(t *testing.T) {
 	}
 	assert.Equal(cache, expected)
 	assert.Nil(err)
+
+	// 15 event rows of which 3 are blank, plus 6 repo rows
+	assert.Equal(int64(21), repos.Stats.RowsRead)
+	assert.Equal(int64(3), repos.Stats.RowsSkipped)
+
+	// Splitting the files into chunks must not change the result
+	for workers := 1; workers <= 8; workers++ {
+		repos.Workers = workers
+		cache, err := repos.topKReposByEvents(count, event, eventsFile, reposFile)
+		assert.Nil(err)
+		assert.Equal(expected, cache, "workers: %d", workers)
+		assert.Equal(int64(21), repos.Stats.RowsRead, "workers: %d", workers)
+	}
+}
+
+func TestTopKReposByEventType(t *testing.T) {
+	assert := assert.New(t)
+
+	eventsFile := "testdata/events.csv"
+	reposFile := "testdata/repos.csv"
+	dir := t.TempDir()
+	_, err := cache.Ingest(cache.Files{Events: eventsFile, Repos: reposFile}, dir, 1)
+	assert.Nil(err)
+
+	// The fork shares its id with a watch event, which isn't counted
+	repos := New()
+	for _, cacheDir := range []string{"", dir} {
+		repos.CacheDir = cacheDir
+		output, err := repos.topKReposByEvents(3, "ForkEvent", eventsFile, reposFile)
+		assert.Nil(err)
+		assert.Equal(utils.GenericDictHeap{{Key: "testrepo3", Value: 1}}, output, "cache: %q", cacheDir)
+
+		var out bytes.Buffer
+		report := repos.eventsReport("ForkEvent", output)
+		assert.Nil(report.Write(&out, utils.OutputOpenMetrics, false))
+		assert.Contains(out.String(), `git_repo_events_total{repo="testrepo3",type="ForkEvent"} 1`, "cache: %q", cacheDir)
+	}
+}
+
+func TestTopKReposByEventsCached(t *testing.T) {
+	assert := assert.New(t)
+
+	repos := New()
+	repos.Dedup = dedup.None
+	eventsFile := "testdata/events.csv"
+	reposFile := "testdata/repos.csv"
+	expected, err := repos.topKReposByEvents(3, events.Watch, eventsFile, reposFile)
+	assert.Nil(err)
+
+	// An ingested cache must come to the same result
+	repos.CacheDir = t.TempDir()
+	_, err = cache.Ingest(cache.Files{Events: eventsFile, Repos: reposFile}, repos.CacheDir, 1)
+	assert.Nil(err)
+	cached, err := repos.topKReposByEvents(3, events.Watch, eventsFile, reposFile)
+	assert.Nil(err)
+	assert.Equal(expected, cached)
+	assert.Equal(int64(21), repos.Stats.RowsRead)
+	assert.Equal(int64(3), repos.Stats.RowsSkipped)
+}
+
+func TestTopKReposDedup(t *testing.T) {
+	assert := assert.New(t)
+
+	eventsFile := "testdata/events.csv"
+	reposFile := "testdata/repos.csv"
+	commitsFile := "testdata/commits.csv"
+	dir := t.TempDir()
+	_, err := cache.Ingest(cache.Files{Events: eventsFile, Commits: commitsFile, Repos: reposFile}, dir, 1)
+	assert.Nil(err)
+
+	// testrepo2 is starred by three copies of the same event
+	expected := map[string]int{"testrepo1": 2, "testrepo2": 1, "testrepo3": 1}
+	repos := New()
+	for _, mode := range []string{dedup.Exact, dedup.Bloom} {
+		for _, cacheDir := range []string{"", dir} {
+			for workers := 1; workers <= 3; workers++ {
+				repos.Dedup = mode
+				repos.CacheDir = cacheDir
+				repos.Workers = workers
+				output, err := repos.topKReposByEvents(3, events.Watch, eventsFile, reposFile)
+				assert.Nil(err)
+				assert.Len(output, 3)
+				assert.Equal("testrepo1", output[0].Key)
+				for _, row := range output {
+					assert.Equal(expected[row.Key], row.Value, "mode: %s, workers: %d", mode, workers)
+				}
+
+				output, err = repos.topKReposByCommits(1, reposFile, eventsFile, commitsFile)
+				assert.Nil(err)
+				assert.Equal(utils.GenericDictHeap{
+					utils.GenericDict{Key: "repowithpushevent1", Value: 3, Extra: map[string]int{ranking.ContributorsHeader: 1}},
+				}, output, "mode: %s, workers: %d", mode, workers)
+			}
+		}
+	}
+}
+
+func TestTopKReposByDistinctActors(t *testing.T) {
+	assert := assert.New(t)
+
+	eventsFile := "testdata/events.csv"
+	reposFile := "testdata/repos.csv"
+	dir := t.TempDir()
+	_, err := cache.Ingest(cache.Files{Events: eventsFile, Repos: reposFile}, dir, 1)
+	assert.Nil(err)
+
+	// testrepo2 is starred three times by the same actor
+	expected := map[string]int{"testrepo1": 2, "testrepo2": 1, "testrepo3": 1}
+	repos := New()
+	repos.DistinctActors = true
+	for _, precision := range []int{0, 12} {
+		for _, cacheDir := range []string{"", dir} {
+			for workers := 1; workers <= 3; workers++ {
+				repos.Precision = precision
+				repos.CacheDir = cacheDir
+				repos.Workers = workers
+				output, err := repos.topKReposByEvents(3, events.Watch, eventsFile, reposFile)
+				assert.Nil(err)
+				assert.Len(output, 3)
+				assert.Equal(utils.GenericDict{Key: "testrepo1", Value: 2}, output[0])
+				for _, row := range output {
+					assert.Equal(expected[row.Key], row.Value, "precision: %d, workers: %d", precision, workers)
+				}
+			}
+		}
+	}
+}
+
+func TestTopKReposByCommitsNonNumericIDs(t *testing.T) {
+	assert := assert.New(t)
+
+	// Numeric IDs with a second spelling must not collide with each other
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		fname := filepath.Join(dir, name)
+		assert.Nil(os.WriteFile(fname, []byte(content), 0o600))
+		return fname
+	}
+	eventsFile := write("events.csv", "e1,PushEvent,1,repo-a\n2,PushEvent,1,7\n3,PushEvent,1,007\n")
+	commitsFile := write("commits.csv", "sha1,one,e1\nsha2,two,e1\nsha3,three,2\nsha4,four,3\nsha5,five,3\nsha6,six,3\n")
+	reposFile := write("repos.csv", "repo-a,first\n7,second\n007,third\n")
+
+	repos := New()
+	cache, err := repos.topKReposByCommits(3, reposFile, eventsFile, commitsFile)
+	assert.Nil(err)
+	assert.Equal(utils.GenericDictHeap{
+		utils.GenericDict{Key: "third", Value: 3, Extra: map[string]int{ranking.ContributorsHeader: 1}},
+		utils.GenericDict{Key: "first", Value: 2, Extra: map[string]int{ranking.ContributorsHeader: 1}},
+		utils.GenericDict{Key: "second", Value: 1, Extra: map[string]int{ranking.ContributorsHeader: 1}},
+	}, cache)
+}
+
+// benchmarkWorkers are the worker counts every benchmark is run with,
+// comparing a single worker with all the available cores
+func benchmarkWorkers() []int {
+	if runtime.NumCPU() == 1 {
+		return []int{1}
+	}
+	return []int{1, runtime.NumCPU()}
 }
 
 func BenchmarkTopKReposByEvents(b *testing.B) {
 
-	b.ReportAllocs()
-	b.ResetTimer()
 	repos := New()
 	count := 10
 	event := events.Watch
 	eventsFile := "../../data/events.csv"
 	reposFile := "../../data/repos.csv"
-	for i := 0; i < b.N; i++ {
-		repos.topKReposByEvents(count, event, eventsFile, reposFile) //nolint
+	for _, workers := range benchmarkWorkers() {
+		b.Run(fmt.Sprintf("workers-%d", workers), func(b *testing.B) {
+			b.ReportAllocs()
+			repos.Workers = workers
+			for i := 0; i < b.N; i++ {
+				repos.topKReposByEvents(count, event, eventsFile, reposFile) //nolint
+			}
+		})
 	}
 }
 
@@ -67,6 +241,7 @@ func TestTopKReposByCommits(t *testing.T) {
 	assert := assert.New(t)
 
 	repos := New()
+	repos.Dedup = dedup.None
 	count := 3
 	eventsFile := "testdata/events.csv"
 	reposFile := "testdata/repos.csv"
@@ -74,23 +249,354 @@ func TestTopKReposByCommits(t *testing.T) {
 	cache, err := repos.topKReposByCommits(count, reposFile, eventsFile, commitsFile)
 
 	expected := utils.GenericDictHeap{
-		utils.GenericDict{Key: "repowithpushevent2", Value: 4},
-		utils.GenericDict{Key: "repowithpushevent1", Value: 3},
+		// Both repositories are pushed to by a single actor
+		utils.GenericDict{Key: "repowithpushevent2", Value: 4, Extra: map[string]int{ranking.ContributorsHeader: 1}},
+		utils.GenericDict{Key: "repowithpushevent1", Value: 3, Extra: map[string]int{ranking.ContributorsHeader: 1}},
 	}
 	assert.Equal(cache, expected)
 	assert.Nil(err)
+
+	// Splitting the files into chunks must not change the result
+	for workers := 1; workers <= 8; workers++ {
+		repos.Workers = workers
+		cache, err := repos.topKReposByCommits(count, reposFile, eventsFile, commitsFile)
+		assert.Nil(err)
+		assert.Equal(expected, cache, "workers: %d", workers)
+	}
+
+	// A tiny memory budget spills the join state to
+	// disk, the merge-join must come to the same result
+	for _, maxMemory := range []int64{1, 100, 1 << 20} {
+		for workers := 1; workers <= 3; workers++ {
+			repos.Workers = workers
+			repos.MaxMemory = maxMemory
+			cache, err := repos.topKReposByCommits(count, reposFile, eventsFile, commitsFile)
+			assert.Nil(err)
+			assert.Equal(expected, cache, "max memory: %d, workers: %d", maxMemory, workers)
+		}
+	}
+}
+
+func TestTopKReposByCommitsDedup(t *testing.T) {
+	assert := assert.New(t)
+
+	eventsFile := "testdata/events.csv"
+	reposFile := "testdata/repos.csv"
+	commitsFile := "testdata/commits.csv"
+	// Two of the commits of repowithpushevent2 are copies of a third one
+	expected := utils.GenericDictHeap{
+		utils.GenericDict{Key: "repowithpushevent1", Value: 3, Extra: map[string]int{ranking.ContributorsHeader: 1}},
+		utils.GenericDict{Key: "repowithpushevent2", Value: 2, Extra: map[string]int{ranking.ContributorsHeader: 1}},
+	}
+	for _, mode := range []string{dedup.Exact, dedup.Bloom} {
+		for workers := 1; workers <= 4; workers++ {
+			repos := New()
+			repos.Dedup = mode
+			repos.Workers = workers
+			cache, err := repos.topKReposByCommits(3, reposFile, eventsFile, commitsFile)
+			assert.Nil(err)
+			assert.Equal(expected, cache, "dedup: %s, workers: %d", mode, workers)
+		}
+	}
+}
+
+func TestTopKReposByCommitsCached(t *testing.T) {
+	assert := assert.New(t)
+
+	repos := New()
+	eventsFile := "testdata/events.csv"
+	reposFile := "testdata/repos.csv"
+	commitsFile := "testdata/commits.csv"
+	expected, err := repos.topKReposByCommits(3, reposFile, eventsFile, commitsFile)
+	assert.Nil(err)
+
+	// An ingested cache must come to the same result
+	repos.CacheDir = t.TempDir()
+	files := cache.Files{Events: eventsFile, Commits: commitsFile, Repos: reposFile}
+	_, err = cache.Ingest(files, repos.CacheDir, 1)
+	assert.Nil(err)
+	cached, err := repos.topKReposByCommits(3, reposFile, eventsFile, commitsFile)
+	assert.Nil(err)
+	assert.Equal(expected, cached)
+}
+
+func TestTopKReposTies(t *testing.T) {
+	assert := assert.New(t)
+
+	eventsFile := "testdata/ties/events.csv"
+	reposFile := "testdata/ties/repos.csv"
+	commitsFile := "testdata/ties/commits.csv"
+	dir := t.TempDir()
+	_, err := cache.Ingest(cache.Files{Events: eventsFile, Commits: commitsFile, Repos: reposFile}, dir, 1)
+	assert.Nil(err)
+
+	// delta, alpha and charlie are starred twice, ties rank by name
+	starred := map[string]utils.GenericDictHeap{
+		utils.TiesExclude: {{Key: "alpha", Value: 2}, {Key: "charlie", Value: 2}},
+		utils.TiesInclude: {{Key: "alpha", Value: 2}, {Key: "charlie", Value: 2}, {Key: "delta", Value: 2}},
+	}
+	// The IDs of the repositories order them differently than their names
+	contributor := map[string]int{ranking.ContributorsHeader: 1}
+	pushed := map[string]utils.GenericDictHeap{
+		utils.TiesExclude: {
+			{Key: "bravo", Value: 2, Extra: contributor},
+			{Key: "alpha", Value: 1, Extra: contributor},
+		},
+		utils.TiesInclude: {
+			{Key: "bravo", Value: 2, Extra: contributor},
+			{Key: "alpha", Value: 1, Extra: contributor},
+			{Key: "charlie", Value: 1, Extra: contributor},
+			{Key: "delta", Value: 1, Extra: contributor},
+		},
+	}
+
+	repos := New()
+	for _, ties := range utils.Ties {
+		for _, cacheDir := range []string{"", dir} {
+			for workers := 1; workers <= 3; workers++ {
+				repos.Selection.Ties = ties
+				repos.CacheDir = cacheDir
+				repos.Workers = workers
+				output, err := repos.topKReposByEvents(2, events.Watch, eventsFile, reposFile)
+				assert.Nil(err)
+				assert.Equal(starred[ties], output, "ties: %s, cache: %q, workers: %d", ties, cacheDir, workers)
+				output, err = repos.topKReposByCommits(2, reposFile, eventsFile, commitsFile)
+				assert.Nil(err)
+				assert.Equal(pushed[ties], output, "ties: %s, cache: %q, workers: %d", ties, cacheDir, workers)
+			}
+		}
+	}
+}
+
+func TestTopKReposSelection(t *testing.T) {
+	assert := assert.New(t)
+
+	eventsFile := "testdata/ties/events.csv"
+	reposFile := "testdata/ties/repos.csv"
+	commitsFile := "testdata/ties/commits.csv"
+	dir := t.TempDir()
+	_, err := cache.Ingest(cache.Files{Events: eventsFile, Commits: commitsFile, Repos: reposFile}, dir, 1)
+	assert.Nil(err)
+
+	two := 2
+	contributor := map[string]int{ranking.ContributorsHeader: 1}
+	tests := []struct {
+		sel     utils.Selection
+		starred utils.GenericDictHeap
+		pushed  utils.GenericDictHeap
+	}{
+		{
+			sel:     utils.Selection{Offset: 1},
+			starred: utils.GenericDictHeap{{Key: "charlie", Value: 2}, {Key: "delta", Value: 2}},
+			pushed:  utils.GenericDictHeap{{Key: "alpha", Value: 1, Extra: contributor}, {Key: "charlie", Value: 1, Extra: contributor}},
+		},
+		{
+			sel:     utils.Selection{Order: utils.OrderAsc},
+			starred: utils.GenericDictHeap{{Key: "bravo", Value: 1}, {Key: "alpha", Value: 2}},
+			pushed:  utils.GenericDictHeap{{Key: "alpha", Value: 1, Extra: contributor}, {Key: "charlie", Value: 1, Extra: contributor}},
+		},
+		{
+			sel:     utils.Selection{Order: utils.OrderAsc, Offset: 2},
+			starred: utils.GenericDictHeap{{Key: "charlie", Value: 2}, {Key: "delta", Value: 2}},
+			pushed:  utils.GenericDictHeap{{Key: "delta", Value: 1, Extra: contributor}, {Key: "bravo", Value: 2, Extra: contributor}},
+		},
+		{
+			sel:     utils.Selection{Min: &two},
+			starred: utils.GenericDictHeap{{Key: "alpha", Value: 2}, {Key: "charlie", Value: 2}},
+			pushed:  utils.GenericDictHeap{{Key: "bravo", Value: 2, Extra: contributor}},
+		},
+	}
+
+	repos := New()
+	for _, tt := range tests {
+		for _, cacheDir := range []string{"", dir} {
+			repos.Selection = tt.sel
+			repos.CacheDir = cacheDir
+			output, err := repos.topKReposByEvents(2, events.Watch, eventsFile, reposFile)
+			assert.Nil(err)
+			assert.Equal(tt.starred, output, "selection: %+v, cache: %q", tt.sel, cacheDir)
+			output, err = repos.topKReposByCommits(2, reposFile, eventsFile, commitsFile)
+			assert.Nil(err)
+			assert.Equal(tt.pushed, output, "selection: %+v, cache: %q", tt.sel, cacheDir)
+		}
+	}
+}
+
+func TestTopKReposFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	eventsFile := "testdata/ties/events.csv"
+	reposFile := "testdata/ties/repos.csv"
+	commitsFile := "testdata/ties/commits.csv"
+	dir := t.TempDir()
+	_, err := cache.Ingest(cache.Files{Events: eventsFile, Commits: commitsFile, Repos: reposFile}, dir, 1)
+	assert.Nil(err)
+
+	contributor := map[string]int{ranking.ContributorsHeader: 1}
+	tests := []struct {
+		include, exclude []string
+		starred, pushed  utils.GenericDictHeap
+	}{
+		// The filter applies before the top k is cut
+		{
+			exclude: []string{"alpha", "bravo"},
+			starred: utils.GenericDictHeap{{Key: "charlie", Value: 2}, {Key: "delta", Value: 2}},
+			pushed:  utils.GenericDictHeap{{Key: "charlie", Value: 1, Extra: contributor}, {Key: "delta", Value: 1, Extra: contributor}},
+		},
+		// IDs match as well as names
+		{
+			include: []string{"4", "?lpha"},
+			starred: utils.GenericDictHeap{{Key: "alpha", Value: 2}, {Key: "bravo", Value: 1}},
+			pushed:  utils.GenericDictHeap{{Key: "bravo", Value: 2, Extra: contributor}, {Key: "alpha", Value: 1, Extra: contributor}},
+		},
+		{
+			include: []string{"re:a$"},
+			exclude: []string{"delta"},
+			starred: utils.GenericDictHeap{{Key: "alpha", Value: 2}},
+			pushed:  utils.GenericDictHeap{{Key: "alpha", Value: 1, Extra: contributor}},
+		},
+	}
+
+	repos := New()
+	for _, tt := range tests {
+		repos.Filter, err = match.New(tt.include, tt.exclude)
+		assert.Nil(err)
+		for _, cacheDir := range []string{"", dir} {
+			repos.CacheDir = cacheDir
+			output, err := repos.topKReposByEvents(2, events.Watch, eventsFile, reposFile)
+			assert.Nil(err)
+			assert.Equal(tt.starred, output, "include: %q, exclude: %q, cache: %q", tt.include, tt.exclude, cacheDir)
+			output, err = repos.topKReposByCommits(2, reposFile, eventsFile, commitsFile)
+			assert.Nil(err)
+			assert.Equal(tt.pushed, output, "include: %q, exclude: %q, cache: %q", tt.include, tt.exclude, cacheDir)
+		}
+	}
+}
+
+func TestTopKReposGroupByOwner(t *testing.T) {
+	assert := assert.New(t)
+
+	eventsFile := "testdata/owners/events.csv"
+	reposFile := "testdata/owners/repos.csv"
+	commitsFile := "testdata/owners/commits.csv"
+	dir := t.TempDir()
+	_, err := cache.Ingest(cache.Files{Events: eventsFile, Commits: commitsFile, Repos: reposFile}, dir, 1)
+	assert.Nil(err)
+
+	repos := func(n int) map[string]int { return map[string]int{ranking.ReposHeader: n} }
+	tests := []struct {
+		exclude         []string
+		starred, pushed utils.GenericDictHeap
+	}{
+		// Owners match regardless of case, a name without an owner is
+		// its own owner and a repository without a name is dropped
+		{
+			starred: utils.GenericDictHeap{{Key: "Acme", Value: 2, Extra: repos(2)}, {Key: "globex", Value: 2, Extra: repos(1)}},
+			pushed:  utils.GenericDictHeap{{Key: "Acme", Value: 5, Extra: repos(3)}, {Key: "globex", Value: 2, Extra: repos(1)}},
+		},
+		// The filter drops repositories before they are summed
+		{
+			exclude: []string{"acme/rockets"},
+			starred: utils.GenericDictHeap{{Key: "globex", Value: 2, Extra: repos(1)}, {Key: "Acme", Value: 1, Extra: repos(1)}},
+			pushed:  utils.GenericDictHeap{{Key: "Acme", Value: 2, Extra: repos(2)}, {Key: "globex", Value: 2, Extra: repos(1)}},
+		},
+	}
+
+	r := New()
+	r.GroupBy = ranking.GroupByOwner
+	for _, tt := range tests {
+		r.Filter, err = match.New(nil, tt.exclude)
+		assert.Nil(err)
+		for _, cacheDir := range []string{"", dir} {
+			r.CacheDir = cacheDir
+			output, err := r.topKReposByEvents(2, events.Watch, eventsFile, reposFile)
+			assert.Nil(err)
+			assert.Equal(tt.starred, output, "exclude: %q, cache: %q", tt.exclude, cacheDir)
+			output, err = r.topKReposByCommits(2, reposFile, eventsFile, commitsFile)
+			assert.Nil(err)
+			assert.Equal(tt.pushed, output, "exclude: %q, cache: %q", tt.exclude, cacheDir)
+		}
+	}
 }
 
 func BenchmarkTopKReposByCommits(b *testing.B) {
 
-	b.ReportAllocs()
-	b.ResetTimer()
 	repos := New()
 	count := 10
 	eventsFile := "../../data/events.csv"
 	reposFile := "../../data/repos.csv"
 	commitsFile := "../../data/commits.csv"
-	for i := 0; i < b.N; i++ {
-		repos.topKReposByCommits(count, reposFile, eventsFile, commitsFile) //nolint
+	for _, workers := range benchmarkWorkers() {
+		b.Run(fmt.Sprintf("workers-%d", workers), func(b *testing.B) {
+			b.ReportAllocs()
+			repos.Workers = workers
+			for i := 0; i < b.N; i++ {
+				repos.topKReposByCommits(count, reposFile, eventsFile, commitsFile) //nolint
+			}
+		})
+	}
+}
+
+// assertApprox asserts that every approximate row overestimates the exact
+// count of its key by at most its error, which is within the error bound
+func assertApprox(assert *assert.Assertions, exact, approx utils.GenericDictHeap, bound int) {
+	counts := make(map[string]int)
+	for _, row := range exact {
+		counts[row.Key] = row.Value
+	}
+	for _, row := range approx {
+		err := row.Extra[utils.ErrorHeader]
+		assert.LessOrEqual(row.Value-err, counts[row.Key], row.Key)
+		assert.GreaterOrEqual(row.Value, counts[row.Key], row.Key)
+		assert.LessOrEqual(err, bound, row.Key)
+	}
+}
+
+func TestTopKReposApprox(t *testing.T) {
+	assert := assert.New(t)
+
+	eventsFile := "testdata/events.csv"
+	reposFile := "testdata/repos.csv"
+	commitsFile := "testdata/commits.csv"
+	dir := t.TempDir()
+	_, err := cache.Ingest(cache.Files{Events: eventsFile, Commits: commitsFile, Repos: reposFile}, dir, 1)
+	assert.Nil(err)
+
+	repos := New()
+	exactEvents, err := repos.topKReposByEvents(100, events.Watch, eventsFile, reposFile)
+	assert.Nil(err)
+	exactCommits, err := repos.topKReposByCommits(100, reposFile, eventsFile, commitsFile)
+	assert.Nil(err)
+
+	for _, cacheDir := range []string{"", dir} {
+		repos.CacheDir = cacheDir
+		for workers := 1; workers <= 3; workers++ {
+			repos.Workers = workers
+
+			// Enough counters for every key count exactly
+			repos.Counters = 1000
+			approx, err := repos.topKReposByEvents(3, events.Watch, eventsFile, reposFile)
+			assert.Nil(err)
+			assert.Equal(0, repos.Stats.ErrorBound)
+			assertApprox(assert, exactEvents, approx, 0)
+			assert.Equal(exactEvents.Take(3)[2].Value, approx[2].Value)
+
+			approx, err = repos.topKReposByCommits(3, reposFile, eventsFile, commitsFile)
+			assert.Nil(err)
+			assertApprox(assert, exactCommits, approx, 0)
+			assert.Equal(len(exactCommits), len(approx))
+
+			// Too few counters overestimate, within the bound
+			repos.Counters = 1
+			approx, err = repos.topKReposByEvents(3, events.Watch, eventsFile, reposFile)
+			assert.Nil(err)
+			assert.NotEmpty(approx)
+			assertApprox(assert, exactEvents, approx, repos.Stats.ErrorBound)
+
+			approx, err = repos.topKReposByCommits(3, reposFile, eventsFile, commitsFile)
+			assert.Nil(err)
+			assert.NotEmpty(approx)
+			assertApprox(assert, exactCommits, approx, repos.Stats.ErrorBound)
+		}
 	}
 }
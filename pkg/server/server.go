@@ -0,0 +1,508 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/oklog/run"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dedup"
+	"gitlab.com/ansrivas/go-analyze-git/internal/flags"
+	"gitlab.com/ansrivas/go-analyze-git/internal/match"
+	"gitlab.com/ansrivas/go-analyze-git/internal/ranking"
+	"gitlab.com/ansrivas/go-analyze-git/internal/spill"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/bots"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/dataset"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/events"
+)
+
+// Server exposes the rankings of an in-memory dataset over HTTP
+type Server struct {
+	files dataset.Files
+	// Bots classifies the users of every dataset loaded, the ones it
+	// suspects are dropped from the rankings asked to exclude the bots
+	Bots *bots.Classifier
+
+	mu   sync.RWMutex
+	data *dataset.Dataset
+	// suspects are the bots of data
+	suspects bots.Suspects
+	// loadErr is the error of the most recent load, nil once it succeeded
+	loadErr error
+	// reloading serializes reloads, queries keep using the previous
+	// dataset until the new one is loaded
+	reloading sync.Mutex
+}
+
+// New returns a Server for the given files. The files are
+// read by Load, until then the server is not ready.
+func New(files dataset.Files) *Server {
+	return &Server{files: files, Bots: bots.Default()}
+}
+
+// Load (re-)reads the files and swaps the in-memory dataset
+func (s *Server) Load() (*dataset.Dataset, error) {
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+
+	data, err := dataset.Load(s.files)
+	var suspects bots.Suspects
+	if err == nil {
+		suspects, err = s.Bots.ClassifyDataset(data)
+	}
+	if err != nil {
+		s.mu.Lock()
+		s.loadErr = err
+		s.mu.Unlock()
+		return nil, err
+	}
+	s.mu.Lock()
+	s.data, s.suspects, s.loadErr = data, suspects, nil
+	s.mu.Unlock()
+	return data, nil
+}
+
+func (s *Server) dataset() *dataset.Dataset {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data
+}
+
+// unavailable writes why there is no dataset yet, the error of
+// the load that failed or that it is still loading
+func (s *Server) unavailable(w http.ResponseWriter) {
+	s.mu.RLock()
+	err := s.loadErr
+	s.mu.RUnlock()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load the dataset: %v", err)
+		return
+	}
+	writeError(w, http.StatusServiceUnavailable, "the dataset is still loading")
+}
+
+// bots returns the bots of data, which a reload may just have replaced
+func (s *Server) bots(data *dataset.Dataset) (bots.Suspects, error) {
+	s.mu.RLock()
+	current, suspects := s.data, s.suspects
+	s.mu.RUnlock()
+	if current != data {
+		return s.Bots.ClassifyDataset(data)
+	}
+	return suspects, nil
+}
+
+// Handler returns the HTTP routes of the server
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.healthz)
+	mux.HandleFunc("/readyz", s.readyz)
+	mux.HandleFunc("/reload", s.reload)
+	mux.HandleFunc("/repos/top", s.ready(s.topRepos))
+	mux.HandleFunc("/users/top", s.ready(s.topUsers))
+	mux.HandleFunc("/users/", s.ready(s.user))
+	return mux
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		log.Error().Msgf("Failed to write the response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, errorResponse{Error: fmt.Sprintf(format, args...)})
+}
+
+// ready rejects requests until the dataset has been loaded
+func (s *Server) ready(next func(http.ResponseWriter, *http.Request, *dataset.Dataset)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method %s is not allowed", r.Method)
+			return
+		}
+		data := s.dataset()
+		if data == nil {
+			s.unavailable(w)
+			return
+		}
+		next(w, r, data)
+	}
+}
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyz fails while the most recent load failed, even though the
+// queries keep using the dataset loaded before
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	data, err := s.data, s.loadErr
+	s.mu.RUnlock()
+	if data == nil || err != nil {
+		s.unavailable(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+type reloadResponse struct {
+	RowsRead    int64  `json:"rows_read"`
+	RowsSkipped int64  `json:"rows_skipped"`
+	Duration    string `json:"duration"`
+}
+
+func (s *Server) reload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST to reload the dataset")
+		return
+	}
+	data, err := s.Load()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to reload the dataset: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, reloadResponse{
+		RowsRead:    data.Stats.RowsRead,
+		RowsSkipped: data.Stats.RowsSkipped,
+		Duration:    data.Stats.Duration.String(),
+	})
+}
+
+// param returns the first non-empty query parameter out of names, so
+// that both the short names and the command line flag names work
+func param(r *http.Request, def string, names ...string) string {
+	for _, name := range names {
+		if v := r.URL.Query().Get(name); v != "" {
+			return v
+		}
+	}
+	return def
+}
+
+func count(r *http.Request) (int, error) {
+	k, err := strconv.Atoi(param(r, strconv.Itoa(flags.CountFlag.Value), "k", "count"))
+	if err != nil || k <= 0 {
+		return 0, fmt.Errorf("k must be a positive number")
+	}
+	return k, nil
+}
+
+// selection returns the rows of a leaderboard selected by the offset,
+// order, min, max and ties parameters
+func selection(r *http.Request) (utils.Selection, error) {
+	sel := utils.Selection{
+		Order: param(r, utils.OrderDesc, "order"),
+		Ties:  param(r, utils.TiesExclude, "ties"),
+	}
+	bounds := map[string]**int{"min": &sel.Min, "max": &sel.Max}
+	for _, name := range []string{"offset", "min", "max"} {
+		v := param(r, "", name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return utils.Selection{}, fmt.Errorf("%s must be a number", name)
+		}
+		if name == "offset" {
+			sel.Offset = n
+		} else {
+			*bounds[name] = &n
+		}
+	}
+	return sel, sel.Validate()
+}
+
+// engine returns the engine dropping the duplicated rows as told by
+// the dedup and dedup-memory parameters
+func engine(r *http.Request) (*ranking.Engine, error) {
+	memory, err := spill.ParseSize(param(r, flags.DedupMemoryFlag.Value, "dedup-memory"))
+	if err != nil {
+		return nil, err
+	}
+	mode := param(r, dedup.Exact, "dedup")
+	if _, err := dedup.New(mode, 0); err != nil {
+		return nil, err
+	}
+	return &ranking.Engine{Workers: runtime.NumCPU(), Dedup: mode, DedupMemory: memory}, nil
+}
+
+// filter returns the filter of the include and exclude parameters,
+// both may be repeated. Unlike the command line flags, the patterns
+// can't be read from files on the server.
+func filter(r *http.Request, include, exclude string) (*match.Filter, error) {
+	query := r.URL.Query()
+	for _, name := range []string{include, exclude} {
+		for _, pattern := range query[name] {
+			if strings.HasPrefix(pattern, match.FilePrefix) {
+				return nil, fmt.Errorf("%s can't read the patterns from a file", name)
+			}
+		}
+	}
+	return match.New(query[include], query[exclude])
+}
+
+// render writes the leaderboard as json, or in the format
+// requested by the output parameter
+func render(w http.ResponseWriter, r *http.Request, report utils.Report) {
+	switch format := param(r, utils.OutputJSON, "output"); format {
+	case utils.OutputJSON:
+		writeJSON(w, http.StatusOK, report.Rows)
+	case utils.OutputOpenMetrics:
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+		if err := report.ToOpenMetrics(w); err != nil {
+			log.Error().Msgf("Failed to write the response: %v", err)
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "unknown output format %s", format)
+	}
+}
+
+func (s *Server) topRepos(w http.ResponseWriter, r *http.Request, data *dataset.Dataset) {
+	k, err := count(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	sel, err := selection(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	repos, err := filter(r, "repo-include", "repo-exclude")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	rk := ranking.Ranking{
+		Count:     k,
+		Selection: sel,
+		Repos:     repos,
+		GroupBy:   param(r, ranking.GroupByRepo, "group-by"),
+	}
+	switch by := param(r, "events", "by"); by {
+	case "events":
+		rk.Analysis = ranking.ReposByEvents
+		rk.EventType = param(r, events.Watch, "type", "event-type")
+	case "commits":
+		rk.Analysis = ranking.ReposByCommits
+	default:
+		writeError(w, http.StatusBadRequest, "repositories can be ranked by events or commits, not %s", by)
+		return
+	}
+	s.rank(w, r, data, rk)
+}
+
+// rank computes the ranking over the dataset and renders it
+func (s *Server) rank(w http.ResponseWriter, r *http.Request, data *dataset.Dataset, rk ranking.Ranking) {
+	if err := rk.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	engine, err := engine(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	results, err := data.Rank(engine, rk)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to rank the dataset: %v", err)
+		return
+	}
+	render(w, r, engine.Report(rk, results[0]))
+}
+
+func (s *Server) topUsers(w http.ResponseWriter, r *http.Request, data *dataset.Dataset) {
+	k, err := count(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	sel, err := selection(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	excludeBots, err := strconv.ParseBool(param(r, "false", "exclude-bots"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "exclude-bots must be true or false")
+		return
+	}
+	users, err := filter(r, "user-include", "user-exclude")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	rk := ranking.Ranking{Analysis: ranking.UsersByCommits, Count: k, Selection: sel, Users: users}
+	if excludeBots {
+		suspects, err := s.bots(data)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to classify the bots: %v", err)
+			return
+		}
+		rk.Exclude = suspects.Has
+	}
+	s.rank(w, r, data, rk)
+}
+
+type userResponse struct {
+	ID      string                `json:"id"`
+	Login   string                `json:"login"`
+	Events  map[string]int        `json:"events"`
+	Commits int                   `json:"commits"`
+	Repos   utils.GenericDictHeap `json:"repos"`
+}
+
+func (s *Server) user(w http.ResponseWriter, r *http.Request, data *dataset.Dataset) {
+	login := strings.TrimPrefix(r.URL.Path, "/users/")
+	if login == "" || strings.Contains(login, "/") {
+		writeError(w, http.StatusNotFound, "unknown path %s", r.URL.Path)
+		return
+	}
+	id, ok := data.ActorID(login)
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown user %s", login)
+		return
+	}
+	k, err := count(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	activity := data.UserActivity(id)
+	writeJSON(w, http.StatusOK, userResponse{
+		ID:      id,
+		Login:   login,
+		Events:  activity.Events,
+		Commits: activity.Commits,
+		Repos:   data.UserRepos(k, id),
+	})
+}
+
+// CmdServe loads the dataset and serves it until interrupted
+func CmdServe() *cli.Command {
+	return &cli.Command{
+		Name:  "serve",
+		Usage: "Load the dataset once and serve the rankings over HTTP",
+		Flags: []cli.Flag{
+			flags.DatasetFlag,
+			flags.ReposFileFlag,
+			flags.EventsFileFlag,
+			flags.CommitsFileFlag,
+			flags.ActorsFileFlag,
+			flags.CacheDirFlag,
+			flags.BotsFileFlag,
+			flags.BotCommitsFlag,
+			flags.BotRepeatedFlag,
+			flags.ListenFlag,
+		},
+		Action: func(c *cli.Context) error {
+			if err := flags.Required(c, "repos-file", "events-file", "commits-file", "actors-file"); err != nil {
+				return err
+			}
+			s := New(dataset.Files{
+				Events:  c.String("events-file"),
+				Commits: c.String("commits-file"),
+				Repos:   c.String("repos-file"),
+				Actors:  c.String("actors-file"),
+				// A reload picks up a re-ingested cache as well
+				CacheDir: c.String("cache-dir"),
+			})
+			classifier, err := bots.FromFlags(c)
+			if err != nil {
+				return err
+			}
+			s.Bots = classifier
+			httpServer := &http.Server{
+				Addr:              c.String("listen"),
+				Handler:           s.Handler(),
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+
+			// Serve health checks while the dataset is loading
+			go func() {
+				data, err := s.Load()
+				if err != nil {
+					log.Error().Msgf("Failed to load the dataset: %v", err)
+					return
+				}
+				log.Info().Msgf("Dataset loaded in %v, ready to serve", data.Stats.Duration)
+			}()
+
+			ctx, cancel := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+			var g run.Group
+			{
+				g.Add(func() error {
+					log.Info().Msgf("Listening on %s", httpServer.Addr)
+					if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+						return err
+					}
+					return nil
+				}, func(error) {
+					shutdownCtx, done := context.WithTimeout(context.Background(), 5*time.Second)
+					defer done()
+					httpServer.Shutdown(shutdownCtx) //nolint
+				})
+			}
+			{
+				g.Add(func() error {
+					<-ctx.Done()
+					return ctx.Err()
+				}, func(error) {
+					cancel()
+				})
+			}
+			err = g.Run()
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			return err
+		},
+	}
+}
@@ -0,0 +1,275 @@
+// MIT License
+//
+// Copyright (c) 2021 Ankur Srivastava
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/bots"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/dataset"
+)
+
+var testFiles = dataset.Files{
+	Events:  "../dataset/testdata/events.csv",
+	Commits: "../dataset/testdata/commits.csv",
+	Repos:   "../dataset/testdata/repos.csv",
+	Actors:  "../dataset/testdata/actors.csv",
+}
+
+func get(t *testing.T, handler http.Handler, method, target string) (int, string) {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	body, err := io.ReadAll(rec.Result().Body)
+	assert.Nil(t, err)
+	return rec.Code, string(body)
+}
+
+func TestServerReadiness(t *testing.T) {
+	assert := assert.New(t)
+
+	s := New(testFiles)
+	handler := s.Handler()
+
+	code, _ := get(t, handler, http.MethodGet, "/healthz")
+	assert.Equal(http.StatusOK, code)
+	code, _ = get(t, handler, http.MethodGet, "/readyz")
+	assert.Equal(http.StatusServiceUnavailable, code)
+	code, _ = get(t, handler, http.MethodGet, "/repos/top")
+	assert.Equal(http.StatusServiceUnavailable, code)
+
+	code, _ = get(t, handler, http.MethodGet, "/reload")
+	assert.Equal(http.StatusMethodNotAllowed, code)
+	code, body := get(t, handler, http.MethodPost, "/reload")
+	assert.Equal(http.StatusOK, code)
+	assert.Contains(body, `"rows_read":41`)
+
+	code, _ = get(t, handler, http.MethodGet, "/readyz")
+	assert.Equal(http.StatusOK, code)
+}
+
+func TestServerRankings(t *testing.T) {
+	assert := assert.New(t)
+
+	s := New(testFiles)
+	_, err := s.Load()
+	assert.Nil(err)
+	handler := s.Handler()
+
+	decode := func(body string) utils.GenericDictHeap {
+		var rows utils.GenericDictHeap
+		assert.Nil(json.Unmarshal([]byte(body), &rows))
+		return rows
+	}
+
+	code, body := get(t, handler, http.MethodGet, "/repos/top?k=2")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(utils.GenericDictHeap{
+		{Key: "testrepo2", Value: 3},
+		{Key: "testrepo1", Value: 2},
+	}, decode(body))
+
+	// The command line flag names work as well
+	code, body = get(t, handler, http.MethodGet, "/repos/top?event-type=ForkEvent&count=1")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(utils.GenericDictHeap{{Key: "testrepo3", Value: 1}}, decode(body))
+
+	code, body = get(t, handler, http.MethodGet, "/repos/top?by=commits&k=1")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(utils.GenericDictHeap{{Key: "testrepo2", Value: 5, Extra: map[string]int{"Contributors": 2}}}, decode(body))
+
+	code, body = get(t, handler, http.MethodGet, "/users/top?k=1&output=openmetrics")
+	assert.Equal(http.StatusOK, code)
+	assert.Contains(body, `git_user_prs_and_commits_total{user="Apexal"} 4`)
+
+	// Pages, the bottom of the list and thresholds
+	code, body = get(t, handler, http.MethodGet, "/repos/top?k=2&offset=1")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(utils.GenericDictHeap{{Key: "testrepo1", Value: 2}, {Key: "testrepo3", Value: 1}}, decode(body))
+
+	code, body = get(t, handler, http.MethodGet, "/repos/top?k=2&order=asc")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(utils.GenericDictHeap{{Key: "testrepo3", Value: 1}, {Key: "testrepo1", Value: 2}}, decode(body))
+
+	code, body = get(t, handler, http.MethodGet, "/repos/top?min=2&max=2")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(utils.GenericDictHeap{{Key: "testrepo1", Value: 2}}, decode(body))
+
+	for _, target := range []string{
+		"/repos/top?by=stars", "/repos/top?k=0", "/users/top?output=xml",
+		"/repos/top?offset=-1", "/repos/top?order=up", "/users/top?min=few", "/repos/top?min=3&max=2",
+	} {
+		code, _ = get(t, handler, http.MethodGet, target)
+		assert.Equal(http.StatusBadRequest, code, target)
+	}
+}
+
+func TestServerFilters(t *testing.T) {
+	assert := assert.New(t)
+
+	s := New(testFiles)
+	_, err := s.Load()
+	assert.Nil(err)
+	handler := s.Handler()
+
+	decode := func(body string) utils.GenericDictHeap {
+		var rows utils.GenericDictHeap
+		assert.Nil(json.Unmarshal([]byte(body), &rows))
+		return rows
+	}
+
+	code, body := get(t, handler, http.MethodGet, "/repos/top?k=2&repo-exclude=testrepo2&repo-exclude=testrepo3")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(utils.GenericDictHeap{{Key: "testrepo1", Value: 2}}, decode(body))
+
+	code, body = get(t, handler, http.MethodGet, "/repos/top?k=1&repo-include=re:1$")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(utils.GenericDictHeap{{Key: "testrepo1", Value: 2}}, decode(body))
+
+	code, body = get(t, handler, http.MethodGet, "/users/top?k=1&user-exclude=Apexal")
+	assert.Equal(http.StatusOK, code)
+	assert.Contains(body, `"anggi1234"`)
+	assert.NotContains(body, `"Apexal"`)
+
+	code, body = get(t, handler, http.MethodGet, "/repos/top?k=1&group-by=owner")
+	assert.Equal(http.StatusOK, code)
+	assert.Len(decode(body), 1)
+
+	code, _ = get(t, handler, http.MethodGet, "/repos/top?dedup=none")
+	assert.Equal(http.StatusOK, code)
+
+	for _, target := range []string{
+		"/repos/top?group-by=org", "/repos/top?dedup=maybe",
+		"/repos/top?dedup=bloom&dedup-memory=lots", "/repos/top?repo-include=re:(",
+		"/users/top?user-include=@/etc/passwd",
+	} {
+		code, _ = get(t, handler, http.MethodGet, target)
+		assert.Equal(http.StatusBadRequest, code, target)
+	}
+}
+
+func TestServerLoadError(t *testing.T) {
+	assert := assert.New(t)
+
+	s := New(dataset.Files{Events: filepath.Join(t.TempDir(), "missing.csv")})
+	handler := s.Handler()
+	_, err := s.Load()
+	assert.NotNil(err)
+
+	code, body := get(t, handler, http.MethodGet, "/readyz")
+	assert.Equal(http.StatusInternalServerError, code)
+	assert.Contains(body, "failed to load the dataset")
+	code, _ = get(t, handler, http.MethodGet, "/repos/top")
+	assert.Equal(http.StatusInternalServerError, code)
+}
+
+func TestServerReloadError(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	files := dataset.Files{Events: filepath.Join(dir, "events.csv")}
+	content, err := os.ReadFile(testFiles.Events)
+	assert.Nil(err)
+	assert.Nil(os.WriteFile(files.Events, content, 0o600))
+	s := New(files)
+	handler := s.Handler()
+	_, err = s.Load()
+	assert.Nil(err)
+
+	// A failed reload keeps serving the previous dataset, but isn't ready
+	assert.Nil(os.Remove(files.Events))
+	code, _ := get(t, handler, http.MethodPost, "/reload")
+	assert.Equal(http.StatusInternalServerError, code)
+	code, body := get(t, handler, http.MethodGet, "/readyz")
+	assert.Equal(http.StatusInternalServerError, code)
+	assert.Contains(body, "failed to load the dataset")
+	assert.Contains(body, "events.csv")
+	code, _ = get(t, handler, http.MethodGet, "/repos/top")
+	assert.Equal(http.StatusOK, code)
+
+	assert.Nil(os.WriteFile(files.Events, content, 0o600))
+	code, _ = get(t, handler, http.MethodPost, "/reload")
+	assert.Equal(http.StatusOK, code)
+	code, _ = get(t, handler, http.MethodGet, "/readyz")
+	assert.Equal(http.StatusOK, code)
+}
+
+func TestServerExcludeBots(t *testing.T) {
+	assert := assert.New(t)
+
+	list := filepath.Join(t.TempDir(), "bots.txt")
+	assert.Nil(os.WriteFile(list, []byte("deny Apexal\n"), 0o644))
+	s := New(testFiles)
+	classifier, err := bots.NewClassifier(list)
+	assert.Nil(err)
+	s.Bots = classifier
+	_, err = s.Load()
+	assert.Nil(err)
+	handler := s.Handler()
+
+	code, body := get(t, handler, http.MethodGet, "/users/top?k=1")
+	assert.Equal(http.StatusOK, code)
+	assert.Contains(body, `"Apexal"`)
+
+	code, body = get(t, handler, http.MethodGet, "/users/top?k=1&exclude-bots=true")
+	assert.Equal(http.StatusOK, code)
+	assert.Contains(body, `"anggi1234"`)
+	assert.NotContains(body, `"Apexal"`)
+
+	code, _ = get(t, handler, http.MethodGet, "/users/top?exclude-bots=maybe")
+	assert.Equal(http.StatusBadRequest, code)
+}
+
+func TestServerUser(t *testing.T) {
+	assert := assert.New(t)
+
+	s := New(testFiles)
+	_, err := s.Load()
+	assert.Nil(err)
+	handler := s.Handler()
+
+	code, body := get(t, handler, http.MethodGet, "/users/Apexal")
+	assert.Equal(http.StatusOK, code)
+	var user userResponse
+	assert.Nil(json.NewDecoder(strings.NewReader(body)).Decode(&user))
+	assert.Equal(userResponse{
+		ID:      "38429025",
+		Login:   "Apexal",
+		Events:  map[string]int{"PushEvent": 3, "CreateEvent": 1},
+		Commits: 3,
+		Repos:   utils.GenericDictHeap{{Key: "repowithpushevent1", Value: 3}},
+	}, user)
+
+	code, _ = get(t, handler, http.MethodGet, "/users/nobody")
+	assert.Equal(http.StatusNotFound, code)
+	code, _ = get(t, handler, http.MethodPost, "/users/Apexal")
+	assert.Equal(http.StatusMethodNotAllowed, code)
+}
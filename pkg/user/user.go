@@ -23,125 +23,96 @@
 package user
 
 import (
-	"container/heap"
-	"os"
-	"strings"
+	"runtime"
 	"time"
 
-	"github.com/oklog/run"
 	"github.com/rs/zerolog/log"
 	cli "github.com/urfave/cli/v2"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dedup"
 	"gitlab.com/ansrivas/go-analyze-git/internal/flags"
+	"gitlab.com/ansrivas/go-analyze-git/internal/match"
+	"gitlab.com/ansrivas/go-analyze-git/internal/ranking"
 	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
-	"gitlab.com/ansrivas/go-analyze-git/pkg/events"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/bots"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/cache"
 )
 
 // UsersByPRsAndCommits represents a list of GenericIntDict
 type UsersByPRsAndCommits []utils.GenericDictHeap
 
 // User struct defines all the operations related to a user
-type User struct{}
+type User struct {
+	// Workers is the number of workers parsing an input file in parallel
+	Workers int
+	// MaxMemory is the budget in bytes of the join state, which is
+	// spilled to disk once exceeded. Zero keeps it all in memory.
+	MaxMemory int64
+	// Counters is the number of counters of the sketch counting
+	// approximately. Zero counts exactly.
+	Counters int
+	// Precision is the precision of the HyperLogLog sketches counting
+	// distinct values approximately. Zero counts exactly.
+	Precision int
+	// Dedup is the dedup.Filter mode dropping duplicated rows,
+	// DedupMemory the size of its Bloom filter
+	Dedup       string
+	DedupMemory int64
+	// Selection tells which rows of the ranking are returned
+	Selection utils.Selection
+	// Filter drops the users by their login or ID before they
+	// are ranked, nil keeps every user
+	Filter *match.Filter
+	// Bots are dropped before ranking, see bots.Excluded
+	Bots bots.Suspects
+	// CacheDir is the directory of an ingested cache, which is
+	// read instead of the csv files while it is fresh
+	CacheDir string
+	// Stats of the most recent analysis
+	Stats utils.RunStats
+}
 
 // Instantiate a new object of User type
 func New() *User {
-	return &User{}
+	return &User{
+		Workers:     runtime.NumCPU(),
+		Dedup:       dedup.Exact,
+		DedupMemory: 64 << 20,
+	}
 }
 
-// topKUsersByPRsAndCommits returns Top K active users sorted
-// by amount of PRs created and commits pushed
-func (u *User) topKUsersByPRsAndCommits(count int, actorsFile, eventsFile, commitsFile string) (utils.GenericDictHeap, error) {
-
-	actorsChan := make(chan string, 10)
-	eventsChan := make(chan string, 10)
-	commitsChan := make(chan string, 10)
-	outputChan := make(chan utils.GenericDictHeap, 1)
-
-	userIDToCommitPRCountsCache := make(map[string]int)
-	eventIDToUserIDCache := make(map[string]string)
-	userIDToUsernameCache := make(map[string]string)
-	var g run.Group
-
-	{
-		g.Add(
-			utils.ExecuteFunc(actorsFile, actorsChan),
-			utils.InterruptFunc("The actorsFile actor was interrupted with: %v\n"))
-	}
-	{
-		g.Add(
-			utils.ExecuteFunc(eventsFile, eventsChan),
-			utils.InterruptFunc("The eventsFile actor was interrupted with: %v\n"))
-	}
-	{
-		g.Add(
-			utils.ExecuteFunc(commitsFile, commitsChan),
-			utils.InterruptFunc("The commitsFile actor was interrupted with: %v\n"))
+// ranking returns the ranking of the users
+func (u *User) ranking(count int) ranking.Ranking {
+	return ranking.Ranking{
+		Analysis:  ranking.UsersByCommits,
+		Count:     count,
+		Selection: u.Selection,
+		Users:     u.Filter,
+		Exclude:   u.Bots.Has,
 	}
+}
 
-	{
-		g.Add(func() error {
-			gdHeap := &utils.GenericDictHeap{}
-			heap.Init(gdHeap)
-
-			for event := range eventsChan {
-				columns := strings.Split(event, ",")
-				if len(columns) != 4 {
-					continue
-				}
-				if columns[1] != events.Push && columns[1] != events.Create {
-					// In case events type is not "PushEvent" or "CreateEvent"
-					continue
-				}
-				userID := columns[2]
-				eventID := columns[0]
-				eventIDToUserIDCache[eventID] = userID
-				userIDToCommitPRCountsCache[userID] = 0
-			}
-			for commitLine := range commitsChan {
-				columns := strings.Split(commitLine, ",")
-				eventID := columns[len(columns)-1]
-				// Check if this eventID is present in eventIDToUserIDCache and is a valid PushEvent
-				userID, ok := eventIDToUserIDCache[eventID]
-				if !ok {
-					continue
-				}
-				userIDToCommitPRCountsCache[userID] += 1
-			}
-
-			for line := range actorsChan {
-				columns := strings.Split(line, ",")
-				userIDToUsernameCache[columns[0]] = columns[1]
-			}
-
-			// Now iterate over userIDToCommitPRCountsCache and
-			// convert the userID -> userName and populate the heap
-			for userID, commitCount := range userIDToCommitPRCountsCache {
-				userName, exists := userIDToUsernameCache[userID]
-				if !exists {
-					continue
-				}
-
-				heap.Push(gdHeap, utils.GenericDict{
-					Key:   userName,
-					Value: commitCount,
-				})
-				// Maintaining only top-k elements
-				if gdHeap.Len() > count {
-					heap.Pop(gdHeap)
-				}
-				delete(userIDToUsernameCache, userID)
-			}
-			initialHeapLen := gdHeap.Len()
-			result := make(utils.GenericDictHeap, initialHeapLen)
-			for i := initialHeapLen; i > 0; i-- {
-				result[i-1] = heap.Pop(gdHeap).(utils.GenericDict)
-			}
-			outputChan <- result
-			return nil
-		}, utils.InterruptFunc("The worker actor was interrupted with: %v\n"))
+// engine returns the engine computing the rankings
+func (u *User) engine() *ranking.Engine {
+	return &ranking.Engine{
+		Workers:     u.Workers,
+		MaxMemory:   u.MaxMemory,
+		Counters:    u.Counters,
+		Precision:   u.Precision,
+		Dedup:       u.Dedup,
+		DedupMemory: u.DedupMemory,
 	}
+}
 
-	err := g.Run()
-	return <-outputChan, err
+// topKUsersByPRsAndCommits returns Top K active users sorted
+// by amount of PRs created and commits pushed
+func (u *User) topKUsersByPRsAndCommits(count int, actorsFile, eventsFile, commitsFile string) (utils.GenericDictHeap, error) {
+	files := cache.Files{Events: eventsFile, Commits: commitsFile, Actors: actorsFile}
+	results, err := u.engine().Run(cache.Inputs(u.CacheDir, files, u.Dedup), u.ranking(count))
+	if err != nil {
+		return nil, err
+	}
+	u.Stats = results[0].Stats
+	return results[0].Rows, nil
 }
 
 func (u *User) CmdTopKUsersByPRsAndCommits() *cli.Command {
@@ -151,29 +122,85 @@ func (u *User) CmdTopKUsersByPRsAndCommits() *cli.Command {
 		Aliases: []string{"t"},
 		Usage:   "Top K active users sorted by amount of PRs created and commits pushed",
 		Flags: []cli.Flag{
+			flags.DatasetFlag,
 			flags.CommitsFileFlag,
 			flags.EventsFileFlag,
 			flags.ActorsFileFlag,
 			flags.CountFlag,
+			flags.OffsetFlag,
+			flags.OrderFlag,
+			flags.MinFlag,
+			flags.MaxFlag,
+			flags.TiesFlag,
+			flags.UserIncludeFlag,
+			flags.UserExcludeFlag,
+			flags.ExcludeBotsFlag,
+			flags.BotsFileFlag,
+			flags.BotCommitsFlag,
+			flags.BotRepeatedFlag,
+			flags.WorkersFlag,
+			flags.MaxMemoryFlag,
+			flags.ApproxFlag,
+			flags.ApproxMemoryFlag,
+			flags.HLLPrecisionFlag,
+			flags.DedupFlag,
+			flags.DedupMemoryFlag,
+			flags.CacheDirFlag,
 			flags.JsonFlag,
+			flags.OutputFlag,
+			flags.ChartFlag,
 		},
 		Action: func(c *cli.Context) error {
+			if err := flags.Required(c, "commits-file", "events-file", "actors-file"); err != nil {
+				return err
+			}
 			commitsFile := c.String("commits-file")
 			eventsFile := c.String("events-file")
 			actorsFile := c.String("actors-file")
 			count := c.Int("count")
-			json := c.Bool("json")
+			chart := c.Bool("chart")
+			u.Workers = c.Int("workers")
+			u.CacheDir = c.String("cache-dir")
+			maxMemory, err := flags.MaxMemory(c)
+			if err != nil {
+				return err
+			}
+			u.MaxMemory = maxMemory
+			counters, err := flags.Counters(c)
+			if err != nil {
+				return err
+			}
+			u.Counters = counters
+			precision, err := flags.Precision(c)
+			if err != nil {
+				return err
+			}
+			u.Precision = precision
+			u.Dedup, u.DedupMemory, err = flags.Dedup(c)
+			if err != nil {
+				return err
+			}
+			u.Selection, err = flags.Selection(c)
+			if err != nil {
+				return err
+			}
+			u.Filter, err = flags.UserFilter(c)
+			if err != nil {
+				return err
+			}
+			u.Bots, err = bots.Excluded(c, eventsFile, commitsFile, actorsFile)
+			if err != nil {
+				return err
+			}
 			start := time.Now()
 			output, err := u.topKUsersByPRsAndCommits(count, actorsFile, eventsFile, commitsFile)
 			if err != nil {
 				return err
 			}
 
-			// If json, print and return
-			if json {
-				output.ToJson(os.Stdout) //nolint
-			} else {
-				utils.RenderTable(output, []string{"RepoID", "Count"})
+			report := u.engine().Report(u.ranking(count), ranking.Result{Rows: output, Stats: u.Stats})
+			if err := report.Render(flags.OutputFormat(c), chart); err != nil {
+				return err
 			}
 
 			log.Debug().Msgf("[%s] took %v", cmdName, time.Since(start))
@@ -23,10 +23,17 @@
 package user
 
 import (
+	"fmt"
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"gitlab.com/ansrivas/go-analyze-git/internal/dedup"
+	"gitlab.com/ansrivas/go-analyze-git/internal/match"
+	"gitlab.com/ansrivas/go-analyze-git/internal/ranking"
 	"gitlab.com/ansrivas/go-analyze-git/internal/utils"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/bots"
+	"gitlab.com/ansrivas/go-analyze-git/pkg/cache"
 )
 
 func TestTopKUsersByPRsAndCommits(t *testing.T) {
@@ -40,25 +47,233 @@ func TestTopKUsersByPRsAndCommits(t *testing.T) {
 	cache, err := user.topKUsersByPRsAndCommits(count, actorsFile, eventsFile, commitsFile)
 
 	expected := utils.GenericDictHeap{
-		utils.GenericDict{Key: "Apexal", Value: 5},
-		utils.GenericDict{Key: "anggi1234", Value: 4},
-		utils.GenericDict{Key: "onosendi", Value: 3},
+		// Apexal pushed to two repositories
+		utils.GenericDict{Key: "Apexal", Value: 5, Extra: map[string]int{ranking.ReposHeader: 2}},
+		utils.GenericDict{Key: "onosendi", Value: 3, Extra: map[string]int{ranking.ReposHeader: 1}},
+		// Two of the commits of anggi1234 are copies of a third one
+		utils.GenericDict{Key: "anggi1234", Value: 2, Extra: map[string]int{ranking.ReposHeader: 1}},
 	}
 
 	assert.Equal(cache, expected)
 	assert.Nil(err)
+
+	// Splitting the files into chunks must not change the result
+	for workers := 1; workers <= 8; workers++ {
+		user.Workers = workers
+		cache, err := user.topKUsersByPRsAndCommits(count, actorsFile, eventsFile, commitsFile)
+		assert.Nil(err)
+		assert.Equal(expected, cache, "workers: %d", workers)
+	}
+
+	// A tiny memory budget spills the join state to
+	// disk, the merge-join must come to the same result
+	for _, maxMemory := range []int64{1, 100, 1 << 20} {
+		for workers := 1; workers <= 3; workers++ {
+			user.Workers = workers
+			user.MaxMemory = maxMemory
+			cache, err := user.topKUsersByPRsAndCommits(count, actorsFile, eventsFile, commitsFile)
+			assert.Nil(err)
+			assert.Equal(expected, cache, "max memory: %d, workers: %d", maxMemory, workers)
+		}
+	}
+}
+
+func TestTopKUsersByPRsAndCommitsTies(t *testing.T) {
+	assert := assert.New(t)
+
+	eventsFile := "testdata/ties/events.csv"
+	commitsFile := "testdata/ties/commits.csv"
+	actorsFile := "testdata/ties/actors.csv"
+	dir := t.TempDir()
+	_, err := cache.Ingest(cache.Files{Events: eventsFile, Commits: commitsFile, Actors: actorsFile}, dir, 1)
+	assert.Nil(err)
+
+	// delta, alpha and charlie pushed a commit each, ties rank by name
+	repo := map[string]int{ranking.ReposHeader: 1}
+	expected := map[string]utils.GenericDictHeap{
+		utils.TiesExclude: {
+			{Key: "bravo", Value: 2, Extra: repo},
+			{Key: "alpha", Value: 1, Extra: repo},
+		},
+		utils.TiesInclude: {
+			{Key: "bravo", Value: 2, Extra: repo},
+			{Key: "alpha", Value: 1, Extra: repo},
+			{Key: "charlie", Value: 1, Extra: repo},
+			{Key: "delta", Value: 1, Extra: repo},
+		},
+	}
+
+	user := New()
+	for _, ties := range utils.Ties {
+		for _, cacheDir := range []string{"", dir} {
+			for workers := 1; workers <= 3; workers++ {
+				user.Selection.Ties = ties
+				user.CacheDir = cacheDir
+				user.Workers = workers
+				output, err := user.topKUsersByPRsAndCommits(2, actorsFile, eventsFile, commitsFile)
+				assert.Nil(err)
+				assert.Equal(expected[ties], output, "ties: %s, cache: %q, workers: %d", ties, cacheDir, workers)
+			}
+		}
+	}
+}
+
+func TestTopKUsersByPRsAndCommitsFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	eventsFile := "testdata/ties/events.csv"
+	commitsFile := "testdata/ties/commits.csv"
+	actorsFile := "testdata/ties/actors.csv"
+	dir := t.TempDir()
+	_, err := cache.Ingest(cache.Files{Events: eventsFile, Commits: commitsFile, Actors: actorsFile}, dir, 1)
+	assert.Nil(err)
+
+	repo := map[string]int{ranking.ReposHeader: 1}
+	tests := []struct {
+		include, exclude []string
+		expected         utils.GenericDictHeap
+	}{
+		// The filter applies before the top k is cut
+		{nil, []string{"b*"}, utils.GenericDictHeap{{Key: "alpha", Value: 1, Extra: repo}, {Key: "charlie", Value: 1, Extra: repo}}},
+		// users.txt lists charlie by ID and delta by login
+		{[]string{"@testdata/ties/users.txt"}, nil, utils.GenericDictHeap{{Key: "charlie", Value: 1, Extra: repo}, {Key: "delta", Value: 1, Extra: repo}}},
+		{[]string{"re:^[bd]"}, []string{"bravo"}, utils.GenericDictHeap{{Key: "delta", Value: 1, Extra: repo}}},
+	}
+
+	user := New()
+	for _, tt := range tests {
+		user.Filter, err = match.New(tt.include, tt.exclude)
+		assert.Nil(err)
+		for _, cacheDir := range []string{"", dir} {
+			user.CacheDir = cacheDir
+			output, err := user.topKUsersByPRsAndCommits(2, actorsFile, eventsFile, commitsFile)
+			assert.Nil(err)
+			assert.Equal(tt.expected, output, "include: %q, exclude: %q, cache: %q", tt.include, tt.exclude, cacheDir)
+		}
+	}
+
+	// The suspected bots are dropped before ranking as well
+	user.Filter = nil
+	user.Bots = bots.Suspects{"14": {ID: "14", Login: "bravo"}}
+	for _, cacheDir := range []string{"", dir} {
+		user.CacheDir = cacheDir
+		output, err := user.topKUsersByPRsAndCommits(2, actorsFile, eventsFile, commitsFile)
+		assert.Nil(err)
+		assert.Equal(utils.GenericDictHeap{{Key: "alpha", Value: 1, Extra: repo}, {Key: "charlie", Value: 1, Extra: repo}}, output, "cache: %q", cacheDir)
+	}
+}
+
+func TestTopKUsersByPRsAndCommitsDedup(t *testing.T) {
+	assert := assert.New(t)
+
+	user := New()
+	eventsFile := "testdata/events.csv"
+	commitsFile := "testdata/commits.csv"
+	actorsFile := "testdata/actors.csv"
+
+	// Without dedup every copy of a commit counts
+	user.Dedup = dedup.None
+	output, err := user.topKUsersByPRsAndCommits(3, actorsFile, eventsFile, commitsFile)
+	assert.Nil(err)
+	assert.Equal(utils.GenericDict{Key: "anggi1234", Value: 4, Extra: map[string]int{ranking.ReposHeader: 1}}, output[1])
+
+	// A Bloom filter with plenty of room drops exactly the copies
+	user.Dedup = dedup.Bloom
+	output, err = user.topKUsersByPRsAndCommits(3, actorsFile, eventsFile, commitsFile)
+	assert.Nil(err)
+	assert.Equal(utils.GenericDict{Key: "anggi1234", Value: 2, Extra: map[string]int{ranking.ReposHeader: 1}}, output[2])
+}
+
+func TestTopKUsersByPRsAndCommitsCached(t *testing.T) {
+	assert := assert.New(t)
+
+	user := New()
+	eventsFile := "testdata/events.csv"
+	commitsFile := "testdata/commits.csv"
+	actorsFile := "testdata/actors.csv"
+	expected, err := user.topKUsersByPRsAndCommits(3, actorsFile, eventsFile, commitsFile)
+	assert.Nil(err)
+	stats := user.Stats
+
+	// An ingested cache must come to the same result
+	user.CacheDir = t.TempDir()
+	files := cache.Files{Events: eventsFile, Commits: commitsFile, Actors: actorsFile}
+	_, err = cache.Ingest(files, user.CacheDir, 1)
+	assert.Nil(err)
+	cached, err := user.topKUsersByPRsAndCommits(3, actorsFile, eventsFile, commitsFile)
+	assert.Nil(err)
+	assert.Equal(expected, cached)
+	assert.Equal(stats.RowsRead, user.Stats.RowsRead)
+}
+
+// benchmarkWorkers are the worker counts every benchmark is run with,
+// comparing a single worker with all the available cores
+func benchmarkWorkers() []int {
+	if runtime.NumCPU() == 1 {
+		return []int{1}
+	}
+	return []int{1, runtime.NumCPU()}
 }
 
 func BenchmarkTopKUsersByPRsAndCommits(b *testing.B) {
 
-	b.ReportAllocs()
-	b.ResetTimer()
 	user := New()
 	count := 10
 	eventsFile := "../../data/events.csv"
 	commitsFile := "../../data/commits.csv"
 	actorsFile := "../../data/actors.csv"
-	for i := 0; i < b.N; i++ {
-		user.topKUsersByPRsAndCommits(count, actorsFile, eventsFile, commitsFile) //nolint
+	for _, workers := range benchmarkWorkers() {
+		b.Run(fmt.Sprintf("workers-%d", workers), func(b *testing.B) {
+			b.ReportAllocs()
+			user.Workers = workers
+			for i := 0; i < b.N; i++ {
+				user.topKUsersByPRsAndCommits(count, actorsFile, eventsFile, commitsFile) //nolint
+			}
+		})
 	}
 }
+
+func TestTopKUsersByPRsAndCommitsApprox(t *testing.T) {
+	assert := assert.New(t)
+
+	user := New()
+	eventsFile := "testdata/events.csv"
+	commitsFile := "testdata/commits.csv"
+	actorsFile := "testdata/actors.csv"
+	exact, err := user.topKUsersByPRsAndCommits(100, actorsFile, eventsFile, commitsFile)
+	assert.Nil(err)
+	counts := make(map[string]int)
+	for _, row := range exact {
+		counts[row.Key] = row.Value
+	}
+
+	for _, counters := range []int{1, 2, 1000} {
+		for workers := 1; workers <= 3; workers++ {
+			user.Workers = workers
+			user.Counters = counters
+			approx, err := user.topKUsersByPRsAndCommits(3, actorsFile, eventsFile, commitsFile)
+			assert.Nil(err)
+			assert.NotEmpty(approx)
+			for _, row := range approx {
+				// The true count lies within [Value-Error, Value]
+				err := row.Extra[utils.ErrorHeader]
+				assert.LessOrEqual(row.Value-err, counts[row.Key], row.Key)
+				assert.GreaterOrEqual(row.Value, counts[row.Key], row.Key)
+				assert.LessOrEqual(err, user.Stats.ErrorBound, row.Key)
+			}
+			if counters == 1000 {
+				assert.Equal(0, user.Stats.ErrorBound)
+				assert.Equal(exact.Take(3)[0].Value, approx[0].Value)
+			}
+		}
+	}
+
+	// Small sets of repositories are counted exactly by the sketches too
+	user.Counters = 0
+	expected, err := user.topKUsersByPRsAndCommits(3, actorsFile, eventsFile, commitsFile)
+	assert.Nil(err)
+	user.Precision = 12
+	estimated, err := user.topKUsersByPRsAndCommits(3, actorsFile, eventsFile, commitsFile)
+	assert.Nil(err)
+	assert.Equal(expected, estimated)
+}